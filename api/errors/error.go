@@ -3,9 +3,10 @@ package errors
 import (
 	"encoding/json"
 	"errors"
-	"log"
+	"log/slog"
+	"math"
 	"net/http"
-	"runtime/debug"
+	"strconv"
 	"time"
 
 	"github.com/jackc/pgx/v5"
@@ -36,6 +37,87 @@ func (e UnauthorizedError) Error() string {
 	return e.Message
 }
 
+type ForbiddenError struct {
+	Message string
+}
+
+func (e ForbiddenError) Error() string {
+	return e.Message
+}
+
+type ConflictError struct {
+	Message string
+}
+
+func (e ConflictError) Error() string {
+	return e.Message
+}
+
+// UnprocessableEntityError is for requests that are well formed but can't be
+// carried out as sent.
+type UnprocessableEntityError struct {
+	Message string
+}
+
+func (e UnprocessableEntityError) Error() string {
+	return e.Message
+}
+
+// PreconditionFailedError is returned when If-Match names an older version
+// of the resource than the stored one.
+type PreconditionFailedError struct {
+	Message string
+}
+
+func (e PreconditionFailedError) Error() string {
+	return e.Message
+}
+
+// PreconditionRequiredError is returned when an update is sent without
+// If-Match.
+type PreconditionRequiredError struct {
+	Message string
+}
+
+func (e PreconditionRequiredError) Error() string {
+	return e.Message
+}
+
+// TooManyRequestsError tells the client to back off. RetryAfter is sent as
+// the Retry-After header when set.
+type TooManyRequestsError struct {
+	Message    string
+	RetryAfter time.Duration
+}
+
+func (e TooManyRequestsError) Error() string {
+	return e.Message
+}
+
+// ErrorRecorder is implemented by the response writer of the access log.
+// HandleError gives it the errors behind 500 responses so they are logged on
+// the line of the request they belong to.
+type ErrorRecorder interface {
+	RecordError(err error)
+}
+
+// logError finds the ErrorRecorder among the wrapped response writers, or
+// logs on its own when the request has none.
+func logError(w http.ResponseWriter, message string, err error) {
+	for {
+		if recorder, ok := w.(ErrorRecorder); ok {
+			recorder.RecordError(err)
+			return
+		}
+		wrapper, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			slog.Error(message, "error", err)
+			return
+		}
+		w = wrapper.Unwrap()
+	}
+}
+
 type ErrorResponse struct {
 	Error string `json:"error"`
 }
@@ -48,27 +130,44 @@ func SendErrorResponse(w http.ResponseWriter, message string, statusCode int) {
 
 func HandleError(w http.ResponseWriter, err error) {
 	var pgErr *pgconn.PgError
+	var tooManyRequests TooManyRequestsError
 	switch {
 	case errors.As(err, &ValidationError{}):
 		SendErrorResponse(w, err.Error(), http.StatusBadRequest)
 	case errors.As(err, &NotFoundError{}):
 		SendErrorResponse(w, err.Error(), http.StatusNotFound)
 	case errors.As(err, &UnauthorizedError{}):
-		time.Sleep(time.Second)
 		SendErrorResponse(w, err.Error(), http.StatusUnauthorized)
+	case errors.As(err, &ForbiddenError{}):
+		SendErrorResponse(w, err.Error(), http.StatusForbidden)
+	case errors.As(err, &ConflictError{}):
+		SendErrorResponse(w, err.Error(), http.StatusConflict)
+	case errors.As(err, &UnprocessableEntityError{}):
+		SendErrorResponse(w, err.Error(), http.StatusUnprocessableEntity)
+	case errors.As(err, &PreconditionFailedError{}):
+		SendErrorResponse(w, err.Error(), http.StatusPreconditionFailed)
+	case errors.As(err, &PreconditionRequiredError{}):
+		SendErrorResponse(w, err.Error(), http.StatusPreconditionRequired)
+	case errors.As(err, &tooManyRequests):
+		if tooManyRequests.RetryAfter > 0 {
+			seconds := int(math.Ceil(tooManyRequests.RetryAfter.Seconds()))
+			w.Header().Set("Retry-After", strconv.Itoa(seconds))
+		}
+		SendErrorResponse(w, err.Error(), http.StatusTooManyRequests)
 	case errors.Is(err, pgx.ErrNoRows):
 		SendErrorResponse(w, "Resource not found", http.StatusNotFound)
 	case errors.As(err, &pgErr):
 		pgErr := err.(*pgconn.PgError)
 		if pgErr.Code == "23505" {
 			SendErrorResponse(w, "Resource already exists", http.StatusConflict)
+		} else if pgErr.Code == "23514" {
+			SendErrorResponse(w, "Request violates a data constraint", http.StatusBadRequest)
 		} else {
-			log.Printf("(%v)Database error: %v", pgErr.Code, err)
+			logError(w, "Database error", err)
 			SendErrorResponse(w, "Internal server error", http.StatusInternalServerError)
 		}
 	default:
-		debug.PrintStack()
-		log.Printf("Unexpected error: %v", err)
+		logError(w, "Unexpected error", err)
 		SendErrorResponse(w, "Internal server error", http.StatusInternalServerError)
 	}
 }
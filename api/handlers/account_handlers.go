@@ -0,0 +1,301 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/joseph-gunnarsson/scheduling/api/errors"
+	"github.com/joseph-gunnarsson/scheduling/api/middleware"
+	db "github.com/joseph-gunnarsson/scheduling/db/models"
+	"github.com/joseph-gunnarsson/scheduling/internals/auth"
+	"github.com/joseph-gunnarsson/scheduling/internals/mail"
+)
+
+const (
+	tokenPurposePasswordReset     = "password_reset"
+	tokenPurposeEmailVerification = "email_verification"
+	tokenPurposeAccountUnlock     = "account_unlock"
+	tokenPurposeEmailChange       = "email_change"
+
+	passwordResetTTL     = time.Hour
+	emailVerificationTTL = 48 * time.Hour
+	mailSendTimeout      = 30 * time.Second
+)
+
+// issueUserToken invalidates older tokens of the same purpose so only the
+// most recent email link works, then stores a new hashed token.
+func issueUserToken(ctx context.Context, query *db.Queries, userID int32, purpose string, email string, ttl time.Duration) (string, error) {
+	err := query.InvalidateUserTokens(ctx, db.InvalidateUserTokensParams{
+		UserID:  userID,
+		Purpose: purpose,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	token, tokenHash, err := auth.GenerateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	_, err = query.CreateUserToken(ctx, db.CreateUserTokenParams{
+		UserID:    userID,
+		Purpose:   purpose,
+		TokenHash: tokenHash,
+		Email:     pgtype.Text{String: email, Valid: email != ""},
+		ExpiresAt: pgtype.Timestamptz{Time: time.Now().Add(ttl), Valid: true},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// sendMailAsync sends outside the request so the response time does not
+// reveal whether an account exists. Wait blocks until these sends are done.
+func (h *BaseHandler) sendMailAsync(ctx context.Context, msg mail.Message) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), mailSendTimeout)
+	h.background.Add(1)
+	go func() {
+		defer h.background.Done()
+		defer cancel()
+		if err := h.mailer.Send(ctx, msg); err != nil {
+			slog.ErrorContext(ctx, "Failed to send mail", "subject", msg.Subject, "error", err)
+		}
+	}()
+}
+
+func (h *BaseHandler) publicLink(path, token string) string {
+	return fmt.Sprintf("%s%s?token=%s", h.publicURL, path, url.QueryEscape(token))
+}
+
+func (h *BaseHandler) sendVerificationEmail(ctx context.Context, userID int32, email string) error {
+	token, err := issueUserToken(ctx, db.New(h.db), userID, tokenPurposeEmailVerification, email, emailVerificationTTL)
+	if err != nil {
+		return err
+	}
+
+	h.sendMailAsync(ctx, mail.Message{
+		To:      email,
+		Subject: "Verify your email address",
+		Body: "Confirm your email address by opening the link below.\n\n" +
+			h.publicLink("/verify-email", token) + "\n\n" +
+			"The link expires in 48 hours. If you did not create an account you can ignore this email.\n",
+	})
+	return nil
+}
+
+func (h *BaseHandler) RequestPasswordResetHandler(rw http.ResponseWriter, r *http.Request) {
+	var resetRequest struct {
+		Email string `json:"email"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&resetRequest)
+	if err != nil || resetRequest.Email == "" {
+		errors.HandleError(rw, errors.ValidationError{Message: "Invalid request body"})
+		return
+	}
+
+	query := db.New(h.db)
+	user, err := query.GetUserByEmail(r.Context(), resetRequest.Email)
+	if err != nil && err != pgx.ErrNoRows {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	// Service accounts have no mailbox and no password to reset.
+	if err == nil && !user.ServiceGroupID.Valid {
+		token, err := issueUserToken(r.Context(), query, user.ID, tokenPurposePasswordReset, user.Email, passwordResetTTL)
+		if err != nil {
+			errors.HandleError(rw, err)
+			return
+		}
+
+		h.sendMailAsync(r.Context(), mail.Message{
+			To:      user.Email,
+			Subject: "Reset your password",
+			Body: "Someone asked to reset the password for " + user.Username + ".\n\n" +
+				h.publicLink("/reset-password", token) + "\n\n" +
+				"The link expires in one hour. If this was not you, you can ignore this email.\n",
+		})
+	}
+
+	// The response is the same whether or not the email is registered.
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(rw).Encode(map[string]string{"message": "If the email is registered, a reset link has been sent"})
+}
+
+func (h *BaseHandler) ResetPasswordHandler(rw http.ResponseWriter, r *http.Request) {
+	var resetRequest struct {
+		Token       string `json:"token"`
+		NewPassword string `json:"new_password"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&resetRequest)
+	if err != nil || resetRequest.Token == "" || resetRequest.NewPassword == "" {
+		errors.HandleError(rw, errors.ValidationError{Message: "Invalid request body"})
+		return
+	}
+
+	passwordHash, err := auth.HashPassword(r.Context(), resetRequest.NewPassword)
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	tx, err := h.db.Begin(r.Context())
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+	defer tx.Rollback(r.Context())
+	query := db.New(h.db).WithTx(tx)
+
+	token, err := query.ConsumeUserToken(r.Context(), db.ConsumeUserTokenParams{
+		TokenHash: auth.HashToken(resetRequest.Token),
+		Purpose:   tokenPurposePasswordReset,
+	})
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			errors.HandleError(rw, errors.ValidationError{Message: "Invalid or expired reset token"})
+		} else {
+			errors.HandleError(rw, err)
+		}
+		return
+	}
+
+	err = query.UpdateUserPassword(r.Context(), db.UpdateUserPasswordParams{
+		ID:           token.UserID,
+		PasswordHash: passwordHash,
+	})
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	// Whoever knew the old password should not stay logged in.
+	err = query.RevokeUserSessions(r.Context(), token.UserID)
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	// Proving access to the email is enough to lift a lockout.
+	err = query.UnlockUser(r.Context(), token.UserID)
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	err = recordAudit(r, query, auditEvent{
+		Action:     "user.reset_password",
+		EntityType: auditEntityUser,
+		EntityID:   token.UserID,
+		ActorID:    token.UserID,
+	})
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	err = tx.Commit(r.Context())
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	json.NewEncoder(rw).Encode(map[string]string{"message": "Password reset successfully"})
+}
+
+func (h *BaseHandler) VerifyEmailHandler(rw http.ResponseWriter, r *http.Request) {
+	var verifyRequest struct {
+		Token string `json:"token"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&verifyRequest)
+	if err != nil || verifyRequest.Token == "" {
+		errors.HandleError(rw, errors.ValidationError{Message: "Invalid request body"})
+		return
+	}
+
+	tx, err := h.db.Begin(r.Context())
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+	defer tx.Rollback(r.Context())
+	query := db.New(h.db).WithTx(tx)
+
+	token, err := query.ConsumeUserToken(r.Context(), db.ConsumeUserTokenParams{
+		TokenHash: auth.HashToken(verifyRequest.Token),
+		Purpose:   tokenPurposeEmailVerification,
+	})
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			errors.HandleError(rw, errors.ValidationError{Message: "Invalid or expired verification token"})
+		} else {
+			errors.HandleError(rw, err)
+		}
+		return
+	}
+
+	verified, err := query.MarkEmailVerified(r.Context(), db.MarkEmailVerifiedParams{
+		ID:    token.UserID,
+		Email: token.Email.String,
+	})
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+	if verified == 0 {
+		errors.HandleError(rw, errors.ValidationError{Message: "The email address has changed since this link was sent"})
+		return
+	}
+
+	err = recordAudit(r, query, auditEvent{
+		Action:     "user.verify_email",
+		EntityType: auditEntityUser,
+		EntityID:   token.UserID,
+		ActorID:    token.UserID,
+	})
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	err = tx.Commit(r.Context())
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	json.NewEncoder(rw).Encode(map[string]string{"message": "Email verified successfully"})
+}
+
+func (h *BaseHandler) ResendVerificationHandler(rw http.ResponseWriter, r *http.Request) {
+	user := r.Context().Value(middleware.UserKey).(db.User)
+	if user.EmailVerifiedAt.Valid {
+		errors.HandleError(rw, errors.ValidationError{Message: "Email is already verified"})
+		return
+	}
+
+	err := h.sendVerificationEmail(r.Context(), user.ID, user.Email)
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(rw).Encode(map[string]string{"message": "Verification email sent"})
+}
@@ -0,0 +1,480 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/joseph-gunnarsson/scheduling/api/errors"
+	"github.com/joseph-gunnarsson/scheduling/api/middleware"
+	db "github.com/joseph-gunnarsson/scheduling/db/models"
+	"github.com/joseph-gunnarsson/scheduling/internals/auth"
+)
+
+var serviceAccountName = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,31}$`)
+
+type apiKeyRequest struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays int      `json:"expires_in_days"`
+}
+
+// createdAPIKey is only returned once, when the key is created. Listings
+// never contain the key itself.
+type createdAPIKey struct {
+	db.CreateAPIKeyRow
+	Key string `json:"key"`
+}
+
+func parsePathID(r *http.Request, name string) (int32, error) {
+	id, err := strconv.ParseInt(r.PathValue(name), 10, 32)
+	if err != nil {
+		return 0, errors.ValidationError{Message: "Invalid " + name}
+	}
+	return int32(id), nil
+}
+
+func decodeAPIKeyRequest(r *http.Request) (apiKeyRequest, time.Duration, error) {
+	var keyRequest apiKeyRequest
+	err := json.NewDecoder(r.Body).Decode(&keyRequest)
+	if err != nil || keyRequest.Name == "" || len(keyRequest.Name) > 100 {
+		return apiKeyRequest{}, 0, errors.ValidationError{Message: "Invalid request body"}
+	}
+	if len(keyRequest.Scopes) == 0 {
+		return apiKeyRequest{}, 0, errors.ValidationError{Message: "At least one scope is required"}
+	}
+	for _, scope := range keyRequest.Scopes {
+		if !auth.ValidScope(scope) {
+			return apiKeyRequest{}, 0, errors.ValidationError{Message: fmt.Sprintf("Unknown scope %q, valid scopes are %v", scope, auth.AllScopes)}
+		}
+	}
+
+	ttl := auth.DefaultAPIKeyTTL
+	if keyRequest.ExpiresInDays != 0 {
+		ttl = time.Duration(keyRequest.ExpiresInDays) * 24 * time.Hour
+	}
+	if ttl <= 0 || ttl > auth.MaxAPIKeyTTL {
+		return apiKeyRequest{}, 0, errors.ValidationError{Message: "expires_in_days must be between 1 and 365"}
+	}
+
+	return keyRequest, ttl, nil
+}
+
+// auditAPIKey is what the audit log keeps of a key. The name is left out,
+// it is free text that erasing the owner would have to remove.
+type auditAPIKey struct {
+	ID        int32              `json:"id"`
+	UserID    int32              `json:"user_id"`
+	KeyPrefix string             `json:"key_prefix"`
+	Scopes    []string           `json:"scopes"`
+	ExpiresAt pgtype.Timestamptz `json:"expires_at"`
+	RevokedAt pgtype.Timestamptz `json:"revoked_at"`
+}
+
+func newAuditAPIKey(key db.CreateAPIKeyRow) auditAPIKey {
+	return auditAPIKey{
+		ID:        key.ID,
+		UserID:    key.UserID,
+		KeyPrefix: key.KeyPrefix,
+		Scopes:    key.Scopes,
+		ExpiresAt: key.ExpiresAt,
+		RevokedAt: key.RevokedAt,
+	}
+}
+
+// issueAPIKey creates a key for ownerID and audits it under groupID, the
+// group of a service account or zero for a personal key.
+func (h *BaseHandler) issueAPIKey(r *http.Request, ownerID int32, groupID int32, keyRequest apiKeyRequest, ttl time.Duration) (createdAPIKey, error) {
+	key, prefix, keyHash, err := auth.GenerateAPIKey()
+	if err != nil {
+		return createdAPIKey{}, err
+	}
+
+	user := r.Context().Value(middleware.UserKey).(db.User)
+	tx, err := h.db.Begin(r.Context())
+	if err != nil {
+		return createdAPIKey{}, err
+	}
+	defer tx.Rollback(r.Context())
+	query := db.New(h.db).WithTx(tx)
+
+	row, err := query.CreateAPIKey(r.Context(), db.CreateAPIKeyParams{
+		UserID:    ownerID,
+		Name:      keyRequest.Name,
+		KeyPrefix: prefix,
+		KeyHash:   keyHash,
+		Scopes:    keyRequest.Scopes,
+		CreatedBy: pgtype.Int4{Int32: user.ID, Valid: true},
+		ExpiresAt: pgtype.Timestamptz{Time: time.Now().Add(ttl), Valid: true},
+	})
+	if err != nil {
+		return createdAPIKey{}, err
+	}
+
+	err = recordAudit(r, query, auditEvent{
+		Action:     "api_key.create",
+		EntityType: auditEntityAPIKey,
+		EntityID:   row.ID,
+		GroupID:    groupID,
+		After:      newAuditAPIKey(row),
+	})
+	if err != nil {
+		return createdAPIKey{}, err
+	}
+
+	err = tx.Commit(r.Context())
+	if err != nil {
+		return createdAPIKey{}, err
+	}
+
+	return createdAPIKey{CreateAPIKeyRow: row, Key: key}, nil
+}
+
+func (h *BaseHandler) CreateAPIKeyHandler(rw http.ResponseWriter, r *http.Request) {
+	keyRequest, ttl, err := decodeAPIKeyRequest(r)
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	user := r.Context().Value(middleware.UserKey).(db.User)
+	session := r.Context().Value(middleware.SessionKey).(db.Session)
+
+	// API keys skip the second factor, so whoever the group policy applies
+	// to has to pass it before minting one.
+	query := db.New(h.db)
+	required, err := query.UserRequiresTwoFactor(r.Context(), user.ID)
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+	if required && !session.TwoFactorVerifiedAt.Valid {
+		errors.HandleError(rw, errors.ForbiddenError{Message: "Log in with two-factor authentication to create API keys"})
+		return
+	}
+
+	created, err := h.issueAPIKey(r, user.ID, 0, keyRequest, ttl)
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusCreated)
+	json.NewEncoder(rw).Encode(created)
+}
+
+func (h *BaseHandler) ListAPIKeysHandler(rw http.ResponseWriter, r *http.Request) {
+	user := r.Context().Value(middleware.UserKey).(db.User)
+
+	query := db.New(h.db)
+	keys, err := query.ListUserAPIKeys(r.Context(), user.ID)
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	json.NewEncoder(rw).Encode(keys)
+}
+
+func (h *BaseHandler) RevokeAPIKeyHandler(rw http.ResponseWriter, r *http.Request) {
+	keyID, err := parsePathID(r, "keyID")
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	user := r.Context().Value(middleware.UserKey).(db.User)
+	h.revokeAPIKey(rw, r, keyID, user.ID, 0)
+}
+
+// revokeAPIKey revokes a key of ownerID and audits it under groupID, like
+// issueAPIKey.
+func (h *BaseHandler) revokeAPIKey(rw http.ResponseWriter, r *http.Request, keyID int32, ownerID int32, groupID int32) {
+	tx, err := h.db.Begin(r.Context())
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+	defer tx.Rollback(r.Context())
+	query := db.New(h.db).WithTx(tx)
+
+	revoked, err := query.RevokeAPIKey(r.Context(), db.RevokeAPIKeyParams{
+		ID:     keyID,
+		UserID: ownerID,
+	})
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			errors.HandleError(rw, errors.NotFoundError{Message: "API key not found"})
+		} else {
+			errors.HandleError(rw, err)
+		}
+		return
+	}
+
+	err = recordAudit(r, query, auditEvent{
+		Action:     "api_key.revoke",
+		EntityType: auditEntityAPIKey,
+		EntityID:   revoked.ID,
+		GroupID:    groupID,
+		After:      newAuditAPIKey(db.CreateAPIKeyRow(revoked)),
+	})
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	err = tx.Commit(r.Context())
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	json.NewEncoder(rw).Encode(map[string]string{"message": "API key revoked"})
+}
+
+func (h *BaseHandler) CreateServiceAccountHandler(rw http.ResponseWriter, r *http.Request) {
+	groupID, err := parsePathID(r, "id")
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	var accountRequest struct {
+		Name string `json:"name"`
+	}
+	err = json.NewDecoder(r.Body).Decode(&accountRequest)
+	if err != nil || !serviceAccountName.MatchString(accountRequest.Name) {
+		errors.HandleError(rw, errors.ValidationError{Message: "Name must be 1-32 lowercase letters, digits or dashes"})
+		return
+	}
+
+	// Nobody knows this password, service accounts can not log in with one.
+	randomPassword, err := auth.RandomString(32)
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+	passwordHash, err := auth.HashPassword(r.Context(), randomPassword)
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	username := fmt.Sprintf("svc-%s-%d", accountRequest.Name, groupID)
+	tx, err := h.db.Begin(r.Context())
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+	defer tx.Rollback(r.Context())
+	query := db.New(h.db).WithTx(tx)
+
+	account, err := query.CreateServiceAccount(r.Context(), db.CreateServiceAccountParams{
+		Username:       username,
+		Email:          username + "@service.invalid",
+		PasswordHash:   passwordHash,
+		ServiceGroupID: pgtype.Int4{Int32: groupID, Valid: true},
+	})
+	if err != nil {
+		if pgErr, ok := err.(*pgconn.PgError); ok && pgErr.Code == "23505" {
+			errors.HandleError(rw, errors.ValidationError{Message: "The group already has a service account with this name"})
+		} else {
+			errors.HandleError(rw, err)
+		}
+		return
+	}
+
+	err = recordAudit(r, query, auditEvent{
+		Action:     "service_account.create",
+		EntityType: auditEntityUser,
+		EntityID:   account.ID,
+		GroupID:    groupID,
+		After:      account,
+	})
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	err = tx.Commit(r.Context())
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusCreated)
+	json.NewEncoder(rw).Encode(account)
+}
+
+func (h *BaseHandler) ListServiceAccountsHandler(rw http.ResponseWriter, r *http.Request) {
+	groupID, err := parsePathID(r, "id")
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	query := db.New(h.db)
+	accounts, err := query.ListServiceAccounts(r.Context(), pgtype.Int4{Int32: groupID, Valid: true})
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	json.NewEncoder(rw).Encode(accounts)
+}
+
+func (h *BaseHandler) DeleteServiceAccountHandler(rw http.ResponseWriter, r *http.Request) {
+	groupID, err := parsePathID(r, "id")
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+	accountID, err := parsePathID(r, "accountID")
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	tx, err := h.db.Begin(r.Context())
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+	defer tx.Rollback(r.Context())
+	query := db.New(h.db).WithTx(tx)
+
+	account, err := query.GetServiceAccount(r.Context(), db.GetServiceAccountParams{
+		ID:             accountID,
+		ServiceGroupID: pgtype.Int4{Int32: groupID, Valid: true},
+	})
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			errors.HandleError(rw, errors.NotFoundError{Message: "Service account not found"})
+		} else {
+			errors.HandleError(rw, err)
+		}
+		return
+	}
+
+	_, err = query.DeleteServiceAccount(r.Context(), db.DeleteServiceAccountParams{
+		ID:             accountID,
+		ServiceGroupID: pgtype.Int4{Int32: groupID, Valid: true},
+	})
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	err = recordAudit(r, query, auditEvent{
+		Action:     "service_account.delete",
+		EntityType: auditEntityUser,
+		EntityID:   account.ID,
+		GroupID:    groupID,
+		Before:     account,
+	})
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	err = tx.Commit(r.Context())
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	json.NewEncoder(rw).Encode(map[string]string{"message": "Service account deleted"})
+}
+
+// groupServiceAccount resolves the accountID path value and makes sure the
+// account belongs to the group in the path, which the caller manages.
+func (h *BaseHandler) groupServiceAccount(r *http.Request) (db.GetServiceAccountRow, error) {
+	groupID, err := parsePathID(r, "id")
+	if err != nil {
+		return db.GetServiceAccountRow{}, err
+	}
+	accountID, err := parsePathID(r, "accountID")
+	if err != nil {
+		return db.GetServiceAccountRow{}, err
+	}
+
+	query := db.New(h.db)
+	return query.GetServiceAccount(r.Context(), db.GetServiceAccountParams{
+		ID:             accountID,
+		ServiceGroupID: pgtype.Int4{Int32: groupID, Valid: true},
+	})
+}
+
+func (h *BaseHandler) CreateServiceAccountKeyHandler(rw http.ResponseWriter, r *http.Request) {
+	account, err := h.groupServiceAccount(r)
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	keyRequest, ttl, err := decodeAPIKeyRequest(r)
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	created, err := h.issueAPIKey(r, account.ID, account.ServiceGroupID.Int32, keyRequest, ttl)
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusCreated)
+	json.NewEncoder(rw).Encode(created)
+}
+
+func (h *BaseHandler) ListServiceAccountKeysHandler(rw http.ResponseWriter, r *http.Request) {
+	account, err := h.groupServiceAccount(r)
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	query := db.New(h.db)
+	keys, err := query.ListUserAPIKeys(r.Context(), account.ID)
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	json.NewEncoder(rw).Encode(keys)
+}
+
+func (h *BaseHandler) RevokeServiceAccountKeyHandler(rw http.ResponseWriter, r *http.Request) {
+	account, err := h.groupServiceAccount(r)
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+	keyID, err := parsePathID(r, "keyID")
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	h.revokeAPIKey(rw, r, keyID, account.ID, account.ServiceGroupID.Int32)
+}
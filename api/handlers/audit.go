@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/joseph-gunnarsson/scheduling/api/errors"
+	"github.com/joseph-gunnarsson/scheduling/api/middleware"
+	db "github.com/joseph-gunnarsson/scheduling/db/models"
+	"github.com/joseph-gunnarsson/scheduling/internals/logging"
+	"github.com/joseph-gunnarsson/scheduling/internals/repository"
+	"github.com/joseph-gunnarsson/scheduling/internals/service"
+)
+
+// Entity types recorded in the audit log.
+const (
+	auditEntityUser       = service.AuditEntityUser
+	auditEntityGroup      = service.AuditEntityGroup
+	auditEntityMembership = service.AuditEntityMembership
+	auditEntityShift      = service.AuditEntityShift
+	auditEntityAPIKey     = service.AuditEntityAPIKey
+)
+
+type auditEvent = service.AuditEvent
+
+// requestActor is the authenticated user of a request, if any, together
+// with the request ID and client address the audit log records.
+func requestActor(r *http.Request) service.Actor {
+	actor := service.Actor{
+		RequestID: logging.RequestID(r.Context()),
+		IPAddress: clientIP(r),
+	}
+	if user, ok := r.Context().Value(middleware.UserKey).(db.User); ok {
+		actor.UserID = user.ID
+	}
+	return actor
+}
+
+// recordAudit appends an event. Pass the query of the transaction that makes
+// the change so the event is only kept if the change is.
+func recordAudit(r *http.Request, query repository.Audit, event auditEvent) error {
+	return service.RecordAudit(r.Context(), query, requestActor(r), event)
+}
+
+const (
+	defaultAuditPageSize = 50
+	maxAuditPageSize     = 200
+)
+
+// ListGroupAuditEventsHandler lists the events of a group and its
+// sub-groups, newest first. Results are paged with before_id set to the last
+// id of the previous page.
+func (h *BaseHandler) ListGroupAuditEventsHandler(rw http.ResponseWriter, r *http.Request) {
+	groupID, err := parsePathID(r, "id")
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	filter := r.URL.Query()
+	params := db.ListGroupAuditEventsParams{
+		RootID:     groupID,
+		Action:     pgtype.Text{String: filter.Get("action"), Valid: filter.Get("action") != ""},
+		EntityType: pgtype.Text{String: filter.Get("entity_type"), Valid: filter.Get("entity_type") != ""},
+		Limit:      defaultAuditPageSize,
+	}
+
+	for name, target := range map[string]*pgtype.Int4{"actor_id": &params.ActorID, "entity_id": &params.EntityID} {
+		if value := filter.Get(name); value != "" {
+			id, err := strconv.ParseInt(value, 10, 32)
+			if err != nil {
+				errors.HandleError(rw, errors.ValidationError{Message: "Invalid " + name})
+				return
+			}
+			*target = pgtype.Int4{Int32: int32(id), Valid: true}
+		}
+	}
+
+	for name, target := range map[string]*pgtype.Timestamptz{"since": &params.Since, "until": &params.Until} {
+		if value := filter.Get(name); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				errors.HandleError(rw, errors.ValidationError{Message: name + " must be an RFC 3339 timestamp"})
+				return
+			}
+			*target = pgtype.Timestamptz{Time: t, Valid: true}
+		}
+	}
+
+	if value := filter.Get("before_id"); value != "" {
+		id, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			errors.HandleError(rw, errors.ValidationError{Message: "Invalid before_id"})
+			return
+		}
+		params.BeforeID = pgtype.Int8{Int64: id, Valid: true}
+	}
+
+	if value := filter.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxAuditPageSize {
+			errors.HandleError(rw, errors.ValidationError{Message: fmt.Sprintf("limit must be between 1 and %d", maxAuditPageSize)})
+			return
+		}
+		params.Limit = int32(limit)
+	}
+
+	query := db.New(h.db)
+	events, err := query.ListGroupAuditEvents(r.Context(), params)
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	json.NewEncoder(rw).Encode(events)
+}
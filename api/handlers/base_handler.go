@@ -1,15 +1,58 @@
 package handlers
 
 import (
-	"github.com/jackc/pgx/v5"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/joseph-gunnarsson/scheduling/internals/auth"
+	"github.com/joseph-gunnarsson/scheduling/internals/mail"
+	"github.com/joseph-gunnarsson/scheduling/internals/oidc"
+	"github.com/joseph-gunnarsson/scheduling/internals/repository"
+	"github.com/joseph-gunnarsson/scheduling/internals/service"
 )
 
 type BaseHandler struct {
-	db *pgx.Conn
+	db     *pgxpool.Pool
+	tokens *auth.TokenService
+	// services serve the user, group, membership and shift handlers. Tests
+	// build them on repository.NewMemory.
+	services service.Services
+	// oidcProvider is nil when OIDC login is not configured.
+	oidcProvider *oidc.Provider
+	mailer       mail.Mailer
+	// publicURL is where links in emails point to, without a trailing slash.
+	publicURL string
+	// retention is how long deleted groups and shifts can be restored.
+	retention time.Duration
+	// schemaVersion is the newest migration of this build, see
+	// migrations.Latest. ReadyHandler wants the database at least there.
+	schemaVersion int64
+	// draining is set once shutdown has started, see Drain. It is a pointer
+	// because some handlers take BaseHandler by value.
+	draining *atomic.Bool
+	// background tracks mail sent after the response, see Wait.
+	background *sync.WaitGroup
 }
 
-func NewBaseHandler(db *pgx.Conn) *BaseHandler {
+func NewBaseHandler(db *pgxpool.Pool, tokens *auth.TokenService, oidcProvider *oidc.Provider, mailer mail.Mailer, publicURL string, retention time.Duration, schemaVersion int64) *BaseHandler {
 	return &BaseHandler{
-		db: db,
+		db:            db,
+		services:      service.New(repository.NewPostgres(db)),
+		tokens:        tokens,
+		oidcProvider:  oidcProvider,
+		mailer:        mailer,
+		publicURL:     publicURL,
+		retention:     retention,
+		schemaVersion: schemaVersion,
+		draining:      &atomic.Bool{},
+		background:    &sync.WaitGroup{},
 	}
 }
+
+// Wait blocks until the work handlers started in the background, such as
+// sending mail, has finished. Call it after the server has shut down.
+func (h *BaseHandler) Wait() {
+	h.background.Wait()
+}
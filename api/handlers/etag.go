@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	stderrors "errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/joseph-gunnarsson/scheduling/api/errors"
+	"github.com/joseph-gunnarsson/scheduling/internals/service"
+)
+
+var errVersionChanged = errors.PreconditionFailedError{Message: "The resource was changed since it was read, fetch it again"}
+
+// etag is the strong entity tag of a row version.
+func etag(version int32) string {
+	return `"` + strconv.Itoa(int(version)) + `"`
+}
+
+// checkIfMatch compares the If-Match header with the current version of a
+// row. The version it returns is passed on to the update query so a change
+// made in between still fails there.
+func checkIfMatch(r *http.Request, version int32) (pgtype.Int4, error) {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return pgtype.Int4{}, errors.PreconditionRequiredError{Message: "If-Match header with the ETag of the resource is required"}
+	}
+
+	current := etag(version)
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || tag == current {
+			return pgtype.Int4{Int32: version, Valid: true}, nil
+		}
+	}
+	return pgtype.Int4{}, errVersionChanged
+}
+
+// ifMatch checks the If-Match header of r inside a service call.
+func ifMatch(r *http.Request) service.Precondition {
+	return func(version int32) (pgtype.Int4, error) {
+		return checkIfMatch(r, version)
+	}
+}
+
+// versionConflict turns the missing row of a version checked update into a
+// 412. Only use it after the row was read in the same transaction.
+func versionConflict(err error) error {
+	if stderrors.Is(err, pgx.ErrNoRows) {
+		return errVersionChanged
+	}
+	return err
+}
+
+// serviceError turns service.ErrVersionChanged into a 412.
+func serviceError(err error) error {
+	if stderrors.Is(err, service.ErrVersionChanged) {
+		return errVersionChanged
+	}
+	return err
+}
@@ -1,13 +1,15 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
-	"log"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/joseph-gunnarsson/scheduling/api/errors"
+	"github.com/joseph-gunnarsson/scheduling/api/middleware"
 	db "github.com/joseph-gunnarsson/scheduling/db/models"
 )
 
@@ -21,37 +23,90 @@ func (h BaseHandler) CreateGroupHandler(rw http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	query := db.New(h.db)
-	group, err := query.CreateGroup(r.Context(), newGroup)
-	log.Printf("%d", newGroup.OwnerID.Int32)
+	group, err := h.services.CreateGroup(r.Context(), requestActor(r), newGroup, h.canCreateUnder)
 	if err != nil {
 		errors.HandleError(rw, err)
 		return
 	}
 
+	rw.Header().Set("ETag", etag(group.Version))
 	rw.Header().Set("Content-Type", "application/json")
 	rw.WriteHeader(http.StatusCreated)
 	json.NewEncoder(rw).Encode(group)
 }
 
+// canCreateUnder checks that the logged in user manages the parent group and
+// meets its two-factor requirement.
+func (h BaseHandler) canCreateUnder(ctx context.Context, parentID int32) error {
+	query := db.New(h.db)
+	user := ctx.Value(middleware.UserKey).(db.User)
+	canManage, err := query.UserCanManageGroup(ctx, db.UserCanManageGroupParams{
+		GroupID: parentID,
+		UserID:  user.ID,
+	})
+	if err != nil {
+		return err
+	}
+	if !canManage {
+		return errors.UnauthorizedError{Message: "User cannot create groups under the parent group"}
+	}
+
+	requiresTwoFactor, err := query.GroupRequiresManagerTwoFactor(ctx, parentID)
+	if err != nil {
+		return err
+	}
+	if requiresTwoFactor {
+		satisfied, err := middleware.ManagerTwoFactorSatisfied(ctx, query, user)
+		if err != nil {
+			return err
+		}
+		if !satisfied {
+			return errors.ForbiddenError{Message: "The parent group requires managers to log in with two-factor authentication"}
+		}
+	}
+	return nil
+}
+
+func (h *BaseHandler) GetGroupHandler(rw http.ResponseWriter, r *http.Request) {
+	groupID, err := parsePathID(r, "id")
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	group, err := h.services.GetGroup(r.Context(), groupID)
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	rw.Header().Set("ETag", etag(group.Version))
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	json.NewEncoder(rw).Encode(group)
+}
+
 func (h BaseHandler) DeleteGroupHandler(rw http.ResponseWriter, r *http.Request) {
 	groupIDstr := r.PathValue("id")
 
 	groupID, err := strconv.ParseInt(groupIDstr, 10, 32)
 	if err != nil {
 		errors.HandleError(rw, errors.ValidationError{Message: "Invalid or missing group id."})
+		return
 	}
 
-	query := db.New(h.db)
-	err = query.DeleteGroup(r.Context(), int32(groupID))
-
+	deleted, err := h.services.DeleteGroup(r.Context(), requestActor(r), int32(groupID), ifMatch(r))
 	if err != nil {
-		errors.HandleError(rw, err)
+		errors.HandleError(rw, serviceError(err))
+		return
 	}
 
 	rw.Header().Set("Content-Type", "application/json")
-	rw.WriteHeader(http.StatusCreated)
-	json.NewEncoder(rw).Encode(map[string]string{"message": "Deleted group successfully"})
+	rw.WriteHeader(http.StatusOK)
+	json.NewEncoder(rw).Encode(map[string]string{
+		"message":          "Deleted group successfully",
+		"restorable_until": deleted.DeletedAt.Time.Add(h.retention).Format(time.RFC3339),
+	})
 }
 
 func (h *BaseHandler) UpdateGroupHandler(rw http.ResponseWriter, r *http.Request) {
@@ -70,13 +125,13 @@ func (h *BaseHandler) UpdateGroupHandler(rw http.ResponseWriter, r *http.Request
 	}
 	updateGroup.ID = int32(groupID)
 
-	query := db.New(h.db)
-	group, err := query.UpdateGroup(r.Context(), updateGroup)
+	group, err := h.services.UpdateGroup(r.Context(), requestActor(r), updateGroup, ifMatch(r))
 	if err != nil {
-		errors.HandleError(rw, err)
+		errors.HandleError(rw, serviceError(err))
 		return
 	}
 
+	rw.Header().Set("ETag", etag(group.Version))
 	rw.Header().Set("Content-Type", "application/json")
 	rw.WriteHeader(http.StatusOK)
 	json.NewEncoder(rw).Encode(group)
@@ -97,24 +152,21 @@ func (h *BaseHandler) PatchGroupHandler(rw http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	query := db.New(h.db)
-	var patchGroup db.PatchGroupParams
-	patchGroup.ID = int32(groupID)
-
+	patchGroup := db.PatchGroupParams{ID: int32(groupID)}
 	if name, ok := patchData["name"].(string); ok {
 		patchGroup.Name = pgtype.Text{String: name, Valid: true}
 	}
-
 	if description, ok := patchData["description"].(string); ok {
 		patchGroup.Description = pgtype.Text{String: description, Valid: true}
 	}
 
-	group, err := query.PatchGroup(r.Context(), patchGroup)
+	group, err := h.services.PatchGroup(r.Context(), requestActor(r), patchGroup, ifMatch(r))
 	if err != nil {
-		errors.HandleError(rw, err)
+		errors.HandleError(rw, serviceError(err))
 		return
 	}
 
+	rw.Header().Set("ETag", etag(group.Version))
 	rw.Header().Set("Content-Type", "application/json")
 	rw.WriteHeader(http.StatusOK)
 	json.NewEncoder(rw).Encode(group)
@@ -128,11 +180,7 @@ func (h *BaseHandler) GetGroupsByOwnerHandler(rw http.ResponseWriter, r *http.Re
 		return
 	}
 
-	query := db.New(h.db)
-
-	groups, err := query.GetGroupsByOwner(r.Context(), pgtype.Int4{Int32: int32(ownerID),
-		Valid: true,
-	})
+	groups, err := h.services.ListOwnedGroups(r.Context(), int32(ownerID))
 	if err != nil {
 		errors.HandleError(rw, err)
 		return
@@ -151,8 +199,7 @@ func (h *BaseHandler) AddUserToGroupHandler(rw http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	query := db.New(h.db)
-	userGroup, err := query.AddUserToGroup(r.Context(), addUserToGroup)
+	userGroup, err := h.services.AddMember(r.Context(), requestActor(r), addUserToGroup)
 	if err != nil {
 		errors.HandleError(rw, err)
 		return
@@ -176,11 +223,11 @@ func (h *BaseHandler) DeleteUserFromGroupHandler(rw http.ResponseWriter, r *http
 		return
 	}
 
-	query := db.New(h.db)
-	err = query.DeleteUserFromGroup(r.Context(), db.DeleteUserFromGroupParams{
+	membership := db.DeleteUserFromGroupParams{
 		UserID:  int32(userID),
 		GroupID: int32(groupID),
-	})
+	}
+	err = h.services.RemoveMember(r.Context(), requestActor(r), membership)
 	if err != nil {
 		errors.HandleError(rw, err)
 		return
@@ -199,8 +246,7 @@ func (h *BaseHandler) GetUserGroupsHandler(rw http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	query := db.New(h.db)
-	userGroups, err := query.GetUserGroups(r.Context(), int32(userID))
+	userGroups, err := h.services.ListUserGroups(r.Context(), int32(userID))
 	if err != nil {
 		errors.HandleError(rw, err)
 		return
@@ -219,8 +265,7 @@ func (h *BaseHandler) GetGroupMembersHandler(rw http.ResponseWriter, r *http.Req
 		return
 	}
 
-	query := db.New(h.db)
-	groupMembers, err := query.GetGroupMembers(r.Context(), int32(groupID))
+	groupMembers, err := h.services.ListGroupMembers(r.Context(), int32(groupID))
 	if err != nil {
 		errors.HandleError(rw, err)
 		return
@@ -0,0 +1,248 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/joseph-gunnarsson/scheduling/api/middleware"
+	db "github.com/joseph-gunnarsson/scheduling/db/models"
+	"github.com/joseph-gunnarsson/scheduling/internals/repository"
+	"github.com/joseph-gunnarsson/scheduling/internals/repository/repositorytest"
+	"github.com/joseph-gunnarsson/scheduling/internals/service"
+)
+
+func newTestHandler(t *testing.T) (*BaseHandler, *repository.Memory) {
+	t.Helper()
+	store := repository.NewMemory()
+	return &BaseHandler{services: service.New(store), retention: 24 * time.Hour}, store
+}
+
+// newRequest builds a request as the middleware would hand it over, with
+// the path values set and user logged in.
+func newRequest(method, target, body string, user db.User, pathValues map[string]string) *http.Request {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	for name, value := range pathValues {
+		r.SetPathValue(name, value)
+	}
+	return r.WithContext(context.WithValue(r.Context(), middleware.UserKey, user))
+}
+
+func id(v int32) string {
+	return strconv.Itoa(int(v))
+}
+
+func TestCreateGroupHandler(t *testing.T) {
+	h, store := newTestHandler(t)
+	alice := repositorytest.CreateUser(t, store, "alice")
+
+	rw := httptest.NewRecorder()
+	h.CreateGroupHandler(rw, newRequest(http.MethodPost, "/group/", `{"name": "Kitchen", "owner_id": `+id(alice.ID)+`}`, alice, nil))
+	if rw.Code != http.StatusCreated {
+		t.Fatalf("status = %d, want 201: %s", rw.Code, rw.Body)
+	}
+	var group db.Group
+	err := json.NewDecoder(rw.Body).Decode(&group)
+	if err != nil || group.Name != "Kitchen" {
+		t.Fatalf("response = %+v, %v, want the new group", group, err)
+	}
+	if got := rw.Header().Get("ETag"); got != etag(group.Version) {
+		t.Fatalf("ETag = %q, want %q", got, etag(group.Version))
+	}
+
+	events := store.AuditEvents()
+	if len(events) != 1 || events[0].Action != "group.create" || events[0].EntityID.Int32 != group.ID {
+		t.Fatalf("audit events = %+v, want one group.create", events)
+	}
+
+	rw = httptest.NewRecorder()
+	h.CreateGroupHandler(rw, newRequest(http.MethodPost, "/group/", `{`, alice, nil))
+	if rw.Code != http.StatusBadRequest {
+		t.Fatalf("status of an invalid body = %d, want 400", rw.Code)
+	}
+}
+
+func TestGetGroupHandler(t *testing.T) {
+	h, store := newTestHandler(t)
+	alice := repositorytest.CreateUser(t, store, "alice")
+	group := repositorytest.CreateGroup(t, store, alice, "Kitchen")
+
+	rw := httptest.NewRecorder()
+	h.GetGroupHandler(rw, newRequest(http.MethodGet, "/group/", "", alice, map[string]string{"id": id(group.ID)}))
+	if rw.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rw.Code, rw.Body)
+	}
+	if got := rw.Header().Get("ETag"); got != etag(group.Version) {
+		t.Fatalf("ETag = %q, want %q", got, etag(group.Version))
+	}
+
+	rw = httptest.NewRecorder()
+	h.GetGroupHandler(rw, newRequest(http.MethodGet, "/group/", "", alice, map[string]string{"id": id(group.ID + 100)}))
+	if rw.Code != http.StatusNotFound {
+		t.Fatalf("status of a missing group = %d, want 404", rw.Code)
+	}
+}
+
+func TestUpdateGroupHandlerChecksVersion(t *testing.T) {
+	h, store := newTestHandler(t)
+	alice := repositorytest.CreateUser(t, store, "alice")
+	group := repositorytest.CreateGroup(t, store, alice, "Kitchen")
+	path := map[string]string{"id": id(group.ID)}
+	body := `{"name": "Main kitchen"}`
+
+	tests := []struct {
+		name    string
+		ifMatch string
+		status  int
+	}{
+		{"missing If-Match", "", http.StatusPreconditionRequired},
+		{"stale ETag", etag(group.Version + 1), http.StatusPreconditionFailed},
+		{"current ETag", etag(group.Version), http.StatusOK},
+		{"ETag of the replaced version", etag(group.Version), http.StatusPreconditionFailed},
+	}
+	for _, tt := range tests {
+		r := newRequest(http.MethodPut, "/group/", body, alice, path)
+		if tt.ifMatch != "" {
+			r.Header.Set("If-Match", tt.ifMatch)
+		}
+		rw := httptest.NewRecorder()
+		h.UpdateGroupHandler(rw, r)
+		if rw.Code != tt.status {
+			t.Fatalf("%s: status = %d, want %d: %s", tt.name, rw.Code, tt.status, rw.Body)
+		}
+	}
+
+	updated, err := store.GetGroupByID(context.Background(), group.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Name != "Main kitchen" || updated.Version != group.Version+1 {
+		t.Fatalf("group after update = %+v", updated)
+	}
+
+	events := store.AuditEvents()
+	if len(events) != 1 || events[0].Action != "group.update" || events[0].ActorID.Int32 != alice.ID {
+		t.Fatalf("audit events = %+v, want one group.update by alice", events)
+	}
+}
+
+func TestDeleteGroupHandlerDeletesShifts(t *testing.T) {
+	h, store := newTestHandler(t)
+	ctx := context.Background()
+	alice := repositorytest.CreateUser(t, store, "alice")
+	group := repositorytest.CreateGroup(t, store, alice, "Kitchen")
+	shift, err := store.CreateShift(ctx, db.CreateShiftParams{
+		GroupID:   pgtype.Int4{Int32: group.ID, Valid: true},
+		Name:      "Morning",
+		StartTime: pgtype.Timestamptz{Time: time.Now(), Valid: true},
+		EndTime:   pgtype.Timestamptz{Time: time.Now().Add(time.Hour), Valid: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := newRequest(http.MethodDelete, "/group/", "", alice, map[string]string{"id": id(group.ID)})
+	r.Header.Set("If-Match", "*")
+	rw := httptest.NewRecorder()
+	h.DeleteGroupHandler(rw, r)
+	if rw.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rw.Code, rw.Body)
+	}
+
+	var response map[string]string
+	err = json.NewDecoder(rw.Body).Decode(&response)
+	if err != nil || response["restorable_until"] == "" {
+		t.Fatalf("response = %v, %v, want restorable_until", response, err)
+	}
+	_, err = store.GetGroupByID(ctx, group.ID)
+	if err == nil {
+		t.Fatal("group is still live after delete")
+	}
+	_, err = store.GetShiftByID(ctx, shift.ID)
+	if err == nil {
+		t.Fatal("shift is still live after its group was deleted")
+	}
+}
+
+func TestMembershipHandlers(t *testing.T) {
+	h, store := newTestHandler(t)
+	alice := repositorytest.CreateUser(t, store, "alice")
+	bob := repositorytest.CreateUser(t, store, "bob")
+	group := repositorytest.CreateGroup(t, store, alice, "Kitchen")
+	body := `{"user_id": ` + id(bob.ID) + `, "group_id": ` + id(group.ID) + `}`
+
+	rw := httptest.NewRecorder()
+	h.AddUserToGroupHandler(rw, newRequest(http.MethodPost, "/group/members/", body, alice, nil))
+	if rw.Code != http.StatusCreated {
+		t.Fatalf("add: status = %d, want 201: %s", rw.Code, rw.Body)
+	}
+
+	rw = httptest.NewRecorder()
+	h.AddUserToGroupHandler(rw, newRequest(http.MethodPost, "/group/members/", body, alice, nil))
+	if rw.Code != http.StatusConflict {
+		t.Fatalf("add twice: status = %d, want 409: %s", rw.Code, rw.Body)
+	}
+
+	rw = httptest.NewRecorder()
+	h.GetGroupMembersHandler(rw, newRequest(http.MethodGet, "/group/members/", "", alice, map[string]string{"group_id": id(group.ID)}))
+	var members []db.GetGroupMembersRow
+	err := json.NewDecoder(rw.Body).Decode(&members)
+	if err != nil || len(members) != 1 || members[0].UserID != bob.ID {
+		t.Fatalf("members = %+v, %v, want bob", members, err)
+	}
+
+	rw = httptest.NewRecorder()
+	h.DeleteUserFromGroupHandler(rw, newRequest(http.MethodDelete, "/group/members/", "", alice, map[string]string{
+		"user_id":  id(bob.ID),
+		"group_id": id(group.ID),
+	}))
+	if rw.Code != http.StatusOK {
+		t.Fatalf("remove: status = %d, want 200: %s", rw.Code, rw.Body)
+	}
+
+	var actions []string
+	for _, event := range store.AuditEvents() {
+		actions = append(actions, event.Action)
+	}
+	if strings.Join(actions, ",") != "membership.add,membership.remove" {
+		t.Fatalf("audit actions = %v, the failed add must not be recorded", actions)
+	}
+}
+
+func TestDeleteShiftHandlerStaysInGroup(t *testing.T) {
+	h, store := newTestHandler(t)
+	alice := repositorytest.CreateUser(t, store, "alice")
+	kitchen := repositorytest.CreateGroup(t, store, alice, "Kitchen")
+	floor := repositorytest.CreateGroup(t, store, alice, "Floor")
+	shift, err := store.CreateShift(context.Background(), db.CreateShiftParams{
+		GroupID:   pgtype.Int4{Int32: kitchen.ID, Valid: true},
+		Name:      "Morning",
+		StartTime: pgtype.Timestamptz{Time: time.Now(), Valid: true},
+		EndTime:   pgtype.Timestamptz{Time: time.Now().Add(time.Hour), Valid: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := newRequest(http.MethodDelete, "/group/shifts/", "", alice, map[string]string{"id": id(floor.ID), "shiftID": id(shift.ID)})
+	r.Header.Set("If-Match", "*")
+	rw := httptest.NewRecorder()
+	h.DeleteShiftHandler(rw, r)
+	if rw.Code != http.StatusNotFound {
+		t.Fatalf("delete through another group: status = %d, want 404", rw.Code)
+	}
+
+	r = newRequest(http.MethodDelete, "/group/shifts/", "", alice, map[string]string{"id": id(kitchen.ID), "shiftID": id(shift.ID)})
+	r.Header.Set("If-Match", etag(shift.Version))
+	rw = httptest.NewRecorder()
+	h.DeleteShiftHandler(rw, r)
+	if rw.Code != http.StatusOK {
+		t.Fatalf("delete: status = %d, want 200: %s", rw.Code, rw.Body)
+	}
+}
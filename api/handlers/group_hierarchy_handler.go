@@ -0,0 +1,247 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/joseph-gunnarsson/scheduling/api/errors"
+	"github.com/joseph-gunnarsson/scheduling/api/middleware"
+	db "github.com/joseph-gunnarsson/scheduling/db/models"
+)
+
+const defaultScheduleWindow = 7 * 24 * time.Hour
+
+var errGroupCycle = errors.ValidationError{Message: "A group cannot be nested under itself or one of its descendants"}
+
+func (h *BaseHandler) SetGroupParentHandler(rw http.ResponseWriter, r *http.Request) {
+	groupID, err := strconv.ParseInt(r.PathValue("id"), 10, 32)
+	if err != nil {
+		errors.HandleError(rw, errors.ValidationError{Message: "Invalid group id"})
+		return
+	}
+
+	var setParent struct {
+		ParentID pgtype.Int4 `json:"parent_id"`
+	}
+	err = json.NewDecoder(r.Body).Decode(&setParent)
+	if err != nil {
+		errors.HandleError(rw, errors.ValidationError{Message: "Invalid request body"})
+		return
+	}
+
+	tx, err := h.db.Begin(r.Context())
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+	defer tx.Rollback(r.Context())
+	query := db.New(h.db).WithTx(tx)
+
+	if setParent.ParentID.Valid {
+		user := r.Context().Value(middleware.UserKey).(db.User)
+		canManage, err := query.UserCanManageGroup(r.Context(), db.UserCanManageGroupParams{
+			GroupID: setParent.ParentID.Int32,
+			UserID:  user.ID,
+		})
+		if err != nil {
+			errors.HandleError(rw, err)
+			return
+		}
+		if !canManage {
+			errors.HandleError(rw, errors.UnauthorizedError{Message: "User cannot move groups under the parent group"})
+			return
+		}
+
+		inSubtree, err := query.IsGroupInSubtree(r.Context(), db.IsGroupInSubtreeParams{
+			RootID:  int32(groupID),
+			GroupID: setParent.ParentID.Int32,
+		})
+		if err != nil {
+			errors.HandleError(rw, err)
+			return
+		}
+		if inSubtree {
+			errors.HandleError(rw, errGroupCycle)
+			return
+		}
+	}
+
+	before, err := query.GetGroupByID(r.Context(), int32(groupID))
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	expectedVersion, err := checkIfMatch(r, before.Version)
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	group, err := query.SetGroupParent(r.Context(), db.SetGroupParentParams{
+		ID:              int32(groupID),
+		ParentID:        setParent.ParentID,
+		ExpectedVersion: expectedVersion,
+	})
+	// The trigger catches a move committed since the check above.
+	if pgErr, ok := err.(*pgconn.PgError); ok && pgErr.Code == "23514" {
+		errors.HandleError(rw, errGroupCycle)
+		return
+	}
+	if err != nil {
+		errors.HandleError(rw, versionConflict(err))
+		return
+	}
+
+	err = recordAudit(r, query, auditEvent{
+		Action:     "group.move",
+		EntityType: auditEntityGroup,
+		EntityID:   group.ID,
+		GroupID:    group.ID,
+		Before:     before,
+		After:      group,
+	})
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	err = tx.Commit(r.Context())
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	rw.Header().Set("ETag", etag(group.Version))
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	json.NewEncoder(rw).Encode(group)
+}
+
+func (h *BaseHandler) GetGroupSubtreeHandler(rw http.ResponseWriter, r *http.Request) {
+	groupID, err := strconv.ParseInt(r.PathValue("id"), 10, 32)
+	if err != nil {
+		errors.HandleError(rw, errors.ValidationError{Message: "Invalid group id"})
+		return
+	}
+
+	query := db.New(h.db)
+	groups, err := query.GetGroupSubtree(r.Context(), int32(groupID))
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	json.NewEncoder(rw).Encode(groups)
+}
+
+func (h *BaseHandler) GetSubtreeMembersHandler(rw http.ResponseWriter, r *http.Request) {
+	groupID, err := strconv.ParseInt(r.PathValue("id"), 10, 32)
+	if err != nil {
+		errors.HandleError(rw, errors.ValidationError{Message: "Invalid group id"})
+		return
+	}
+
+	query := db.New(h.db)
+	members, err := query.GetSubtreeMembers(r.Context(), int32(groupID))
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	json.NewEncoder(rw).Encode(members)
+}
+
+func (h *BaseHandler) GetSubtreeShiftsHandler(rw http.ResponseWriter, r *http.Request) {
+	groupID, err := strconv.ParseInt(r.PathValue("id"), 10, 32)
+	if err != nil {
+		errors.HandleError(rw, errors.ValidationError{Message: "Invalid group id"})
+		return
+	}
+
+	rangeStart, rangeEnd, err := parseTimeRange(r)
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	query := db.New(h.db)
+	shifts, err := query.ListShiftsBySubtree(r.Context(), db.ListShiftsBySubtreeParams{
+		RootID:     int32(groupID),
+		RangeStart: rangeStart,
+		RangeEnd:   rangeEnd,
+	})
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	json.NewEncoder(rw).Encode(shifts)
+}
+
+func (h *BaseHandler) GetSubtreeCoverageHandler(rw http.ResponseWriter, r *http.Request) {
+	groupID, err := strconv.ParseInt(r.PathValue("id"), 10, 32)
+	if err != nil {
+		errors.HandleError(rw, errors.ValidationError{Message: "Invalid group id"})
+		return
+	}
+
+	rangeStart, rangeEnd, err := parseTimeRange(r)
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	query := db.New(h.db)
+	coverage, err := query.GetSubtreeCoverage(r.Context(), db.GetSubtreeCoverageParams{
+		RootID:     int32(groupID),
+		RangeStart: rangeStart,
+		RangeEnd:   rangeEnd,
+	})
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	json.NewEncoder(rw).Encode(coverage)
+}
+
+// parseTimeRange reads the optional RFC 3339 "from" and "to" query parameters.
+// Without them the range covers the next seven days.
+func parseTimeRange(r *http.Request) (pgtype.Timestamptz, pgtype.Timestamptz, error) {
+	from := time.Now()
+	if v := r.URL.Query().Get("from"); v != "" {
+		parsed, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return pgtype.Timestamptz{}, pgtype.Timestamptz{}, errors.ValidationError{Message: "Invalid from time, expected RFC 3339"}
+		}
+		from = parsed
+	}
+
+	to := from.Add(defaultScheduleWindow)
+	if v := r.URL.Query().Get("to"); v != "" {
+		parsed, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return pgtype.Timestamptz{}, pgtype.Timestamptz{}, errors.ValidationError{Message: "Invalid to time, expected RFC 3339"}
+		}
+		to = parsed
+	}
+
+	if !to.After(from) {
+		return pgtype.Timestamptz{}, pgtype.Timestamptz{}, errors.ValidationError{Message: "to must be after from"}
+	}
+
+	return pgtype.Timestamptz{Time: from, Valid: true}, pgtype.Timestamptz{Time: to, Valid: true}, nil
+}
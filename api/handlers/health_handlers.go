@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/joseph-gunnarsson/scheduling/db/migrations"
+)
+
+// notReady is the reason ReadyHandler gives for any database failure. The
+// details are logged, the probe is not authenticated.
+const notReady = "database not ready"
+
+// readyTimeout bounds the database checks of ReadyHandler so a hung
+// database fails the probe instead of stalling it.
+const readyTimeout = 2 * time.Second
+
+type healthResponse struct {
+	Status        string `json:"status"`
+	SchemaVersion int64  `json:"schema_version,omitempty"`
+	Reason        string `json:"reason,omitempty"`
+}
+
+// Drain makes ReadyHandler fail from now on, so the load balancer stops
+// sending requests before the server shuts down.
+func (h *BaseHandler) Drain() {
+	h.draining.Store(true)
+}
+
+// HealthHandler tells the orchestrator the process is alive. It doesn't
+// touch the database, restarting the server would not fix an outage there.
+func (h *BaseHandler) HealthHandler(rw http.ResponseWriter, r *http.Request) {
+	writeHealth(rw, http.StatusOK, healthResponse{Status: "ok"})
+}
+
+// ReadyHandler tells the orchestrator whether to send traffic here: the
+// database answers and has every migration this build needs. A database
+// ahead of the build is fine, that is an older server during a deploy.
+func (h *BaseHandler) ReadyHandler(rw http.ResponseWriter, r *http.Request) {
+	if h.draining.Load() {
+		writeHealth(rw, http.StatusServiceUnavailable, healthResponse{Status: "unavailable", Reason: "shutting down"})
+		return
+	}
+
+	version, err := h.checkReady(r.Context())
+	if err != nil {
+		slog.WarnContext(r.Context(), "Readiness check failed", "error", err)
+		writeHealth(rw, http.StatusServiceUnavailable, healthResponse{Status: "unavailable", SchemaVersion: version, Reason: notReady})
+		return
+	}
+	writeHealth(rw, http.StatusOK, healthResponse{Status: "ok", SchemaVersion: version})
+}
+
+func (h *BaseHandler) checkReady(ctx context.Context) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, readyTimeout)
+	defer cancel()
+
+	err := h.db.Ping(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("database unreachable: %w", err)
+	}
+	version, dirty, err := migrations.ReadVersion(ctx, h.db)
+	switch {
+	case err != nil:
+		return 0, fmt.Errorf("reading the schema version: %w", err)
+	case dirty:
+		return version, fmt.Errorf("migration %d is dirty", version)
+	case version < h.schemaVersion:
+		return version, fmt.Errorf("database is at migration %d, this build needs %d", version, h.schemaVersion)
+	}
+	return version, nil
+}
+
+func writeHealth(rw http.ResponseWriter, status int, response healthResponse) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Set("Cache-Control", "no-store")
+	rw.WriteHeader(status)
+	json.NewEncoder(rw).Encode(response)
+}
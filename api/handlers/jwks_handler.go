@@ -0,0 +1,15 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// JWKSHandler publishes the public signing keys so other services can verify
+// our access tokens without sharing a secret.
+func (h *BaseHandler) JWKSHandler(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Set("Cache-Control", "public, max-age=300")
+	rw.WriteHeader(http.StatusOK)
+	json.NewEncoder(rw).Encode(h.tokens.JWKS())
+}
@@ -0,0 +1,232 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/joseph-gunnarsson/scheduling/api/errors"
+	"github.com/joseph-gunnarsson/scheduling/api/middleware"
+	db "github.com/joseph-gunnarsson/scheduling/db/models"
+	"github.com/joseph-gunnarsson/scheduling/internals/auth"
+	"github.com/joseph-gunnarsson/scheduling/internals/mail"
+)
+
+const (
+	// ipFailureWindow is how far back failed logins from an address count.
+	ipFailureWindow     = 15 * time.Minute
+	accountUnlockTTL    = 24 * time.Hour
+	maxIdentifierLength = 100
+	loginHistoryLimit   = 50
+)
+
+var (
+	accountLockout = auth.DefaultAccountLockout
+	ipThrottle     = auth.DefaultIPThrottle
+)
+
+// clientIP returns the address without the port so attempts from the same
+// host are counted together.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func (h *BaseHandler) checkIPThrottle(ctx context.Context, query *db.Queries, ip string) error {
+	now := time.Now()
+	stats, err := query.GetIPFailureStats(ctx, db.GetIPFailureStatsParams{
+		IpAddress: ip,
+		CreatedAt: pgtype.Timestamptz{Time: now.Add(-ipFailureWindow), Valid: true},
+	})
+	if err != nil {
+		return err
+	}
+	if !stats.LastFailedAt.Valid {
+		return nil
+	}
+
+	blockedUntil := ipThrottle.BlockedUntil(int(stats.Failures), stats.LastFailedAt.Time)
+	if now.Before(blockedUntil) {
+		return errors.TooManyRequestsError{
+			Message:    "Too many failed logins from this address, try again later",
+			RetryAfter: blockedUntil.Sub(now),
+		}
+	}
+	return nil
+}
+
+func checkAccountLock(failures int32, lockedUntil pgtype.Timestamptz) error {
+	now := time.Now()
+	if !lockedUntil.Valid || !now.Before(lockedUntil.Time) {
+		return nil
+	}
+
+	message := "Too many failed logins for this account, try again later"
+	if accountLockout.Locked(int(failures)) {
+		message = "Account is locked after too many failed logins, use the unlock link sent by email or try again later"
+	}
+	return errors.TooManyRequestsError{Message: message, RetryAfter: lockedUntil.Time.Sub(now)}
+}
+
+// recordLoginAttempt writes the audit record. userID is zero when the
+// identifier did not match an account. For successes reason is the login
+// method, for failures why it failed.
+func recordLoginAttempt(r *http.Request, query *db.Queries, identifier string, userID int32, succeeded bool, reason string) error {
+	if len(identifier) > maxIdentifierLength {
+		identifier = identifier[:maxIdentifierLength]
+	}
+	return query.CreateLoginAttempt(r.Context(), db.CreateLoginAttemptParams{
+		Identifier: identifier,
+		UserID:     pgtype.Int4{Int32: userID, Valid: userID != 0},
+		IpAddress:  clientIP(r),
+		UserAgent:  pgtype.Text{String: r.UserAgent(), Valid: r.UserAgent() != ""},
+		Succeeded:  succeeded,
+		Reason:     pgtype.Text{String: reason, Valid: reason != ""},
+	})
+}
+
+// recordLoginFailure audits the attempt and counts it against the account,
+// locking it once the policy says so. The unlock email goes out exactly when
+// the hard lockout starts.
+func (h *BaseHandler) recordLoginFailure(r *http.Request, identifier string, userID int32, email string, reason string) error {
+	query := db.New(h.db)
+	err := recordLoginAttempt(r, query, identifier, userID, false, reason)
+	if err != nil || userID == 0 {
+		return err
+	}
+
+	failures, err := query.RecordFailedLogin(r.Context(), userID)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	blockedUntil := accountLockout.BlockedUntil(int(failures), now)
+	if blockedUntil.After(now) {
+		err = query.LockUser(r.Context(), db.LockUserParams{
+			ID:          userID,
+			LockedUntil: pgtype.Timestamptz{Time: blockedUntil, Valid: true},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if int(failures) == accountLockout.LockoutThreshold {
+		return h.sendUnlockEmail(r.Context(), userID, email)
+	}
+	return nil
+}
+
+func (h *BaseHandler) recordLoginSuccess(r *http.Request, identifier string, userID int32, method string) error {
+	query := db.New(h.db)
+	err := recordLoginAttempt(r, query, identifier, userID, true, method)
+	if err != nil {
+		return err
+	}
+	return query.UnlockUser(r.Context(), userID)
+}
+
+func (h *BaseHandler) sendUnlockEmail(ctx context.Context, userID int32, email string) error {
+	token, err := issueUserToken(ctx, db.New(h.db), userID, tokenPurposeAccountUnlock, email, accountUnlockTTL)
+	if err != nil {
+		return err
+	}
+
+	h.sendMailAsync(ctx, mail.Message{
+		To:      email,
+		Subject: "Your account has been locked",
+		Body: "There were too many failed attempts to log in to your account, so it has been locked for " +
+			accountLockout.LockoutDuration.String() + ".\n\n" +
+			"If this was you, you can unlock it right away with the link below.\n\n" +
+			h.publicLink("/unlock-account", token) + "\n\n" +
+			"If it was not you, consider resetting your password.\n",
+	})
+	return nil
+}
+
+func (h *BaseHandler) UnlockAccountHandler(rw http.ResponseWriter, r *http.Request) {
+	var unlockRequest struct {
+		Token string `json:"token"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&unlockRequest)
+	if err != nil || unlockRequest.Token == "" {
+		errors.HandleError(rw, errors.ValidationError{Message: "Invalid request body"})
+		return
+	}
+
+	tx, err := h.db.Begin(r.Context())
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+	defer tx.Rollback(r.Context())
+	query := db.New(h.db).WithTx(tx)
+
+	token, err := query.ConsumeUserToken(r.Context(), db.ConsumeUserTokenParams{
+		TokenHash: auth.HashToken(unlockRequest.Token),
+		Purpose:   tokenPurposeAccountUnlock,
+	})
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			errors.HandleError(rw, errors.ValidationError{Message: "Invalid or expired unlock token"})
+		} else {
+			errors.HandleError(rw, err)
+		}
+		return
+	}
+
+	err = query.UnlockUser(r.Context(), token.UserID)
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	err = recordAudit(r, query, auditEvent{
+		Action:     "user.unlock",
+		EntityType: auditEntityUser,
+		EntityID:   token.UserID,
+		ActorID:    token.UserID,
+	})
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	err = tx.Commit(r.Context())
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	json.NewEncoder(rw).Encode(map[string]string{"message": "Account unlocked"})
+}
+
+// LoginHistoryHandler lists recent login attempts against the caller's
+// account so they can spot someone guessing their password.
+func (h *BaseHandler) LoginHistoryHandler(rw http.ResponseWriter, r *http.Request) {
+	user := r.Context().Value(middleware.UserKey).(db.User)
+
+	query := db.New(h.db)
+	attempts, err := query.ListUserLoginAttempts(r.Context(), db.ListUserLoginAttemptsParams{
+		UserID: pgtype.Int4{Int32: user.ID, Valid: true},
+		Limit:  loginHistoryLimit,
+	})
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	json.NewEncoder(rw).Encode(attempts)
+}
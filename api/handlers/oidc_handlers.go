@@ -0,0 +1,346 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/joseph-gunnarsson/scheduling/api/errors"
+	"github.com/joseph-gunnarsson/scheduling/api/middleware"
+	db "github.com/joseph-gunnarsson/scheduling/db/models"
+	"github.com/joseph-gunnarsson/scheduling/internals/auth"
+	"github.com/joseph-gunnarsson/scheduling/internals/oidc"
+)
+
+const oidcLoginStateTTL = 10 * time.Minute
+
+// oidcBrowserCookie holds a nonce that ties the state to the browser that
+// started the flow, so a callback URL cannot be replayed in someone else's
+// browser to log them in or link an identity to their account.
+const oidcBrowserCookie = "oidc_browser"
+
+// OIDCLoginHandler starts an authorization code login and redirects the
+// browser to the identity provider.
+func (h *BaseHandler) OIDCLoginHandler(rw http.ResponseWriter, r *http.Request) {
+	if h.oidcProvider == nil {
+		errors.HandleError(rw, errors.NotFoundError{Message: "OIDC login is not configured"})
+		return
+	}
+
+	authURL, err := h.beginOIDCFlow(rw, r, pgtype.Int4{})
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	http.Redirect(rw, r, authURL, http.StatusFound)
+}
+
+// OIDCLinkHandler starts an authorization code flow that links the external
+// identity to the logged in user instead of logging in.
+func (h *BaseHandler) OIDCLinkHandler(rw http.ResponseWriter, r *http.Request) {
+	if h.oidcProvider == nil {
+		errors.HandleError(rw, errors.NotFoundError{Message: "OIDC login is not configured"})
+		return
+	}
+
+	user := r.Context().Value(middleware.UserKey).(db.User)
+	authURL, err := h.beginOIDCFlow(rw, r, pgtype.Int4{Int32: user.ID, Valid: true})
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	json.NewEncoder(rw).Encode(map[string]string{"authorization_url": authURL})
+}
+
+func (h *BaseHandler) beginOIDCFlow(rw http.ResponseWriter, r *http.Request, linkUserID pgtype.Int4) (string, error) {
+	ctx := r.Context()
+	state, stateHash, err := auth.GenerateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+	browser, browserHash, err := auth.GenerateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+	nonce, err := auth.RandomString(16)
+	if err != nil {
+		return "", err
+	}
+	verifier, err := oidc.NewCodeVerifier()
+	if err != nil {
+		return "", err
+	}
+
+	query := db.New(h.db)
+	_, err = query.DeleteExpiredOIDCLoginStates(ctx)
+	if err != nil {
+		return "", err
+	}
+	err = query.CreateOIDCLoginState(ctx, db.CreateOIDCLoginStateParams{
+		StateHash:    stateHash,
+		CodeVerifier: verifier,
+		Nonce:        nonce,
+		LinkUserID:   linkUserID,
+		ExpiresAt:    pgtype.Timestamptz{Time: time.Now().Add(oidcLoginStateTTL), Valid: true},
+		BrowserHash:  browserHash,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	authURL, err := h.oidcProvider.AuthCodeURL(ctx, state, nonce, oidc.CodeChallengeS256(verifier))
+	if err != nil {
+		return "", err
+	}
+	h.setOIDCBrowserCookie(rw, browser, oidcLoginStateTTL)
+	return authURL, nil
+}
+
+// setOIDCBrowserCookie sets the browser nonce, or clears it when maxAge is
+// zero. Lax lets the cookie through the top-level redirect back from the
+// provider but not on cross-site subrequests.
+func (h *BaseHandler) setOIDCBrowserCookie(rw http.ResponseWriter, value string, maxAge time.Duration) {
+	cookie := &http.Cookie{
+		Name:     oidcBrowserCookie,
+		Value:    value,
+		Path:     "/auth/oidc/",
+		MaxAge:   int(maxAge.Seconds()),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(h.publicURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	}
+	if maxAge == 0 {
+		cookie.MaxAge = -1
+	}
+	http.SetCookie(rw, cookie)
+}
+
+// OIDCCallbackHandler finishes the flow. It either links the identity, logs
+// in the linked user, or provisions a new user when that is enabled.
+func (h *BaseHandler) OIDCCallbackHandler(rw http.ResponseWriter, r *http.Request) {
+	if h.oidcProvider == nil {
+		errors.HandleError(rw, errors.NotFoundError{Message: "OIDC login is not configured"})
+		return
+	}
+
+	params := r.URL.Query()
+	if providerError := params.Get("error"); providerError != "" {
+		errors.HandleError(rw, errors.UnauthorizedError{Message: "Identity provider rejected the login: " + providerError})
+		return
+	}
+	if params.Get("state") == "" || params.Get("code") == "" {
+		errors.HandleError(rw, errors.ValidationError{Message: "Missing state or code"})
+		return
+	}
+
+	browser, err := r.Cookie(oidcBrowserCookie)
+	if err != nil || browser.Value == "" {
+		errors.HandleError(rw, errors.UnauthorizedError{Message: "Login was not started in this browser"})
+		return
+	}
+	h.setOIDCBrowserCookie(rw, "", 0)
+
+	query := db.New(h.db)
+	loginState, err := query.ConsumeOIDCLoginState(r.Context(), auth.HashToken(params.Get("state")))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			errors.HandleError(rw, errors.UnauthorizedError{Message: "Invalid or expired login state"})
+		} else {
+			errors.HandleError(rw, err)
+		}
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(auth.HashToken(browser.Value)), []byte(loginState.BrowserHash)) != 1 {
+		errors.HandleError(rw, errors.UnauthorizedError{Message: "Login was not started in this browser"})
+		return
+	}
+
+	claims, err := h.oidcProvider.Exchange(r.Context(), params.Get("code"), loginState.CodeVerifier, loginState.Nonce)
+	if err != nil {
+		slog.WarnContext(r.Context(), "OIDC code exchange failed", "error", err)
+		errors.HandleError(rw, errors.UnauthorizedError{Message: "Could not verify the identity provider response"})
+		return
+	}
+
+	email := pgtype.Text{String: claims.Email, Valid: claims.Email != "" && claims.EmailVerified}
+
+	if loginState.LinkUserID.Valid {
+		identity, err := query.CreateIdentity(r.Context(), db.CreateIdentityParams{
+			UserID:  loginState.LinkUserID.Int32,
+			Issuer:  claims.Issuer,
+			Subject: claims.Subject,
+			Email:   email,
+		})
+		if err != nil {
+			if pgErr, ok := err.(*pgconn.PgError); ok && pgErr.Code == "23505" {
+				errors.HandleError(rw, errors.ValidationError{Message: "This identity is already linked to an account"})
+			} else {
+				errors.HandleError(rw, err)
+			}
+			return
+		}
+
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusCreated)
+		json.NewEncoder(rw).Encode(identity)
+		return
+	}
+
+	var userID int32
+	identity, err := query.GetIdentityByIssuerSubject(r.Context(), db.GetIdentityByIssuerSubjectParams{
+		Issuer:  claims.Issuer,
+		Subject: claims.Subject,
+	})
+	switch {
+	case err == nil:
+		userID = identity.UserID
+		err = query.TouchIdentity(r.Context(), db.TouchIdentityParams{ID: identity.ID, Email: email})
+		if err != nil {
+			errors.HandleError(rw, err)
+			return
+		}
+	case err == pgx.ErrNoRows && h.oidcProvider.Config().AutoProvision:
+		userID, err = h.provisionOIDCUser(r, claims, email)
+		if err != nil {
+			errors.HandleError(rw, err)
+			return
+		}
+	case err == pgx.ErrNoRows:
+		errors.HandleError(rw, errors.UnauthorizedError{Message: "No account is linked to this identity"})
+		return
+	default:
+		errors.HandleError(rw, err)
+		return
+	}
+
+	user, err := query.GetUserByID(r.Context(), userID)
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+	if user.DisabledAt.Valid {
+		errors.HandleError(rw, errors.UnauthorizedError{Message: "Account is disabled"})
+		return
+	}
+
+	h.completeLogin(rw, r, user.ID, user.Username, claims.Issuer+"|"+claims.Subject, "oidc")
+}
+
+// provisionOIDCUser creates a local user and its identity in one transaction.
+// The password is random so the account can only log in through the provider
+// until the user sets one.
+func (h *BaseHandler) provisionOIDCUser(r *http.Request, claims oidc.Claims, email pgtype.Text) (int32, error) {
+	ctx := r.Context()
+	if !email.Valid {
+		return 0, errors.UnauthorizedError{Message: "Identity provider did not return a verified email"}
+	}
+
+	randomPassword, err := auth.RandomString(32)
+	if err != nil {
+		return 0, err
+	}
+	passwordHash, err := auth.HashPassword(ctx, randomPassword)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := h.db.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+	query := db.New(h.db).WithTx(tx)
+
+	username, err := availableUsername(ctx, query, claims)
+	if err != nil {
+		return 0, err
+	}
+
+	user, err := query.CreateUser(ctx, db.CreateUserParams{
+		Username:     username,
+		Email:        email.String,
+		PasswordHash: passwordHash,
+		FirstName:    pgtype.Text{String: claims.GivenName, Valid: claims.GivenName != ""},
+		LastName:     pgtype.Text{String: claims.FamilyName, Valid: claims.FamilyName != ""},
+	})
+	if err != nil {
+		if pgErr, ok := err.(*pgconn.PgError); ok && pgErr.Code == "23505" {
+			return 0, errors.ValidationError{Message: "An account with this email already exists, log in and link the identity instead"}
+		}
+		return 0, err
+	}
+
+	_, err = query.CreateIdentity(ctx, db.CreateIdentityParams{
+		UserID:  user.ID,
+		Issuer:  claims.Issuer,
+		Subject: claims.Subject,
+		Email:   email,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// The provider already verified the address, no need to ask again.
+	_, err = query.MarkEmailVerified(ctx, db.MarkEmailVerifiedParams{
+		ID:    user.ID,
+		Email: user.Email,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	err = recordAudit(r, query, auditEvent{
+		Action:     "user.register",
+		EntityType: auditEntityUser,
+		EntityID:   user.ID,
+		ActorID:    user.ID,
+		After:      map[string]string{"method": "oidc"},
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return user.ID, tx.Commit(ctx)
+}
+
+func availableUsername(ctx context.Context, query *db.Queries, claims oidc.Claims) (string, error) {
+	base := claims.PreferredUsername
+	if base == "" {
+		base, _, _ = strings.Cut(claims.Email, "@")
+	}
+	if len(base) > 40 {
+		base = base[:40]
+	}
+
+	for i := 0; i < 10; i++ {
+		candidate := base
+		if i > 0 {
+			candidate = fmt.Sprintf("%s%d", base, i)
+		}
+		_, err := query.GetUserByUsername(ctx, candidate)
+		if err == pgx.ErrNoRows {
+			return candidate, nil
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+
+	suffix, err := auth.RandomString(6)
+	if err != nil {
+		return "", err
+	}
+	return base + "-" + suffix, nil
+}
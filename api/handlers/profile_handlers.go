@@ -0,0 +1,355 @@
+package handlers
+
+import (
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/joseph-gunnarsson/scheduling/api/errors"
+	"github.com/joseph-gunnarsson/scheduling/api/middleware"
+	db "github.com/joseph-gunnarsson/scheduling/db/models"
+	"github.com/joseph-gunnarsson/scheduling/internals/auth"
+	"github.com/joseph-gunnarsson/scheduling/internals/mail"
+	"github.com/joseph-gunnarsson/scheduling/internals/privacy"
+)
+
+const (
+	emailChangeTTL    = 24 * time.Hour
+	maxUsernameLength = 50
+	maxNameLength     = 50
+	maxEmailLength    = 100
+)
+
+// userProfile is what a user gets to see about their own account.
+type userProfile struct {
+	ID              int32              `json:"id"`
+	Username        string             `json:"username"`
+	Email           string             `json:"email"`
+	FirstName       pgtype.Text        `json:"first_name"`
+	LastName        pgtype.Text        `json:"last_name"`
+	EmailVerifiedAt pgtype.Timestamptz `json:"email_verified_at"`
+	CreatedAt       pgtype.Timestamptz `json:"created_at"`
+	UpdatedAt       pgtype.Timestamptz `json:"updated_at"`
+}
+
+func newUserProfile(user db.User) userProfile {
+	return userProfile{
+		ID:              user.ID,
+		Username:        user.Username,
+		Email:           user.Email,
+		FirstName:       user.FirstName,
+		LastName:        user.LastName,
+		EmailVerifiedAt: user.EmailVerifiedAt,
+		CreatedAt:       user.CreatedAt,
+		UpdatedAt:       user.UpdatedAt,
+	}
+}
+
+// checkPassword asks a logged in user for their password again before a
+// sensitive change. Wrong guesses count against the account like failed
+// logins, so a stolen session cannot be used to find the password.
+func (h *BaseHandler) checkPassword(r *http.Request, user db.User, password string) error {
+	err := checkAccountLock(user.FailedLoginCount, user.LockedUntil)
+	if err != nil {
+		return err
+	}
+	err = auth.ComparePassword(r.Context(), user.PasswordHash, password)
+	if err != nil {
+		err = h.recordLoginFailure(r, user.Username, user.ID, user.Email, "invalid_password")
+		if err != nil {
+			return err
+		}
+		return errors.UnauthorizedError{Message: "Invalid password"}
+	}
+	return nil
+}
+
+func (h *BaseHandler) GetProfileHandler(rw http.ResponseWriter, r *http.Request) {
+	user, err := h.services.GetUser(r.Context(), r.Context().Value(middleware.UserKey).(db.User).ID)
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	json.NewEncoder(rw).Encode(newUserProfile(user))
+}
+
+func (h *BaseHandler) UpdateProfileHandler(rw http.ResponseWriter, r *http.Request) {
+	var patchData map[string]interface{}
+	err := json.NewDecoder(r.Body).Decode(&patchData)
+	if err != nil {
+		errors.HandleError(rw, errors.ValidationError{Message: "Invalid request body"})
+		return
+	}
+	if _, ok := patchData["email"]; ok {
+		errors.HandleError(rw, errors.ValidationError{Message: "Use POST /user/me/email/ to change the email address"})
+		return
+	}
+
+	user := r.Context().Value(middleware.UserKey).(db.User)
+	var patchUser db.UpdateUserProfileParams
+	patchUser.ID = user.ID
+
+	if username, ok := patchData["username"].(string); ok {
+		if username == "" || len(username) > maxUsernameLength {
+			errors.HandleError(rw, errors.ValidationError{Message: fmt.Sprintf("Username must be 1-%d characters", maxUsernameLength)})
+			return
+		}
+		patchUser.Username = pgtype.Text{String: username, Valid: true}
+	}
+
+	if firstName, ok := patchData["first_name"].(string); ok {
+		if len(firstName) > maxNameLength {
+			errors.HandleError(rw, errors.ValidationError{Message: "First name is too long"})
+			return
+		}
+		patchUser.FirstName = pgtype.Text{String: firstName, Valid: true}
+	}
+
+	if lastName, ok := patchData["last_name"].(string); ok {
+		if len(lastName) > maxNameLength {
+			errors.HandleError(rw, errors.ValidationError{Message: "Last name is too long"})
+			return
+		}
+		patchUser.LastName = pgtype.Text{String: lastName, Valid: true}
+	}
+
+	updated, err := h.services.UpdateProfile(r.Context(), requestActor(r), patchUser)
+	if err != nil {
+		if pgErr, ok := err.(*pgconn.PgError); ok && pgErr.Code == "23505" {
+			errors.HandleError(rw, errors.ValidationError{Message: "Username already exists"})
+		} else {
+			errors.HandleError(rw, err)
+		}
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	json.NewEncoder(rw).Encode(newUserProfile(updated))
+}
+
+// RequestEmailChangeHandler sends a confirmation link to the new address.
+// The account keeps its current email until the link is used.
+func (h *BaseHandler) RequestEmailChangeHandler(rw http.ResponseWriter, r *http.Request) {
+	var changeRequest struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&changeRequest)
+	if err != nil || !strings.Contains(changeRequest.Email, "@") || len(changeRequest.Email) > maxEmailLength {
+		errors.HandleError(rw, errors.ValidationError{Message: "Invalid request body"})
+		return
+	}
+
+	user := r.Context().Value(middleware.UserKey).(db.User)
+	err = h.checkPassword(r, user, changeRequest.Password)
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+	if changeRequest.Email == user.Email {
+		errors.HandleError(rw, errors.ValidationError{Message: "This is already your email address"})
+		return
+	}
+
+	query := db.New(h.db)
+	_, err = query.GetUserByEmail(r.Context(), changeRequest.Email)
+	if err == nil {
+		errors.HandleError(rw, errors.ValidationError{Message: "Email already exists"})
+		return
+	}
+	if err != pgx.ErrNoRows {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	token, err := issueUserToken(r.Context(), query, user.ID, tokenPurposeEmailChange, changeRequest.Email, emailChangeTTL)
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	h.sendMailAsync(r.Context(), mail.Message{
+		To:      changeRequest.Email,
+		Subject: "Confirm your new email address",
+		Body: "Confirm that this is the new email address of your account by opening the link below.\n\n" +
+			h.publicLink("/confirm-email-change", token) + "\n\n" +
+			"The link expires in 24 hours. If you did not ask for this you can ignore this email.\n",
+	})
+	h.sendMailAsync(r.Context(), mail.Message{
+		To:      user.Email,
+		Subject: "Your email address is being changed",
+		Body: "Someone asked to change the email address of your account to " + changeRequest.Email + ".\n\n" +
+			"If this was not you, reset your password right away.\n",
+	})
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(rw).Encode(map[string]string{"message": "Confirmation email sent to the new address"})
+}
+
+func (h *BaseHandler) ConfirmEmailChangeHandler(rw http.ResponseWriter, r *http.Request) {
+	var confirmRequest struct {
+		Token string `json:"token"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&confirmRequest)
+	if err != nil || confirmRequest.Token == "" {
+		errors.HandleError(rw, errors.ValidationError{Message: "Invalid request body"})
+		return
+	}
+
+	tx, err := h.db.Begin(r.Context())
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+	defer tx.Rollback(r.Context())
+	query := db.New(h.db).WithTx(tx)
+
+	token, err := query.ConsumeUserToken(r.Context(), db.ConsumeUserTokenParams{
+		TokenHash: auth.HashToken(confirmRequest.Token),
+		Purpose:   tokenPurposeEmailChange,
+	})
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			errors.HandleError(rw, errors.ValidationError{Message: "Invalid or expired confirmation token"})
+		} else {
+			errors.HandleError(rw, err)
+		}
+		return
+	}
+
+	err = query.ChangeUserEmail(r.Context(), db.ChangeUserEmailParams{
+		ID:    token.UserID,
+		Email: token.Email.String,
+	})
+	if err != nil {
+		if pgErr, ok := err.(*pgconn.PgError); ok && pgErr.Code == "23505" {
+			errors.HandleError(rw, errors.ValidationError{Message: "Email already exists"})
+		} else {
+			errors.HandleError(rw, err)
+		}
+		return
+	}
+
+	err = recordAudit(r, query, auditEvent{
+		Action:     "user.change_email",
+		EntityType: auditEntityUser,
+		EntityID:   token.UserID,
+		ActorID:    token.UserID,
+	})
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	err = tx.Commit(r.Context())
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	json.NewEncoder(rw).Encode(map[string]string{"message": "Email changed successfully"})
+}
+
+// DeleteAccountHandler erases the account. Its shifts stay behind without
+// personal data so group schedules and payroll history remain correct.
+func (h *BaseHandler) DeleteAccountHandler(rw http.ResponseWriter, r *http.Request) {
+	var deleteRequest struct {
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&deleteRequest)
+	if err != nil {
+		errors.HandleError(rw, errors.ValidationError{Message: "Invalid request body"})
+		return
+	}
+
+	user := r.Context().Value(middleware.UserKey).(db.User)
+	err = h.checkPassword(r, user, deleteRequest.Password)
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	tx, err := h.db.Begin(r.Context())
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+	defer tx.Rollback(r.Context())
+	query := db.New(h.db).WithTx(tx)
+
+	totp, err := query.GetUserTOTP(r.Context(), user.ID)
+	if err != nil && err != pgx.ErrNoRows {
+		errors.HandleError(rw, err)
+		return
+	}
+	if err == nil && totp.ConfirmedAt.Valid {
+		ok, err := verifyTwoFactorCode(r.Context(), query, user.ID, deleteRequest.Code)
+		if err != nil {
+			errors.HandleError(rw, err)
+			return
+		}
+		if !ok {
+			errors.HandleError(rw, errors.ValidationError{Message: "Invalid two-factor code"})
+			return
+		}
+	}
+
+	err = privacy.Erase(r.Context(), query, user.ID)
+	if err != nil {
+		if stderrors.Is(err, privacy.ErrOwnsGroups) {
+			errors.HandleError(rw, errors.ValidationError{Message: "Transfer or delete the groups you own first"})
+		} else {
+			errors.HandleError(rw, err)
+		}
+		return
+	}
+
+	err = recordAudit(r, query, auditEvent{
+		Action:     "user.erase",
+		EntityType: auditEntityUser,
+		EntityID:   user.ID,
+	})
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	err = tx.Commit(r.Context())
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	json.NewEncoder(rw).Encode(map[string]string{"message": "Account deleted"})
+}
+
+func (h *BaseHandler) ExportAccountHandler(rw http.ResponseWriter, r *http.Request) {
+	user := r.Context().Value(middleware.UserKey).(db.User)
+
+	archive, err := privacy.Export(r.Context(), db.New(h.db), user.ID)
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"user-%d-export.json\"", user.ID))
+	rw.WriteHeader(http.StatusOK)
+	json.NewEncoder(rw).Encode(archive)
+}
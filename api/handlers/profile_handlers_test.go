@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/joseph-gunnarsson/scheduling/internals/repository/repositorytest"
+)
+
+func TestUpdateProfileHandler(t *testing.T) {
+	h, store := newTestHandler(t)
+	alice := repositorytest.CreateUser(t, store, "alice")
+	repositorytest.CreateUser(t, store, "bob")
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{"email", `{"email": "new@example.com"}`, http.StatusBadRequest},
+		{"taken username", `{"username": "bob"}`, http.StatusBadRequest},
+		{"empty username", `{"username": ""}`, http.StatusBadRequest},
+		{"names", `{"first_name": "Alice", "last_name": "Smith"}`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		rw := httptest.NewRecorder()
+		h.UpdateProfileHandler(rw, newRequest(http.MethodPatch, "/user/me/", tt.body, alice, nil))
+		if rw.Code != tt.status {
+			t.Fatalf("%s: status = %d, want %d: %s", tt.name, rw.Code, tt.status, rw.Body)
+		}
+	}
+
+	rw := httptest.NewRecorder()
+	h.GetProfileHandler(rw, newRequest(http.MethodGet, "/user/me/", "", alice, nil))
+	var profile map[string]any
+	err := json.NewDecoder(rw.Body).Decode(&profile)
+	if err != nil || profile["first_name"] != "Alice" || profile["last_name"] != "Smith" || profile["username"] != "alice" {
+		t.Fatalf("profile = %v, %v, want the new names", profile, err)
+	}
+
+	events := store.AuditEvents()
+	if len(events) != 1 || events[0].Action != "user.update_profile" || events[0].ActorID.Int32 != alice.ID {
+		t.Fatalf("audit events = %+v, want one user.update_profile by alice", events)
+	}
+}
@@ -0,0 +1,225 @@
+package handlers
+
+import (
+	"encoding/json"
+	stderrors "errors"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/joseph-gunnarsson/scheduling/api/errors"
+	"github.com/joseph-gunnarsson/scheduling/api/middleware"
+	database "github.com/joseph-gunnarsson/scheduling/db"
+	db "github.com/joseph-gunnarsson/scheduling/db/models"
+	"github.com/joseph-gunnarsson/scheduling/internals/retention"
+)
+
+type deletedItems struct {
+	Groups []db.Group `json:"groups"`
+	Shifts []db.Shift `json:"shifts"`
+}
+
+// ListDeletedHandler lists the sub-groups and shifts of a group that were
+// deleted and can still be restored.
+func (h *BaseHandler) ListDeletedHandler(rw http.ResponseWriter, r *http.Request) {
+	groupID, err := parsePathID(r, "id")
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	query := db.New(h.db)
+	cutoff := retention.Cutoff(time.Now(), h.retention)
+	items := deletedItems{Groups: []db.Group{}, Shifts: []db.Shift{}}
+
+	groups, err := query.ListDeletedChildGroups(r.Context(), pgtype.Int4{Int32: groupID, Valid: true})
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+	for _, group := range groups {
+		if group.DeletedAt.Time.After(cutoff.Time) {
+			items.Groups = append(items.Groups, group)
+		}
+	}
+
+	shifts, err := query.ListDeletedShiftsByGroup(r.Context(), pgtype.Int4{Int32: groupID, Valid: true})
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+	for _, shift := range shifts {
+		if shift.DeletedAt.Time.After(cutoff.Time) {
+			items.Shifts = append(items.Shifts, shift)
+		}
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	json.NewEncoder(rw).Encode(items)
+}
+
+// RestoreGroupHandler brings back a deleted group together with the
+// sub-groups and shifts deleted along with it. GroupPermissionMiddleware can't be used since it
+// only sees live groups, so the owner of the group or a manager of its parent
+// is checked here.
+func (h *BaseHandler) RestoreGroupHandler(rw http.ResponseWriter, r *http.Request) {
+	groupID, err := parsePathID(r, "id")
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	user := r.Context().Value(middleware.UserKey).(db.User)
+
+	var restored db.Group
+	err = database.InTx(r.Context(), h.db, func(query *db.Queries) error {
+		group, err := query.GetDeletedGroupByID(r.Context(), groupID)
+		if err != nil {
+			return err
+		}
+
+		err = authorizeRestoreGroup(r, query, user, group)
+		if err != nil {
+			return err
+		}
+
+		restored, err = query.RestoreGroup(r.Context(), db.RestoreGroupParams{
+			ID:     group.ID,
+			Cutoff: retention.Cutoff(time.Now(), h.retention),
+		})
+		if stderrors.Is(err, pgx.ErrNoRows) {
+			return errors.NotFoundError{Message: "The group was deleted too long ago to be restored"}
+		}
+		if err != nil {
+			return err
+		}
+
+		// Shifts first, they are found through the sub-groups that still
+		// carry the deleted_at of the group.
+		err = query.RestoreGroupShifts(r.Context(), db.RestoreGroupShiftsParams{
+			GroupID:   group.ID,
+			DeletedAt: group.DeletedAt,
+		})
+		if err != nil {
+			return err
+		}
+
+		err = query.RestoreGroupDescendants(r.Context(), db.RestoreGroupDescendantsParams{
+			RootID:    group.ID,
+			DeletedAt: group.DeletedAt,
+		})
+		if err != nil {
+			return err
+		}
+
+		return recordAudit(r, query, auditEvent{
+			Action:     "group.restore",
+			EntityType: auditEntityGroup,
+			EntityID:   restored.ID,
+			GroupID:    restored.ID,
+			Before:     group,
+			After:      restored,
+		})
+	})
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	rw.Header().Set("ETag", etag(restored.Version))
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	json.NewEncoder(rw).Encode(restored)
+}
+
+func authorizeRestoreGroup(r *http.Request, query *db.Queries, user db.User, group db.Group) error {
+	requiresTwoFactor := group.RequireManagerTwoFactor
+
+	if group.ParentID.Valid {
+		_, err := query.GetGroupByID(r.Context(), group.ParentID.Int32)
+		if stderrors.Is(err, pgx.ErrNoRows) {
+			return errors.ValidationError{Message: "Restore the parent group first"}
+		}
+		if err != nil {
+			return err
+		}
+
+		canManage, err := query.UserCanManageGroup(r.Context(), db.UserCanManageGroupParams{
+			GroupID: group.ParentID.Int32,
+			UserID:  user.ID,
+		})
+		if err != nil {
+			return err
+		}
+		if !canManage && group.OwnerID.Int32 != user.ID {
+			return errors.UnauthorizedError{Message: "User does not own the group or any of its parent groups"}
+		}
+
+		parentRequires, err := query.GroupRequiresManagerTwoFactor(r.Context(), group.ParentID.Int32)
+		if err != nil {
+			return err
+		}
+		requiresTwoFactor = requiresTwoFactor || parentRequires
+	} else if group.OwnerID.Int32 != user.ID {
+		return errors.UnauthorizedError{Message: "User does not own the group"}
+	}
+
+	if !requiresTwoFactor {
+		return nil
+	}
+	satisfied, err := middleware.ManagerTwoFactorSatisfied(r.Context(), query, user)
+	if err != nil {
+		return err
+	}
+	if !satisfied {
+		return errors.ForbiddenError{Message: "This group requires managers to log in with two-factor authentication"}
+	}
+	return nil
+}
+
+func (h *BaseHandler) RestoreShiftHandler(rw http.ResponseWriter, r *http.Request) {
+	groupID, err := parsePathID(r, "id")
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+	shiftID, err := parsePathID(r, "shiftID")
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	var shift db.Shift
+	err = database.InTx(r.Context(), h.db, func(query *db.Queries) error {
+		shift, err = query.RestoreShift(r.Context(), db.RestoreShiftParams{
+			ID:      shiftID,
+			GroupID: pgtype.Int4{Int32: groupID, Valid: true},
+			Cutoff:  retention.Cutoff(time.Now(), h.retention),
+		})
+		if stderrors.Is(err, pgx.ErrNoRows) {
+			return errors.NotFoundError{Message: "No deleted shift that can still be restored"}
+		}
+		if err != nil {
+			return err
+		}
+
+		return recordAudit(r, query, auditEvent{
+			Action:     "shift.restore",
+			EntityType: auditEntityShift,
+			EntityID:   shift.ID,
+			GroupID:    groupID,
+			After:      shift,
+		})
+	})
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	rw.Header().Set("ETag", etag(shift.Version))
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	json.NewEncoder(rw).Encode(shift)
+}
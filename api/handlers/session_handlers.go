@@ -0,0 +1,334 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/joseph-gunnarsson/scheduling/api/errors"
+	"github.com/joseph-gunnarsson/scheduling/api/middleware"
+	db "github.com/joseph-gunnarsson/scheduling/db/models"
+	"github.com/joseph-gunnarsson/scheduling/internals/auth"
+)
+
+type tokenResponse struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
+
+// startSession creates a session with its first refresh token and returns the
+// token pair the client should use from now on.
+func (h *BaseHandler) startSession(r *http.Request, userID int32, username string, twoFactorVerified bool) (tokenResponse, error) {
+	ctx := r.Context()
+	tx, err := h.db.Begin(ctx)
+	if err != nil {
+		return tokenResponse{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	query := db.New(h.db).WithTx(tx)
+	now := time.Now()
+	expiresAt := now.Add(auth.RefreshTokenTTL)
+	session, err := query.CreateSession(ctx, db.CreateSessionParams{
+		UserID:              userID,
+		UserAgent:           pgtype.Text{String: r.UserAgent(), Valid: r.UserAgent() != ""},
+		IpAddress:           pgtype.Text{String: clientIP(r), Valid: r.RemoteAddr != ""},
+		ExpiresAt:           pgtype.Timestamptz{Time: expiresAt, Valid: true},
+		TwoFactorVerifiedAt: pgtype.Timestamptz{Time: now, Valid: twoFactorVerified},
+	})
+	if err != nil {
+		return tokenResponse{}, err
+	}
+
+	refreshToken, err := createRefreshToken(ctx, query, session.ID, expiresAt)
+	if err != nil {
+		return tokenResponse{}, err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return tokenResponse{}, err
+	}
+
+	return h.newTokenResponse(userID, username, session.ID, refreshToken)
+}
+
+func createRefreshToken(ctx context.Context, query *db.Queries, sessionID int32, expiresAt time.Time) (string, error) {
+	token, tokenHash, err := auth.GenerateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	_, err = query.CreateRefreshToken(ctx, db.CreateRefreshTokenParams{
+		SessionID: sessionID,
+		TokenHash: tokenHash,
+		ExpiresAt: pgtype.Timestamptz{Time: expiresAt, Valid: true},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+func (h *BaseHandler) newTokenResponse(userID int32, username string, sessionID int32, refreshToken string) (tokenResponse, error) {
+	accessToken, err := h.tokens.GenerateAccessToken(userID, username, sessionID)
+	if err != nil {
+		return tokenResponse{}, err
+	}
+
+	return tokenResponse{
+		Token:        accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(auth.AccessTokenTTL.Seconds()),
+	}, nil
+}
+
+func (h *BaseHandler) RefreshTokenHandler(rw http.ResponseWriter, r *http.Request) {
+	var refreshRequest struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&refreshRequest)
+	if err != nil || refreshRequest.RefreshToken == "" {
+		errors.HandleError(rw, errors.ValidationError{Message: "Invalid request body"})
+		return
+	}
+
+	query := db.New(h.db)
+	stored, err := query.GetRefreshTokenByHash(r.Context(), auth.HashToken(refreshRequest.RefreshToken))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			errors.HandleError(rw, errors.UnauthorizedError{Message: "Invalid refresh token"})
+		} else {
+			errors.HandleError(rw, err)
+		}
+		return
+	}
+
+	// A refresh token is only ever handed out once, so seeing a used one again
+	// means it was copied. Kill the whole session rather than guess who is who.
+	if stored.UsedAt.Valid {
+		h.revokeReusedSession(rw, r, query, stored.SessionID)
+		return
+	}
+
+	if time.Now().After(stored.ExpiresAt.Time) {
+		errors.HandleError(rw, errors.UnauthorizedError{Message: "Refresh token has expired"})
+		return
+	}
+
+	session, err := query.GetSessionByID(r.Context(), stored.SessionID)
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+	if session.RevokedAt.Valid || time.Now().After(session.ExpiresAt.Time) {
+		errors.HandleError(rw, errors.UnauthorizedError{Message: "Session has been revoked or expired"})
+		return
+	}
+
+	user, err := query.GetUserByID(r.Context(), session.UserID)
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	tx, err := h.db.Begin(r.Context())
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	qtx := query.WithTx(tx)
+	marked, err := qtx.MarkRefreshTokenUsed(r.Context(), stored.ID)
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+	if marked == 0 {
+		tx.Rollback(r.Context())
+		h.revokeReusedSession(rw, r, query, stored.SessionID)
+		return
+	}
+
+	expiresAt := time.Now().Add(auth.RefreshTokenTTL)
+	refreshToken, err := createRefreshToken(r.Context(), qtx, session.ID, expiresAt)
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	err = qtx.TouchSession(r.Context(), db.TouchSessionParams{
+		ID:        session.ID,
+		ExpiresAt: pgtype.Timestamptz{Time: expiresAt, Valid: true},
+	})
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	err = tx.Commit(r.Context())
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	response, err := h.newTokenResponse(user.ID, user.Username, session.ID, refreshToken)
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	json.NewEncoder(rw).Encode(response)
+}
+
+func (h *BaseHandler) revokeReusedSession(rw http.ResponseWriter, r *http.Request, query *db.Queries, sessionID int32) {
+	err := query.RevokeSession(r.Context(), sessionID)
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+	errors.HandleError(rw, errors.UnauthorizedError{Message: "Refresh token reuse detected, session revoked"})
+}
+
+func (h *BaseHandler) LogoutHandler(rw http.ResponseWriter, r *http.Request) {
+	session := r.Context().Value(middleware.SessionKey).(db.Session)
+
+	query := db.New(h.db)
+	err := query.RevokeSession(r.Context(), session.ID)
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	json.NewEncoder(rw).Encode(map[string]string{"message": "Logged out successfully"})
+}
+
+func (h *BaseHandler) LogoutAllHandler(rw http.ResponseWriter, r *http.Request) {
+	user := r.Context().Value(middleware.UserKey).(db.User)
+
+	tx, err := h.db.Begin(r.Context())
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+	defer tx.Rollback(r.Context())
+	query := db.New(h.db).WithTx(tx)
+
+	err = query.RevokeUserSessions(r.Context(), user.ID)
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	err = recordAudit(r, query, auditEvent{
+		Action:     "user.sessions_revoke",
+		EntityType: auditEntityUser,
+		EntityID:   user.ID,
+	})
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	err = tx.Commit(r.Context())
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	json.NewEncoder(rw).Encode(map[string]string{"message": "Logged out of all sessions successfully"})
+}
+
+// sessionInfo marks the session the request came from so clients can tell
+// which entry is the current device.
+type sessionInfo struct {
+	db.Session
+	Current bool `json:"current"`
+}
+
+func (h *BaseHandler) ListSessionsHandler(rw http.ResponseWriter, r *http.Request) {
+	user := r.Context().Value(middleware.UserKey).(db.User)
+	current := r.Context().Value(middleware.SessionKey).(db.Session)
+
+	query := db.New(h.db)
+	sessions, err := query.ListUserSessions(r.Context(), user.ID)
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	infos := make([]sessionInfo, 0, len(sessions))
+	for _, session := range sessions {
+		infos = append(infos, sessionInfo{Session: session, Current: session.ID == current.ID})
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	json.NewEncoder(rw).Encode(infos)
+}
+
+func (h *BaseHandler) RevokeSessionHandler(rw http.ResponseWriter, r *http.Request) {
+	sessionID, err := parsePathID(r, "sessionID")
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	user := r.Context().Value(middleware.UserKey).(db.User)
+
+	tx, err := h.db.Begin(r.Context())
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+	defer tx.Rollback(r.Context())
+	query := db.New(h.db).WithTx(tx)
+
+	revoked, err := query.RevokeUserSession(r.Context(), db.RevokeUserSessionParams{
+		ID:     sessionID,
+		UserID: user.ID,
+	})
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+	if revoked == 0 {
+		errors.HandleError(rw, errors.NotFoundError{Message: "Session not found"})
+		return
+	}
+
+	// Only the session ID, the session itself holds the client address and
+	// user agent.
+	err = recordAudit(r, query, auditEvent{
+		Action:     "user.session_revoke",
+		EntityType: auditEntityUser,
+		EntityID:   user.ID,
+		Before:     map[string]int32{"session_id": sessionID},
+	})
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	err = tx.Commit(r.Context())
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	json.NewEncoder(rw).Encode(map[string]string{"message": "Session revoked"})
+}
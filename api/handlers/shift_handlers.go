@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/joseph-gunnarsson/scheduling/api/errors"
+)
+
+func (h *BaseHandler) GetShiftHandler(rw http.ResponseWriter, r *http.Request) {
+	groupID, err := parsePathID(r, "id")
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+	shiftID, err := parsePathID(r, "shiftID")
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	shift, err := h.services.GetShift(r.Context(), groupID, shiftID)
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	rw.Header().Set("ETag", etag(shift.Version))
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	json.NewEncoder(rw).Encode(shift)
+}
+
+func (h *BaseHandler) DeleteShiftHandler(rw http.ResponseWriter, r *http.Request) {
+	groupID, err := parsePathID(r, "id")
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+	shiftID, err := parsePathID(r, "shiftID")
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	shift, err := h.services.DeleteShift(r.Context(), requestActor(r), groupID, shiftID, ifMatch(r))
+	if err != nil {
+		errors.HandleError(rw, serviceError(err))
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	json.NewEncoder(rw).Encode(map[string]string{
+		"message":          "Deleted shift successfully",
+		"restorable_until": shift.DeletedAt.Time.Add(h.retention).Format(time.RFC3339),
+	})
+}
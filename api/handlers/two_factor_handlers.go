@@ -0,0 +1,626 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/joseph-gunnarsson/scheduling/api/errors"
+	"github.com/joseph-gunnarsson/scheduling/api/middleware"
+	db "github.com/joseph-gunnarsson/scheduling/db/models"
+	"github.com/joseph-gunnarsson/scheduling/internals/auth"
+)
+
+const (
+	totpIssuer            = "Scheduling"
+	twoFactorChallengeTTL = 5 * time.Minute
+	maxTwoFactorAttempts  = 5
+)
+
+type twoFactorChallengeResponse struct {
+	TwoFactorRequired bool   `json:"two_factor_required"`
+	TwoFactorToken    string `json:"two_factor_token"`
+	ExpiresIn         int64  `json:"expires_in"`
+}
+
+// completeLogin is called once the first factor checked out. Users with an
+// authenticator get a short lived challenge instead of a session.
+func (h *BaseHandler) completeLogin(rw http.ResponseWriter, r *http.Request, userID int32, username string, identifier string, method string) {
+	query := db.New(h.db)
+	totp, err := query.GetUserTOTP(r.Context(), userID)
+	if err != nil && err != pgx.ErrNoRows {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	if err == nil && totp.ConfirmedAt.Valid {
+		token, err := createTwoFactorChallenge(r.Context(), query, userID)
+		if err != nil {
+			errors.HandleError(rw, err)
+			return
+		}
+
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(twoFactorChallengeResponse{
+			TwoFactorRequired: true,
+			TwoFactorToken:    token,
+			ExpiresIn:         int64(twoFactorChallengeTTL.Seconds()),
+		})
+		return
+	}
+
+	err = h.recordLoginSuccess(r, identifier, userID, method)
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	response, err := h.startSession(r, userID, username, false)
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	json.NewEncoder(rw).Encode(response)
+}
+
+func createTwoFactorChallenge(ctx context.Context, query *db.Queries, userID int32) (string, error) {
+	_, err := query.DeleteExpiredMFAChallenges(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	token, tokenHash, err := auth.GenerateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	err = query.CreateMFAChallenge(ctx, db.CreateMFAChallengeParams{
+		ChallengeHash: tokenHash,
+		UserID:        userID,
+		ExpiresAt:     pgtype.Timestamptz{Time: time.Now().Add(twoFactorChallengeTTL), Valid: true},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// verifyTwoFactorCode accepts either a current authenticator code or an
+// unused recovery code. Both can only be used once.
+func verifyTwoFactorCode(ctx context.Context, query *db.Queries, userID int32, code string) (bool, error) {
+	totp, err := query.GetUserTOTP(ctx, userID)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return false, nil
+		}
+		return false, err
+	}
+	if !totp.ConfirmedAt.Valid {
+		return false, nil
+	}
+
+	if step, ok := auth.ValidateTOTP(totp.Secret, code, time.Now()); ok {
+		used, err := query.UseTOTPStep(ctx, db.UseTOTPStepParams{
+			UserID:       userID,
+			LastUsedStep: step,
+		})
+		return used == 1, err
+	}
+
+	used, err := query.UseRecoveryCode(ctx, db.UseRecoveryCodeParams{
+		UserID:   userID,
+		CodeHash: auth.HashRecoveryCode(code),
+	})
+	return used == 1, err
+}
+
+// replaceRecoveryCodes drops the old codes and returns new ones. The plain
+// codes are only ever shown in the response that created them.
+func replaceRecoveryCodes(ctx context.Context, query *db.Queries, userID int32) ([]string, error) {
+	err := query.DeleteRecoveryCodes(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	codes, err := auth.GenerateRecoveryCodes(auth.RecoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, code := range codes {
+		err = query.CreateRecoveryCode(ctx, db.CreateRecoveryCodeParams{
+			UserID:   userID,
+			CodeHash: auth.HashRecoveryCode(code),
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return codes, nil
+}
+
+func (h *BaseHandler) VerifyLoginTwoFactorHandler(rw http.ResponseWriter, r *http.Request) {
+	var verifyRequest struct {
+		TwoFactorToken string `json:"two_factor_token"`
+		Code           string `json:"code"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&verifyRequest)
+	if err != nil || verifyRequest.TwoFactorToken == "" || verifyRequest.Code == "" {
+		errors.HandleError(rw, errors.ValidationError{Message: "Invalid request body"})
+		return
+	}
+
+	query := db.New(h.db)
+	err = h.checkIPThrottle(r.Context(), query, clientIP(r))
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	challengeHash := auth.HashToken(verifyRequest.TwoFactorToken)
+	challenge, err := query.GetMFAChallenge(r.Context(), challengeHash)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			errors.HandleError(rw, errors.UnauthorizedError{Message: "Invalid or expired two-factor token"})
+		} else {
+			errors.HandleError(rw, err)
+		}
+		return
+	}
+
+	user, err := query.GetUserByID(r.Context(), challenge.UserID)
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	err = checkAccountLock(user.FailedLoginCount, user.LockedUntil)
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	ok, err := verifyTwoFactorCode(r.Context(), query, user.ID, verifyRequest.Code)
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+	if !ok {
+		// Too many wrong codes send the user back to the password step.
+		attempts, err := query.RecordMFAChallengeFailure(r.Context(), challengeHash)
+		if err == nil && attempts >= maxTwoFactorAttempts {
+			err = query.DeleteMFAChallenge(r.Context(), challengeHash)
+		}
+		if err == nil {
+			err = h.recordLoginFailure(r, user.Username, user.ID, user.Email, "invalid_two_factor_code")
+		}
+		if err != nil {
+			errors.HandleError(rw, err)
+			return
+		}
+		errors.HandleError(rw, errors.UnauthorizedError{Message: "Invalid two-factor code"})
+		return
+	}
+
+	err = query.DeleteMFAChallenge(r.Context(), challengeHash)
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	err = h.recordLoginSuccess(r, user.Username, user.ID, "two_factor")
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	response, err := h.startSession(r, user.ID, user.Username, true)
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	json.NewEncoder(rw).Encode(response)
+}
+
+func (h *BaseHandler) TwoFactorStatusHandler(rw http.ResponseWriter, r *http.Request) {
+	user := r.Context().Value(middleware.UserKey).(db.User)
+	query := db.New(h.db)
+
+	enabled := false
+	totp, err := query.GetUserTOTP(r.Context(), user.ID)
+	if err != nil && err != pgx.ErrNoRows {
+		errors.HandleError(rw, err)
+		return
+	}
+	if err == nil {
+		enabled = totp.ConfirmedAt.Valid
+	}
+
+	required, err := query.UserRequiresTwoFactor(r.Context(), user.ID)
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	remaining, err := query.CountUnusedRecoveryCodes(r.Context(), user.ID)
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	json.NewEncoder(rw).Encode(map[string]interface{}{
+		"enabled":                  enabled,
+		"required":                 required,
+		"recovery_codes_remaining": remaining,
+	})
+}
+
+// EnrollTwoFactorHandler creates a new secret. It is not active until
+// ConfirmTwoFactorHandler sees a valid code for it.
+func (h *BaseHandler) EnrollTwoFactorHandler(rw http.ResponseWriter, r *http.Request) {
+	user := r.Context().Value(middleware.UserKey).(db.User)
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	tx, err := h.db.Begin(r.Context())
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+	defer tx.Rollback(r.Context())
+	query := db.New(h.db).WithTx(tx)
+
+	_, err = query.UpsertUserTOTP(r.Context(), db.UpsertUserTOTPParams{
+		UserID: user.ID,
+		Secret: secret,
+	})
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			errors.HandleError(rw, errors.ValidationError{Message: "Two-factor authentication is already enabled"})
+		} else {
+			errors.HandleError(rw, err)
+		}
+		return
+	}
+
+	err = recordAudit(r, query, auditEvent{
+		Action:     "user.two_factor_enroll",
+		EntityType: auditEntityUser,
+		EntityID:   user.ID,
+	})
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	err = tx.Commit(r.Context())
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusCreated)
+	json.NewEncoder(rw).Encode(map[string]string{
+		"secret":      secret,
+		"otpauth_uri": auth.TOTPKeyURI(totpIssuer, user.Email, secret),
+	})
+}
+
+func (h *BaseHandler) ConfirmTwoFactorHandler(rw http.ResponseWriter, r *http.Request) {
+	var confirmRequest struct {
+		Code string `json:"code"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&confirmRequest)
+	if err != nil || confirmRequest.Code == "" {
+		errors.HandleError(rw, errors.ValidationError{Message: "Invalid request body"})
+		return
+	}
+
+	user := r.Context().Value(middleware.UserKey).(db.User)
+	session := r.Context().Value(middleware.SessionKey).(db.Session)
+
+	query := db.New(h.db)
+	totp, err := query.GetUserTOTP(r.Context(), user.ID)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			errors.HandleError(rw, errors.ValidationError{Message: "Start two-factor enrolment first"})
+		} else {
+			errors.HandleError(rw, err)
+		}
+		return
+	}
+	if totp.ConfirmedAt.Valid {
+		errors.HandleError(rw, errors.ValidationError{Message: "Two-factor authentication is already enabled"})
+		return
+	}
+
+	step, ok := auth.ValidateTOTP(totp.Secret, confirmRequest.Code, time.Now())
+	if !ok {
+		errors.HandleError(rw, errors.ValidationError{Message: "Invalid two-factor code"})
+		return
+	}
+
+	tx, err := h.db.Begin(r.Context())
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+	defer tx.Rollback(r.Context())
+	qtx := query.WithTx(tx)
+
+	confirmed, err := qtx.ConfirmUserTOTP(r.Context(), db.ConfirmUserTOTPParams{
+		UserID:       user.ID,
+		LastUsedStep: step,
+	})
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+	if confirmed == 0 {
+		errors.HandleError(rw, errors.ValidationError{Message: "Two-factor authentication is already enabled"})
+		return
+	}
+
+	codes, err := replaceRecoveryCodes(r.Context(), qtx, user.ID)
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	err = recordAudit(r, qtx, auditEvent{
+		Action:     "user.two_factor_enable",
+		EntityType: auditEntityUser,
+		EntityID:   user.ID,
+	})
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	// The user just proved they hold the authenticator, so the current
+	// session counts as verified.
+	err = qtx.MarkSessionTwoFactorVerified(r.Context(), session.ID)
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	err = tx.Commit(r.Context())
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	json.NewEncoder(rw).Encode(map[string][]string{"recovery_codes": codes})
+}
+
+func (h *BaseHandler) RegenerateRecoveryCodesHandler(rw http.ResponseWriter, r *http.Request) {
+	var regenerateRequest struct {
+		Code string `json:"code"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&regenerateRequest)
+	if err != nil || regenerateRequest.Code == "" {
+		errors.HandleError(rw, errors.ValidationError{Message: "Invalid request body"})
+		return
+	}
+
+	user := r.Context().Value(middleware.UserKey).(db.User)
+
+	tx, err := h.db.Begin(r.Context())
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+	defer tx.Rollback(r.Context())
+	query := db.New(h.db).WithTx(tx)
+
+	ok, err := verifyTwoFactorCode(r.Context(), query, user.ID, regenerateRequest.Code)
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+	if !ok {
+		errors.HandleError(rw, errors.ValidationError{Message: "Invalid two-factor code"})
+		return
+	}
+
+	codes, err := replaceRecoveryCodes(r.Context(), query, user.ID)
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	err = recordAudit(r, query, auditEvent{
+		Action:     "user.two_factor_recovery_regenerate",
+		EntityType: auditEntityUser,
+		EntityID:   user.ID,
+	})
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	err = tx.Commit(r.Context())
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	json.NewEncoder(rw).Encode(map[string][]string{"recovery_codes": codes})
+}
+
+func (h *BaseHandler) DisableTwoFactorHandler(rw http.ResponseWriter, r *http.Request) {
+	var disableRequest struct {
+		Code string `json:"code"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&disableRequest)
+	if err != nil || disableRequest.Code == "" {
+		errors.HandleError(rw, errors.ValidationError{Message: "Invalid request body"})
+		return
+	}
+
+	user := r.Context().Value(middleware.UserKey).(db.User)
+
+	tx, err := h.db.Begin(r.Context())
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+	defer tx.Rollback(r.Context())
+	query := db.New(h.db).WithTx(tx)
+
+	required, err := query.UserRequiresTwoFactor(r.Context(), user.ID)
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+	if required {
+		errors.HandleError(rw, errors.ForbiddenError{Message: "A group you manage requires two-factor authentication"})
+		return
+	}
+
+	ok, err := verifyTwoFactorCode(r.Context(), query, user.ID, disableRequest.Code)
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+	if !ok {
+		errors.HandleError(rw, errors.ValidationError{Message: "Invalid two-factor code"})
+		return
+	}
+
+	err = query.DeleteUserTOTP(r.Context(), user.ID)
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+	err = query.DeleteRecoveryCodes(r.Context(), user.ID)
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	err = recordAudit(r, query, auditEvent{
+		Action:     "user.two_factor_disable",
+		EntityType: auditEntityUser,
+		EntityID:   user.ID,
+	})
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	err = tx.Commit(r.Context())
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	json.NewEncoder(rw).Encode(map[string]string{"message": "Two-factor authentication disabled"})
+}
+
+// SetGroupTwoFactorPolicyHandler turns the manager 2FA requirement on or off
+// for a group and its sub-groups.
+func (h *BaseHandler) SetGroupTwoFactorPolicyHandler(rw http.ResponseWriter, r *http.Request) {
+	groupID, err := strconv.ParseInt(r.PathValue("id"), 10, 32)
+	if err != nil {
+		errors.HandleError(rw, errors.ValidationError{Message: "Invalid group id"})
+		return
+	}
+
+	var policyRequest struct {
+		RequireManagerTwoFactor *bool `json:"require_manager_two_factor"`
+	}
+	err = json.NewDecoder(r.Body).Decode(&policyRequest)
+	if err != nil || policyRequest.RequireManagerTwoFactor == nil {
+		errors.HandleError(rw, errors.ValidationError{Message: "Invalid request body"})
+		return
+	}
+
+	// Turning the policy on without 2FA would lock the caller out right away.
+	session := r.Context().Value(middleware.SessionKey).(db.Session)
+	if *policyRequest.RequireManagerTwoFactor && !session.TwoFactorVerifiedAt.Valid {
+		errors.HandleError(rw, errors.ForbiddenError{Message: "Log in with two-factor authentication before requiring it"})
+		return
+	}
+
+	tx, err := h.db.Begin(r.Context())
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+	defer tx.Rollback(r.Context())
+	query := db.New(h.db).WithTx(tx)
+
+	before, err := query.GetGroupByID(r.Context(), int32(groupID))
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	expectedVersion, err := checkIfMatch(r, before.Version)
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	group, err := query.SetGroupTwoFactorPolicy(r.Context(), db.SetGroupTwoFactorPolicyParams{
+		ID:                      int32(groupID),
+		RequireManagerTwoFactor: *policyRequest.RequireManagerTwoFactor,
+		ExpectedVersion:         expectedVersion,
+	})
+	if err != nil {
+		errors.HandleError(rw, versionConflict(err))
+		return
+	}
+
+	err = recordAudit(r, query, auditEvent{
+		Action:     "group.two_factor_policy",
+		EntityType: auditEntityGroup,
+		EntityID:   group.ID,
+		GroupID:    group.ID,
+		Before:     before,
+		After:      group,
+	})
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	err = tx.Commit(r.Context())
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	rw.Header().Set("ETag", etag(group.Version))
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	json.NewEncoder(rw).Encode(group)
+}
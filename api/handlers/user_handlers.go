@@ -2,40 +2,60 @@ package handlers
 
 import (
 	"encoding/json"
-	"log"
+	"log/slog"
 	"net/http"
 	"strconv"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
 
 	"github.com/joseph-gunnarsson/scheduling/api/errors"
+	"github.com/joseph-gunnarsson/scheduling/api/middleware"
 	db "github.com/joseph-gunnarsson/scheduling/db/models"
 	"github.com/joseph-gunnarsson/scheduling/internals/auth"
-	"golang.org/x/crypto/bcrypt"
 )
 
+type registerRequest struct {
+	Username  string      `json:"username"`
+	Email     string      `json:"email"`
+	Password  string      `json:"password"`
+	FirstName pgtype.Text `json:"first_name"`
+	LastName  pgtype.Text `json:"last_name"`
+	// LegacyPassword is the plain password under the name older clients
+	// still send it as.
+	LegacyPassword string `json:"password_hash"`
+}
+
 func (h *BaseHandler) CreateUserHandler(rw http.ResponseWriter, r *http.Request) {
-	var newUser db.CreateUserParams
+	var newUser registerRequest
 	err := json.NewDecoder(r.Body).Decode(&newUser)
 	if err != nil {
 		errors.HandleError(rw, errors.ValidationError{Message: "Invalid request body"})
 		return
 	}
+	if newUser.Password == "" {
+		newUser.Password = newUser.LegacyPassword
+	}
 
-	if newUser.Username == "" || newUser.Email == "" || newUser.PasswordHash == "" {
+	if newUser.Username == "" || newUser.Email == "" || newUser.Password == "" {
 		errors.HandleError(rw, errors.ValidationError{Message: "Missing required fields"})
 		return
 	}
 
-	newUser.PasswordHash, err = auth.HashPassword(newUser.PasswordHash)
+	passwordHash, err := auth.HashPassword(r.Context(), newUser.Password)
 	if err != nil {
 		errors.HandleError(rw, err)
 		return
 	}
 
-	query := db.New(h.db)
-	user, err := query.CreateUser(r.Context(), newUser)
+	user, err := h.services.Register(r.Context(), requestActor(r), db.CreateUserParams{
+		Username:     newUser.Username,
+		Email:        newUser.Email,
+		PasswordHash: passwordHash,
+		FirstName:    newUser.FirstName,
+		LastName:     newUser.LastName,
+	})
 	if err != nil {
 		if pgErr, ok := err.(*pgconn.PgError); ok && pgErr.Code == "23505" {
 			errors.HandleError(rw, errors.ValidationError{Message: "Username or email already exists"})
@@ -45,6 +65,11 @@ func (h *BaseHandler) CreateUserHandler(rw http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	err = h.sendVerificationEmail(r.Context(), user.ID, user.Email)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to send verification email", "user_id", user.ID, "error", err)
+	}
+
 	rw.Header().Set("Content-Type", "application/json")
 	rw.WriteHeader(http.StatusCreated)
 	json.NewEncoder(rw).Encode(user)
@@ -62,35 +87,44 @@ func (h *BaseHandler) LoginHandler(rw http.ResponseWriter, r *http.Request) {
 	}
 
 	query := db.New(h.db)
+	err = h.checkIPThrottle(r.Context(), query, clientIP(r))
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
 	userInformation, err := query.LoginUser(r.Context(), loginRequest.Username)
 	if err != nil {
 		if err == pgx.ErrNoRows {
-			errors.HandleError(rw, errors.UnauthorizedError{Message: "Invalid username or password"})
-		} else {
-			errors.HandleError(rw, err)
+			err = h.recordLoginFailure(r, loginRequest.Username, 0, "", "unknown_user")
+			if err == nil {
+				err = errors.UnauthorizedError{Message: "Invalid username or password"}
+			}
 		}
+		errors.HandleError(rw, err)
 		return
 	}
 
-	err = bcrypt.CompareHashAndPassword([]byte(userInformation.PasswordHash), []byte(loginRequest.Password))
+	err = checkAccountLock(userInformation.FailedLoginCount, userInformation.LockedUntil)
 	if err != nil {
-		errors.HandleError(rw, errors.UnauthorizedError{Message: "Invalid username or password"})
+		if recordErr := recordLoginAttempt(r, query, loginRequest.Username, userInformation.ID, false, "locked"); recordErr != nil {
+			err = recordErr
+		}
+		errors.HandleError(rw, err)
 		return
 	}
 
-	token, err := auth.GenerateJWTToken(userInformation.ID, userInformation.Username)
+	err = auth.ComparePassword(r.Context(), userInformation.PasswordHash, loginRequest.Password)
 	if err != nil {
+		err = h.recordLoginFailure(r, loginRequest.Username, userInformation.ID, userInformation.Email, "invalid_password")
+		if err == nil {
+			err = errors.UnauthorizedError{Message: "Invalid username or password"}
+		}
 		errors.HandleError(rw, err)
 		return
 	}
 
-	response := map[string]string{
-		"token": token,
-	}
-
-	rw.Header().Set("Content-Type", "application/json")
-	rw.WriteHeader(http.StatusOK)
-	json.NewEncoder(rw).Encode(response)
+	h.completeLogin(rw, r, userInformation.ID, userInformation.Username, loginRequest.Username, "password")
 }
 
 func (h *BaseHandler) UpdatePassword(rw http.ResponseWriter, r *http.Request) {
@@ -110,8 +144,20 @@ func (h *BaseHandler) UpdatePassword(rw http.ResponseWriter, r *http.Request) {
 		errors.HandleError(rw, errors.ValidationError{Message: "Invalid user ID"})
 		return
 	}
+	// Wrong old passwords count against the account, so nobody else may try.
+	if r.Context().Value(middleware.UserKey).(db.User).ID != int32(userID) {
+		errors.HandleError(rw, errors.ForbiddenError{Message: "You can only change your own password"})
+		return
+	}
+
+	tx, err := h.db.Begin(r.Context())
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+	defer tx.Rollback(r.Context())
+	query := db.New(h.db).WithTx(tx)
 
-	query := db.New(h.db)
 	user, err := query.GetUserByID(r.Context(), int32(userID))
 	if err != nil {
 		if err == pgx.ErrNoRows {
@@ -121,15 +167,13 @@ func (h *BaseHandler) UpdatePassword(rw http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
-	log.Println(updatePasswordRequest.OldPassword)
-	log.Println(user.PasswordHash)
-	err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(updatePasswordRequest.OldPassword))
+	err = h.checkPassword(r, user, updatePasswordRequest.OldPassword)
 	if err != nil {
-		errors.HandleError(rw, errors.UnauthorizedError{Message: "Invalid old password"})
+		errors.HandleError(rw, err)
 		return
 	}
 
-	newPasswordHash, err := auth.HashPassword(updatePasswordRequest.NewPassword)
+	newPasswordHash, err := auth.HashPassword(r.Context(), updatePasswordRequest.NewPassword)
 	if err != nil {
 		errors.HandleError(rw, err)
 		return
@@ -144,6 +188,34 @@ func (h *BaseHandler) UpdatePassword(rw http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Whoever holds a stolen refresh token loses it with the old password.
+	// Requests made with an API key have no session to keep.
+	session, _ := r.Context().Value(middleware.SessionKey).(db.Session)
+	err = query.RevokeOtherUserSessions(r.Context(), db.RevokeOtherUserSessionsParams{
+		UserID: user.ID,
+		KeepID: session.ID,
+	})
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	err = recordAudit(r, query, auditEvent{
+		Action:     "user.change_password",
+		EntityType: auditEntityUser,
+		EntityID:   user.ID,
+	})
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	err = tx.Commit(r.Context())
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
 	rw.Header().Set("Content-Type", "application/json")
 	rw.WriteHeader(http.StatusOK)
 	json.NewEncoder(rw).Encode(map[string]string{"message": "Password updated successfully"})
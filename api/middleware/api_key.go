@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/joseph-gunnarsson/scheduling/api/errors"
+	db "github.com/joseph-gunnarsson/scheduling/db/models"
+	"github.com/joseph-gunnarsson/scheduling/internals/auth"
+	"github.com/joseph-gunnarsson/scheduling/internals/logging"
+)
+
+// RequireScope names the scope an API key needs for a route. It must come
+// before AuthMiddleware. Routes without it do not accept API keys at all.
+func (m *MiddlewareManager) RequireScope(scope string) Middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(rw http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), scopeKey, scope)
+			next.ServeHTTP(rw, r.WithContext(ctx))
+		}
+	}
+}
+
+func (m *MiddlewareManager) authenticateAPIKey(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc, key string) {
+	scope, _ := r.Context().Value(scopeKey).(string)
+	if scope == "" {
+		errors.HandleError(rw, errors.ForbiddenError{Message: "API keys cannot be used for this endpoint"})
+		return
+	}
+
+	query := db.New(m.db)
+	apiKey, err := query.GetActiveAPIKeyByHash(r.Context(), auth.HashToken(key))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			errors.HandleError(rw, errors.UnauthorizedError{Message: "Invalid or expired API key"})
+		} else {
+			errors.HandleError(rw, err)
+		}
+		return
+	}
+
+	if !auth.HasScope(apiKey.Scopes, scope) {
+		errors.HandleError(rw, errors.ForbiddenError{Message: "API key is missing the " + scope + " scope"})
+		return
+	}
+
+	user, err := query.GetUserByID(r.Context(), apiKey.UserID)
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+	if user.DisabledAt.Valid {
+		errors.HandleError(rw, errors.UnauthorizedError{Message: "Account is disabled"})
+		return
+	}
+
+	err = query.TouchAPIKey(r.Context(), apiKey.ID)
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	logging.SetUserID(r.Context(), user.ID)
+	ctx := context.WithValue(r.Context(), UserKey, user)
+	ctx = context.WithValue(ctx, APIKeyKey, apiKey)
+	next.ServeHTTP(rw, r.WithContext(ctx))
+}
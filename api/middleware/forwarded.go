@@ -0,0 +1,90 @@
+package middleware
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// TrustedProxies are the networks whose X-Forwarded-For header is believed.
+// Anyone else could put any address in it to dodge the login throttle.
+type TrustedProxies []netip.Prefix
+
+// ParseTrustedProxies accepts CIDR ranges and single addresses.
+func ParseTrustedProxies(values []string) (TrustedProxies, error) {
+	proxies := make(TrustedProxies, 0, len(values))
+	for _, value := range values {
+		if prefix, err := netip.ParsePrefix(value); err == nil {
+			proxies = append(proxies, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q, want an address or CIDR range", value)
+		}
+		proxies = append(proxies, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return proxies, nil
+}
+
+func (p TrustedProxies) contains(host string) bool {
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range p {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientAddr walks X-Forwarded-For from the right, where the nearest proxy
+// appended the address it saw, and returns the first hop that is not one of
+// our proxies. Entries left of it were written by the client and are not
+// trusted.
+func (p TrustedProxies) clientAddr(r *http.Request) (string, bool) {
+	peer, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil || !p.contains(peer) {
+		return "", false
+	}
+
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(header, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+	client := ""
+	for i := len(hops) - 1; i >= 0; i-- {
+		if _, err := netip.ParseAddr(hops[i]); err != nil {
+			break
+		}
+		client = hops[i]
+		if !p.contains(hops[i]) {
+			break
+		}
+	}
+	return client, client != ""
+}
+
+// ForwardedFor replaces r.RemoteAddr with the client address from
+// X-Forwarded-For when the request came through a trusted proxy, so the
+// login throttles, sessions and the audit log see the client and not the
+// proxy. Wrap the whole router with it.
+func ForwardedFor(proxies TrustedProxies, next http.Handler) http.Handler {
+	if len(proxies) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if client, ok := proxies.clientAddr(r); ok {
+			r = r.Clone(r.Context())
+			r.RemoteAddr = net.JoinHostPort(client, "0")
+		}
+		next.ServeHTTP(rw, r)
+	})
+}
@@ -0,0 +1,189 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/joseph-gunnarsson/scheduling/api/errors"
+	db "github.com/joseph-gunnarsson/scheduling/db/models"
+)
+
+const (
+	maxIdempotencyKey = 255
+	// maxIdempotentBody bounds what is read into memory to fingerprint a
+	// request.
+	maxIdempotentBody = 1 << 20
+)
+
+// IdempotencyMiddleware makes a request safe to retry when it carries an
+// Idempotency-Key header. The first request with a key runs and its response
+// is stored, later requests with the same key and body get that response
+// replayed. It must run after AuthMiddleware since keys belong to a user.
+// A claim whose request has not finished within the lease, because the server
+// died while handling it, can be taken over by a retry.
+func (m *MiddlewareManager) IdempotencyMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			next.ServeHTTP(rw, r)
+			return
+		}
+		if len(key) > maxIdempotencyKey {
+			errors.HandleError(rw, errors.ValidationError{Message: fmt.Sprintf("Idempotency-Key must be at most %d characters", maxIdempotencyKey)})
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxIdempotentBody+1))
+		if err != nil {
+			errors.HandleError(rw, errors.ValidationError{Message: "Invalid request body"})
+			return
+		}
+		if len(body) > maxIdempotentBody {
+			errors.HandleError(rw, errors.ValidationError{Message: "Request body is too large to use with Idempotency-Key"})
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		user, ok := r.Context().Value(UserKey).(db.User)
+		if !ok {
+			errors.SendErrorResponse(rw, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		query := db.New(m.db)
+		fingerprint := requestFingerprint(r, body)
+
+		now := time.Now()
+		claimed, err := query.ClaimIdempotencyKey(r.Context(), db.ClaimIdempotencyKeyParams{
+			UserID:           user.ID,
+			Key:              key,
+			Fingerprint:      fingerprint,
+			ExpiresAt:        pgtype.Timestamptz{Time: now.Add(m.idempotencyTTL), Valid: true},
+			InProgressBefore: pgtype.Timestamptz{Time: now.Add(-m.idempotencyLease), Valid: true},
+		})
+		if err == pgx.ErrNoRows {
+			m.replayIdempotent(rw, r, user.ID, key, fingerprint)
+			return
+		}
+		if err != nil {
+			errors.HandleError(rw, err)
+			return
+		}
+
+		// Storing the outcome should still happen when the client hung up.
+		ctx := context.WithoutCancel(r.Context())
+		recorder := &responseRecorder{ResponseWriter: rw, status: http.StatusOK}
+		completed := false
+		defer func() {
+			// A failed or panicking request releases the key so the client
+			// can try again.
+			if !completed {
+				query.DeleteIdempotencyKey(ctx, claimed.ID)
+			}
+		}()
+
+		next.ServeHTTP(recorder, r)
+
+		if recorder.status >= http.StatusInternalServerError {
+			return
+		}
+		headers, err := json.Marshal(recorder.stored)
+		if err != nil {
+			return
+		}
+		err = query.CompleteIdempotencyKey(ctx, db.CompleteIdempotencyKeyParams{
+			ID:              claimed.ID,
+			StatusCode:      pgtype.Int4{Int32: int32(recorder.status), Valid: true},
+			ResponseHeaders: headers,
+			ResponseBody:    recorder.body.Bytes(),
+		})
+		completed = err == nil
+	}
+}
+
+func (m *MiddlewareManager) replayIdempotent(rw http.ResponseWriter, r *http.Request, userID int32, key, fingerprint string) {
+	stored, err := db.New(m.db).GetIdempotencyKey(r.Context(), db.GetIdempotencyKeyParams{
+		UserID: userID,
+		Key:    key,
+	})
+	if err == pgx.ErrNoRows {
+		// Expired or released by a failed request since the claim, the
+		// client can simply retry.
+		errors.HandleError(rw, errors.ConflictError{Message: "Idempotency-Key is being released, retry the request"})
+		return
+	}
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+
+	if stored.Fingerprint != fingerprint {
+		errors.HandleError(rw, errors.UnprocessableEntityError{Message: "Idempotency-Key was already used for a different request"})
+		return
+	}
+	if !stored.StatusCode.Valid {
+		errors.HandleError(rw, errors.ConflictError{Message: "A request with this Idempotency-Key is still in progress"})
+		return
+	}
+
+	var headers http.Header
+	err = json.Unmarshal(stored.ResponseHeaders, &headers)
+	if err != nil {
+		errors.HandleError(rw, err)
+		return
+	}
+	for name, values := range headers {
+		rw.Header()[name] = values
+	}
+	rw.Header().Set("Idempotent-Replayed", "true")
+	rw.WriteHeader(int(stored.StatusCode.Int32))
+	rw.Write(stored.ResponseBody)
+}
+
+// requestFingerprint ties a key to the method, path and body it was first
+// used with.
+func requestFingerprint(r *http.Request, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(r.Method + " " + r.URL.Path + "\n"))
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// responseRecorder passes a response through while keeping a copy to store.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	stored http.Header
+	body   bytes.Buffer
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if rec.stored == nil {
+		rec.status = status
+		rec.stored = rec.Header().Clone()
+		rec.stored.Del("Set-Cookie")
+		// A replay is a new request with its own ID.
+		rec.stored.Del("X-Request-ID")
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if rec.stored == nil {
+		rec.WriteHeader(http.StatusOK)
+	}
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
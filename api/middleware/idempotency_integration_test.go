@@ -0,0 +1,170 @@
+//go:build integration
+
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	db "github.com/joseph-gunnarsson/scheduling/db/models"
+	"github.com/joseph-gunnarsson/scheduling/internals/pgtest"
+)
+
+var server *pgtest.Server
+
+func TestMain(m *testing.M) {
+	var err error
+	server, err = pgtest.Start()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	code := m.Run()
+	err = server.Stop()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	os.Exit(code)
+}
+
+// idempotentHandler counts how often the wrapped handler ran and answers
+// with status.
+type idempotentHandler struct {
+	calls  int
+	status int
+}
+
+func (h *idempotentHandler) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	h.calls++
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(h.status)
+	fmt.Fprintf(rw, `{"call":%d}`, h.calls)
+}
+
+func newIdempotencyTest(t *testing.T) (*pgxpool.Pool, db.User, *idempotentHandler, func(key, body string, user *db.User) *httptest.ResponseRecorder) {
+	t.Helper()
+	pool := server.NewDatabase(t)
+	query := db.New(pool)
+	created, err := query.CreateUser(context.Background(), db.CreateUserParams{
+		Username:     "alice",
+		Email:        "alice@example.com",
+		PasswordHash: "hash",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := query.GetUserByID(context.Background(), created.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mm := NewMiddlewareManager(pool, nil, UnverifiedAllow, time.Hour, time.Minute, nil, "")
+	handler := &idempotentHandler{status: http.StatusCreated}
+	wrapped := mm.IdempotencyMiddleware(handler.ServeHTTP)
+	send := func(key, body string, user *db.User) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/group/", strings.NewReader(body))
+		r.Header.Set("Idempotency-Key", key)
+		if user != nil {
+			r = r.WithContext(context.WithValue(r.Context(), UserKey, *user))
+		}
+		rw := httptest.NewRecorder()
+		wrapped(rw, r)
+		return rw
+	}
+	return pool, user, handler, send
+}
+
+func TestIdempotencyReplay(t *testing.T) {
+	_, user, handler, send := newIdempotencyTest(t)
+
+	first := send("create", `{"name":"Kitchen"}`, &user)
+	if first.Code != http.StatusCreated {
+		t.Fatalf("first request = %d, want %d", first.Code, http.StatusCreated)
+	}
+	replay := send("create", `{"name":"Kitchen"}`, &user)
+	if replay.Code != http.StatusCreated || replay.Header().Get("Idempotent-Replayed") != "true" {
+		t.Fatalf("retry = %d %v, want a replayed %d", replay.Code, replay.Header(), http.StatusCreated)
+	}
+	if replay.Body.String() != first.Body.String() || replay.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("replayed %q, want %q", replay.Body.String(), first.Body.String())
+	}
+	if handler.calls != 1 {
+		t.Errorf("handler ran %d times, want once", handler.calls)
+	}
+}
+
+func TestIdempotencyFingerprintMismatch(t *testing.T) {
+	_, user, handler, send := newIdempotencyTest(t)
+
+	send("create", `{"name":"Kitchen"}`, &user)
+	if rw := send("create", `{"name":"Floor"}`, &user); rw.Code != http.StatusUnprocessableEntity {
+		t.Errorf("same key with another body = %d, want %d", rw.Code, http.StatusUnprocessableEntity)
+	}
+	if handler.calls != 1 {
+		t.Errorf("handler ran %d times, want once", handler.calls)
+	}
+}
+
+func TestIdempotencyReleaseOnServerError(t *testing.T) {
+	_, user, handler, send := newIdempotencyTest(t)
+
+	handler.status = http.StatusInternalServerError
+	if rw := send("create", `{"name":"Kitchen"}`, &user); rw.Code != http.StatusInternalServerError {
+		t.Fatalf("failing request = %d, want %d", rw.Code, http.StatusInternalServerError)
+	}
+	handler.status = http.StatusCreated
+	rw := send("create", `{"name":"Kitchen"}`, &user)
+	if rw.Code != http.StatusCreated || rw.Header().Get("Idempotent-Replayed") != "" {
+		t.Errorf("retry after a server error = %d %v, want it to run again", rw.Code, rw.Header())
+	}
+	if handler.calls != 2 {
+		t.Errorf("handler ran %d times, want twice", handler.calls)
+	}
+}
+
+func TestIdempotencyStaleClaim(t *testing.T) {
+	pool, user, handler, send := newIdempotencyTest(t)
+	ctx := context.Background()
+
+	// A claim left behind by a server that died while handling the request.
+	claim := func(key string, age time.Duration) {
+		_, err := pool.Exec(ctx, `
+			INSERT INTO idempotency_keys (user_id, key, fingerprint, created_at, expires_at)
+			VALUES ($1, $2, $3, $4, $5)`,
+			user.ID, key, strings.Repeat("0", 64), time.Now().Add(-age), time.Now().Add(time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	claim("running", time.Second)
+	if rw := send("running", `{"name":"Kitchen"}`, &user); rw.Code != http.StatusConflict {
+		t.Errorf("retry during the lease = %d, want %d", rw.Code, http.StatusConflict)
+	}
+	claim("crashed", 2*time.Minute)
+	if rw := send("crashed", `{"name":"Kitchen"}`, &user); rw.Code != http.StatusCreated {
+		t.Errorf("retry after the lease = %d, want %d", rw.Code, http.StatusCreated)
+	}
+	if handler.calls != 1 {
+		t.Errorf("handler ran %d times, want once", handler.calls)
+	}
+}
+
+func TestIdempotencyWithoutUser(t *testing.T) {
+	_, _, handler, send := newIdempotencyTest(t)
+
+	if rw := send("create", `{"name":"Kitchen"}`, nil); rw.Code != http.StatusInternalServerError {
+		t.Errorf("request without a user = %d, want %d", rw.Code, http.StatusInternalServerError)
+	}
+	if handler.calls != 0 {
+		t.Errorf("handler ran %d times, want never", handler.calls)
+	}
+}
@@ -0,0 +1,114 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/joseph-gunnarsson/scheduling/internals/logging"
+)
+
+// maxRequestIDLength is the longest X-Request-ID taken from a client, it is
+// stored with audit events.
+const maxRequestIDLength = 64
+
+// RequestLogMiddleware gives the request an ID and writes one access log line
+// when it is done. It goes first in every chain so the line has the final
+// status, including the 500 ErrorHandlerMiddleware turns a panic into. The
+// query string is left out, it can carry tokens such as OIDC codes.
+func (m *MiddlewareManager) RequestLogMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		requestID := r.Header.Get("X-Request-ID")
+		if !validRequestID(requestID) {
+			requestID = newRequestID()
+		}
+		rw.Header().Set("X-Request-ID", requestID)
+		ctx := logging.WithRequest(r.Context(), requestID)
+
+		recorder := &accessRecorder{statusRecorder: statusRecorder{ResponseWriter: rw, status: http.StatusOK}}
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+
+		attrs := []slog.Attr{
+			slog.String("method", r.Method),
+			slog.String("route", r.Pattern),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", recorder.status),
+			slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+			slog.Int("bytes", recorder.bytes),
+		}
+		level := slog.LevelInfo
+		if recorder.status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		if recorder.err != nil {
+			attrs = append(attrs, slog.String("error", recorder.err.Error()))
+		}
+		slog.LogAttrs(ctx, level, "request", attrs...)
+	}
+}
+
+// validRequestID accepts IDs from proxies and clients as long as they are
+// short and can't break the log line.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// statusRecorder notes the status and size of the response while passing it
+// through.
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int
+	wroteHeader bool
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	if !rec.wroteHeader {
+		rec.status, rec.wroteHeader = status, true
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	rec.wroteHeader = true
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += n
+	return n, err
+}
+
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// accessRecorder also keeps the error behind a 500 for the access log. Only
+// the outermost recorder takes errors, so the one of MetricsMiddleware is a
+// plain statusRecorder.
+type accessRecorder struct {
+	statusRecorder
+	err error
+}
+
+// RecordError implements errors.ErrorRecorder.
+func (rec *accessRecorder) RecordError(err error) {
+	rec.err = err
+}
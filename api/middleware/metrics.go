@@ -0,0 +1,36 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/joseph-gunnarsson/scheduling/api/errors"
+)
+
+// MetricsMiddleware counts and times the request by route and status. It
+// goes right after RequestLogMiddleware in every chain so it sees the final
+// status as well.
+func (m *MiddlewareManager) MetricsMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: rw, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+		m.metrics.ObserveRequest(r.Pattern, recorder.status, time.Since(start))
+	}
+}
+
+// MetricsHandler serves the metrics to Prometheus. When a metrics token is
+// configured the scraper has to send it as a bearer token, the endpoint is
+// on the same port as the API.
+func (m *MiddlewareManager) MetricsHandler(rw http.ResponseWriter, r *http.Request) {
+	if m.metricsToken != "" {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(m.metricsToken)) != 1 {
+			errors.HandleError(rw, errors.UnauthorizedError{Message: "Invalid metrics token"})
+			return
+		}
+	}
+	m.metrics.ServeHTTP(rw, r)
+}
@@ -2,30 +2,54 @@ package middleware
 
 import (
 	"context"
-	"log"
+	"log/slog"
 	"net/http"
+	"reflect"
+	"runtime"
 	"runtime/debug"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/joseph-gunnarsson/scheduling/api/errors"
 	db "github.com/joseph-gunnarsson/scheduling/db/models"
 	"github.com/joseph-gunnarsson/scheduling/internals/auth"
+	"github.com/joseph-gunnarsson/scheduling/internals/logging"
+	"github.com/joseph-gunnarsson/scheduling/internals/metrics"
+	"github.com/joseph-gunnarsson/scheduling/internals/tracing"
 )
 
 type Middleware func(http.HandlerFunc) http.HandlerFunc
 
 type MiddlewareManager struct {
-	db *pgx.Conn
+	db               *pgxpool.Pool
+	tokens           *auth.TokenService
+	unverifiedAccess UnverifiedAccess
+	idempotencyTTL   time.Duration
+	idempotencyLease time.Duration
+	metrics          *metrics.Metrics
+	metricsToken     string
 }
 type ContextKey string
 
-const UserKey ContextKey = "user"
+const (
+	UserKey    ContextKey = "user"
+	SessionKey ContextKey = "session"
+	APIKeyKey  ContextKey = "api_key"
+	scopeKey   ContextKey = "scope"
+)
 
-func NewMiddlewareManager(db *pgx.Conn) *MiddlewareManager {
+func NewMiddlewareManager(db *pgxpool.Pool, tokens *auth.TokenService, unverifiedAccess UnverifiedAccess, idempotencyTTL, idempotencyLease time.Duration, metrics *metrics.Metrics, metricsToken string) *MiddlewareManager {
 	return &MiddlewareManager{
-		db: db,
+		db:               db,
+		tokens:           tokens,
+		unverifiedAccess: unverifiedAccess,
+		idempotencyTTL:   idempotencyTTL,
+		idempotencyLease: idempotencyLease,
+		metrics:          metrics,
+		metricsToken:     metricsToken,
 	}
 }
 
@@ -41,25 +65,42 @@ func (m *MiddlewareManager) AuthMiddleware(next http.HandlerFunc) http.HandlerFu
 			errors.HandleError(rw, errors.UnauthorizedError{Message: "Invalid Authorization header format"})
 			return
 		}
-		token := tokenParts[1]
-		err := auth.VerifyToken(token)
-		if err != nil {
-			errors.HandleError(rw, errors.UnauthorizedError{Message: "Invalid token"})
+		if auth.IsAPIKey(tokenParts[1]) {
+			m.authenticateAPIKey(rw, r, next, tokenParts[1])
 			return
 		}
-		userID, err := auth.ExtractSubFromToken(token)
+		payload, err := m.tokens.VerifyToken(tokenParts[1])
 		if err != nil {
-			errors.HandleError(rw, errors.UnauthorizedError{Message: "Failed to extract user ID from token"})
+			errors.HandleError(rw, errors.UnauthorizedError{Message: "Invalid token"})
 			return
 		}
 		query := db.New(m.db)
-		user, err := query.GetUserByID(r.Context(), userID)
+		user, err := query.GetUserByID(r.Context(), payload.Sub)
 
 		if err != nil {
 			errors.HandleError(rw, err)
 			return
 		}
+		if user.DisabledAt.Valid {
+			errors.HandleError(rw, errors.UnauthorizedError{Message: "Account is disabled"})
+			return
+		}
+
+		session, err := query.GetActiveSession(r.Context(), db.GetActiveSessionParams{
+			ID:     payload.Sid,
+			UserID: user.ID,
+		})
+		if err != nil {
+			if err == pgx.ErrNoRows {
+				errors.HandleError(rw, errors.UnauthorizedError{Message: "Session has been revoked or expired"})
+			} else {
+				errors.HandleError(rw, err)
+			}
+			return
+		}
+		logging.SetUserID(r.Context(), user.ID)
 		ctx := context.WithValue(r.Context(), UserKey, user)
+		ctx = context.WithValue(ctx, SessionKey, session)
 
 		next.ServeHTTP(rw, r.WithContext(ctx))
 	}
@@ -69,7 +110,6 @@ func (m *MiddlewareManager) GroupPermissionMiddleware(next http.HandlerFunc) htt
 	return func(rw http.ResponseWriter, r *http.Request) {
 		groupIDStr := r.PathValue("id")
 		groupID, err := strconv.ParseInt(groupIDStr, 10, 32)
-		log.Println()
 		if err != nil {
 			errors.HandleError(rw, err)
 			return
@@ -78,27 +118,122 @@ func (m *MiddlewareManager) GroupPermissionMiddleware(next http.HandlerFunc) htt
 		user := r.Context().Value(UserKey).(db.User)
 
 		query := db.New(m.db)
-		group, err := query.GetGroupByID(r.Context(), int32(groupID))
+		_, err = query.GetGroupByID(r.Context(), int32(groupID))
+		if err != nil {
+			errors.HandleError(rw, err)
+			return
+		}
+
+		canManage, err := query.UserCanManageGroup(r.Context(), db.UserCanManageGroupParams{
+			GroupID: int32(groupID),
+			UserID:  user.ID,
+		})
+		if err != nil {
+			errors.HandleError(rw, err)
+			return
+		}
+
+		if !canManage {
+			errors.HandleError(rw, errors.UnauthorizedError{Message: "User does not own the group or any of its parent groups"})
+			return
+		}
+
+		requiresTwoFactor, err := query.GroupRequiresManagerTwoFactor(r.Context(), int32(groupID))
+		if err != nil {
+			errors.HandleError(rw, err)
+			return
+		}
+
+		if requiresTwoFactor {
+			satisfied, err := ManagerTwoFactorSatisfied(r.Context(), query, user)
+			if err != nil {
+				errors.HandleError(rw, err)
+				return
+			}
+			if !satisfied {
+				errors.HandleError(rw, errors.ForbiddenError{Message: "This group requires managers to log in with two-factor authentication"})
+				return
+			}
+		}
+
+		next.ServeHTTP(rw, r)
+	}
+}
+
+// GroupAuditPermissionMiddleware guards a group's audit log. Live groups go
+// through GroupPermissionMiddleware. A soft deleted group's log, which holds
+// its group.delete event, stays open to the group's owner until it is purged.
+func (m *MiddlewareManager) GroupAuditPermissionMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	live := m.GroupPermissionMiddleware(next)
+	return func(rw http.ResponseWriter, r *http.Request) {
+		groupID, err := strconv.ParseInt(r.PathValue("id"), 10, 32)
+		if err != nil {
+			errors.HandleError(rw, err)
+			return
+		}
+
+		query := db.New(m.db)
+		group, err := query.GetDeletedGroupByID(r.Context(), int32(groupID))
+		if err == pgx.ErrNoRows {
+			live(rw, r)
+			return
+		}
 		if err != nil {
 			errors.HandleError(rw, err)
 			return
 		}
 
-		if group.OwnerID.Int32 != user.ID {
-			errors.HandleError(rw, errors.UnauthorizedError{Message: "User is not the group owner"})
+		user := r.Context().Value(UserKey).(db.User)
+		if !group.OwnerID.Valid || group.OwnerID.Int32 != user.ID {
+			errors.HandleError(rw, errors.UnauthorizedError{Message: "User does not own the group"})
 			return
 		}
 
+		if group.RequireManagerTwoFactor {
+			satisfied, err := ManagerTwoFactorSatisfied(r.Context(), query, user)
+			if err != nil {
+				errors.HandleError(rw, err)
+				return
+			}
+			if !satisfied {
+				errors.HandleError(rw, errors.ForbiddenError{Message: "This group requires managers to log in with two-factor authentication"})
+				return
+			}
+		}
+
 		next.ServeHTTP(rw, r)
 	}
 }
 
+// ManagerTwoFactorSatisfied checks a request against a group's manager 2FA
+// policy. A session must have passed the second factor. API keys have no
+// session, so the person who created the key must have a confirmed
+// authenticator, otherwise keys made before the policy was turned on would
+// keep managing the group without it.
+func ManagerTwoFactorSatisfied(ctx context.Context, query *db.Queries, user db.User) (bool, error) {
+	if session, ok := ctx.Value(SessionKey).(db.Session); ok {
+		return session.TwoFactorVerifiedAt.Valid, nil
+	}
+
+	creatorID := user.ID
+	if apiKey, ok := ctx.Value(APIKeyKey).(db.ApiKey); ok && apiKey.CreatedBy.Valid {
+		creatorID = apiKey.CreatedBy.Int32
+	}
+	totp, err := query.GetUserTOTP(ctx, creatorID)
+	if err == pgx.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return totp.ConfirmedAt.Valid, nil
+}
+
 func (m *MiddlewareManager) ErrorHandlerMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(rw http.ResponseWriter, r *http.Request) {
 		defer func() {
 			if err := recover(); err != nil {
-				debug.PrintStack()
-				log.Printf("Panic: %v", err)
+				slog.ErrorContext(r.Context(), "Panic", "panic", err, "stack", string(debug.Stack()))
 				errors.SendErrorResponse(rw, "Internal server error", http.StatusInternalServerError)
 			}
 		}()
@@ -106,14 +241,29 @@ func (m *MiddlewareManager) ErrorHandlerMiddleware(next http.HandlerFunc) http.H
 	}
 }
 
+// MultipleMiddleware chains middlewares around h, the first one runs first.
+// Each middleware and h get a span named after them, so a trace shows where
+// a slow request spent its time.
 func MultipleMiddleware(h http.HandlerFunc, middlewares ...Middleware) http.HandlerFunc {
-	if len(middlewares) < 1 {
-		return h
-	}
-	wrapped := h
+	wrapped := tracing.Wrap(funcName(h), h)
 	for i := len(middlewares) - 1; i >= 0; i-- {
-		wrapped = middlewares[i](wrapped)
-
+		wrapped = tracing.Wrap(funcName(middlewares[i]), middlewares[i](wrapped))
 	}
 	return wrapped
 }
+
+// funcName turns the name the runtime has for a method value or closure,
+// "github.com/.../handlers.(*BaseHandler).LoginHandler-fm" or
+// "github.com/.../middleware.(*MiddlewareManager).RequireScope.func1", into
+// LoginHandler or RequireScope.
+func funcName(f any) string {
+	name := runtime.FuncForPC(reflect.ValueOf(f).Pointer()).Name()
+	name = strings.TrimSuffix(name[strings.LastIndex(name, "/")+1:], "-fm")
+	parts := strings.Split(name, ".")
+	for i := len(parts) - 1; i > 0; i-- {
+		if !strings.HasPrefix(parts[i], "func") && strings.Trim(parts[i], "0123456789") != "" {
+			return parts[i]
+		}
+	}
+	return name
+}
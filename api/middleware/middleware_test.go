@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/joseph-gunnarsson/scheduling/internals/auth"
+)
+
+func TestFuncName(t *testing.T) {
+	m := &MiddlewareManager{}
+	tests := []struct {
+		f    any
+		want string
+	}{
+		{m.AuthMiddleware, "AuthMiddleware"},
+		{m.RequireScope(auth.ScopeReadGroups), "RequireScope"},
+		{http.NotFound, "NotFound"},
+	}
+	for _, tt := range tests {
+		if got := funcName(tt.f); got != tt.want {
+			t.Errorf("funcName = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestForwardedFor(t *testing.T) {
+	proxies, err := ParseTrustedProxies([]string{"10.0.0.0/8", "192.0.2.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		peer   string
+		header []string
+		want   string
+	}{
+		{"direct client", "203.0.113.9:4000", nil, "203.0.113.9:4000"},
+		{"direct client lying", "203.0.113.9:4000", []string{"198.51.100.1"}, "203.0.113.9:4000"},
+		{"one proxy", "10.1.2.3:4000", []string{"198.51.100.1"}, "198.51.100.1:0"},
+		{"spoofed entry left of the client", "10.1.2.3:4000", []string{"1.1.1.1, 198.51.100.1"}, "198.51.100.1:0"},
+		{"proxy chain", "192.0.2.1:4000", []string{"198.51.100.1, 10.9.9.9"}, "198.51.100.1:0"},
+		{"repeated headers", "10.1.2.3:4000", []string{"1.1.1.1", "198.51.100.1"}, "198.51.100.1:0"},
+		{"IPv6 client", "10.1.2.3:4000", []string{"2001:db8::1"}, "[2001:db8::1]:0"},
+		{"garbage", "10.1.2.3:4000", []string{"unknown"}, "10.1.2.3:4000"},
+		{"no header", "10.1.2.3:4000", nil, "10.1.2.3:4000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			handler := ForwardedFor(proxies, http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+				got = r.RemoteAddr
+			}))
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.peer
+			for _, value := range tt.header {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), r)
+			if got != tt.want {
+				t.Errorf("RemoteAddr = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := ParseTrustedProxies([]string{"proxy.internal"}); err == nil {
+		t.Error("ParseTrustedProxies accepted a host name")
+	}
+}
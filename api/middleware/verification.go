@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/joseph-gunnarsson/scheduling/api/errors"
+	db "github.com/joseph-gunnarsson/scheduling/db/models"
+)
+
+// UnverifiedAccess decides what users who have not verified their email
+// address are allowed to do.
+type UnverifiedAccess string
+
+const (
+	UnverifiedAllow    UnverifiedAccess = "allow"
+	UnverifiedReadOnly UnverifiedAccess = "read_only"
+	UnverifiedDeny     UnverifiedAccess = "deny"
+)
+
+func ParseUnverifiedAccess(s string) (UnverifiedAccess, error) {
+	switch UnverifiedAccess(s) {
+	case "":
+		return UnverifiedAllow, nil
+	case UnverifiedAllow, UnverifiedReadOnly, UnverifiedDeny:
+		return UnverifiedAccess(s), nil
+	default:
+		return "", fmt.Errorf("unknown unverified access policy %q", s)
+	}
+}
+
+// VerifiedEmailMiddleware applies the unverified access policy. It must run
+// after AuthMiddleware.
+func (m *MiddlewareManager) VerifiedEmailMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		user := r.Context().Value(UserKey).(db.User)
+		if user.EmailVerifiedAt.Valid {
+			next.ServeHTTP(rw, r)
+			return
+		}
+
+		switch m.unverifiedAccess {
+		case UnverifiedReadOnly:
+			if r.Method != http.MethodGet && r.Method != http.MethodHead {
+				errors.HandleError(rw, errors.ForbiddenError{Message: "Verify your email address to make changes"})
+				return
+			}
+		case UnverifiedDeny:
+			errors.HandleError(rw, errors.ForbiddenError{Message: "Verify your email address to continue"})
+			return
+		}
+
+		next.ServeHTTP(rw, r)
+	}
+}
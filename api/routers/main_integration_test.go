@@ -0,0 +1,324 @@
+//go:build integration
+
+package routers_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/joseph-gunnarsson/scheduling/api/handlers"
+	"github.com/joseph-gunnarsson/scheduling/api/middleware"
+	"github.com/joseph-gunnarsson/scheduling/api/routers"
+	"github.com/joseph-gunnarsson/scheduling/db/migrations"
+	"github.com/joseph-gunnarsson/scheduling/internals/auth"
+	"github.com/joseph-gunnarsson/scheduling/internals/mail"
+	"github.com/joseph-gunnarsson/scheduling/internals/metrics"
+	"github.com/joseph-gunnarsson/scheduling/internals/oidc"
+	"github.com/joseph-gunnarsson/scheduling/internals/oidc/oidctest"
+	"github.com/joseph-gunnarsson/scheduling/internals/pgtest"
+)
+
+const (
+	testPassword = "correct horse battery staple"
+	mailTimeout  = 5 * time.Second
+	metricsToken = "integration-metrics-token"
+)
+
+var (
+	server *pgtest.Server
+
+	// covered collects the patterns of every route a test called.
+	coveredMu sync.Mutex
+	covered   = map[string]bool{}
+)
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+
+	var err error
+	server, err = pgtest.Start()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	code := m.Run()
+	// Only a full run is expected to reach every route.
+	if code == 0 && flag.Lookup("test.run").Value.String() == "" {
+		missing, err := uncoveredRoutes()
+		if err != nil || len(missing) > 0 {
+			fmt.Fprintf(os.Stderr, "routes without an integration test: %v %v\n", missing, err)
+			code = 1
+		}
+	}
+
+	err = server.Stop()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	os.Exit(code)
+}
+
+var routePattern = regexp.MustCompile(`mux\.HandleFunc\("([^"]+)"`)
+
+// uncoveredRoutes reads the patterns from router.go so a new route fails the
+// run until a test calls it.
+func uncoveredRoutes() ([]string, error) {
+	source, err := os.ReadFile("router.go")
+	if err != nil {
+		return nil, err
+	}
+
+	coveredMu.Lock()
+	defer coveredMu.Unlock()
+	var missing []string
+	for _, match := range routePattern.FindAllStringSubmatch(string(source), -1) {
+		if !covered[match[1]] {
+			missing = append(missing, match[1])
+		}
+	}
+	sort.Strings(missing)
+	return missing, nil
+}
+
+// testMailer keeps every message so tests can follow the links in them.
+type testMailer struct {
+	mu       sync.Mutex
+	messages []mail.Message
+}
+
+func (m *testMailer) Send(ctx context.Context, msg mail.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+var mailToken = regexp.MustCompile(`\?token=(\S+)`)
+
+// token waits for the next mail to the address that links to path and
+// returns the token in the link. Mail is sent in the background, so call it
+// once per expected mail, in the order they were triggered.
+func (m *testMailer) token(t *testing.T, to, path string) string {
+	t.Helper()
+	deadline := time.Now().Add(mailTimeout)
+	for time.Now().Before(deadline) {
+		m.mu.Lock()
+		for i, msg := range m.messages {
+			if msg.To != to || !strings.Contains(msg.Body, path+"?token=") {
+				continue
+			}
+			m.messages = append(m.messages[:i], m.messages[i+1:]...)
+			m.mu.Unlock()
+
+			token, err := url.QueryUnescape(mailToken.FindStringSubmatch(msg.Body)[1])
+			if err != nil {
+				t.Fatalf("token in %q mail: %v", msg.Subject, err)
+			}
+			return token
+		}
+		m.mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("no mail to %s with a %s link", to, path)
+	return ""
+}
+
+// env is the whole API on its own database, served over HTTP.
+type env struct {
+	url    string
+	pool   *pgxpool.Pool
+	mailer *testMailer
+	oidc   *oidctest.Provider
+	client *http.Client
+}
+
+func newEnv(t *testing.T) *env {
+	t.Helper()
+	pool := server.NewDatabase(t)
+
+	key, err := auth.NewHMACKey([]byte("integration-test-secret-of-32-bytes!"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := auth.NewTokenService(auth.TokenConfig{
+		Issuer:      "scheduling-test",
+		Audience:    "scheduling-test",
+		ActiveKeyID: "test",
+		Keys:        map[string]auth.SigningKey{"test": key},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	api := httptest.NewUnstartedServer(nil)
+	apiURL := "http://" + api.Listener.Addr().String()
+
+	oidcServer, oidcProvider, err := oidctest.NewServer("scheduling", oidctest.Identity{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(oidcServer.Close)
+	provider := oidc.NewProvider(oidc.Config{
+		Issuer:        oidcProvider.Issuer(),
+		ClientID:      "scheduling",
+		RedirectURL:   apiURL + "/auth/oidc/callback/",
+		Scopes:        []string{"openid", "email", "profile"},
+		AutoProvision: true,
+	}, nil)
+
+	mailer := &testMailer{}
+	schemaVersion, err := migrations.Latest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := handlers.NewBaseHandler(pool, tokens, provider, mailer, apiURL, 24*time.Hour, schemaVersion)
+	serverMetrics := metrics.New()
+	serverMetrics.WatchPool(pool)
+	mm := middleware.NewMiddlewareManager(pool, tokens, middleware.UnverifiedAllow, time.Hour, time.Minute, serverMetrics, metricsToken)
+	mux := routers.Routers(handler, mm)
+
+	api.Config.Handler = http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		mux.ServeHTTP(rw, r)
+		// ServeMux sets the pattern it matched on the request.
+		if r.Pattern != "" {
+			coveredMu.Lock()
+			covered[r.Pattern] = true
+			coveredMu.Unlock()
+		}
+	})
+	api.Start()
+	t.Cleanup(api.Close)
+
+	// The jar carries the OIDC browser cookie through the provider redirects.
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &env{
+		url:    apiURL,
+		pool:   pool,
+		mailer: mailer,
+		oidc:   oidcProvider,
+		client: &http.Client{Timeout: 10 * time.Second, Jar: jar},
+	}
+}
+
+// request is one call to the API. token is an access token or an API key.
+type request struct {
+	method string
+	path   string
+	token  string
+	body   interface{}
+	header map[string]string
+}
+
+// call sends the request, checks the status and decodes the response into
+// out when it is not nil.
+func (e *env) call(t *testing.T, req request, status int, out interface{}) *http.Response {
+	t.Helper()
+
+	var body io.Reader
+	if req.body != nil {
+		data, err := json.Marshal(req.body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body = bytes.NewReader(data)
+	}
+	r, err := http.NewRequest(req.method, e.url+req.path, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.body != nil {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	if req.token != "" {
+		r.Header.Set("Authorization", "Bearer "+req.token)
+	}
+	for name, value := range req.header {
+		r.Header.Set(name, value)
+	}
+
+	resp, err := e.client.Do(r)
+	if err != nil {
+		t.Fatalf("%s %s: %v", req.method, req.path, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != status {
+		t.Fatalf("%s %s: status = %d, want %d: %s", req.method, req.path, resp.StatusCode, status, data)
+	}
+	if out != nil {
+		err = json.Unmarshal(data, out)
+		if err != nil {
+			t.Fatalf("%s %s: decode %s: %v", req.method, req.path, data, err)
+		}
+	}
+	return resp
+}
+
+type user struct {
+	ID       int32  `json:"id"`
+	Username string `json:"username"`
+	Email    string `json:"email"`
+}
+
+type tokens struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// register signs a user up, verifies the email address and logs in.
+func (e *env) register(t *testing.T, name string) (user, tokens) {
+	t.Helper()
+	var u user
+	e.call(t, request{method: "POST", path: "/user/register/", body: map[string]string{
+		"username": name,
+		"email":    name + "@example.com",
+		"password": testPassword,
+	}}, http.StatusCreated, &u)
+
+	token := e.mailer.token(t, u.Email, "/verify-email")
+	e.call(t, request{method: "POST", path: "/user/email/verify/", body: map[string]string{"token": token}}, http.StatusOK, nil)
+
+	return u, e.login(t, name, testPassword)
+}
+
+func (e *env) login(t *testing.T, username, password string) tokens {
+	t.Helper()
+	var login tokens
+	e.call(t, request{method: "POST", path: "/user/login/", body: map[string]string{
+		"username": username,
+		"password": password,
+	}}, http.StatusOK, &login)
+	if login.Token == "" {
+		t.Fatalf("login of %s returned no token", username)
+	}
+	return login
+}
+
+func id(v int32) string {
+	return fmt.Sprint(v)
+}
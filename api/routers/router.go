@@ -5,22 +5,78 @@ import (
 
 	"github.com/joseph-gunnarsson/scheduling/api/handlers"
 	"github.com/joseph-gunnarsson/scheduling/api/middleware"
+	"github.com/joseph-gunnarsson/scheduling/internals/auth"
 )
 
 func Routers(handler *handlers.BaseHandler, mm *middleware.MiddlewareManager) *http.ServeMux {
 	mux := http.NewServeMux()
 
-	mux.HandleFunc("POST /user/register/", middleware.MultipleMiddleware(handler.CreateUserHandler, mm.ErrorHandlerMiddleware))
-	mux.HandleFunc("POST /user/updatepassword/{id}/", middleware.MultipleMiddleware(handler.UpdatePassword, mm.AuthMiddleware, mm.ErrorHandlerMiddleware))
+	mux.HandleFunc("GET /healthz", middleware.MultipleMiddleware(handler.HealthHandler, mm.RequestLogMiddleware, mm.MetricsMiddleware, mm.ErrorHandlerMiddleware))
+	mux.HandleFunc("GET /readyz", middleware.MultipleMiddleware(handler.ReadyHandler, mm.RequestLogMiddleware, mm.MetricsMiddleware, mm.ErrorHandlerMiddleware))
+	mux.HandleFunc("GET /metrics", middleware.MultipleMiddleware(mm.MetricsHandler, mm.RequestLogMiddleware, mm.MetricsMiddleware, mm.ErrorHandlerMiddleware))
+	mux.HandleFunc("GET /.well-known/jwks.json", middleware.MultipleMiddleware(handler.JWKSHandler, mm.RequestLogMiddleware, mm.MetricsMiddleware, mm.ErrorHandlerMiddleware))
 
-	mux.HandleFunc("POST /user/login/", middleware.MultipleMiddleware(handler.LoginHandler, mm.ErrorHandlerMiddleware))
+	mux.HandleFunc("POST /user/register/", middleware.MultipleMiddleware(handler.CreateUserHandler, mm.RequestLogMiddleware, mm.MetricsMiddleware, mm.ErrorHandlerMiddleware))
+	mux.HandleFunc("POST /user/updatepassword/{id}/", middleware.MultipleMiddleware(handler.UpdatePassword, mm.RequestLogMiddleware, mm.MetricsMiddleware, mm.AuthMiddleware, mm.VerifiedEmailMiddleware, mm.ErrorHandlerMiddleware))
 
-	mux.HandleFunc("POST /group/", middleware.MultipleMiddleware(handler.CreateGroupHandler, mm.AuthMiddleware, mm.ErrorHandlerMiddleware))
-	mux.HandleFunc("DELETE /group/{id}/", middleware.MultipleMiddleware(handler.DeleteGroupHandler, mm.ErrorHandlerMiddleware, mm.AuthMiddleware, mm.GroupPermissionMiddleware))
-	mux.HandleFunc("PATCH /group/{id}/", middleware.MultipleMiddleware(handler.PatchGroupHandler, mm.ErrorHandlerMiddleware, mm.AuthMiddleware, mm.GroupPermissionMiddleware))
-	mux.HandleFunc("PUT /group/{id}/", middleware.MultipleMiddleware(handler.UpdateGroupHandler, mm.ErrorHandlerMiddleware, mm.AuthMiddleware, mm.GroupPermissionMiddleware))
+	mux.HandleFunc("POST /user/login/", middleware.MultipleMiddleware(handler.LoginHandler, mm.RequestLogMiddleware, mm.MetricsMiddleware, mm.ErrorHandlerMiddleware))
+	mux.HandleFunc("POST /user/login/2fa/", middleware.MultipleMiddleware(handler.VerifyLoginTwoFactorHandler, mm.RequestLogMiddleware, mm.MetricsMiddleware, mm.ErrorHandlerMiddleware))
+	mux.HandleFunc("GET /auth/oidc/login/", middleware.MultipleMiddleware(handler.OIDCLoginHandler, mm.RequestLogMiddleware, mm.MetricsMiddleware, mm.ErrorHandlerMiddleware))
+	mux.HandleFunc("GET /auth/oidc/callback/", middleware.MultipleMiddleware(handler.OIDCCallbackHandler, mm.RequestLogMiddleware, mm.MetricsMiddleware, mm.ErrorHandlerMiddleware))
+	mux.HandleFunc("POST /auth/oidc/link/", middleware.MultipleMiddleware(handler.OIDCLinkHandler, mm.RequestLogMiddleware, mm.MetricsMiddleware, mm.ErrorHandlerMiddleware, mm.AuthMiddleware, mm.VerifiedEmailMiddleware))
+	mux.HandleFunc("POST /user/password/forgot/", middleware.MultipleMiddleware(handler.RequestPasswordResetHandler, mm.RequestLogMiddleware, mm.MetricsMiddleware, mm.ErrorHandlerMiddleware))
+	mux.HandleFunc("POST /user/password/reset/", middleware.MultipleMiddleware(handler.ResetPasswordHandler, mm.RequestLogMiddleware, mm.MetricsMiddleware, mm.ErrorHandlerMiddleware))
+	mux.HandleFunc("POST /user/unlock/", middleware.MultipleMiddleware(handler.UnlockAccountHandler, mm.RequestLogMiddleware, mm.MetricsMiddleware, mm.ErrorHandlerMiddleware))
+	mux.HandleFunc("GET /user/login-attempts/", middleware.MultipleMiddleware(handler.LoginHistoryHandler, mm.RequestLogMiddleware, mm.MetricsMiddleware, mm.ErrorHandlerMiddleware, mm.AuthMiddleware, mm.VerifiedEmailMiddleware))
+	mux.HandleFunc("POST /user/email/verify/", middleware.MultipleMiddleware(handler.VerifyEmailHandler, mm.RequestLogMiddleware, mm.MetricsMiddleware, mm.ErrorHandlerMiddleware))
+	mux.HandleFunc("POST /user/email/verify/resend/", middleware.MultipleMiddleware(handler.ResendVerificationHandler, mm.RequestLogMiddleware, mm.MetricsMiddleware, mm.ErrorHandlerMiddleware, mm.AuthMiddleware))
+	mux.HandleFunc("GET /user/2fa/", middleware.MultipleMiddleware(handler.TwoFactorStatusHandler, mm.RequestLogMiddleware, mm.MetricsMiddleware, mm.ErrorHandlerMiddleware, mm.AuthMiddleware))
+	mux.HandleFunc("POST /user/2fa/enroll/", middleware.MultipleMiddleware(handler.EnrollTwoFactorHandler, mm.RequestLogMiddleware, mm.MetricsMiddleware, mm.ErrorHandlerMiddleware, mm.AuthMiddleware, mm.VerifiedEmailMiddleware))
+	mux.HandleFunc("POST /user/2fa/confirm/", middleware.MultipleMiddleware(handler.ConfirmTwoFactorHandler, mm.RequestLogMiddleware, mm.MetricsMiddleware, mm.ErrorHandlerMiddleware, mm.AuthMiddleware, mm.VerifiedEmailMiddleware))
+	mux.HandleFunc("POST /user/2fa/recovery-codes/", middleware.MultipleMiddleware(handler.RegenerateRecoveryCodesHandler, mm.RequestLogMiddleware, mm.MetricsMiddleware, mm.ErrorHandlerMiddleware, mm.AuthMiddleware, mm.VerifiedEmailMiddleware))
+	mux.HandleFunc("POST /user/2fa/disable/", middleware.MultipleMiddleware(handler.DisableTwoFactorHandler, mm.RequestLogMiddleware, mm.MetricsMiddleware, mm.ErrorHandlerMiddleware, mm.AuthMiddleware, mm.VerifiedEmailMiddleware))
+	mux.HandleFunc("POST /user/api-keys/", middleware.MultipleMiddleware(handler.CreateAPIKeyHandler, mm.RequestLogMiddleware, mm.MetricsMiddleware, mm.ErrorHandlerMiddleware, mm.AuthMiddleware, mm.VerifiedEmailMiddleware))
+	mux.HandleFunc("GET /user/api-keys/", middleware.MultipleMiddleware(handler.ListAPIKeysHandler, mm.RequestLogMiddleware, mm.MetricsMiddleware, mm.ErrorHandlerMiddleware, mm.AuthMiddleware, mm.VerifiedEmailMiddleware))
+	mux.HandleFunc("DELETE /user/api-keys/{keyID}/", middleware.MultipleMiddleware(handler.RevokeAPIKeyHandler, mm.RequestLogMiddleware, mm.MetricsMiddleware, mm.ErrorHandlerMiddleware, mm.AuthMiddleware, mm.VerifiedEmailMiddleware))
+	mux.HandleFunc("GET /user/me/", middleware.MultipleMiddleware(handler.GetProfileHandler, mm.RequestLogMiddleware, mm.MetricsMiddleware, mm.ErrorHandlerMiddleware, mm.AuthMiddleware))
+	mux.HandleFunc("PATCH /user/me/", middleware.MultipleMiddleware(handler.UpdateProfileHandler, mm.RequestLogMiddleware, mm.MetricsMiddleware, mm.ErrorHandlerMiddleware, mm.AuthMiddleware, mm.VerifiedEmailMiddleware))
+	mux.HandleFunc("DELETE /user/me/", middleware.MultipleMiddleware(handler.DeleteAccountHandler, mm.RequestLogMiddleware, mm.MetricsMiddleware, mm.ErrorHandlerMiddleware, mm.AuthMiddleware))
+	mux.HandleFunc("GET /user/me/export/", middleware.MultipleMiddleware(handler.ExportAccountHandler, mm.RequestLogMiddleware, mm.MetricsMiddleware, mm.ErrorHandlerMiddleware, mm.AuthMiddleware))
+	mux.HandleFunc("POST /user/me/email/", middleware.MultipleMiddleware(handler.RequestEmailChangeHandler, mm.RequestLogMiddleware, mm.MetricsMiddleware, mm.ErrorHandlerMiddleware, mm.AuthMiddleware))
+	mux.HandleFunc("POST /user/email/change/confirm/", middleware.MultipleMiddleware(handler.ConfirmEmailChangeHandler, mm.RequestLogMiddleware, mm.MetricsMiddleware, mm.ErrorHandlerMiddleware))
+	mux.HandleFunc("GET /user/sessions/", middleware.MultipleMiddleware(handler.ListSessionsHandler, mm.RequestLogMiddleware, mm.MetricsMiddleware, mm.ErrorHandlerMiddleware, mm.AuthMiddleware))
+	mux.HandleFunc("DELETE /user/sessions/{sessionID}/", middleware.MultipleMiddleware(handler.RevokeSessionHandler, mm.RequestLogMiddleware, mm.MetricsMiddleware, mm.ErrorHandlerMiddleware, mm.AuthMiddleware))
+	mux.HandleFunc("POST /user/refresh/", middleware.MultipleMiddleware(handler.RefreshTokenHandler, mm.RequestLogMiddleware, mm.MetricsMiddleware, mm.ErrorHandlerMiddleware))
+	mux.HandleFunc("POST /user/logout/", middleware.MultipleMiddleware(handler.LogoutHandler, mm.RequestLogMiddleware, mm.MetricsMiddleware, mm.ErrorHandlerMiddleware, mm.AuthMiddleware))
+	mux.HandleFunc("POST /user/logout/all/", middleware.MultipleMiddleware(handler.LogoutAllHandler, mm.RequestLogMiddleware, mm.MetricsMiddleware, mm.ErrorHandlerMiddleware, mm.AuthMiddleware))
 
-	mux.HandleFunc("GET /user/{id}/group/", middleware.MultipleMiddleware(handler.GetGroupsByOwnerHandler, mm.ErrorHandlerMiddleware, mm.AuthMiddleware))
+	mux.HandleFunc("POST /group/", middleware.MultipleMiddleware(handler.CreateGroupHandler, mm.RequestLogMiddleware, mm.MetricsMiddleware, mm.ErrorHandlerMiddleware, mm.RequireScope(auth.ScopeWriteGroups), mm.AuthMiddleware, mm.VerifiedEmailMiddleware, mm.IdempotencyMiddleware))
+	mux.HandleFunc("GET /group/{id}/", middleware.MultipleMiddleware(handler.GetGroupHandler, mm.RequestLogMiddleware, mm.MetricsMiddleware, mm.ErrorHandlerMiddleware, mm.RequireScope(auth.ScopeReadGroups), mm.AuthMiddleware, mm.VerifiedEmailMiddleware, mm.GroupPermissionMiddleware))
+	mux.HandleFunc("DELETE /group/{id}/", middleware.MultipleMiddleware(handler.DeleteGroupHandler, mm.RequestLogMiddleware, mm.MetricsMiddleware, mm.ErrorHandlerMiddleware, mm.RequireScope(auth.ScopeWriteGroups), mm.AuthMiddleware, mm.VerifiedEmailMiddleware, mm.GroupPermissionMiddleware))
+	mux.HandleFunc("PATCH /group/{id}/", middleware.MultipleMiddleware(handler.PatchGroupHandler, mm.RequestLogMiddleware, mm.MetricsMiddleware, mm.ErrorHandlerMiddleware, mm.RequireScope(auth.ScopeWriteGroups), mm.AuthMiddleware, mm.VerifiedEmailMiddleware, mm.GroupPermissionMiddleware))
+	mux.HandleFunc("PUT /group/{id}/", middleware.MultipleMiddleware(handler.UpdateGroupHandler, mm.RequestLogMiddleware, mm.MetricsMiddleware, mm.ErrorHandlerMiddleware, mm.RequireScope(auth.ScopeWriteGroups), mm.AuthMiddleware, mm.VerifiedEmailMiddleware, mm.GroupPermissionMiddleware))
+
+	mux.HandleFunc("PUT /group/{id}/parent/", middleware.MultipleMiddleware(handler.SetGroupParentHandler, mm.RequestLogMiddleware, mm.MetricsMiddleware, mm.ErrorHandlerMiddleware, mm.RequireScope(auth.ScopeWriteGroups), mm.AuthMiddleware, mm.VerifiedEmailMiddleware, mm.GroupPermissionMiddleware))
+	mux.HandleFunc("PUT /group/{id}/two-factor-policy/", middleware.MultipleMiddleware(handler.SetGroupTwoFactorPolicyHandler, mm.RequestLogMiddleware, mm.MetricsMiddleware, mm.ErrorHandlerMiddleware, mm.AuthMiddleware, mm.VerifiedEmailMiddleware, mm.GroupPermissionMiddleware))
+	mux.HandleFunc("POST /group/{id}/restore/", middleware.MultipleMiddleware(handler.RestoreGroupHandler, mm.RequestLogMiddleware, mm.MetricsMiddleware, mm.ErrorHandlerMiddleware, mm.RequireScope(auth.ScopeWriteGroups), mm.AuthMiddleware, mm.VerifiedEmailMiddleware))
+	mux.HandleFunc("GET /group/{id}/deleted/", middleware.MultipleMiddleware(handler.ListDeletedHandler, mm.RequestLogMiddleware, mm.MetricsMiddleware, mm.ErrorHandlerMiddleware, mm.RequireScope(auth.ScopeReadGroups), mm.AuthMiddleware, mm.VerifiedEmailMiddleware, mm.GroupPermissionMiddleware))
+	mux.HandleFunc("GET /group/{id}/shifts/{shiftID}/", middleware.MultipleMiddleware(handler.GetShiftHandler, mm.RequestLogMiddleware, mm.MetricsMiddleware, mm.ErrorHandlerMiddleware, mm.RequireScope(auth.ScopeReadShifts), mm.AuthMiddleware, mm.VerifiedEmailMiddleware, mm.GroupPermissionMiddleware))
+	mux.HandleFunc("DELETE /group/{id}/shifts/{shiftID}/", middleware.MultipleMiddleware(handler.DeleteShiftHandler, mm.RequestLogMiddleware, mm.MetricsMiddleware, mm.ErrorHandlerMiddleware, mm.RequireScope(auth.ScopeWriteShifts), mm.AuthMiddleware, mm.VerifiedEmailMiddleware, mm.GroupPermissionMiddleware))
+	mux.HandleFunc("POST /group/{id}/shifts/{shiftID}/restore/", middleware.MultipleMiddleware(handler.RestoreShiftHandler, mm.RequestLogMiddleware, mm.MetricsMiddleware, mm.ErrorHandlerMiddleware, mm.RequireScope(auth.ScopeWriteShifts), mm.AuthMiddleware, mm.VerifiedEmailMiddleware, mm.GroupPermissionMiddleware))
+	mux.HandleFunc("GET /group/{id}/audit/", middleware.MultipleMiddleware(handler.ListGroupAuditEventsHandler, mm.RequestLogMiddleware, mm.MetricsMiddleware, mm.ErrorHandlerMiddleware, mm.RequireScope(auth.ScopeReadGroups), mm.AuthMiddleware, mm.VerifiedEmailMiddleware, mm.GroupAuditPermissionMiddleware))
+	mux.HandleFunc("GET /group/{id}/subtree/", middleware.MultipleMiddleware(handler.GetGroupSubtreeHandler, mm.RequestLogMiddleware, mm.MetricsMiddleware, mm.ErrorHandlerMiddleware, mm.RequireScope(auth.ScopeReadGroups), mm.AuthMiddleware, mm.VerifiedEmailMiddleware, mm.GroupPermissionMiddleware))
+	mux.HandleFunc("GET /group/{id}/subtree/members/", middleware.MultipleMiddleware(handler.GetSubtreeMembersHandler, mm.RequestLogMiddleware, mm.MetricsMiddleware, mm.ErrorHandlerMiddleware, mm.RequireScope(auth.ScopeReadGroups), mm.AuthMiddleware, mm.VerifiedEmailMiddleware, mm.GroupPermissionMiddleware))
+	mux.HandleFunc("GET /group/{id}/subtree/shifts/", middleware.MultipleMiddleware(handler.GetSubtreeShiftsHandler, mm.RequestLogMiddleware, mm.MetricsMiddleware, mm.ErrorHandlerMiddleware, mm.RequireScope(auth.ScopeReadShifts), mm.AuthMiddleware, mm.VerifiedEmailMiddleware, mm.GroupPermissionMiddleware))
+	mux.HandleFunc("GET /group/{id}/subtree/coverage/", middleware.MultipleMiddleware(handler.GetSubtreeCoverageHandler, mm.RequestLogMiddleware, mm.MetricsMiddleware, mm.ErrorHandlerMiddleware, mm.RequireScope(auth.ScopeReadShifts), mm.AuthMiddleware, mm.VerifiedEmailMiddleware, mm.GroupPermissionMiddleware))
+
+	mux.HandleFunc("POST /group/{id}/service-accounts/", middleware.MultipleMiddleware(handler.CreateServiceAccountHandler, mm.RequestLogMiddleware, mm.MetricsMiddleware, mm.ErrorHandlerMiddleware, mm.AuthMiddleware, mm.VerifiedEmailMiddleware, mm.GroupPermissionMiddleware))
+	mux.HandleFunc("GET /group/{id}/service-accounts/", middleware.MultipleMiddleware(handler.ListServiceAccountsHandler, mm.RequestLogMiddleware, mm.MetricsMiddleware, mm.ErrorHandlerMiddleware, mm.AuthMiddleware, mm.VerifiedEmailMiddleware, mm.GroupPermissionMiddleware))
+	mux.HandleFunc("DELETE /group/{id}/service-accounts/{accountID}/", middleware.MultipleMiddleware(handler.DeleteServiceAccountHandler, mm.RequestLogMiddleware, mm.MetricsMiddleware, mm.ErrorHandlerMiddleware, mm.AuthMiddleware, mm.VerifiedEmailMiddleware, mm.GroupPermissionMiddleware))
+	mux.HandleFunc("POST /group/{id}/service-accounts/{accountID}/api-keys/", middleware.MultipleMiddleware(handler.CreateServiceAccountKeyHandler, mm.RequestLogMiddleware, mm.MetricsMiddleware, mm.ErrorHandlerMiddleware, mm.AuthMiddleware, mm.VerifiedEmailMiddleware, mm.GroupPermissionMiddleware))
+	mux.HandleFunc("GET /group/{id}/service-accounts/{accountID}/api-keys/", middleware.MultipleMiddleware(handler.ListServiceAccountKeysHandler, mm.RequestLogMiddleware, mm.MetricsMiddleware, mm.ErrorHandlerMiddleware, mm.AuthMiddleware, mm.VerifiedEmailMiddleware, mm.GroupPermissionMiddleware))
+	mux.HandleFunc("DELETE /group/{id}/service-accounts/{accountID}/api-keys/{keyID}/", middleware.MultipleMiddleware(handler.RevokeServiceAccountKeyHandler, mm.RequestLogMiddleware, mm.MetricsMiddleware, mm.ErrorHandlerMiddleware, mm.AuthMiddleware, mm.VerifiedEmailMiddleware, mm.GroupPermissionMiddleware))
+
+	mux.HandleFunc("GET /user/{id}/group/", middleware.MultipleMiddleware(handler.GetGroupsByOwnerHandler, mm.RequestLogMiddleware, mm.MetricsMiddleware, mm.ErrorHandlerMiddleware, mm.RequireScope(auth.ScopeReadGroups), mm.AuthMiddleware, mm.VerifiedEmailMiddleware))
 
 	return mux
 }
@@ -0,0 +1,623 @@
+//go:build integration
+
+package routers_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/joseph-gunnarsson/scheduling/db/migrations"
+	db "github.com/joseph-gunnarsson/scheduling/db/models"
+	"github.com/joseph-gunnarsson/scheduling/internals/auth"
+	"github.com/joseph-gunnarsson/scheduling/internals/oidc/oidctest"
+)
+
+type group struct {
+	ID       int32       `json:"id"`
+	Name     string      `json:"name"`
+	ParentID pgtype.Int4 `json:"parent_id"`
+}
+
+type apiKey struct {
+	ID  int32  `json:"id"`
+	Key string `json:"key"`
+}
+
+func TestHealthRoutes(t *testing.T) {
+	e := newEnv(t)
+	ctx := context.Background()
+
+	e.call(t, request{method: "GET", path: "/healthz"}, http.StatusOK, nil)
+	var ready struct {
+		SchemaVersion int64 `json:"schema_version"`
+	}
+	e.call(t, request{method: "GET", path: "/readyz"}, http.StatusOK, &ready)
+	latest, err := migrations.Latest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ready.SchemaVersion != latest {
+		t.Fatalf("schema version = %d, want %d", ready.SchemaVersion, latest)
+	}
+
+	// A database behind the build is not ready, the server stays alive.
+	_, err = e.pool.Exec(ctx, "UPDATE schema_migrations SET version = version - 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var notReady struct {
+		Reason string `json:"reason"`
+	}
+	e.call(t, request{method: "GET", path: "/readyz"}, http.StatusServiceUnavailable, &notReady)
+	if notReady.Reason != "database not ready" {
+		t.Errorf("reason = %q, want the generic one", notReady.Reason)
+	}
+	e.call(t, request{method: "GET", path: "/healthz"}, http.StatusOK, nil)
+}
+
+func TestMetricsRoute(t *testing.T) {
+	e := newEnv(t)
+
+	e.call(t, request{method: "GET", path: "/healthz"}, http.StatusOK, nil)
+	e.call(t, request{method: "GET", path: "/metrics"}, http.StatusUnauthorized, nil)
+	e.call(t, request{method: "GET", path: "/metrics", token: "wrong"}, http.StatusUnauthorized, nil)
+
+	req, err := http.NewRequest("GET", e.url+"/metrics", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+metricsToken)
+	resp, err := e.client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /metrics = %d: %s", resp.StatusCode, body)
+	}
+	for _, want := range []string{
+		`http_requests_total{route="GET /healthz",status="200"} 1`,
+		`http_requests_total{route="GET /metrics",status="401"} 2`,
+		`http_request_duration_seconds_bucket{route="GET /healthz",status="200",le="+Inf"} 1`,
+		"db_pool_max_connections",
+		"scheduling_active_users 0",
+		"scheduling_failed_logins_last_hour 0",
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("metrics don't include %s", want)
+		}
+	}
+}
+
+func TestAccountRoutes(t *testing.T) {
+	e := newEnv(t)
+	ctx := context.Background()
+
+	e.call(t, request{method: "GET", path: "/.well-known/jwks.json"}, http.StatusOK, nil)
+
+	var alice user
+	e.call(t, request{method: "POST", path: "/user/register/", body: map[string]string{
+		"username": "alice",
+		"email":    "alice@example.com",
+		"password": testPassword,
+	}}, http.StatusCreated, &alice)
+	e.mailer.token(t, alice.Email, "/verify-email")
+	session := e.login(t, "alice", testPassword)
+
+	// Only the link from the most recent mail works.
+	e.call(t, request{method: "POST", path: "/user/email/verify/resend/", token: session.Token}, http.StatusAccepted, nil)
+	token := e.mailer.token(t, alice.Email, "/verify-email")
+	e.call(t, request{method: "POST", path: "/user/email/verify/", body: map[string]string{"token": token}}, http.StatusOK, nil)
+
+	e.call(t, request{method: "PATCH", path: "/user/me/", token: session.Token, body: map[string]string{"first_name": "Alice"}}, http.StatusOK, nil)
+	var profile struct {
+		Email     string      `json:"email"`
+		FirstName pgtype.Text `json:"first_name"`
+	}
+	e.call(t, request{method: "GET", path: "/user/me/", token: session.Token}, http.StatusOK, &profile)
+	if profile.FirstName.String != "Alice" {
+		t.Fatalf("first name = %q, want Alice", profile.FirstName.String)
+	}
+
+	// Changing the password logs out every other session.
+	other := e.login(t, "alice", testPassword)
+	e.call(t, request{method: "POST", path: "/user/updatepassword/" + id(alice.ID) + "/", token: session.Token, body: map[string]string{
+		"old_password": testPassword,
+		"new_password": "a second password",
+	}}, http.StatusOK, nil)
+	e.call(t, request{method: "GET", path: "/user/me/", token: other.Token}, http.StatusUnauthorized, nil)
+	e.call(t, request{method: "POST", path: "/user/refresh/", body: map[string]string{"refresh_token": other.RefreshToken}}, http.StatusUnauthorized, nil)
+	e.call(t, request{method: "GET", path: "/user/me/", token: session.Token}, http.StatusOK, nil)
+	e.login(t, "alice", "a second password")
+
+	// Resetting the password logs out every session.
+	e.call(t, request{method: "POST", path: "/user/password/forgot/", body: map[string]string{"email": alice.Email}}, http.StatusAccepted, nil)
+	token = e.mailer.token(t, alice.Email, "/reset-password")
+	e.call(t, request{method: "POST", path: "/user/password/reset/", body: map[string]string{
+		"token":        token,
+		"new_password": testPassword,
+	}}, http.StatusOK, nil)
+	e.call(t, request{method: "GET", path: "/user/me/", token: session.Token}, http.StatusUnauthorized, nil)
+	session = e.login(t, "alice", testPassword)
+
+	e.call(t, request{method: "POST", path: "/user/login/", body: map[string]string{
+		"username": "alice",
+		"password": "wrong",
+	}}, http.StatusUnauthorized, nil)
+	var attempts []map[string]interface{}
+	e.call(t, request{method: "GET", path: "/user/login-attempts/", token: session.Token}, http.StatusOK, &attempts)
+	if len(attempts) == 0 {
+		t.Fatal("login attempts are empty")
+	}
+
+	// Wrong passwords when changing the email count as failed logins too.
+	changeEmail := request{method: "POST", path: "/user/me/email/", token: session.Token, body: map[string]string{
+		"email":    "alice@example.org",
+		"password": "wrong",
+	}}
+	for i := 0; i < 3; i++ {
+		e.call(t, changeEmail, http.StatusUnauthorized, nil)
+	}
+	changeEmail.body = map[string]string{"email": "alice@example.org", "password": testPassword}
+	e.call(t, changeEmail, http.StatusTooManyRequests, nil)
+
+	// Reaching the hard lockout through the API takes hours of backoff, so
+	// the unlock token is issued the way the lockout would.
+	token, tokenHash, err := auth.GenerateOpaqueToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.New(e.pool).CreateUserToken(ctx, db.CreateUserTokenParams{
+		UserID:    alice.ID,
+		Purpose:   "account_unlock",
+		TokenHash: tokenHash,
+		ExpiresAt: pgtype.Timestamptz{Time: time.Now().Add(time.Hour), Valid: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.call(t, request{method: "POST", path: "/user/unlock/", body: map[string]string{"token": token}}, http.StatusOK, nil)
+	e.call(t, request{method: "POST", path: "/user/unlock/", body: map[string]string{"token": token}}, http.StatusBadRequest, nil)
+
+	e.call(t, changeEmail, http.StatusAccepted, nil)
+	token = e.mailer.token(t, "alice@example.org", "/confirm-email-change")
+	e.call(t, request{method: "POST", path: "/user/email/change/confirm/", body: map[string]string{"token": token}}, http.StatusOK, nil)
+	e.call(t, request{method: "GET", path: "/user/me/", token: session.Token}, http.StatusOK, &profile)
+	if profile.Email != "alice@example.org" {
+		t.Fatalf("email = %q after the change was confirmed", profile.Email)
+	}
+
+	e.call(t, request{method: "GET", path: "/user/me/export/", token: session.Token}, http.StatusOK, nil)
+
+	e.call(t, request{method: "DELETE", path: "/user/me/", token: session.Token, body: map[string]string{"password": testPassword}}, http.StatusOK, nil)
+	e.call(t, request{method: "POST", path: "/user/login/", body: map[string]string{
+		"username": "alice",
+		"password": testPassword,
+	}}, http.StatusUnauthorized, nil)
+}
+
+func TestSessionRoutes(t *testing.T) {
+	e := newEnv(t)
+	bob, first := e.register(t, "bob")
+	second := e.login(t, "bob", testPassword)
+	third := e.login(t, "bob", testPassword)
+
+	var refreshed tokens
+	e.call(t, request{method: "POST", path: "/user/refresh/", body: map[string]string{"refresh_token": first.RefreshToken}}, http.StatusOK, &refreshed)
+	e.call(t, request{method: "GET", path: "/user/me/", token: refreshed.Token}, http.StatusOK, nil)
+
+	// Using a refresh token twice revokes its session.
+	e.call(t, request{method: "POST", path: "/user/refresh/", body: map[string]string{"refresh_token": first.RefreshToken}}, http.StatusUnauthorized, nil)
+	e.call(t, request{method: "GET", path: "/user/me/", token: refreshed.Token}, http.StatusUnauthorized, nil)
+
+	var sessions []struct {
+		ID      int32 `json:"id"`
+		Current bool  `json:"current"`
+	}
+	e.call(t, request{method: "GET", path: "/user/sessions/", token: third.Token}, http.StatusOK, &sessions)
+	var thirdID int32
+	for _, session := range sessions {
+		if session.Current {
+			thirdID = session.ID
+		}
+	}
+	if thirdID == 0 {
+		t.Fatalf("sessions = %+v, none is current", sessions)
+	}
+
+	e.call(t, request{method: "DELETE", path: "/user/sessions/" + id(thirdID) + "/", token: second.Token}, http.StatusOK, nil)
+	e.call(t, request{method: "GET", path: "/user/me/", token: third.Token}, http.StatusUnauthorized, nil)
+
+	e.call(t, request{method: "POST", path: "/user/logout/", token: second.Token}, http.StatusOK, nil)
+	e.call(t, request{method: "GET", path: "/user/me/", token: second.Token}, http.StatusUnauthorized, nil)
+
+	fourth := e.login(t, "bob", testPassword)
+	fifth := e.login(t, "bob", testPassword)
+	e.call(t, request{method: "POST", path: "/user/logout/all/", token: fourth.Token}, http.StatusOK, nil)
+	e.call(t, request{method: "GET", path: "/user/me/", token: fifth.Token}, http.StatusUnauthorized, nil)
+
+	var revocations int
+	err := e.pool.QueryRow(context.Background(), `SELECT count(*) FROM audit_events
+		WHERE entity_type = 'user' AND entity_id = $1 AND action IN ('user.session_revoke', 'user.sessions_revoke')`, bob.ID).Scan(&revocations)
+	if err != nil || revocations != 2 {
+		t.Fatalf("session revocation events = %d, %v, want 2", revocations, err)
+	}
+}
+
+func TestTwoFactorRoutes(t *testing.T) {
+	e := newEnv(t)
+	carol, session := e.register(t, "carol")
+
+	var status struct {
+		Enabled bool `json:"enabled"`
+	}
+	e.call(t, request{method: "GET", path: "/user/2fa/", token: session.Token}, http.StatusOK, &status)
+	if status.Enabled {
+		t.Fatal("two-factor authentication is enabled before enrolling")
+	}
+
+	var enrollment struct {
+		Secret string `json:"secret"`
+	}
+	e.call(t, request{method: "POST", path: "/user/2fa/enroll/", token: session.Token}, http.StatusCreated, &enrollment)
+	code, err := auth.TOTPCode(enrollment.Secret, auth.TOTPStep(time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var recovery struct {
+		Codes []string `json:"recovery_codes"`
+	}
+	e.call(t, request{method: "POST", path: "/user/2fa/confirm/", token: session.Token, body: map[string]string{"code": code}}, http.StatusOK, &recovery)
+	if len(recovery.Codes) < 2 {
+		t.Fatalf("recovery codes = %v", recovery.Codes)
+	}
+
+	// Every TOTP step is accepted only once, so the steps below use
+	// recovery codes instead of waiting for the next one.
+	var challenge struct {
+		TwoFactorRequired bool   `json:"two_factor_required"`
+		TwoFactorToken    string `json:"two_factor_token"`
+	}
+	e.call(t, request{method: "POST", path: "/user/login/", body: map[string]string{
+		"username": "carol",
+		"password": testPassword,
+	}}, http.StatusOK, &challenge)
+	if !challenge.TwoFactorRequired {
+		t.Fatal("login did not ask for the second factor")
+	}
+	var verified tokens
+	e.call(t, request{method: "POST", path: "/user/login/2fa/", body: map[string]string{
+		"two_factor_token": challenge.TwoFactorToken,
+		"code":             recovery.Codes[0],
+	}}, http.StatusOK, &verified)
+
+	// Keys made by a manager with an authenticator work in groups that
+	// require it.
+	var kitchen group
+	e.call(t, request{method: "POST", path: "/group/", token: verified.Token, body: map[string]interface{}{
+		"name":     "Kitchen",
+		"owner_id": carol.ID,
+	}}, http.StatusCreated, &kitchen)
+	kitchenPath := "/group/" + id(kitchen.ID) + "/"
+	var key apiKey
+	e.call(t, request{method: "POST", path: "/user/api-keys/", token: verified.Token, body: map[string]interface{}{
+		"name":   "reporting",
+		"scopes": []string{auth.ScopeReadGroups},
+	}}, http.StatusCreated, &key)
+	policy := request{method: "PUT", path: kitchenPath + "two-factor-policy/", token: verified.Token, header: map[string]string{"If-Match": "*"}, body: map[string]bool{"require_manager_two_factor": true}}
+	e.call(t, policy, http.StatusOK, nil)
+	e.call(t, request{method: "GET", path: kitchenPath, token: key.Key}, http.StatusOK, nil)
+	e.call(t, request{method: "GET", path: kitchenPath, token: session.Token}, http.StatusForbidden, nil)
+	policy.body = map[string]bool{"require_manager_two_factor": false}
+	e.call(t, policy, http.StatusOK, nil)
+
+	var regenerated struct {
+		Codes []string `json:"recovery_codes"`
+	}
+	e.call(t, request{method: "POST", path: "/user/2fa/recovery-codes/", token: verified.Token, body: map[string]string{"code": recovery.Codes[1]}}, http.StatusOK, &regenerated)
+	e.call(t, request{method: "POST", path: "/user/2fa/disable/", token: verified.Token, body: map[string]string{"code": recovery.Codes[2]}}, http.StatusBadRequest, nil)
+	e.call(t, request{method: "POST", path: "/user/2fa/disable/", token: verified.Token, body: map[string]string{"code": regenerated.Codes[0]}}, http.StatusOK, nil)
+
+	e.call(t, request{method: "GET", path: "/user/2fa/", token: verified.Token}, http.StatusOK, &status)
+	if status.Enabled {
+		t.Fatal("two-factor authentication is still enabled")
+	}
+
+	rows, err := e.pool.Query(context.Background(), `SELECT action FROM audit_events
+		WHERE entity_type = 'user' AND entity_id = $1 AND action LIKE 'user.two_factor%' ORDER BY id`, carol.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actions, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "user.two_factor_enroll user.two_factor_enable user.two_factor_recovery_regenerate user.two_factor_disable"
+	if got := strings.Join(actions, " "); got != want {
+		t.Fatalf("two-factor events = %s, want %s", got, want)
+	}
+}
+
+// follow requests an absolute URL, following redirects through the identity
+// provider back to the callback.
+func (e *env) follow(t *testing.T, target string, status int, out interface{}) {
+	t.Helper()
+	u, err := url.Parse(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := e.url
+	e.url = u.Scheme + "://" + u.Host
+	defer func() { e.url = base }()
+	e.call(t, request{method: "GET", path: u.RequestURI()}, status, out)
+}
+
+func TestOIDCRoutes(t *testing.T) {
+	e := newEnv(t)
+	dave, session := e.register(t, "dave")
+
+	e.oidc.SetIdentity(oidctest.Identity{Subject: "dave", Email: "dave@idp.example", EmailVerified: true, PreferredUsername: "dave"})
+	var link struct {
+		AuthorizationURL string `json:"authorization_url"`
+	}
+	// The callback only completes in the browser that started the flow.
+	e.call(t, request{method: "POST", path: "/auth/oidc/link/", token: session.Token}, http.StatusOK, &link)
+	browser := e.client
+	e.client = &http.Client{Timeout: 10 * time.Second}
+	e.follow(t, link.AuthorizationURL, http.StatusUnauthorized, nil)
+	e.client = browser
+	var earlier struct {
+		AuthorizationURL string `json:"authorization_url"`
+	}
+	e.call(t, request{method: "POST", path: "/auth/oidc/link/", token: session.Token}, http.StatusOK, &earlier)
+	e.call(t, request{method: "POST", path: "/auth/oidc/link/", token: session.Token}, http.StatusOK, &link)
+	e.follow(t, earlier.AuthorizationURL, http.StatusUnauthorized, nil)
+
+	e.call(t, request{method: "POST", path: "/auth/oidc/link/", token: session.Token}, http.StatusOK, &link)
+	e.follow(t, link.AuthorizationURL, http.StatusCreated, nil)
+
+	var login tokens
+	e.follow(t, e.url+"/auth/oidc/login/", http.StatusOK, &login)
+	var profile user
+	e.call(t, request{method: "GET", path: "/user/me/", token: login.Token}, http.StatusOK, &profile)
+	if profile.ID != dave.ID {
+		t.Fatalf("OIDC login as %+v, want the linked user %+v", profile, dave)
+	}
+
+	e.oidc.SetIdentity(oidctest.Identity{Subject: "erin", Email: "erin@idp.example", EmailVerified: true, PreferredUsername: "erin"})
+	e.follow(t, e.url+"/auth/oidc/login/", http.StatusOK, &login)
+	e.call(t, request{method: "GET", path: "/user/me/", token: login.Token}, http.StatusOK, &profile)
+	if profile.Email != "erin@idp.example" {
+		t.Fatalf("provisioned user = %+v, want erin@idp.example", profile)
+	}
+}
+
+func TestGroupRoutes(t *testing.T) {
+	e := newEnv(t)
+	ctx := context.Background()
+	alice, session := e.register(t, "alice")
+	_, mallory := e.register(t, "mallory")
+	ifMatchAny := map[string]string{"If-Match": "*"}
+
+	var restaurant, replayed, kitchen, floor group
+	create := request{method: "POST", path: "/group/", token: session.Token, body: map[string]interface{}{
+		"name":     "Restaurant",
+		"owner_id": alice.ID,
+	}, header: map[string]string{"Idempotency-Key": "create-restaurant", "X-Request-ID": "create-restaurant-1"}}
+	resp := e.call(t, create, http.StatusCreated, &restaurant)
+	if got := resp.Header.Get("X-Request-ID"); got != "create-restaurant-1" {
+		t.Fatalf("X-Request-ID = %q, want the one sent", got)
+	}
+	create.header = map[string]string{"Idempotency-Key": "create-restaurant"}
+	resp = e.call(t, create, http.StatusCreated, &replayed)
+	if replayed.ID != restaurant.ID || resp.Header.Get("Idempotent-Replayed") != "true" {
+		t.Fatalf("retry created %+v instead of replaying %+v", replayed, restaurant)
+	}
+	// The replay gets an ID of its own, not the stored one.
+	if got := resp.Header.Get("X-Request-ID"); got == "" || got == "create-restaurant-1" {
+		t.Fatalf("X-Request-ID of the replay = %q, want a new one", got)
+	}
+	e.call(t, request{method: "POST", path: "/group/", token: session.Token, body: map[string]interface{}{
+		"name":      "Kitchen",
+		"owner_id":  alice.ID,
+		"parent_id": restaurant.ID,
+	}}, http.StatusCreated, &kitchen)
+	e.call(t, request{method: "POST", path: "/group/", token: session.Token, body: map[string]interface{}{
+		"name":     "Floor",
+		"owner_id": alice.ID,
+	}}, http.StatusCreated, &floor)
+
+	restaurantPath := "/group/" + id(restaurant.ID) + "/"
+	kitchenPath := "/group/" + id(kitchen.ID) + "/"
+	resp = e.call(t, request{method: "GET", path: restaurantPath, token: session.Token}, http.StatusOK, nil)
+	e.call(t, request{method: "GET", path: restaurantPath, token: mallory.Token}, http.StatusUnauthorized, nil)
+
+	update := request{method: "PUT", path: restaurantPath, token: session.Token, body: map[string]string{"name": "Bistro"}}
+	e.call(t, update, http.StatusPreconditionRequired, nil)
+	update.header = map[string]string{"If-Match": resp.Header.Get("ETag")}
+	e.call(t, update, http.StatusOK, nil)
+	e.call(t, update, http.StatusPreconditionFailed, nil)
+	e.call(t, request{method: "PATCH", path: restaurantPath, token: session.Token, header: ifMatchAny, body: map[string]string{"description": "Downtown"}}, http.StatusOK, nil)
+
+	e.call(t, request{method: "PUT", path: "/group/" + id(floor.ID) + "/parent/", token: session.Token, header: ifMatchAny, body: map[string]int32{"parent_id": restaurant.ID}}, http.StatusOK, &floor)
+	if floor.ParentID.Int32 != restaurant.ID {
+		t.Fatalf("floor after moving = %+v", floor)
+	}
+	// The restaurant can't be moved under its own sub-group.
+	e.call(t, request{method: "PUT", path: restaurantPath + "parent/", token: session.Token, header: ifMatchAny, body: map[string]int32{"parent_id": floor.ID}}, http.StatusBadRequest, nil)
+	e.call(t, request{method: "PUT", path: restaurantPath + "parent/", token: session.Token, header: ifMatchAny, body: map[string]int32{"parent_id": restaurant.ID}}, http.StatusBadRequest, nil)
+	e.call(t, request{method: "PUT", path: restaurantPath + "two-factor-policy/", token: session.Token, header: ifMatchAny, body: map[string]bool{"require_manager_two_factor": false}}, http.StatusOK, nil)
+
+	var owned []group
+	e.call(t, request{method: "GET", path: "/user/" + id(alice.ID) + "/group/", token: session.Token}, http.StatusOK, &owned)
+	if len(owned) != 3 {
+		t.Fatalf("alice owns %d groups, want 3", len(owned))
+	}
+
+	// There is no route to create shifts yet.
+	shift, err := db.New(e.pool).CreateShift(ctx, db.CreateShiftParams{
+		GroupID:   pgtype.Int4{Int32: kitchen.ID, Valid: true},
+		Name:      "Morning",
+		StartTime: pgtype.Timestamptz{Time: time.Now().Add(time.Hour), Valid: true},
+		EndTime:   pgtype.Timestamptz{Time: time.Now().Add(9 * time.Hour), Valid: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	shiftPath := kitchenPath + "shifts/" + id(shift.ID) + "/"
+	e.call(t, request{method: "GET", path: shiftPath, token: session.Token}, http.StatusOK, nil)
+	e.call(t, request{method: "GET", path: "/group/" + id(floor.ID) + "/shifts/" + id(shift.ID) + "/", token: session.Token}, http.StatusNotFound, nil)
+
+	e.call(t, request{method: "GET", path: restaurantPath + "subtree/", token: session.Token}, http.StatusOK, nil)
+	e.call(t, request{method: "GET", path: restaurantPath + "subtree/members/", token: session.Token}, http.StatusOK, nil)
+	var shifts []map[string]interface{}
+	e.call(t, request{method: "GET", path: restaurantPath + "subtree/shifts/", token: session.Token}, http.StatusOK, &shifts)
+	if len(shifts) != 1 {
+		t.Fatalf("subtree shifts = %v, want the kitchen shift", shifts)
+	}
+	window := url.Values{
+		"from": {time.Now().Format(time.RFC3339)},
+		"to":   {time.Now().Add(24 * time.Hour).Format(time.RFC3339)},
+	}
+	e.call(t, request{method: "GET", path: restaurantPath + "subtree/coverage/?" + window.Encode(), token: session.Token}, http.StatusOK, nil)
+
+	e.call(t, request{method: "DELETE", path: shiftPath, token: session.Token, header: ifMatchAny}, http.StatusOK, nil)
+	var deleted struct {
+		Shifts []map[string]interface{} `json:"shifts"`
+	}
+	e.call(t, request{method: "GET", path: kitchenPath + "deleted/", token: session.Token}, http.StatusOK, &deleted)
+	if len(deleted.Shifts) != 1 {
+		t.Fatalf("deleted shifts = %v, want the morning shift", deleted.Shifts)
+	}
+	e.call(t, request{method: "POST", path: shiftPath + "restore/", token: session.Token}, http.StatusOK, nil)
+	e.call(t, request{method: "GET", path: shiftPath, token: session.Token}, http.StatusOK, nil)
+
+	e.call(t, request{method: "DELETE", path: kitchenPath, token: session.Token, header: ifMatchAny}, http.StatusOK, nil)
+	e.call(t, request{method: "GET", path: kitchenPath, token: session.Token}, http.StatusNotFound, nil)
+	e.call(t, request{method: "POST", path: kitchenPath + "restore/", token: mallory.Token}, http.StatusUnauthorized, nil)
+	e.call(t, request{method: "POST", path: kitchenPath + "restore/", token: session.Token}, http.StatusOK, nil)
+	e.call(t, request{method: "GET", path: shiftPath, token: session.Token}, http.StatusOK, nil)
+
+	var events []map[string]interface{}
+	e.call(t, request{method: "GET", path: restaurantPath + "audit/", token: session.Token}, http.StatusOK, &events)
+	if len(events) == 0 {
+		t.Fatal("audit log of the restaurant is empty")
+	}
+	e.call(t, request{method: "GET", path: restaurantPath + "audit/?action=group.create&entity_id=" + id(restaurant.ID), token: session.Token}, http.StatusOK, &events)
+	if len(events) != 1 || events[0]["request_id"] != "create-restaurant-1" {
+		t.Fatalf("group.create events = %v, want one with the request ID", events)
+	}
+
+	// A deleted root group keeps its own log, and only its owner can read it.
+	e.call(t, request{method: "DELETE", path: restaurantPath, token: session.Token, header: ifMatchAny}, http.StatusOK, nil)
+	e.call(t, request{method: "GET", path: restaurantPath + "audit/", token: mallory.Token}, http.StatusUnauthorized, nil)
+	e.call(t, request{method: "GET", path: restaurantPath + "audit/?action=group.delete", token: session.Token}, http.StatusOK, &events)
+	if len(events) != 1 || events[0]["entity_id"] != float64(restaurant.ID) {
+		t.Fatalf("group.delete events = %v, want the restaurant's", events)
+	}
+
+	// The kitchen went with the restaurant and comes back with it.
+	e.call(t, request{method: "GET", path: kitchenPath, token: session.Token}, http.StatusNotFound, nil)
+	e.call(t, request{method: "POST", path: kitchenPath + "restore/", token: session.Token}, http.StatusBadRequest, nil)
+	e.call(t, request{method: "POST", path: restaurantPath + "restore/", token: session.Token}, http.StatusOK, nil)
+	e.call(t, request{method: "GET", path: kitchenPath, token: session.Token}, http.StatusOK, nil)
+	e.call(t, request{method: "GET", path: shiftPath, token: session.Token}, http.StatusOK, nil)
+}
+
+func TestAPIKeyRoutes(t *testing.T) {
+	e := newEnv(t)
+	alice, session := e.register(t, "alice")
+
+	var restaurant group
+	e.call(t, request{method: "POST", path: "/group/", token: session.Token, body: map[string]interface{}{
+		"name":     "Restaurant",
+		"owner_id": alice.ID,
+	}}, http.StatusCreated, &restaurant)
+	restaurantPath := "/group/" + id(restaurant.ID) + "/"
+
+	var key apiKey
+	e.call(t, request{method: "POST", path: "/user/api-keys/", token: session.Token, body: map[string]interface{}{
+		"name":   "reporting",
+		"scopes": []string{auth.ScopeReadGroups},
+	}}, http.StatusCreated, &key)
+	e.call(t, request{method: "GET", path: restaurantPath, token: key.Key}, http.StatusOK, nil)
+	e.call(t, request{method: "PATCH", path: restaurantPath, token: key.Key, header: map[string]string{"If-Match": "*"}, body: map[string]string{"name": "Bistro"}}, http.StatusForbidden, nil)
+	e.call(t, request{method: "GET", path: "/user/me/", token: key.Key}, http.StatusForbidden, nil)
+
+	// Once the group requires manager 2FA, keys of a creator without an
+	// authenticator stop working there.
+	_, err := e.pool.Exec(context.Background(), "UPDATE groups SET require_manager_two_factor = true WHERE id = $1", restaurant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.call(t, request{method: "GET", path: restaurantPath, token: key.Key}, http.StatusForbidden, nil)
+	_, err = e.pool.Exec(context.Background(), "UPDATE groups SET require_manager_two_factor = false WHERE id = $1", restaurant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.call(t, request{method: "GET", path: restaurantPath, token: key.Key}, http.StatusOK, nil)
+
+	var keys []map[string]interface{}
+	e.call(t, request{method: "GET", path: "/user/api-keys/", token: session.Token}, http.StatusOK, &keys)
+	if len(keys) != 1 {
+		t.Fatalf("api keys = %v, want one", keys)
+	}
+	e.call(t, request{method: "DELETE", path: "/user/api-keys/" + id(key.ID) + "/", token: session.Token}, http.StatusOK, nil)
+	e.call(t, request{method: "GET", path: restaurantPath, token: key.Key}, http.StatusUnauthorized, nil)
+}
+
+func TestServiceAccountRoutes(t *testing.T) {
+	e := newEnv(t)
+	alice, session := e.register(t, "alice")
+
+	var restaurant group
+	e.call(t, request{method: "POST", path: "/group/", token: session.Token, body: map[string]interface{}{
+		"name":     "Restaurant",
+		"owner_id": alice.ID,
+	}}, http.StatusCreated, &restaurant)
+	accountsPath := "/group/" + id(restaurant.ID) + "/service-accounts/"
+
+	var account user
+	e.call(t, request{method: "POST", path: accountsPath, token: session.Token, body: map[string]string{"name": "scheduler"}}, http.StatusCreated, &account)
+	e.call(t, request{method: "POST", path: accountsPath, token: session.Token, body: map[string]string{"name": "Not Valid"}}, http.StatusBadRequest, nil)
+	var accounts []map[string]interface{}
+	e.call(t, request{method: "GET", path: accountsPath, token: session.Token}, http.StatusOK, &accounts)
+	if len(accounts) != 1 {
+		t.Fatalf("service accounts = %v, want one", accounts)
+	}
+
+	keysPath := accountsPath + id(account.ID) + "/api-keys/"
+	var key apiKey
+	e.call(t, request{method: "POST", path: keysPath, token: session.Token, body: map[string]interface{}{
+		"name":   "cron",
+		"scopes": []string{auth.ScopeReadGroups, auth.ScopeReadShifts},
+	}}, http.StatusCreated, &key)
+	e.call(t, request{method: "GET", path: keysPath, token: session.Token}, http.StatusOK, nil)
+
+	// The account manages the group it belongs to.
+	e.call(t, request{method: "GET", path: "/group/" + id(restaurant.ID) + "/", token: key.Key}, http.StatusOK, nil)
+
+	e.call(t, request{method: "DELETE", path: keysPath + id(key.ID) + "/", token: session.Token}, http.StatusOK, nil)
+	e.call(t, request{method: "GET", path: "/group/" + id(restaurant.ID) + "/", token: key.Key}, http.StatusUnauthorized, nil)
+	e.call(t, request{method: "DELETE", path: keysPath + id(key.ID) + "/", token: session.Token}, http.StatusNotFound, nil)
+
+	// Keys of the group's accounts are in the group's audit log.
+	var events []map[string]interface{}
+	e.call(t, request{method: "GET", path: "/group/" + id(restaurant.ID) + "/audit/?entity_type=api_key", token: session.Token}, http.StatusOK, &events)
+	if len(events) != 2 || events[0]["action"] != "api_key.revoke" || events[1]["action"] != "api_key.create" ||
+		events[0]["entity_id"] != float64(key.ID) || events[1]["actor_id"] != float64(alice.ID) {
+		t.Fatalf("api_key events = %v, want the creation and revocation", events)
+	}
+	e.call(t, request{method: "DELETE", path: accountsPath + id(account.ID) + "/", token: session.Token}, http.StatusOK, nil)
+}
@@ -0,0 +1,36 @@
+// Command mockoidc runs the oidctest provider so the OIDC login flow can be
+// tried locally without a real identity provider.
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+
+	"github.com/joseph-gunnarsson/scheduling/internals/oidc/oidctest"
+)
+
+func main() {
+	addr := flag.String("addr", "localhost:9000", "address to listen on")
+	clientID := flag.String("client-id", "scheduling", "client id accepted by the provider")
+	subject := flag.String("sub", "mock-user-1", "subject of the logged in identity")
+	email := flag.String("email", "mock.user@example.com", "email of the logged in identity")
+	username := flag.String("username", "mockuser", "preferred_username of the logged in identity")
+	flag.Parse()
+
+	issuer := "http://" + *addr
+	provider, err := oidctest.NewProvider(issuer, *clientID, oidctest.Identity{
+		Subject:           *subject,
+		Email:             *email,
+		EmailVerified:     true,
+		PreferredUsername: *username,
+		GivenName:         "Mock",
+		FamilyName:        "User",
+	})
+	if err != nil {
+		log.Fatalf("Failed to create provider: %v", err)
+	}
+
+	log.Printf("Mock OIDC provider listening, set OIDC_ISSUER=%s", issuer)
+	log.Fatal(http.ListenAndServe(*addr, provider))
+}
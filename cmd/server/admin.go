@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/joseph-gunnarsson/scheduling/db"
+	models "github.com/joseph-gunnarsson/scheduling/db/models"
+	"github.com/joseph-gunnarsson/scheduling/internals/config"
+)
+
+// adminCommand is one leaf of the admin command tree, such as "user disable".
+type adminCommand struct {
+	name  string
+	args  string
+	about string
+	// minArgs and maxArgs bound the positional arguments.
+	minArgs, maxArgs int
+	// readOnly commands always roll back, --dry-run or not.
+	readOnly bool
+	// setup registers the flags of the command and returns the function
+	// that runs it. The function gets queries bound to one transaction and
+	// can read cfg once it runs.
+	setup func(flags *flag.FlagSet, cfg *config.Config) adminFunc
+}
+
+type adminFunc func(ctx context.Context, query *models.Queries, args []string) (*adminResult, error)
+
+// adminResult is what a command prints. data is printed with --output json,
+// columns and rows with --output table. Results without columns are always
+// printed as JSON.
+type adminResult struct {
+	data    any
+	columns []string
+	rows    [][]string
+}
+
+// errRollback makes db.InTx roll back a dry run after the command has
+// produced its result.
+var errRollback = errors.New("rollback")
+
+func adminUsage() string {
+	var b strings.Builder
+	b.WriteString("usage: server admin <command> [arguments] [flags]\n\n")
+	w := tabwriter.NewWriter(&b, 0, 0, 3, ' ', 0)
+	for _, cmd := range adminCommands {
+		fmt.Fprintf(w, "  %s %s\t%s\n", cmd.name, cmd.args, cmd.about)
+	}
+	w.Flush()
+	b.WriteString(`
+Users are given by username or email, groups by id. Every command takes
+--output table|json and --dry-run, which rolls the transaction back after
+printing what would have changed. server admin <command> -h lists the flags.`)
+	return b.String()
+}
+
+func admin(args []string) {
+	if len(args) == 0 || args[0] == "help" {
+		fmt.Println(adminUsage())
+		return
+	}
+
+	var cmd *adminCommand
+	var rest []string
+	for i := range adminCommands {
+		words := strings.Fields(adminCommands[i].name)
+		if len(args) >= len(words) && strings.Join(args[:len(words)], " ") == adminCommands[i].name {
+			cmd, rest = &adminCommands[i], args[len(words):]
+			break
+		}
+	}
+	if cmd == nil {
+		fmt.Fprintln(os.Stderr, adminUsage())
+		os.Exit(2)
+	}
+
+	flags := flag.NewFlagSet("admin "+cmd.name, flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: server admin %s %s\n\n", cmd.name, cmd.args)
+		flags.PrintDefaults()
+	}
+	output := flags.String("output", "table", "output format, table or json")
+	dryRun := flags.Bool("dry-run", false, "roll back instead of committing")
+	cfg := &config.Config{}
+	run := cmd.setup(flags, cfg)
+
+	// Parse has already printed the usage when it fails.
+	positional, err := parseInterleaved(flags, rest)
+	if err == flag.ErrHelp {
+		return
+	}
+	if err != nil {
+		os.Exit(2)
+	}
+	if len(positional) < cmd.minArgs || len(positional) > cmd.maxArgs {
+		flags.Usage()
+		os.Exit(2)
+	}
+	if *output != "table" && *output != "json" {
+		log.Fatalf("Invalid output format %q, use table or json", *output)
+	}
+
+	loaded, err := config.NewLoader().Load("database", "retention")
+	if err != nil {
+		log.Fatal(err)
+	}
+	*cfg = *loaded
+	ctx := context.Background()
+	pool, err := connect(cfg.Database, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer pool.Close()
+
+	result, err := runAdmin(ctx, pool, run, positional, *dryRun || cmd.readOnly)
+	if err != nil {
+		log.Fatalf("admin %s: %v", cmd.name, err)
+	}
+
+	err = result.print(os.Stdout, *output)
+	if err != nil {
+		log.Fatalf("Failed to write output: %v", err)
+	}
+	if *dryRun && !cmd.readOnly {
+		fmt.Fprintln(os.Stderr, "Dry run, nothing was changed")
+	}
+}
+
+// runAdmin runs a command in one transaction, which is rolled back instead of
+// committed when rollback is set.
+func runAdmin(ctx context.Context, conn db.TxBeginner, run adminFunc, args []string, rollback bool) (*adminResult, error) {
+	var result *adminResult
+	err := db.InTx(ctx, conn, func(query *models.Queries) error {
+		var err error
+		result, err = run(ctx, query, args)
+		if err != nil {
+			return err
+		}
+		if rollback {
+			return errRollback
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errRollback) {
+		return nil, err
+	}
+	return result, nil
+}
+
+// parseInterleaved parses flags before, between and after the positional
+// arguments, so both "user disable --dry-run alice" and "user disable alice
+// --dry-run" work. Everything after "--" is positional.
+func parseInterleaved(flags *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		err := flags.Parse(args)
+		if err != nil {
+			return nil, err
+		}
+		rest := flags.Args()
+		if n := len(args) - len(rest); n > 0 && args[n-1] == "--" {
+			return append(positional, rest...), nil
+		}
+		if len(rest) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+}
+
+func (r *adminResult) print(w io.Writer, output string) error {
+	if output == "json" || len(r.columns) == 0 {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(r.data)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.ToUpper(strings.Join(r.columns, "\t")))
+	for _, row := range r.rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
+
+// findUser looks a user up by username or email, including disabled ones.
+func findUser(ctx context.Context, query *models.Queries, name string) (models.User, error) {
+	user, err := query.GetUserByUsernameOrEmail(ctx, name)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return user, fmt.Errorf("no user with username or email %q", name)
+	}
+	return user, err
+}
+
+// findActiveUser is findUser for users that are about to be given something,
+// who have to be able to log in.
+func findActiveUser(ctx context.Context, query *models.Queries, name string) (models.User, error) {
+	user, err := findUser(ctx, query, name)
+	switch {
+	case err != nil:
+		return user, err
+	case user.ErasedAt.Valid:
+		return user, fmt.Errorf("%s has been erased", name)
+	case user.DisabledAt.Valid:
+		return user, fmt.Errorf("%s is disabled", name)
+	case user.ServiceGroupID.Valid:
+		return user, fmt.Errorf("%s is a service account", name)
+	}
+	return user, nil
+}
+
+func formatTime(t pgtype.Timestamptz) string {
+	if !t.Valid {
+		return "-"
+	}
+	return t.Time.Local().Format(time.RFC3339)
+}
+
+func formatText(t pgtype.Text) string {
+	if !t.Valid || t.String == "" {
+		return "-"
+	}
+	return t.String
+}
+
+func formatInt4(i pgtype.Int4) string {
+	if !i.Valid {
+		return "-"
+	}
+	return fmt.Sprint(i.Int32)
+}
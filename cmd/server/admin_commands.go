@@ -0,0 +1,559 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+	models "github.com/joseph-gunnarsson/scheduling/db/models"
+	"github.com/joseph-gunnarsson/scheduling/internals/auth"
+	"github.com/joseph-gunnarsson/scheduling/internals/config"
+	"github.com/joseph-gunnarsson/scheduling/internals/privacy"
+	"github.com/joseph-gunnarsson/scheduling/internals/retention"
+	"github.com/joseph-gunnarsson/scheduling/internals/service"
+)
+
+// generatedPasswordBytes gives 16 character passwords.
+const generatedPasswordBytes = 12
+
+var adminCommands = []adminCommand{
+	{
+		name: "user show", args: "<user>", about: "show an account and whether it can log in",
+		minArgs: 1, maxArgs: 1, readOnly: true,
+		setup: func(*flag.FlagSet, *config.Config) adminFunc { return userShow },
+	},
+	{
+		name: "user create", args: "<username> <email> [flags]",
+		about:   "create an account, with a generated password unless --password-stdin is set",
+		minArgs: 2, maxArgs: 2,
+		setup: userCreate,
+	},
+	{
+		name: "user disable", args: "<user>", about: "block logins and end every session",
+		minArgs: 1, maxArgs: 1,
+		setup: func(*flag.FlagSet, *config.Config) adminFunc { return userDisable },
+	},
+	{
+		name: "user enable", args: "<user>", about: "allow a disabled account to log in again",
+		minArgs: 1, maxArgs: 1,
+		setup: func(*flag.FlagSet, *config.Config) adminFunc { return userEnable },
+	},
+	{
+		name: "user reset-password", args: "<user> [flags]",
+		about:   "set a new password, generated unless --password-stdin is set, and end every session",
+		minArgs: 1, maxArgs: 1,
+		setup: userResetPassword,
+	},
+	{
+		name: "user unlock", args: "<user>", about: "clear failed logins and any lockout",
+		minArgs: 1, maxArgs: 1,
+		setup: func(*flag.FlagSet, *config.Config) adminFunc { return userUnlock },
+	},
+	{
+		name: "user export", args: "<user> [file]", about: "write everything stored about a user as JSON",
+		minArgs: 1, maxArgs: 2, readOnly: true,
+		setup: func(*flag.FlagSet, *config.Config) adminFunc { return userExport },
+	},
+	{
+		name: "user erase", args: "<user>", about: "erase the personal data of a user",
+		minArgs: 1, maxArgs: 1,
+		setup: func(*flag.FlagSet, *config.Config) adminFunc { return userErase },
+	},
+	{
+		name: "group transfer", args: "<group> <new owner>", about: "hand a group over to another user",
+		minArgs: 2, maxArgs: 2,
+		setup: func(*flag.FlagSet, *config.Config) adminFunc { return groupTransfer },
+	},
+	{
+		name: "shift reassign", args: "<from user> <to user> [flags]",
+		about:   "move upcoming shifts to another user in the groups they are a member of",
+		minArgs: 2, maxArgs: 2,
+		setup: shiftReassign,
+	},
+	{
+		name: "expired list", args: "", about: "count the rows expired purge would remove",
+		readOnly: true,
+		setup:    expiredPurge,
+	},
+	{
+		name: "expired purge", args: "", about: "remove expired sessions, tokens and deleted rows past retention",
+		setup: expiredPurge,
+	},
+}
+
+var userColumns = []string{"id", "username", "email", "status"}
+
+func userRow(user models.User) []string {
+	return []string{fmt.Sprint(user.ID), user.Username, user.Email, userStatus(user)}
+}
+
+// userStatus says whether the user can log in and why not.
+func userStatus(user models.User) string {
+	switch {
+	case user.ErasedAt.Valid:
+		return "erased"
+	case user.DisabledAt.Valid:
+		return "disabled since " + formatTime(user.DisabledAt)
+	case user.LockedUntil.Valid && user.LockedUntil.Time.After(time.Now()):
+		return "locked until " + formatTime(user.LockedUntil)
+	case user.ServiceGroupID.Valid:
+		return "service account of group " + formatInt4(user.ServiceGroupID)
+	case !user.EmailVerifiedAt.Valid:
+		return "unverified"
+	}
+	return "active"
+}
+
+func userResult(user models.User) *adminResult {
+	return &adminResult{data: user, columns: userColumns, rows: [][]string{userRow(user)}}
+}
+
+func userShow(ctx context.Context, query *models.Queries, args []string) (*adminResult, error) {
+	user, err := findUser(ctx, query, args[0])
+	if err != nil {
+		return nil, err
+	}
+	return userResult(user), nil
+}
+
+func userCreate(flags *flag.FlagSet, _ *config.Config) adminFunc {
+	passwordStdin := flags.Bool("password-stdin", false, "read the password from standard input instead of generating one")
+	firstName := flags.String("first-name", "", "first name")
+	lastName := flags.String("last-name", "", "last name")
+	verified := flags.Bool("verified", false, "mark the email address as verified")
+
+	return func(ctx context.Context, query *models.Queries, args []string) (*adminResult, error) {
+		plain, generated, err := passwordOrGenerated(*passwordStdin)
+		if err != nil {
+			return nil, err
+		}
+		hash, err := auth.HashPassword(ctx, plain)
+		if err != nil {
+			return nil, err
+		}
+
+		created, err := query.CreateUser(ctx, models.CreateUserParams{
+			Username:     args[0],
+			Email:        args[1],
+			PasswordHash: hash,
+			FirstName:    pgtype.Text{String: *firstName, Valid: *firstName != ""},
+			LastName:     pgtype.Text{String: *lastName, Valid: *lastName != ""},
+		})
+		if pgErr, ok := err.(*pgconn.PgError); ok && pgErr.Code == "23505" {
+			return nil, errors.New("username or email already exists")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if *verified {
+			_, err = query.MarkEmailVerified(ctx, models.MarkEmailVerifiedParams{ID: created.ID, Email: created.Email})
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		err = service.RecordAudit(ctx, query, service.Actor{}, service.AuditEvent{
+			Action:     "user.create",
+			EntityType: service.AuditEntityUser,
+			EntityID:   created.ID,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return passwordResult(ctx, query, created.ID, generated)
+	}
+}
+
+// adminStdin is where --password-stdin reads from.
+var adminStdin io.Reader = os.Stdin
+
+// passwordOrGenerated returns the first line of standard input when
+// fromStdin is set, or a random password otherwise. generated is only set in
+// the second case so it can be shown. Passwords are never flags, which end
+// up in the shell history and the process list.
+func passwordOrGenerated(fromStdin bool) (password string, generated string, err error) {
+	if !fromStdin {
+		generated, err = auth.RandomString(generatedPasswordBytes)
+		return generated, generated, err
+	}
+	line, err := bufio.NewReader(adminStdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", "", fmt.Errorf("read password: %w", err)
+	}
+	password = strings.TrimRight(line, "\r\n")
+	if password == "" {
+		return "", "", errors.New("no password on standard input")
+	}
+	return password, "", nil
+}
+
+// passwordResult shows the user together with a generated password, the
+// only time it is ever printed.
+func passwordResult(ctx context.Context, query *models.Queries, userID int32, generated string) (*adminResult, error) {
+	user, err := query.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if generated == "" {
+		return userResult(user), nil
+	}
+	return &adminResult{
+		data: struct {
+			User     models.User `json:"user"`
+			Password string      `json:"password"`
+		}{user, generated},
+		columns: []string{"id", "username", "email", "status", "password"},
+		rows:    [][]string{append(userRow(user), generated)},
+	}, nil
+}
+
+func userDisable(ctx context.Context, query *models.Queries, args []string) (*adminResult, error) {
+	user, err := findUser(ctx, query, args[0])
+	if err != nil {
+		return nil, err
+	}
+	disabled, err := query.DisableUser(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if disabled == 0 {
+		return nil, fmt.Errorf("%s is already disabled", user.Username)
+	}
+	// API keys stay, they are refused while the account is disabled and work
+	// again once it is enabled.
+	err = query.RevokeUserSessions(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = service.RecordAudit(ctx, query, service.Actor{}, service.AuditEvent{
+		Action:     "user.disable",
+		EntityType: service.AuditEntityUser,
+		EntityID:   user.ID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return userShow(ctx, query, []string{user.Username})
+}
+
+func userEnable(ctx context.Context, query *models.Queries, args []string) (*adminResult, error) {
+	user, err := findUser(ctx, query, args[0])
+	if err != nil {
+		return nil, err
+	}
+	enabled, err := query.EnableUser(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if enabled == 0 {
+		return nil, fmt.Errorf("%s is not disabled", user.Username)
+	}
+
+	err = service.RecordAudit(ctx, query, service.Actor{}, service.AuditEvent{
+		Action:     "user.enable",
+		EntityType: service.AuditEntityUser,
+		EntityID:   user.ID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return userShow(ctx, query, []string{user.Username})
+}
+
+func userResetPassword(flags *flag.FlagSet, _ *config.Config) adminFunc {
+	passwordStdin := flags.Bool("password-stdin", false, "read the new password from standard input instead of generating one")
+
+	return func(ctx context.Context, query *models.Queries, args []string) (*adminResult, error) {
+		user, err := findUser(ctx, query, args[0])
+		if err != nil {
+			return nil, err
+		}
+		if user.ErasedAt.Valid || user.ServiceGroupID.Valid {
+			return nil, fmt.Errorf("%s cannot log in with a password", user.Username)
+		}
+
+		plain, generated, err := passwordOrGenerated(*passwordStdin)
+		if err != nil {
+			return nil, err
+		}
+		hash, err := auth.HashPassword(ctx, plain)
+		if err != nil {
+			return nil, err
+		}
+		err = query.UpdateUserPassword(ctx, models.UpdateUserPasswordParams{ID: user.ID, PasswordHash: hash})
+		if err != nil {
+			return nil, err
+		}
+
+		// Same as a reset through email: whoever knew the old password is
+		// logged out, and links in earlier reset emails stop working.
+		err = query.RevokeUserSessions(ctx, user.ID)
+		if err != nil {
+			return nil, err
+		}
+		err = query.InvalidateUserTokens(ctx, models.InvalidateUserTokensParams{UserID: user.ID, Purpose: "password_reset"})
+		if err != nil {
+			return nil, err
+		}
+		err = query.UnlockUser(ctx, user.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		err = service.RecordAudit(ctx, query, service.Actor{}, service.AuditEvent{
+			Action:     "user.reset_password",
+			EntityType: service.AuditEntityUser,
+			EntityID:   user.ID,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return passwordResult(ctx, query, user.ID, generated)
+	}
+}
+
+func userUnlock(ctx context.Context, query *models.Queries, args []string) (*adminResult, error) {
+	user, err := findUser(ctx, query, args[0])
+	if err != nil {
+		return nil, err
+	}
+	err = query.UnlockUser(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = service.RecordAudit(ctx, query, service.Actor{}, service.AuditEvent{
+		Action:     "user.unlock",
+		EntityType: service.AuditEntityUser,
+		EntityID:   user.ID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return userShow(ctx, query, []string{user.Username})
+}
+
+func userExport(ctx context.Context, query *models.Queries, args []string) (*adminResult, error) {
+	user, err := findUser(ctx, query, args[0])
+	if err != nil {
+		return nil, err
+	}
+	archive, err := privacy.Export(ctx, query, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) == 1 {
+		return &adminResult{data: archive}, nil
+	}
+
+	file, err := os.OpenFile(args[1], os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	err = encoder.Encode(archive)
+	if err != nil {
+		return nil, err
+	}
+	return &adminResult{
+		data:    map[string]any{"user_id": user.ID, "file": args[1]},
+		columns: []string{"id", "username", "file"},
+		rows:    [][]string{{fmt.Sprint(user.ID), user.Username, args[1]}},
+	}, file.Close()
+}
+
+func userErase(ctx context.Context, query *models.Queries, args []string) (*adminResult, error) {
+	user, err := findUser(ctx, query, args[0])
+	if err != nil {
+		return nil, err
+	}
+	err = privacy.Erase(ctx, query, user.ID)
+	if errors.Is(err, privacy.ErrOwnsGroups) {
+		return nil, fmt.Errorf("%s still owns groups, transfer them with group transfer first", user.Username)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = service.RecordAudit(ctx, query, service.Actor{}, service.AuditEvent{
+		Action:     "user.erase",
+		EntityType: service.AuditEntityUser,
+		EntityID:   user.ID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	erased, err := query.GetUserByID(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	return userResult(erased), nil
+}
+
+func groupTransfer(ctx context.Context, query *models.Queries, args []string) (*adminResult, error) {
+	groupID, err := strconv.ParseInt(args[0], 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid group id %q", args[0])
+	}
+	before, err := query.GetGroupByID(ctx, int32(groupID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("no group with id %d", groupID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	owner, err := findActiveUser(ctx, query, args[1])
+	if err != nil {
+		return nil, err
+	}
+	if before.OwnerID.Valid && before.OwnerID.Int32 == owner.ID {
+		return nil, fmt.Errorf("%s already owns group %d", owner.Username, groupID)
+	}
+
+	group, err := query.TransferGroupOwnership(ctx, models.TransferGroupOwnershipParams{
+		ID:      before.ID,
+		OwnerID: pgtype.Int4{Int32: owner.ID, Valid: true},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = service.RecordAudit(ctx, query, service.Actor{}, service.AuditEvent{
+		Action:     "group.transfer",
+		EntityType: service.AuditEntityGroup,
+		EntityID:   group.ID,
+		GroupID:    group.ID,
+		Before:     before,
+		After:      group,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &adminResult{
+		data:    group,
+		columns: []string{"id", "name", "previous owner", "owner", "version"},
+		rows: [][]string{{
+			fmt.Sprint(group.ID), group.Name, formatInt4(before.OwnerID), owner.Username, fmt.Sprint(group.Version),
+		}},
+	}, nil
+}
+
+func shiftReassign(flags *flag.FlagSet, _ *config.Config) adminFunc {
+	groupID := flags.Int("group", 0, "only move shifts in this group")
+	since := time.Now()
+	flags.Func("since", "move shifts starting at or after this time, RFC 3339 or YYYY-MM-DD (default now)", func(value string) error {
+		var err error
+		since, err = time.Parse(time.RFC3339, value)
+		if err != nil {
+			since, err = time.ParseInLocation(time.DateOnly, value, time.Local)
+		}
+		if err != nil {
+			return errors.New("want RFC 3339 or YYYY-MM-DD")
+		}
+		return nil
+	})
+
+	return func(ctx context.Context, query *models.Queries, args []string) (*adminResult, error) {
+		from, err := findUser(ctx, query, args[0])
+		if err != nil {
+			return nil, err
+		}
+		to, err := findActiveUser(ctx, query, args[1])
+		if err != nil {
+			return nil, err
+		}
+		if from.ID == to.ID {
+			return nil, errors.New("the shifts already belong to that user")
+		}
+
+		moved, err := query.ReassignUserShifts(ctx, models.ReassignUserShiftsParams{
+			ToUserID:   pgtype.Int4{Int32: to.ID, Valid: true},
+			FromUserID: pgtype.Int4{Int32: from.ID, Valid: true},
+			Since:      pgtype.Timestamptz{Time: since, Valid: true},
+			GroupID:    pgtype.Int4{Int32: int32(*groupID), Valid: *groupID != 0},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		// What is left in range is in groups the new user is not a member of.
+		kept, err := query.ListShiftsByUser(ctx, pgtype.Int4{Int32: from.ID, Valid: true})
+		if err != nil {
+			return nil, err
+		}
+		skipped := []models.Shift{}
+		for _, shift := range kept {
+			if !shift.StartTime.Time.Before(since) && (*groupID == 0 || shift.GroupID.Int32 == int32(*groupID)) {
+				skipped = append(skipped, shift)
+			}
+		}
+
+		if moved == nil {
+			moved = []models.Shift{}
+		}
+		result := &adminResult{
+			data: struct {
+				Moved   []models.Shift `json:"moved"`
+				Skipped []models.Shift `json:"skipped"`
+			}{moved, skipped},
+			columns: []string{"id", "group", "name", "start", "end", "from", "to", "result"},
+		}
+		for _, shift := range moved {
+			err = service.RecordAudit(ctx, query, service.Actor{}, service.AuditEvent{
+				Action:     "shift.reassign",
+				EntityType: service.AuditEntityShift,
+				EntityID:   shift.ID,
+				GroupID:    shift.GroupID.Int32,
+				Before:     map[string]int32{"user_id": from.ID},
+				After:      map[string]int32{"user_id": to.ID},
+			})
+			if err != nil {
+				return nil, err
+			}
+			result.rows = append(result.rows, []string{
+				fmt.Sprint(shift.ID), formatInt4(shift.GroupID), shift.Name,
+				formatTime(shift.StartTime), formatTime(shift.EndTime), from.Username, to.Username, "moved",
+			})
+		}
+		for _, shift := range skipped {
+			result.rows = append(result.rows, []string{
+				fmt.Sprint(shift.ID), formatInt4(shift.GroupID), shift.Name,
+				formatTime(shift.StartTime), formatTime(shift.EndTime), from.Username, to.Username,
+				"skipped, " + to.Username + " is not a member of the group",
+			})
+		}
+		return result, nil
+	}
+}
+
+// expiredPurge backs both expired commands, list is the same purge rolled
+// back.
+func expiredPurge(_ *flag.FlagSet, cfg *config.Config) adminFunc {
+	return func(ctx context.Context, query *models.Queries, args []string) (*adminResult, error) {
+		cutoff := retention.Cutoff(time.Now(), cfg.Retention.Window)
+		expired, err := retention.PurgeExpired(ctx, query, cutoff)
+		if err != nil {
+			return nil, err
+		}
+
+		result := &adminResult{data: expired, columns: []string{"kind", "count"}}
+		for _, e := range expired {
+			result.rows = append(result.rows, []string{e.Kind, fmt.Sprint(e.Count)})
+		}
+		return result, nil
+	}
+}
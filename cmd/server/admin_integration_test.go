@@ -0,0 +1,278 @@
+//go:build integration
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+	models "github.com/joseph-gunnarsson/scheduling/db/models"
+	"github.com/joseph-gunnarsson/scheduling/internals/auth"
+	"github.com/joseph-gunnarsson/scheduling/internals/config"
+	"github.com/joseph-gunnarsson/scheduling/internals/pgtest"
+)
+
+var server *pgtest.Server
+
+func TestMain(m *testing.M) {
+	var err error
+	server, err = pgtest.Start()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	code := m.Run()
+	err = server.Stop()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	os.Exit(code)
+}
+
+// runCommand runs an admin command line, such as "user disable alice",
+// the way server admin does.
+func runCommand(t *testing.T, pool *pgxpool.Pool, line string) (*adminResult, error) {
+	t.Helper()
+	args := strings.Fields(line)
+	for _, cmd := range adminCommands {
+		words := strings.Fields(cmd.name)
+		if len(args) < len(words) || strings.Join(args[:len(words)], " ") != cmd.name {
+			continue
+		}
+
+		flags := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+		flags.SetOutput(io.Discard)
+		dryRun := flags.Bool("dry-run", false, "")
+		run := cmd.setup(flags, &config.Config{Retention: config.Retention{Window: 30 * 24 * time.Hour}})
+		positional, err := parseInterleaved(flags, args[len(words):])
+		if err != nil {
+			t.Fatalf("%s: %v", line, err)
+		}
+		return runAdmin(context.Background(), pool, run, positional, *dryRun || cmd.readOnly)
+	}
+	t.Fatalf("no admin command for %q", line)
+	return nil, nil
+}
+
+func mustRun(t *testing.T, pool *pgxpool.Pool, line string) *adminResult {
+	t.Helper()
+	result, err := runCommand(t, pool, line)
+	if err != nil {
+		t.Fatalf("%s: %v", line, err)
+	}
+	return result
+}
+
+func TestAdminUserCommands(t *testing.T) {
+	ctx := context.Background()
+	pool := server.NewDatabase(t)
+	query := models.New(pool)
+
+	created := mustRun(t, pool, "user create alice alice@example.com --verified")
+	if got := created.rows[0][len(created.rows[0])-1]; len(got) != 16 {
+		t.Fatalf("generated password %q, want 16 characters", got)
+	}
+	if got := created.rows[0][3]; got != "active" {
+		t.Fatalf("status of a verified new user = %q, want active", got)
+	}
+	_, err := runCommand(t, pool, "user create alice other@example.com")
+	if err == nil {
+		t.Fatal("creating a second alice succeeded")
+	}
+
+	mustRun(t, pool, "user disable alice --dry-run")
+	_, err = query.LoginUser(ctx, "alice")
+	if err != nil {
+		t.Fatalf("alice cannot log in after a dry run: %v", err)
+	}
+
+	mustRun(t, pool, "user disable alice")
+	_, err = query.LoginUser(ctx, "alice")
+	if err != pgx.ErrNoRows {
+		t.Fatalf("LoginUser of a disabled user = %v, want no rows", err)
+	}
+	_, err = runCommand(t, pool, "user disable alice")
+	if err == nil {
+		t.Fatal("disabling alice twice succeeded")
+	}
+
+	mustRun(t, pool, "user enable alice@example.com")
+	_, err = query.LoginUser(ctx, "alice")
+	if err != nil {
+		t.Fatalf("alice cannot log in after being enabled: %v", err)
+	}
+
+	defer func(stdin io.Reader) { adminStdin = stdin }(adminStdin)
+	adminStdin = strings.NewReader("new-password-123\n")
+	reset := mustRun(t, pool, "user reset-password alice --password-stdin")
+	if len(reset.columns) != 4 {
+		t.Fatalf("a given password was printed: %v", reset.columns)
+	}
+	login, err := query.LoginUser(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := auth.ComparePassword(ctx, login.PasswordHash, "new-password-123"); err != nil {
+		t.Fatalf("the password read from stdin does not work: %v", err)
+	}
+	adminStdin = strings.NewReader("")
+	_, err = runCommand(t, pool, "user reset-password alice --password-stdin")
+	if err == nil {
+		t.Fatal("resetting to an empty password succeeded")
+	}
+
+	var events int
+	err = pool.QueryRow(ctx, "SELECT count(*) FROM audit_events WHERE action LIKE 'user.%' AND actor_id IS NULL").Scan(&events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// create, disable, enable and reset, the dry run is rolled back.
+	if events != 4 {
+		t.Fatalf("%d audit events, want 4", events)
+	}
+}
+
+func TestAdminGroupTransferAndShiftReassign(t *testing.T) {
+	ctx := context.Background()
+	pool := server.NewDatabase(t)
+	query := models.New(pool)
+
+	mustRun(t, pool, "user create alice alice@example.com --verified")
+	mustRun(t, pool, "user create bob bob@example.com --verified")
+	alice, err := query.GetUserByUsernameOrEmail(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := query.GetUserByUsernameOrEmail(ctx, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	group, err := query.CreateGroup(ctx, models.CreateGroupParams{
+		Name:    "Kitchen",
+		OwnerID: pgtype.Int4{Int32: alice.ID, Valid: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bar, err := query.CreateGroup(ctx, models.CreateGroupParams{
+		Name:    "Bar",
+		OwnerID: pgtype.Int4{Int32: alice.ID, Valid: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = query.AddUserToGroup(ctx, models.AddUserToGroupParams{UserID: bob.ID, GroupID: group.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	shifts := []struct {
+		groupID int32
+		start   time.Time
+	}{
+		{group.ID, now.Add(-48 * time.Hour)},
+		{group.ID, now.Add(24 * time.Hour)},
+		{group.ID, now.Add(48 * time.Hour)},
+		// bob is not in the bar, so this one stays with alice.
+		{bar.ID, now.Add(24 * time.Hour)},
+	}
+	for _, shift := range shifts {
+		_, err = query.CreateShift(ctx, models.CreateShiftParams{
+			UserID:    pgtype.Int4{Int32: alice.ID, Valid: true},
+			GroupID:   pgtype.Int4{Int32: shift.groupID, Valid: true},
+			Name:      "Morning",
+			StartTime: pgtype.Timestamptz{Time: shift.start, Valid: true},
+			EndTime:   pgtype.Timestamptz{Time: shift.start.Add(4 * time.Hour), Valid: true},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err = runCommand(t, pool, fmt.Sprintf("group transfer %d alice", group.ID))
+	if err == nil {
+		t.Fatal("transferring a group to its owner succeeded")
+	}
+	mustRun(t, pool, fmt.Sprintf("group transfer %d bob", group.ID))
+	transferred, err := query.GetGroupByID(ctx, group.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transferred.OwnerID.Int32 != bob.ID || transferred.Version != group.Version+1 {
+		t.Fatalf("group after transfer = %+v, want owner %d and a new version", transferred, bob.ID)
+	}
+
+	moved := mustRun(t, pool, fmt.Sprintf("shift reassign alice bob --group %d", group.ID))
+	if len(moved.rows) != 2 {
+		t.Fatalf("reassigned %d shifts, want the 2 upcoming ones", len(moved.rows))
+	}
+	skipped := mustRun(t, pool, "shift reassign alice bob")
+	if len(skipped.rows) != 1 || skipped.rows[0][1] != fmt.Sprint(bar.ID) || !strings.HasPrefix(skipped.rows[0][7], "skipped") {
+		t.Fatalf("reassigning the rest = %v, want the bar shift reported as skipped", skipped.rows)
+	}
+	kept, err := query.ListShiftsByUser(ctx, pgtype.Int4{Int32: alice.ID, Valid: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kept) != 2 || !kept[0].StartTime.Time.Before(now) || kept[1].GroupID.Int32 != bar.ID {
+		t.Fatalf("alice kept %+v, want the past shift and the bar shift", kept)
+	}
+
+	mustRun(t, pool, "user disable bob")
+	_, err = runCommand(t, pool, "shift reassign alice bob --since 2000-01-01")
+	if err == nil {
+		t.Fatal("reassigning shifts to a disabled user succeeded")
+	}
+}
+
+func TestAdminExpired(t *testing.T) {
+	ctx := context.Background()
+	pool := server.NewDatabase(t)
+	query := models.New(pool)
+
+	mustRun(t, pool, "user create alice alice@example.com")
+	alice, err := query.GetUserByUsernameOrEmail(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = query.CreateUserToken(ctx, models.CreateUserTokenParams{
+		UserID:    alice.ID,
+		Purpose:   "email_verification",
+		TokenHash: strings.Repeat("a", 64),
+		ExpiresAt: pgtype.Timestamptz{Time: time.Now().Add(-time.Hour), Valid: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count := func(result *adminResult) string {
+		for _, row := range result.rows {
+			if row[0] == "user_tokens" {
+				return row[1]
+			}
+		}
+		t.Fatalf("no user_tokens row in %v", result.rows)
+		return ""
+	}
+	if got := count(mustRun(t, pool, "expired list")); got != "1" {
+		t.Fatalf("expired list counts %s user tokens, want 1", got)
+	}
+	// list only counts, so purge still finds the token.
+	if got := count(mustRun(t, pool, "expired purge")); got != "1" {
+		t.Fatalf("expired purge removed %s user tokens, want 1", got)
+	}
+	if got := count(mustRun(t, pool, "expired list")); got != "0" {
+		t.Fatalf("expired list after a purge counts %s user tokens, want 0", got)
+	}
+}
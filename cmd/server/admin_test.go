@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseInterleaved(t *testing.T) {
+	tests := []struct {
+		args       []string
+		positional []string
+		dryRun     bool
+		output     string
+	}{
+		{[]string{"alice"}, []string{"alice"}, false, "table"},
+		{[]string{"--dry-run", "alice"}, []string{"alice"}, true, "table"},
+		{[]string{"alice", "--dry-run", "--output", "json"}, []string{"alice"}, true, "json"},
+		{[]string{"alice", "-output=json", "bob"}, []string{"alice", "bob"}, false, "json"},
+		{[]string{"alice", "--", "--dry-run"}, []string{"alice", "--dry-run"}, false, "table"},
+	}
+	for _, tt := range tests {
+		flags := flag.NewFlagSet("test", flag.ContinueOnError)
+		flags.SetOutput(io.Discard)
+		dryRun := flags.Bool("dry-run", false, "")
+		output := flags.String("output", "table", "")
+
+		positional, err := parseInterleaved(flags, tt.args)
+		if err != nil {
+			t.Fatalf("parseInterleaved(%q): %v", tt.args, err)
+		}
+		if !reflect.DeepEqual(positional, tt.positional) || *dryRun != tt.dryRun || *output != tt.output {
+			t.Errorf("parseInterleaved(%q) = %q, dry-run %v, output %q, want %q, %v, %q",
+				tt.args, positional, *dryRun, *output, tt.positional, tt.dryRun, tt.output)
+		}
+	}
+}
+
+func TestPasswordOrGenerated(t *testing.T) {
+	defer func(stdin io.Reader) { adminStdin = stdin }(adminStdin)
+
+	tests := []struct {
+		stdin        string
+		wantPassword string
+		wantErr      bool
+	}{
+		{"new-password-123\n", "new-password-123", false},
+		{"new-password-123\r\nignored\n", "new-password-123", false},
+		{"no-newline", "no-newline", false},
+		{"", "", true},
+		{"\n", "", true},
+	}
+	for _, tt := range tests {
+		adminStdin = strings.NewReader(tt.stdin)
+		password, generated, err := passwordOrGenerated(true)
+		if (err != nil) != tt.wantErr || password != tt.wantPassword || generated != "" {
+			t.Errorf("passwordOrGenerated(true) with %q = %q, %q, %v, want %q", tt.stdin, password, generated, err, tt.wantPassword)
+		}
+	}
+
+	password, generated, err := passwordOrGenerated(false)
+	if err != nil || len(password) != 16 || generated != password {
+		t.Errorf("passwordOrGenerated(false) = %q, %q, %v, want a shown 16 character password", password, generated, err)
+	}
+}
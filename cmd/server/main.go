@@ -1,25 +1,238 @@
+// Command server runs the scheduling API and manages its database schema.
+//
+//	server [serve] [-migrate]
+//	server migrate up [N]
+//	server migrate down [N]
+//	server migrate goto <version>
+//	server migrate status
+//	server admin <command> [arguments] [flags]
+//	server version
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
-	"github.com/joho/godotenv"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/joseph-gunnarsson/scheduling/api/handlers"
 	"github.com/joseph-gunnarsson/scheduling/api/middleware"
 	"github.com/joseph-gunnarsson/scheduling/api/routers"
 	"github.com/joseph-gunnarsson/scheduling/db"
+	"github.com/joseph-gunnarsson/scheduling/db/migrations"
+	"github.com/joseph-gunnarsson/scheduling/internals/auth"
+	"github.com/joseph-gunnarsson/scheduling/internals/config"
+	"github.com/joseph-gunnarsson/scheduling/internals/logging"
+	"github.com/joseph-gunnarsson/scheduling/internals/mail"
+	"github.com/joseph-gunnarsson/scheduling/internals/metrics"
+	"github.com/joseph-gunnarsson/scheduling/internals/oidc"
+	"github.com/joseph-gunnarsson/scheduling/internals/retention"
+	"github.com/joseph-gunnarsson/scheduling/internals/tracing"
 )
 
+const usage = `usage:
+  server [serve] [-migrate]       run the API, -migrate applies pending migrations first
+  server migrate up [N]           apply all or the next N pending migrations
+  server migrate down [N]         revert the last N migrations, 1 by default
+  server migrate goto <version>   migrate up or down to a version, 0 reverts everything
+  server migrate status           show the applied and pending migrations
+  server admin <command>          run an operational task, see server admin help
+  server version                  print the build version`
+
 func main() {
-	err := godotenv.Load()
+	command, args := "serve", os.Args[1:]
+	// Flags without a command belong to serve.
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+
+	switch command {
+	case "serve":
+		serve(args)
+	case "migrate":
+		migrate(args)
+	case "admin":
+		admin(args)
+	case "version":
+		printVersion()
+	case "help":
+		fmt.Println(usage)
+	default:
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+}
+
+func serve(args []string) {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, usage)
+		fmt.Fprintln(os.Stderr, "\nserve flags:")
+		flags.PrintDefaults()
+	}
+	migrateFirst := flags.Bool("migrate", false, "apply pending migrations before serving")
+	loader := config.NewLoader()
+	loader.RegisterFlags(flags)
+	flags.Parse(args)
+
+	cfg, err := loader.Load()
+	if err != nil {
+		log.Fatal(err)
+	}
+	slog.SetDefault(logging.New(os.Stderr, cfg.Log.Format, cfg.Log.Level))
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Config{
+		Exporter:       cfg.Tracing.Exporter,
+		File:           cfg.Tracing.File,
+		Endpoint:       cfg.Tracing.Endpoint,
+		ServiceVersion: version,
+	})
 	if err != nil {
-		log.Fatalf("Error loading .env file")
+		fatal("Invalid tracing configuration", err)
 	}
-	conn := db.GetDBConnection()
-	handler := handlers.NewBaseHandler(conn)
-	mm := middleware.NewMiddlewareManager(conn)
-	mux := routers.Routers(handler, mm)
+	tokens, err := auth.NewTokenServiceFromSettings(auth.KeySettings{
+		Algorithm:       cfg.JWT.Algorithm,
+		Secret:          cfg.JWT.Secret,
+		PrivateKeyFile:  cfg.JWT.PrivateKeyFile,
+		KeyID:           cfg.JWT.KeyID,
+		Issuer:          cfg.JWT.Issuer,
+		Audience:        cfg.JWT.Audience,
+		Leeway:          cfg.JWT.Leeway,
+		PreviousSecrets: cfg.JWT.PreviousSecrets,
+		PublicKeyFiles:  cfg.JWT.PublicKeyFiles,
+	})
+	if err != nil {
+		fatal("Invalid token configuration", err)
+	}
+	var oidcProvider *oidc.Provider
+	if cfg.OIDC.Issuer != "" {
+		oidcProvider = oidc.NewProvider(oidc.Config{
+			Issuer:        cfg.OIDC.Issuer,
+			ClientID:      cfg.OIDC.ClientID,
+			ClientSecret:  cfg.OIDC.ClientSecret,
+			RedirectURL:   cfg.OIDC.RedirectURL,
+			Scopes:        cfg.OIDC.Scopes,
+			AutoProvision: cfg.OIDC.AutoProvision,
+		}, nil)
+	}
+	mailer, err := mail.New(mail.Config{
+		Driver: cfg.Mail.Driver,
+		From:   cfg.Mail.From,
+		Dir:    cfg.Mail.Dir,
+		SMTP: mail.SMTPConfig{
+			Host:     cfg.Mail.SMTP.Host,
+			Port:     cfg.Mail.SMTP.Port,
+			Username: cfg.Mail.SMTP.Username,
+			Password: cfg.Mail.SMTP.Password,
+		},
+	})
+	if err != nil {
+		fatal("Invalid mail configuration", err)
+	}
+	unverifiedAccess, err := middleware.ParseUnverifiedAccess(cfg.Server.UnverifiedUserAccess)
+	if err != nil {
+		fatal("Invalid configuration", err)
+	}
+	trustedProxies, err := middleware.ParseTrustedProxies(cfg.Server.TrustedProxies)
+	if err != nil {
+		fatal("Invalid configuration", err)
+	}
+	publicURL := strings.TrimSuffix(cfg.Server.PublicURL, "/")
+
+	// The first SIGTERM or Ctrl-C drains, a second one kills the process.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
 
-	log.Fatal(http.ListenAndServe(":8080", mux))
+	serverMetrics := metrics.New()
+	pool, err := connect(cfg.Database, db.ChainTracers(serverMetrics.QueryTracer(), tracing.QueryTracer()))
+	if err != nil {
+		fatal("Failed to connect to the database", err)
+	}
+	serverMetrics.WatchPool(pool)
+	if *migrateFirst {
+		err = migrateUp(ctx, pool)
+		if err != nil {
+			fatal("Failed to migrate the database", err)
+		}
+	}
+	schemaVersion, err := migrations.Latest()
+	if err != nil {
+		fatal("Failed to read the embedded migrations", err)
+	}
+	retentionDone := make(chan struct{})
+	go func() {
+		defer close(retentionDone)
+		retention.Run(ctx, pool, cfg.Retention.Window, retention.DefaultPurgeInterval)
+	}()
+	handler := handlers.NewBaseHandler(pool, tokens, oidcProvider, mailer, publicURL, cfg.Retention.Window, schemaVersion)
+	mm := middleware.NewMiddlewareManager(pool, tokens, unverifiedAccess, cfg.Server.IdempotencyKeyTTL, cfg.Server.WriteTimeout, serverMetrics, cfg.Server.MetricsToken)
+	server := &http.Server{
+		Addr:         cfg.Server.Addr,
+		Handler:      middleware.ForwardedFor(trustedProxies, tracing.Handler(routers.Routers(handler, mm))),
+		ReadTimeout:  cfg.Server.ReadTimeout,
+		WriteTimeout: cfg.Server.WriteTimeout,
+		IdleTimeout:  cfg.Server.IdleTimeout,
+	}
+
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- server.ListenAndServe() }()
+	slog.Info("Listening", "addr", cfg.Server.Addr, "env", cfg.Env)
+	select {
+	case err = <-serveErr:
+		fatal("Server failed", err)
+	case <-ctx.Done():
+	}
+	stop()
+
+	slog.Info("Shutting down", "delay", cfg.Server.ShutdownDelay.String(), "timeout", cfg.Server.ShutdownTimeout.String())
+	handler.Drain()
+	time.Sleep(cfg.Server.ShutdownDelay)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+	err = server.Shutdown(shutdownCtx)
+	if err != nil {
+		slog.Warn("Requests still running were cut off", "error", err)
+		server.Close()
+	}
+	handler.Wait()
+	<-retentionDone
+	pool.Close()
+	flushCtx, cancelFlush := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancelFlush()
+	err = shutdownTracing(flushCtx)
+	if err != nil {
+		slog.Warn("Failed to export the last spans", "error", err)
+	}
+	slog.Info("Server stopped")
+}
+
+// fatal logs at the error level and exits. log.Fatal would log at the info
+// level once slog is the default logger.
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
+
+// connect opens the connection pool and checks that the database answers.
+// tracer, when not nil, sees every query run on the pool.
+func connect(settings config.Database, tracer pgx.QueryTracer) (*pgxpool.Pool, error) {
+	poolConfig, err := db.PoolConfig(settings.URL, db.PoolSettings{
+		MaxConns:        settings.MaxConns,
+		MinConns:        settings.MinConns,
+		MaxConnLifetime: settings.MaxConnLifetime,
+		MaxConnIdleTime: settings.MaxConnIdleTime,
+	})
+	if err != nil {
+		return nil, err
+	}
+	poolConfig.ConnConfig.Tracer = tracer
+	return db.Connect(context.Background(), poolConfig)
 }
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/joseph-gunnarsson/scheduling/db/migrations"
+	"github.com/joseph-gunnarsson/scheduling/internals/config"
+)
+
+func migrate(args []string) {
+	if len(args) == 0 || len(args) > 2 {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	ctx := context.Background()
+	var run func(m *migrations.Migrator) error
+	switch {
+	case args[0] == "up":
+		steps := parseSteps(args, 0)
+		run = func(m *migrations.Migrator) error { return m.Up(ctx, steps) }
+	case args[0] == "down":
+		steps := parseSteps(args, 1)
+		run = func(m *migrations.Migrator) error { return m.Down(ctx, steps) }
+	case args[0] == "goto" && len(args) == 2:
+		version, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil || version < 0 {
+			log.Fatalf("Invalid version %q", args[1])
+		}
+		run = func(m *migrations.Migrator) error { return m.Goto(ctx, version) }
+	case args[0] == "status" && len(args) == 1:
+		run = func(m *migrations.Migrator) error { return printStatus(ctx, m) }
+	default:
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	cfg, err := config.NewLoader().Load("database")
+	if err != nil {
+		log.Fatal(err)
+	}
+	pool, err := connect(cfg.Database, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer pool.Close()
+
+	err = withMigrator(ctx, pool, run)
+	if err != nil {
+		log.Fatalf("Migration failed: %v", err)
+	}
+}
+
+// parseSteps reads the optional step count after up or down.
+func parseSteps(args []string, fallback int) int {
+	if len(args) == 1 {
+		return fallback
+	}
+	steps, err := strconv.Atoi(args[1])
+	if err != nil || steps < 1 {
+		log.Fatalf("Invalid number of migrations %q", args[1])
+	}
+	return steps
+}
+
+// migrateUp applies every pending migration, for serve -migrate.
+func migrateUp(ctx context.Context, pool *pgxpool.Pool) error {
+	return withMigrator(ctx, pool, func(m *migrations.Migrator) error {
+		return m.Up(ctx, 0)
+	})
+}
+
+func withMigrator(ctx context.Context, pool *pgxpool.Pool, fn func(m *migrations.Migrator) error) error {
+	all, err := migrations.Load()
+	if err != nil {
+		return err
+	}
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	migrator := migrations.NewMigrator(conn.Conn(), all)
+	migrator.OnApply = func(m migrations.Migration, up bool) {
+		direction := "Applied"
+		if !up {
+			direction = "Reverted"
+		}
+		log.Printf("%s %06d_%s", direction, m.Version, m.Name)
+	}
+	return fn(migrator)
+}
+
+func printStatus(ctx context.Context, m *migrations.Migrator) error {
+	version, dirty, err := m.Version(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, migration := range m.Migrations() {
+		state := "pending"
+		if migration.Version <= version {
+			state = "applied"
+		}
+		fmt.Printf("%-8s %06d_%s\n", state, migration.Version, migration.Name)
+	}
+
+	switch {
+	case version == 0:
+		fmt.Println("\nNo migrations applied")
+	case dirty:
+		fmt.Printf("\nVersion %d is dirty, fix the schema by hand before migrating\n", version)
+	default:
+		fmt.Printf("\nVersion %d\n", version)
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// version is set at build time:
+//
+//	go build -ldflags "-X main.version=v1.2.0" ./cmd/server
+var version = "dev"
+
+func printVersion() {
+	fmt.Printf("scheduling %s\n", version)
+
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return
+	}
+	fmt.Printf("go         %s\n", info.GoVersion)
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			fmt.Printf("commit     %s\n", setting.Value)
+		case "vcs.time":
+			fmt.Printf("built from %s\n", setting.Value)
+		case "vcs.modified":
+			if setting.Value == "true" {
+				fmt.Println("modified   true")
+			}
+		}
+	}
+}
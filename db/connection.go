@@ -2,24 +2,61 @@ package db
 
 import (
 	"context"
-	"log"
-	"os"
+	"fmt"
+	"time"
 
-	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-func GetDBConnection() *pgx.Conn {
-	ctx := context.Background()
-	conn, err := pgx.Connect(ctx, os.Getenv("POSTGRES_URL"))
+// PoolSettings size the connection pool. Zero values keep the pgxpool
+// defaults or the pool_* options of the URL.
+type PoolSettings struct {
+	MaxConns        int
+	MinConns        int
+	MaxConnLifetime time.Duration
+	MaxConnIdleTime time.Duration
+}
+
+// PoolConfig parses the connection URL and applies the pool sizing.
+func PoolConfig(url string, s PoolSettings) (*pgxpool.Config, error) {
+	config, err := pgxpool.ParseConfig(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid database URL: %w", err)
+	}
+
+	if s.MaxConns > 0 {
+		config.MaxConns = int32(s.MaxConns)
+	}
+	if s.MinConns > 0 {
+		config.MinConns = int32(s.MinConns)
+	}
+	if config.MinConns > config.MaxConns {
+		return nil, fmt.Errorf("the pool can't keep %d connections open with at most %d", config.MinConns, config.MaxConns)
+	}
+	if s.MaxConnLifetime > 0 {
+		config.MaxConnLifetime = s.MaxConnLifetime
+	}
+	if s.MaxConnIdleTime > 0 {
+		config.MaxConnIdleTime = s.MaxConnIdleTime
+	}
+
+	return config, nil
+}
+
+// Connect opens the pool and checks the database answers. The pool is safe
+// to share between requests, every query borrows a connection for as long
+// as it runs.
+func Connect(ctx context.Context, config *pgxpool.Config) (*pgxpool.Pool, error) {
+	pool, err := pgxpool.NewWithConfig(ctx, config)
 	if err != nil {
-		log.Fatalf("Unable to connect to database: %v\n", err)
+		return nil, fmt.Errorf("unable to connect to database: %w", err)
 	}
 
-	err = conn.Ping(ctx)
+	err = pool.Ping(ctx)
 	if err != nil {
-		log.Fatalf("Failed to ping database: %v\n", err)
-		conn.Close(ctx)
+		pool.Close()
+		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 
-	return conn
+	return pool, nil
 }
@@ -0,0 +1,10 @@
+-- 2_group_hierarchy.down.sql
+
+DROP TRIGGER IF EXISTS groups_prevent_cycle ON groups;
+DROP FUNCTION IF EXISTS prevent_group_cycle();
+
+DROP INDEX IF EXISTS idx_groups_parent;
+
+ALTER TABLE groups
+    DROP CONSTRAINT IF EXISTS groups_parent_not_self,
+    DROP COLUMN IF EXISTS parent_id;
@@ -0,0 +1,40 @@
+-- 2_group_hierarchy.up.sql
+
+-- Allow groups to be nested under a parent group
+ALTER TABLE groups
+    ADD COLUMN parent_id INT REFERENCES groups(id) ON DELETE SET NULL,
+    ADD CONSTRAINT groups_parent_not_self CHECK (parent_id <> id);
+
+CREATE INDEX idx_groups_parent ON groups(parent_id);
+
+-- Reject parent assignments that would make a group its own ancestor.
+-- Parent changes are serialized so two moves committed at the same time can't
+-- each pass the check and form a cycle together. The lock is held until the
+-- transaction ends, the check runs on what the previous move committed.
+CREATE OR REPLACE FUNCTION prevent_group_cycle() RETURNS TRIGGER AS $$
+BEGIN
+    IF NEW.parent_id IS NULL THEN
+        RETURN NEW;
+    END IF;
+
+    PERFORM pg_advisory_xact_lock(hashtext('groups_parent_id'));
+
+    IF EXISTS (
+        WITH RECURSIVE ancestors AS (
+            SELECT id, parent_id FROM groups WHERE id = NEW.parent_id
+            UNION
+            SELECT g.id, g.parent_id FROM groups g JOIN ancestors a ON g.id = a.parent_id
+        )
+        SELECT 1 FROM ancestors WHERE id = NEW.id
+    ) THEN
+        RAISE EXCEPTION 'group % cannot be nested under its own descendant %', NEW.id, NEW.parent_id
+            USING ERRCODE = 'check_violation';
+    END IF;
+
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER groups_prevent_cycle
+    BEFORE INSERT OR UPDATE OF parent_id ON groups
+    FOR EACH ROW EXECUTE FUNCTION prevent_group_cycle();
@@ -0,0 +1,7 @@
+-- 3_sessions.down.sql
+
+-- Drop refresh_tokens table
+DROP TABLE IF EXISTS refresh_tokens;
+
+-- Drop sessions table
+DROP TABLE IF EXISTS sessions;
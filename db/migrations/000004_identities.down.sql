@@ -0,0 +1,7 @@
+-- 4_identities.down.sql
+
+-- Drop oidc_login_states table
+DROP TABLE IF EXISTS oidc_login_states;
+
+-- Drop identities table
+DROP TABLE IF EXISTS identities;
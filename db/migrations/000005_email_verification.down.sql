@@ -0,0 +1,7 @@
+-- 5_email_verification.down.sql
+
+-- Drop user_tokens table
+DROP TABLE IF EXISTS user_tokens;
+
+ALTER TABLE users
+    DROP COLUMN IF EXISTS email_verified_at;
@@ -0,0 +1,26 @@
+-- 5_email_verification.up.sql
+
+-- Track when a user proved ownership of their email address
+ALTER TABLE users
+    ADD COLUMN email_verified_at TIMESTAMP WITH TIME ZONE;
+
+-- Accounts that exist before email verification keep working when
+-- UNVERIFIED_USER_ACCESS is read_only or deny. Their address counts as
+-- verified from the day they signed up.
+UPDATE users
+SET email_verified_at = created_at
+WHERE email_verified_at IS NULL;
+
+-- Create user_tokens table for single-use password reset and email verification tokens
+CREATE TABLE IF NOT EXISTS user_tokens (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    purpose VARCHAR(32) NOT NULL CHECK (purpose IN ('password_reset', 'email_verification')),
+    token_hash CHAR(64) UNIQUE NOT NULL,
+    email VARCHAR(100),
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    used_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_user_tokens_user ON user_tokens(user_id, purpose);
@@ -0,0 +1,16 @@
+-- 6_two_factor.down.sql
+
+ALTER TABLE groups
+    DROP COLUMN IF EXISTS require_manager_two_factor;
+
+ALTER TABLE sessions
+    DROP COLUMN IF EXISTS two_factor_verified_at;
+
+-- Drop mfa_challenges table
+DROP TABLE IF EXISTS mfa_challenges;
+
+-- Drop recovery_codes table
+DROP TABLE IF EXISTS recovery_codes;
+
+-- Drop user_totp table
+DROP TABLE IF EXISTS user_totp;
@@ -0,0 +1,39 @@
+-- 6_two_factor.up.sql
+
+-- Create user_totp table, one authenticator secret per user
+CREATE TABLE IF NOT EXISTS user_totp (
+    user_id INT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    secret VARCHAR(64) NOT NULL,
+    confirmed_at TIMESTAMP WITH TIME ZONE,
+    last_used_step BIGINT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Create recovery_codes table, only hashes of the codes are stored
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    code_hash CHAR(64) NOT NULL,
+    used_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (user_id, code_hash)
+);
+
+-- Create mfa_challenges table for logins waiting on the second factor
+CREATE TABLE IF NOT EXISTS mfa_challenges (
+    challenge_hash CHAR(64) PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    attempts INT NOT NULL DEFAULT 0,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_mfa_challenges_expires ON mfa_challenges(expires_at);
+
+-- Remember whether a session passed the second factor
+ALTER TABLE sessions
+    ADD COLUMN two_factor_verified_at TIMESTAMP WITH TIME ZONE;
+
+-- Per-group policy, applies to the group and all of its sub-groups
+ALTER TABLE groups
+    ADD COLUMN require_manager_two_factor BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,14 @@
+-- 7_login_throttling.down.sql
+
+DELETE FROM user_tokens WHERE purpose = 'account_unlock';
+
+ALTER TABLE user_tokens
+    DROP CONSTRAINT IF EXISTS user_tokens_purpose_check,
+    ADD CONSTRAINT user_tokens_purpose_check CHECK (purpose IN ('password_reset', 'email_verification'));
+
+-- Drop login_attempts table
+DROP TABLE IF EXISTS login_attempts;
+
+ALTER TABLE users
+    DROP COLUMN IF EXISTS locked_until,
+    DROP COLUMN IF EXISTS failed_login_count;
@@ -0,0 +1,26 @@
+-- 7_login_throttling.up.sql
+
+-- Track consecutive failed logins and temporary lockouts per account
+ALTER TABLE users
+    ADD COLUMN failed_login_count INT NOT NULL DEFAULT 0,
+    ADD COLUMN locked_until TIMESTAMP WITH TIME ZONE;
+
+-- Create login_attempts table, the audit trail of every login attempt
+CREATE TABLE IF NOT EXISTS login_attempts (
+    id SERIAL PRIMARY KEY,
+    identifier VARCHAR(100) NOT NULL,
+    user_id INT REFERENCES users(id) ON DELETE SET NULL,
+    ip_address VARCHAR(64) NOT NULL,
+    user_agent TEXT,
+    succeeded BOOLEAN NOT NULL,
+    reason VARCHAR(32),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_login_attempts_user ON login_attempts(user_id, created_at);
+CREATE INDEX idx_login_attempts_ip ON login_attempts(ip_address, created_at);
+
+-- Allow unlock links as a user token purpose
+ALTER TABLE user_tokens
+    DROP CONSTRAINT IF EXISTS user_tokens_purpose_check,
+    ADD CONSTRAINT user_tokens_purpose_check CHECK (purpose IN ('password_reset', 'email_verification', 'account_unlock'));
@@ -0,0 +1,9 @@
+-- 8_api_keys.down.sql
+
+-- Drop api_keys table
+DROP TABLE IF EXISTS api_keys;
+
+DELETE FROM users WHERE service_group_id IS NOT NULL;
+
+ALTER TABLE users
+    DROP COLUMN IF EXISTS service_group_id;
@@ -0,0 +1,24 @@
+-- 8_api_keys.up.sql
+
+-- Service accounts are users that belong to a group and can only authenticate with API keys
+ALTER TABLE users
+    ADD COLUMN service_group_id INT REFERENCES groups(id) ON DELETE CASCADE;
+
+CREATE INDEX idx_users_service_group ON users(service_group_id);
+
+-- Create api_keys table, only the hash of a key is stored
+CREATE TABLE IF NOT EXISTS api_keys (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    key_prefix VARCHAR(16) NOT NULL,
+    key_hash CHAR(64) UNIQUE NOT NULL,
+    scopes TEXT[] NOT NULL,
+    created_by INT REFERENCES users(id) ON DELETE SET NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    last_used_at TIMESTAMP WITH TIME ZONE,
+    revoked_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_api_keys_user ON api_keys(user_id);
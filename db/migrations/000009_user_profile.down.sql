@@ -0,0 +1,7 @@
+-- 9_user_profile.down.sql
+
+DELETE FROM user_tokens WHERE purpose = 'email_change';
+
+ALTER TABLE user_tokens
+    DROP CONSTRAINT IF EXISTS user_tokens_purpose_check,
+    ADD CONSTRAINT user_tokens_purpose_check CHECK (purpose IN ('password_reset', 'email_verification', 'account_unlock'));
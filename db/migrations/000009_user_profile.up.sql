@@ -0,0 +1,6 @@
+-- 9_user_profile.up.sql
+
+-- Allow email change confirmations as a user token purpose
+ALTER TABLE user_tokens
+    DROP CONSTRAINT IF EXISTS user_tokens_purpose_check,
+    ADD CONSTRAINT user_tokens_purpose_check CHECK (purpose IN ('password_reset', 'email_verification', 'account_unlock', 'email_change'));
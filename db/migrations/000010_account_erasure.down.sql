@@ -0,0 +1,8 @@
+-- 10_account_erasure.down.sql
+
+ALTER TABLE shifts
+    DROP CONSTRAINT IF EXISTS shifts_user_id_fkey,
+    ADD CONSTRAINT shifts_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE;
+
+ALTER TABLE users
+    DROP COLUMN IF EXISTS erased_at;
@@ -0,0 +1,10 @@
+-- 10_account_erasure.up.sql
+
+-- Erased accounts keep their row so shift history stays intact
+ALTER TABLE users
+    ADD COLUMN erased_at TIMESTAMP WITH TIME ZONE;
+
+-- Deleting a user no longer takes their shifts with it
+ALTER TABLE shifts
+    DROP CONSTRAINT IF EXISTS shifts_user_id_fkey,
+    ADD CONSTRAINT shifts_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL;
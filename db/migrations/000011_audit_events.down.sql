@@ -0,0 +1,7 @@
+-- 11_audit_events.down.sql
+
+DROP TRIGGER IF EXISTS audit_events_append_only ON audit_events;
+DROP FUNCTION IF EXISTS audit_events_append_only();
+
+-- Drop audit_events table
+DROP TABLE IF EXISTS audit_events;
@@ -0,0 +1,14 @@
+-- 12_soft_delete.down.sql
+
+-- Rows that were only soft deleted would come back to life without the column
+DELETE FROM shifts WHERE deleted_at IS NOT NULL;
+DELETE FROM groups WHERE deleted_at IS NOT NULL;
+
+DROP INDEX IF EXISTS idx_shifts_deleted_at;
+DROP INDEX IF EXISTS idx_groups_deleted_at;
+
+ALTER TABLE shifts
+    DROP COLUMN IF EXISTS deleted_at;
+
+ALTER TABLE groups
+    DROP COLUMN IF EXISTS deleted_at;
@@ -0,0 +1,12 @@
+-- 12_soft_delete.up.sql
+
+-- Deleted groups and shifts are kept for a retention window so they can be
+-- restored, a background job removes them for good afterwards
+ALTER TABLE groups
+    ADD COLUMN deleted_at TIMESTAMP WITH TIME ZONE;
+
+ALTER TABLE shifts
+    ADD COLUMN deleted_at TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX idx_groups_deleted_at ON groups(deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX idx_shifts_deleted_at ON shifts(deleted_at) WHERE deleted_at IS NOT NULL;
@@ -0,0 +1,7 @@
+-- 13_row_versions.down.sql
+
+ALTER TABLE shifts
+    DROP COLUMN IF EXISTS version;
+
+ALTER TABLE groups
+    DROP COLUMN IF EXISTS version;
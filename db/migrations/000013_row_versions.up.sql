@@ -0,0 +1,8 @@
+-- 13_row_versions.up.sql
+
+-- Bumped on every update, sent as the ETag and checked against If-Match
+ALTER TABLE groups
+    ADD COLUMN version INT NOT NULL DEFAULT 1;
+
+ALTER TABLE shifts
+    ADD COLUMN version INT NOT NULL DEFAULT 1;
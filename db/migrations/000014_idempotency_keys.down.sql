@@ -0,0 +1,3 @@
+-- 14_idempotency_keys.down.sql
+
+DROP TABLE IF EXISTS idempotency_keys;
@@ -0,0 +1,4 @@
+-- 15_user_disable.down.sql
+
+ALTER TABLE users
+    DROP COLUMN IF EXISTS disabled_at;
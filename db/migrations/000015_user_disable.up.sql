@@ -0,0 +1,5 @@
+-- 15_user_disable.up.sql
+
+-- Disabled accounts keep their data but cannot log in until enabled again
+ALTER TABLE users
+    ADD COLUMN disabled_at TIMESTAMP WITH TIME ZONE;
@@ -0,0 +1,287 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// lockKey is the advisory lock held while migrating so two deploys starting
+// at once do not both apply the same migration.
+const lockKey = 7243901562
+
+var fileName = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// ErrDirty means a migration failed halfway under a tool that does not run
+// migrations in a transaction. The schema has to be fixed by hand.
+var ErrDirty = errors.New("database is dirty, fix the schema by hand and reset schema_migrations")
+
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Load reads the embedded migrations ordered by version. Every migration
+// needs both an up and a down file.
+func Load() ([]Migration, error) {
+	names, err := fs.Glob(FS, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int64]*Migration{}
+	for _, name := range names {
+		match := fileName.FindStringSubmatch(name)
+		if match == nil {
+			return nil, fmt.Errorf("migration file %s is not named <version>_<name>.(up|down).sql", name)
+		}
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration file %s has an invalid version", name)
+		}
+		sql, err := fs.ReadFile(FS, name)
+		if err != nil {
+			return nil, err
+		}
+
+		m := byVersion[version]
+		if m == nil {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has files with different names", version)
+		}
+		if match[3] == "up" {
+			m.Up = string(sql)
+		} else {
+			m.Down = string(sql)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s needs an up and a down file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Latest returns the version of the newest embedded migration, the version
+// this build expects the database to be at.
+func Latest() (int64, error) {
+	all, err := Load()
+	if err != nil {
+		return 0, err
+	}
+	if len(all) == 0 {
+		return 0, nil
+	}
+	return all[len(all)-1].Version, nil
+}
+
+// Querier is what ReadVersion needs, a pool, connection or transaction.
+type Querier interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// ReadVersion is Migrator.Version without creating schema_migrations, for
+// health checks that must not write. A database without the table is at
+// version zero.
+func ReadVersion(ctx context.Context, q Querier) (int64, bool, error) {
+	var version int64
+	var dirty bool
+	err := q.QueryRow(ctx, "SELECT version, dirty FROM schema_migrations LIMIT 1").Scan(&version, &dirty)
+	var pgErr *pgconn.PgError
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		return 0, false, nil
+	case errors.As(err, &pgErr) && pgErr.Code == "42P01":
+		return 0, false, nil
+	}
+	return version, dirty, err
+}
+
+// Migrator applies migrations to one connection. It keeps the current
+// version in schema_migrations like golang-migrate does, so a database can
+// move between the two. Unlike golang-migrate every migration runs in a
+// transaction together with the version change.
+type Migrator struct {
+	conn       *pgx.Conn
+	migrations []Migration
+	// OnApply is called after each migration is committed.
+	OnApply func(m Migration, up bool)
+}
+
+func NewMigrator(conn *pgx.Conn, migrations []Migration) *Migrator {
+	return &Migrator{conn: conn, migrations: migrations}
+}
+
+func (m *Migrator) Migrations() []Migration {
+	return m.migrations
+}
+
+// Version returns the version of the last applied migration, zero when none
+// has been applied yet.
+func (m *Migrator) Version(ctx context.Context) (int64, bool, error) {
+	_, err := m.conn.Exec(ctx, "CREATE TABLE IF NOT EXISTS schema_migrations (version bigint NOT NULL PRIMARY KEY, dirty boolean NOT NULL)")
+	if err != nil {
+		return 0, false, err
+	}
+
+	var version int64
+	var dirty bool
+	err = m.conn.QueryRow(ctx, "SELECT version, dirty FROM schema_migrations LIMIT 1").Scan(&version, &dirty)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, false, nil
+	}
+	return version, dirty, err
+}
+
+// Up applies up to steps pending migrations, all of them when steps is 0.
+func (m *Migrator) Up(ctx context.Context, steps int) error {
+	return m.locked(ctx, func(current int) error {
+		target := len(m.migrations) - 1
+		if steps > 0 && current+steps < target {
+			target = current + steps
+		}
+		return m.migrate(ctx, current, target)
+	})
+}
+
+// Down reverts the last steps migrations, all of them when steps is 0.
+func (m *Migrator) Down(ctx context.Context, steps int) error {
+	return m.locked(ctx, func(current int) error {
+		target := -1
+		if steps > 0 && current-steps > target {
+			target = current - steps
+		}
+		return m.migrate(ctx, current, target)
+	})
+}
+
+// Goto migrates up or down until version is the last applied migration.
+// Version 0 reverts everything.
+func (m *Migrator) Goto(ctx context.Context, version int64) error {
+	target := -1
+	if version != 0 {
+		target = m.index(version)
+		if target < 0 {
+			return fmt.Errorf("no migration with version %d", version)
+		}
+	}
+	return m.locked(ctx, func(current int) error {
+		return m.migrate(ctx, current, target)
+	})
+}
+
+// index returns the position of version in the migrations, -1 when it is
+// not one of them.
+func (m *Migrator) index(version int64) int {
+	for i, migration := range m.migrations {
+		if migration.Version == version {
+			return i
+		}
+	}
+	return -1
+}
+
+// locked runs fn with the advisory lock held and the position of the
+// current version, -1 when nothing is applied.
+func (m *Migrator) locked(ctx context.Context, fn func(current int) error) error {
+	_, err := m.conn.Exec(ctx, "SELECT pg_advisory_lock($1)", lockKey)
+	if err != nil {
+		return err
+	}
+	defer m.conn.Exec(context.WithoutCancel(ctx), "SELECT pg_advisory_unlock($1)", lockKey)
+
+	version, dirty, err := m.Version(ctx)
+	if err != nil {
+		return err
+	}
+	if dirty {
+		return fmt.Errorf("version %d: %w", version, ErrDirty)
+	}
+
+	current := -1
+	if version != 0 {
+		current = m.index(version)
+		if current < 0 {
+			return fmt.Errorf("database is at version %d, which this binary does not know about", version)
+		}
+	}
+	return fn(current)
+}
+
+func (m *Migrator) migrate(ctx context.Context, current, target int) error {
+	for current < target {
+		current++
+		err := m.apply(ctx, m.migrations[current], true, m.migrations[current].Version)
+		if err != nil {
+			return err
+		}
+	}
+	for current > target {
+		var previous int64
+		if current > 0 {
+			previous = m.migrations[current-1].Version
+		}
+		err := m.apply(ctx, m.migrations[current], false, previous)
+		if err != nil {
+			return err
+		}
+		current--
+	}
+	return nil
+}
+
+func (m *Migrator) apply(ctx context.Context, migration Migration, up bool, version int64) error {
+	sql, direction := migration.Up, "up"
+	if !up {
+		sql, direction = migration.Down, "down"
+	}
+
+	tx, err := m.conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// Without arguments Exec uses the simple protocol, which runs all
+	// statements of the file.
+	_, err = tx.Exec(ctx, sql)
+	if err != nil {
+		return fmt.Errorf("migrate %s %d_%s: %w", direction, migration.Version, migration.Name, err)
+	}
+	_, err = tx.Exec(ctx, "DELETE FROM schema_migrations")
+	if err != nil {
+		return err
+	}
+	if version != 0 {
+		_, err = tx.Exec(ctx, "INSERT INTO schema_migrations (version, dirty) VALUES ($1, false)", version)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return err
+	}
+	if m.OnApply != nil {
+		m.OnApply(migration, up)
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+//go:build integration
+
+package migrations_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/joseph-gunnarsson/scheduling/db/migrations"
+	"github.com/joseph-gunnarsson/scheduling/internals/pgtest"
+)
+
+var server *pgtest.Server
+
+func TestMain(m *testing.M) {
+	var err error
+	server, err = pgtest.Start()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	code := m.Run()
+	err = server.Stop()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	os.Exit(code)
+}
+
+// TestDownAndUpAgain reverts every migration of a migrated database and
+// applies them again, which catches down files that miss something.
+func TestDownAndUpAgain(t *testing.T) {
+	ctx := context.Background()
+	pool := server.NewDatabase(t)
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Release()
+
+	all, err := migrations.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	latest := all[len(all)-1].Version
+	migrator := migrations.NewMigrator(conn.Conn(), all)
+
+	steps := []struct {
+		name    string
+		migrate func() error
+		want    int64
+	}{
+		{"down one", func() error { return migrator.Down(ctx, 1) }, latest - 1},
+		{"up", func() error { return migrator.Up(ctx, 0) }, latest},
+		{"goto 0", func() error { return migrator.Goto(ctx, 0) }, 0},
+		{"up two", func() error { return migrator.Up(ctx, 2) }, 2},
+		{"goto latest", func() error { return migrator.Goto(ctx, latest) }, latest},
+	}
+	for _, step := range steps {
+		err := step.migrate()
+		if err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		version, dirty, err := migrator.Version(ctx)
+		if err != nil || dirty || version != step.want {
+			t.Fatalf("%s: version = %d, dirty = %v, %v, want %d", step.name, version, dirty, err, step.want)
+		}
+	}
+}
+
+// TestConcurrentGroupMoves moves two groups under each other in transactions
+// that overlap. Each move alone is fine, together they would form a cycle,
+// so the second one has to wait for the first and fail.
+func TestConcurrentGroupMoves(t *testing.T) {
+	ctx := context.Background()
+	pool := server.NewDatabase(t)
+
+	var owner, a, b int32
+	err := pool.QueryRow(ctx, "INSERT INTO users (username, email, password_hash) VALUES ('owner', 'owner@example.com', '') RETURNING id").Scan(&owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, group := range []*int32{&a, &b} {
+		err = pool.QueryRow(ctx, "INSERT INTO groups (name, owner_id) VALUES ('group', $1) RETURNING id", owner).Scan(group)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	first, err := pool.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Rollback(ctx)
+	_, err = first.Exec(ctx, "UPDATE groups SET parent_id = $1 WHERE id = $2", b, a)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second := make(chan error, 1)
+	go func() {
+		tx, err := pool.Begin(ctx)
+		if err != nil {
+			second <- err
+			return
+		}
+		defer tx.Rollback(ctx)
+		_, err = tx.Exec(ctx, "UPDATE groups SET parent_id = $1 WHERE id = $2", a, b)
+		if err == nil {
+			err = tx.Commit(ctx)
+		}
+		second <- err
+	}()
+
+	select {
+	case err := <-second:
+		t.Fatalf("second move finished while the first was open: %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+	err = first.Commit(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var pgErr *pgconn.PgError
+	err = <-second
+	if !errors.As(err, &pgErr) || pgErr.Code != "23514" {
+		t.Fatalf("second move = %v, want a check violation", err)
+	}
+}
@@ -0,0 +1,18 @@
+package migrations
+
+import "testing"
+
+func TestLoad(t *testing.T) {
+	migrations, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) == 0 {
+		t.Fatal("no migrations are embedded")
+	}
+	for i, m := range migrations {
+		if m.Version != int64(i+1) {
+			t.Fatalf("migration %d_%s follows version %d, versions must not have gaps", m.Version, m.Name, i)
+		}
+	}
+}
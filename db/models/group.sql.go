@@ -29,20 +29,40 @@ func (q *Queries) AddUserToGroup(ctx context.Context, arg AddUserToGroupParams)
 	return i, err
 }
 
+const countGroupsOwnedByUser = `-- name: CountGroupsOwnedByUser :one
+SELECT COUNT(*)
+FROM groups
+WHERE owner_id = $1 AND deleted_at IS NULL
+`
+
+// Count the groups a user owns
+func (q *Queries) CountGroupsOwnedByUser(ctx context.Context, ownerID pgtype.Int4) (int64, error) {
+	row := q.db.QueryRow(ctx, countGroupsOwnedByUser, ownerID)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
 const createGroup = `-- name: CreateGroup :one
-INSERT INTO groups (name, description, owner_id, created_at, updated_at)
-VALUES ($1, $2, $3, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
-RETURNING id, name, description, owner_id, created_at, updated_at
+INSERT INTO groups (name, description, owner_id, parent_id, created_at, updated_at)
+VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+RETURNING id, name, description, owner_id, created_at, updated_at, parent_id, require_manager_two_factor, deleted_at, version
 `
 
 type CreateGroupParams struct {
 	Name        string      `json:"name"`
 	Description pgtype.Text `json:"description"`
 	OwnerID     pgtype.Int4 `json:"owner_id"`
+	ParentID    pgtype.Int4 `json:"parent_id"`
 }
 
 func (q *Queries) CreateGroup(ctx context.Context, arg CreateGroupParams) (Group, error) {
-	row := q.db.QueryRow(ctx, createGroup, arg.Name, arg.Description, arg.OwnerID)
+	row := q.db.QueryRow(ctx, createGroup,
+		arg.Name,
+		arg.Description,
+		arg.OwnerID,
+		arg.ParentID,
+	)
 	var i Group
 	err := row.Scan(
 		&i.ID,
@@ -51,17 +71,75 @@ func (q *Queries) CreateGroup(ctx context.Context, arg CreateGroupParams) (Group
 		&i.OwnerID,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.ParentID,
+		&i.RequireManagerTwoFactor,
+		&i.DeletedAt,
+		&i.Version,
 	)
 	return i, err
 }
 
-const deleteGroup = `-- name: DeleteGroup :exec
-DELETE FROM groups
-WHERE id = $1
+const deleteGroup = `-- name: DeleteGroup :one
+UPDATE groups
+SET deleted_at = CURRENT_TIMESTAMP,
+    version = version + 1,
+    updated_at = CURRENT_TIMESTAMP
+WHERE id = $1 AND deleted_at IS NULL
+    AND ($2::int IS NULL OR version = $2)
+RETURNING id, name, description, owner_id, created_at, updated_at, parent_id, require_manager_two_factor, deleted_at, version
+`
+
+type DeleteGroupParams struct {
+	ID              int32       `json:"id"`
+	ExpectedVersion pgtype.Int4 `json:"expected_version"`
+}
+
+// Soft delete a group, its sub-groups and shifts are marked in the same
+// transaction with the same deleted_at
+func (q *Queries) DeleteGroup(ctx context.Context, arg DeleteGroupParams) (Group, error) {
+	row := q.db.QueryRow(ctx, deleteGroup, arg.ID, arg.ExpectedVersion)
+	var i Group
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Description,
+		&i.OwnerID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.ParentID,
+		&i.RequireManagerTwoFactor,
+		&i.DeletedAt,
+		&i.Version,
+	)
+	return i, err
+}
+
+const deleteGroupDescendants = `-- name: DeleteGroupDescendants :exec
+WITH RECURSIVE descendants AS (
+    SELECT groups.id
+    FROM groups
+    WHERE groups.parent_id = $1::int AND groups.deleted_at IS NULL
+    UNION
+    SELECT child.id
+    FROM groups child
+    JOIN descendants ON child.parent_id = descendants.id
+    WHERE child.deleted_at IS NULL
+)
+UPDATE groups
+SET deleted_at = $2::timestamptz,
+    version = version + 1,
+    updated_at = CURRENT_TIMESTAMP
+WHERE groups.id IN (SELECT descendants.id FROM descendants)
 `
 
-func (q *Queries) DeleteGroup(ctx context.Context, id int32) error {
-	_, err := q.db.Exec(ctx, deleteGroup, id)
+type DeleteGroupDescendantsParams struct {
+	RootID    int32              `json:"root_id"`
+	DeletedAt pgtype.Timestamptz `json:"deleted_at"`
+}
+
+// Soft delete the live sub-groups of a group that is being deleted
+func (q *Queries) DeleteGroupDescendants(ctx context.Context, arg DeleteGroupDescendantsParams) error {
+	_, err := q.db.Exec(ctx, deleteGroupDescendants, arg.RootID, arg.DeletedAt)
 	return err
 }
 
@@ -80,10 +158,46 @@ func (q *Queries) DeleteUserFromGroup(ctx context.Context, arg DeleteUserFromGro
 	return err
 }
 
+const deleteUserMemberships = `-- name: DeleteUserMemberships :exec
+DELETE FROM user_groups
+WHERE user_id = $1
+`
+
+// Remove a user from every group
+func (q *Queries) DeleteUserMemberships(ctx context.Context, userID int32) error {
+	_, err := q.db.Exec(ctx, deleteUserMemberships, userID)
+	return err
+}
+
+const getDeletedGroupByID = `-- name: GetDeletedGroupByID :one
+SELECT id, name, description, owner_id, created_at, updated_at, parent_id, require_manager_two_factor, deleted_at, version
+FROM groups
+WHERE id = $1 AND deleted_at IS NOT NULL
+`
+
+// Get a soft deleted group
+func (q *Queries) GetDeletedGroupByID(ctx context.Context, id int32) (Group, error) {
+	row := q.db.QueryRow(ctx, getDeletedGroupByID, id)
+	var i Group
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Description,
+		&i.OwnerID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.ParentID,
+		&i.RequireManagerTwoFactor,
+		&i.DeletedAt,
+		&i.Version,
+	)
+	return i, err
+}
+
 const getGroupByID = `-- name: GetGroupByID :one
-SELECT id, name, description, owner_id, created_at, updated_at
+SELECT id, name, description, owner_id, created_at, updated_at, parent_id, require_manager_two_factor, deleted_at, version
 FROM groups
-WHERE id = $1
+WHERE id = $1 AND deleted_at IS NULL
 `
 
 func (q *Queries) GetGroupByID(ctx context.Context, id int32) (Group, error) {
@@ -96,6 +210,10 @@ func (q *Queries) GetGroupByID(ctx context.Context, id int32) (Group, error) {
 		&i.OwnerID,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.ParentID,
+		&i.RequireManagerTwoFactor,
+		&i.DeletedAt,
+		&i.Version,
 	)
 	return i, err
 }
@@ -112,7 +230,8 @@ SELECT
     ug.joined_at
 FROM user_groups ug
 JOIN users u ON ug.user_id = u.id
-WHERE ug.group_id = $1
+JOIN groups g ON ug.group_id = g.id
+WHERE ug.group_id = $1 AND g.deleted_at IS NULL
 `
 
 type GetGroupMembersRow struct {
@@ -155,9 +274,74 @@ func (q *Queries) GetGroupMembers(ctx context.Context, groupID int32) ([]GetGrou
 	return items, nil
 }
 
+const getGroupSubtree = `-- name: GetGroupSubtree :many
+WITH RECURSIVE subtree AS (
+    SELECT groups.id, 0 AS depth
+    FROM groups
+    WHERE groups.id = $1 AND groups.deleted_at IS NULL
+    UNION ALL
+    SELECT child.id, subtree.depth + 1
+    FROM groups child
+    JOIN subtree ON child.parent_id = subtree.id
+    WHERE child.deleted_at IS NULL
+)
+SELECT
+    g.id,
+    g.name,
+    g.description,
+    g.owner_id,
+    g.parent_id,
+    g.created_at,
+    g.updated_at,
+    subtree.depth
+FROM subtree
+JOIN groups g ON g.id = subtree.id
+ORDER BY subtree.depth, g.name
+`
+
+type GetGroupSubtreeRow struct {
+	ID          int32              `json:"id"`
+	Name        string             `json:"name"`
+	Description pgtype.Text        `json:"description"`
+	OwnerID     pgtype.Int4        `json:"owner_id"`
+	ParentID    pgtype.Int4        `json:"parent_id"`
+	CreatedAt   pgtype.Timestamptz `json:"created_at"`
+	UpdatedAt   pgtype.Timestamptz `json:"updated_at"`
+	Depth       int32              `json:"depth"`
+}
+
+func (q *Queries) GetGroupSubtree(ctx context.Context, rootID int32) ([]GetGroupSubtreeRow, error) {
+	rows, err := q.db.Query(ctx, getGroupSubtree, rootID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetGroupSubtreeRow
+	for rows.Next() {
+		var i GetGroupSubtreeRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Description,
+			&i.OwnerID,
+			&i.ParentID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Depth,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const getGroupsByOwner = `-- name: GetGroupsByOwner :many
-SELECT id, name, description, owner_id, created_at, updated_at FROM groups
-WHERE owner_id = $1
+SELECT id, name, description, owner_id, created_at, updated_at, parent_id, require_manager_two_factor, deleted_at, version FROM groups
+WHERE owner_id = $1 AND deleted_at IS NULL
 ORDER BY created_at DESC
 `
 
@@ -177,6 +361,73 @@ func (q *Queries) GetGroupsByOwner(ctx context.Context, ownerID pgtype.Int4) ([]
 			&i.OwnerID,
 			&i.CreatedAt,
 			&i.UpdatedAt,
+			&i.ParentID,
+			&i.RequireManagerTwoFactor,
+			&i.DeletedAt,
+			&i.Version,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getSubtreeMembers = `-- name: GetSubtreeMembers :many
+WITH RECURSIVE subtree AS (
+    SELECT groups.id
+    FROM groups
+    WHERE groups.id = $1 AND groups.deleted_at IS NULL
+    UNION ALL
+    SELECT child.id
+    FROM groups child
+    JOIN subtree ON child.parent_id = subtree.id
+    WHERE child.deleted_at IS NULL
+)
+SELECT
+    u.id AS user_id,
+    u.username,
+    u.email,
+    u.first_name,
+    u.last_name,
+    ug.group_id,
+    ug.joined_at
+FROM user_groups ug
+JOIN subtree ON ug.group_id = subtree.id
+JOIN users u ON ug.user_id = u.id
+ORDER BY u.id, ug.group_id
+`
+
+type GetSubtreeMembersRow struct {
+	UserID    int32              `json:"user_id"`
+	Username  string             `json:"username"`
+	Email     string             `json:"email"`
+	FirstName pgtype.Text        `json:"first_name"`
+	LastName  pgtype.Text        `json:"last_name"`
+	GroupID   int32              `json:"group_id"`
+	JoinedAt  pgtype.Timestamptz `json:"joined_at"`
+}
+
+func (q *Queries) GetSubtreeMembers(ctx context.Context, rootID int32) ([]GetSubtreeMembersRow, error) {
+	rows, err := q.db.Query(ctx, getSubtreeMembers, rootID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetSubtreeMembersRow
+	for rows.Next() {
+		var i GetSubtreeMembersRow
+		if err := rows.Scan(
+			&i.UserID,
+			&i.Username,
+			&i.Email,
+			&i.FirstName,
+			&i.LastName,
+			&i.GroupID,
+			&i.JoinedAt,
 		); err != nil {
 			return nil, err
 		}
@@ -199,7 +450,7 @@ SELECT
     ug.joined_at AS user_joined_at
 FROM user_groups ug
 JOIN groups g ON ug.group_id = g.id
-WHERE ug.user_id = $1
+WHERE ug.user_id = $1 AND g.deleted_at IS NULL
 `
 
 type GetUserGroupsRow struct {
@@ -240,24 +491,122 @@ func (q *Queries) GetUserGroups(ctx context.Context, userID int32) ([]GetUserGro
 	return items, nil
 }
 
+const groupRequiresManagerTwoFactor = `-- name: GroupRequiresManagerTwoFactor :one
+WITH RECURSIVE ancestors AS (
+    SELECT groups.id, groups.parent_id, groups.require_manager_two_factor
+    FROM groups
+    WHERE groups.id = $1 AND groups.deleted_at IS NULL
+    UNION
+    SELECT parent.id, parent.parent_id, parent.require_manager_two_factor
+    FROM groups parent
+    JOIN ancestors ON parent.id = ancestors.parent_id
+    WHERE parent.deleted_at IS NULL
+)
+SELECT EXISTS (
+    SELECT 1 FROM ancestors WHERE ancestors.require_manager_two_factor
+) AS required
+`
+
+func (q *Queries) GroupRequiresManagerTwoFactor(ctx context.Context, groupID int32) (bool, error) {
+	row := q.db.QueryRow(ctx, groupRequiresManagerTwoFactor, groupID)
+	var required bool
+	err := row.Scan(&required)
+	return required, err
+}
+
+const isGroupInSubtree = `-- name: IsGroupInSubtree :one
+WITH RECURSIVE subtree AS (
+    SELECT groups.id
+    FROM groups
+    WHERE groups.id = $1 AND groups.deleted_at IS NULL
+    UNION
+    SELECT child.id
+    FROM groups child
+    JOIN subtree ON child.parent_id = subtree.id
+    WHERE child.deleted_at IS NULL
+)
+SELECT EXISTS (
+    SELECT 1 FROM subtree WHERE subtree.id = $2
+) AS in_subtree
+`
+
+type IsGroupInSubtreeParams struct {
+	RootID  int32 `json:"root_id"`
+	GroupID int32 `json:"group_id"`
+}
+
+func (q *Queries) IsGroupInSubtree(ctx context.Context, arg IsGroupInSubtreeParams) (bool, error) {
+	row := q.db.QueryRow(ctx, isGroupInSubtree, arg.RootID, arg.GroupID)
+	var in_subtree bool
+	err := row.Scan(&in_subtree)
+	return in_subtree, err
+}
+
+const listDeletedChildGroups = `-- name: ListDeletedChildGroups :many
+SELECT id, name, description, owner_id, created_at, updated_at, parent_id, require_manager_two_factor, deleted_at, version
+FROM groups
+WHERE parent_id = $1 AND deleted_at IS NOT NULL
+ORDER BY deleted_at DESC
+`
+
+// List the soft deleted children of a group, newest deletion first
+func (q *Queries) ListDeletedChildGroups(ctx context.Context, parentID pgtype.Int4) ([]Group, error) {
+	rows, err := q.db.Query(ctx, listDeletedChildGroups, parentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Group
+	for rows.Next() {
+		var i Group
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Description,
+			&i.OwnerID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.ParentID,
+			&i.RequireManagerTwoFactor,
+			&i.DeletedAt,
+			&i.Version,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const patchGroup = `-- name: PatchGroup :one
 UPDATE groups
 SET 
     name = COALESCE($3, name),
     description = COALESCE($2, description),
+    version = version + 1,
     updated_at = CURRENT_TIMESTAMP
-WHERE id = $1
-RETURNING id, name, description, owner_id, created_at, updated_at
+WHERE id = $1 AND deleted_at IS NULL
+    AND ($4::int IS NULL OR version = $4)
+RETURNING id, name, description, owner_id, created_at, updated_at, parent_id, require_manager_two_factor, deleted_at, version
 `
 
 type PatchGroupParams struct {
-	ID          int32       `json:"id"`
-	Description pgtype.Text `json:"description"`
-	Name        pgtype.Text `json:"name"`
+	ID              int32       `json:"id"`
+	Description     pgtype.Text `json:"description"`
+	Name            pgtype.Text `json:"name"`
+	ExpectedVersion pgtype.Int4 `json:"expected_version"`
 }
 
 func (q *Queries) PatchGroup(ctx context.Context, arg PatchGroupParams) (Group, error) {
-	row := q.db.QueryRow(ctx, patchGroup, arg.ID, arg.Description, arg.Name)
+	row := q.db.QueryRow(ctx, patchGroup,
+		arg.ID,
+		arg.Description,
+		arg.Name,
+		arg.ExpectedVersion,
+	)
 	var i Group
 	err := row.Scan(
 		&i.ID,
@@ -266,6 +615,187 @@ func (q *Queries) PatchGroup(ctx context.Context, arg PatchGroupParams) (Group,
 		&i.OwnerID,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.ParentID,
+		&i.RequireManagerTwoFactor,
+		&i.DeletedAt,
+		&i.Version,
+	)
+	return i, err
+}
+
+const purgeDeletedGroups = `-- name: PurgeDeletedGroups :execrows
+DELETE FROM groups
+WHERE deleted_at < $1::timestamptz
+`
+
+// Permanently remove groups deleted before the cutoff
+func (q *Queries) PurgeDeletedGroups(ctx context.Context, cutoff pgtype.Timestamptz) (int64, error) {
+	result, err := q.db.Exec(ctx, purgeDeletedGroups, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const restoreGroup = `-- name: RestoreGroup :one
+UPDATE groups
+SET deleted_at = NULL,
+    version = version + 1,
+    updated_at = CURRENT_TIMESTAMP
+WHERE id = $1 AND deleted_at > $2::timestamptz
+RETURNING id, name, description, owner_id, created_at, updated_at, parent_id, require_manager_two_factor, deleted_at, version
+`
+
+type RestoreGroupParams struct {
+	ID     int32              `json:"id"`
+	Cutoff pgtype.Timestamptz `json:"cutoff"`
+}
+
+// Restore a soft deleted group if it was deleted after the cutoff
+func (q *Queries) RestoreGroup(ctx context.Context, arg RestoreGroupParams) (Group, error) {
+	row := q.db.QueryRow(ctx, restoreGroup, arg.ID, arg.Cutoff)
+	var i Group
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Description,
+		&i.OwnerID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.ParentID,
+		&i.RequireManagerTwoFactor,
+		&i.DeletedAt,
+		&i.Version,
+	)
+	return i, err
+}
+
+const restoreGroupDescendants = `-- name: RestoreGroupDescendants :exec
+WITH RECURSIVE descendants AS (
+    SELECT groups.id
+    FROM groups
+    WHERE groups.parent_id = $1::int AND groups.deleted_at = $2::timestamptz
+    UNION
+    SELECT child.id
+    FROM groups child
+    JOIN descendants ON child.parent_id = descendants.id
+    WHERE child.deleted_at = $2::timestamptz
+)
+UPDATE groups
+SET deleted_at = NULL,
+    version = version + 1,
+    updated_at = CURRENT_TIMESTAMP
+WHERE groups.id IN (SELECT descendants.id FROM descendants)
+`
+
+type RestoreGroupDescendantsParams struct {
+	RootID    int32              `json:"root_id"`
+	DeletedAt pgtype.Timestamptz `json:"deleted_at"`
+}
+
+// Restore the sub-groups that were deleted together with a group
+func (q *Queries) RestoreGroupDescendants(ctx context.Context, arg RestoreGroupDescendantsParams) error {
+	_, err := q.db.Exec(ctx, restoreGroupDescendants, arg.RootID, arg.DeletedAt)
+	return err
+}
+
+const setGroupParent = `-- name: SetGroupParent :one
+UPDATE groups
+SET parent_id = $2,
+    version = version + 1,
+    updated_at = CURRENT_TIMESTAMP
+WHERE id = $1 AND deleted_at IS NULL
+    AND ($3::int IS NULL OR version = $3)
+RETURNING id, name, description, owner_id, created_at, updated_at, parent_id, require_manager_two_factor, deleted_at, version
+`
+
+type SetGroupParentParams struct {
+	ID              int32       `json:"id"`
+	ParentID        pgtype.Int4 `json:"parent_id"`
+	ExpectedVersion pgtype.Int4 `json:"expected_version"`
+}
+
+func (q *Queries) SetGroupParent(ctx context.Context, arg SetGroupParentParams) (Group, error) {
+	row := q.db.QueryRow(ctx, setGroupParent, arg.ID, arg.ParentID, arg.ExpectedVersion)
+	var i Group
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Description,
+		&i.OwnerID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.ParentID,
+		&i.RequireManagerTwoFactor,
+		&i.DeletedAt,
+		&i.Version,
+	)
+	return i, err
+}
+
+const setGroupTwoFactorPolicy = `-- name: SetGroupTwoFactorPolicy :one
+UPDATE groups
+SET require_manager_two_factor = $2,
+    version = version + 1,
+    updated_at = CURRENT_TIMESTAMP
+WHERE id = $1 AND deleted_at IS NULL
+    AND ($3::int IS NULL OR version = $3)
+RETURNING id, name, description, owner_id, created_at, updated_at, parent_id, require_manager_two_factor, deleted_at, version
+`
+
+type SetGroupTwoFactorPolicyParams struct {
+	ID                      int32       `json:"id"`
+	RequireManagerTwoFactor bool        `json:"require_manager_two_factor"`
+	ExpectedVersion         pgtype.Int4 `json:"expected_version"`
+}
+
+func (q *Queries) SetGroupTwoFactorPolicy(ctx context.Context, arg SetGroupTwoFactorPolicyParams) (Group, error) {
+	row := q.db.QueryRow(ctx, setGroupTwoFactorPolicy, arg.ID, arg.RequireManagerTwoFactor, arg.ExpectedVersion)
+	var i Group
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Description,
+		&i.OwnerID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.ParentID,
+		&i.RequireManagerTwoFactor,
+		&i.DeletedAt,
+		&i.Version,
+	)
+	return i, err
+}
+
+const transferGroupOwnership = `-- name: TransferGroupOwnership :one
+UPDATE groups
+SET owner_id = $2,
+    version = version + 1,
+    updated_at = CURRENT_TIMESTAMP
+WHERE id = $1 AND deleted_at IS NULL
+RETURNING id, name, description, owner_id, created_at, updated_at, parent_id, require_manager_two_factor, deleted_at, version
+`
+
+type TransferGroupOwnershipParams struct {
+	ID      int32       `json:"id"`
+	OwnerID pgtype.Int4 `json:"owner_id"`
+}
+
+// Hand a group over to a new owner
+func (q *Queries) TransferGroupOwnership(ctx context.Context, arg TransferGroupOwnershipParams) (Group, error) {
+	row := q.db.QueryRow(ctx, transferGroupOwnership, arg.ID, arg.OwnerID)
+	var i Group
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Description,
+		&i.OwnerID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.ParentID,
+		&i.RequireManagerTwoFactor,
+		&i.DeletedAt,
+		&i.Version,
 	)
 	return i, err
 }
@@ -274,19 +804,27 @@ const updateGroup = `-- name: UpdateGroup :one
 UPDATE groups
 SET name = $2,
     description = $3,
+    version = version + 1,
     updated_at = CURRENT_TIMESTAMP
-WHERE id = $1
-RETURNING id, name, description, owner_id, created_at, updated_at
+WHERE id = $1 AND deleted_at IS NULL
+    AND ($4::int IS NULL OR version = $4)
+RETURNING id, name, description, owner_id, created_at, updated_at, parent_id, require_manager_two_factor, deleted_at, version
 `
 
 type UpdateGroupParams struct {
-	ID          int32       `json:"id"`
-	Name        string      `json:"name"`
-	Description pgtype.Text `json:"description"`
+	ID              int32       `json:"id"`
+	Name            string      `json:"name"`
+	Description     pgtype.Text `json:"description"`
+	ExpectedVersion pgtype.Int4 `json:"expected_version"`
 }
 
 func (q *Queries) UpdateGroup(ctx context.Context, arg UpdateGroupParams) (Group, error) {
-	row := q.db.QueryRow(ctx, updateGroup, arg.ID, arg.Name, arg.Description)
+	row := q.db.QueryRow(ctx, updateGroup,
+		arg.ID,
+		arg.Name,
+		arg.Description,
+		arg.ExpectedVersion,
+	)
 	var i Group
 	err := row.Scan(
 		&i.ID,
@@ -295,6 +833,73 @@ func (q *Queries) UpdateGroup(ctx context.Context, arg UpdateGroupParams) (Group
 		&i.OwnerID,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.ParentID,
+		&i.RequireManagerTwoFactor,
+		&i.DeletedAt,
+		&i.Version,
 	)
 	return i, err
 }
+
+const userCanManageGroup = `-- name: UserCanManageGroup :one
+WITH RECURSIVE ancestors AS (
+    SELECT groups.id, groups.parent_id, groups.owner_id
+    FROM groups
+    WHERE groups.id = $1 AND groups.deleted_at IS NULL
+    UNION
+    SELECT parent.id, parent.parent_id, parent.owner_id
+    FROM groups parent
+    JOIN ancestors ON parent.id = ancestors.parent_id
+    WHERE parent.deleted_at IS NULL
+)
+SELECT EXISTS (
+    SELECT 1 FROM ancestors
+    WHERE ancestors.owner_id = $2::int
+        OR ancestors.id = (SELECT users.service_group_id FROM users WHERE users.id = $2::int)
+) AS can_manage
+`
+
+type UserCanManageGroupParams struct {
+	GroupID int32 `json:"group_id"`
+	UserID  int32 `json:"user_id"`
+}
+
+func (q *Queries) UserCanManageGroup(ctx context.Context, arg UserCanManageGroupParams) (bool, error) {
+	row := q.db.QueryRow(ctx, userCanManageGroup, arg.GroupID, arg.UserID)
+	var can_manage bool
+	err := row.Scan(&can_manage)
+	return can_manage, err
+}
+
+const userRequiresTwoFactor = `-- name: UserRequiresTwoFactor :one
+WITH RECURSIVE managed AS (
+    SELECT groups.id, groups.require_manager_two_factor
+    FROM groups
+    WHERE groups.owner_id = $1::int AND groups.deleted_at IS NULL
+    UNION
+    SELECT child.id, child.require_manager_two_factor
+    FROM groups child
+    JOIN managed ON child.parent_id = managed.id
+    WHERE child.deleted_at IS NULL
+), ancestors AS (
+    SELECT groups.id, groups.parent_id, groups.require_manager_two_factor
+    FROM groups
+    WHERE groups.owner_id = $1::int AND groups.deleted_at IS NULL
+    UNION
+    SELECT parent.id, parent.parent_id, parent.require_manager_two_factor
+    FROM groups parent
+    JOIN ancestors ON parent.id = ancestors.parent_id
+    WHERE parent.deleted_at IS NULL
+)
+SELECT (
+    EXISTS (SELECT 1 FROM managed WHERE managed.require_manager_two_factor)
+    OR EXISTS (SELECT 1 FROM ancestors WHERE ancestors.require_manager_two_factor)
+)::bool AS required
+`
+
+func (q *Queries) UserRequiresTwoFactor(ctx context.Context, userID int32) (bool, error) {
+	row := q.db.QueryRow(ctx, userRequiresTwoFactor, userID)
+	var required bool
+	err := row.Scan(&required)
+	return required, err
+}
@@ -5,16 +5,130 @@
 package db
 
 import (
+	"encoding/json"
+
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
+type ApiKey struct {
+	ID         int32              `json:"id"`
+	UserID     int32              `json:"user_id"`
+	Name       string             `json:"name"`
+	KeyPrefix  string             `json:"key_prefix"`
+	KeyHash    string             `json:"key_hash"`
+	Scopes     []string           `json:"scopes"`
+	CreatedBy  pgtype.Int4        `json:"created_by"`
+	ExpiresAt  pgtype.Timestamptz `json:"expires_at"`
+	LastUsedAt pgtype.Timestamptz `json:"last_used_at"`
+	RevokedAt  pgtype.Timestamptz `json:"revoked_at"`
+	CreatedAt  pgtype.Timestamptz `json:"created_at"`
+}
+
+type AuditEvent struct {
+	ID         int64              `json:"id"`
+	ActorID    pgtype.Int4        `json:"actor_id"`
+	Action     string             `json:"action"`
+	EntityType string             `json:"entity_type"`
+	EntityID   pgtype.Int4        `json:"entity_id"`
+	GroupID    pgtype.Int4        `json:"group_id"`
+	Before     json.RawMessage    `json:"before"`
+	After      json.RawMessage    `json:"after"`
+	RequestID  pgtype.Text        `json:"request_id"`
+	IpAddress  pgtype.Text        `json:"ip_address"`
+	CreatedAt  pgtype.Timestamptz `json:"created_at"`
+}
+
 type Group struct {
+	ID                      int32              `json:"id"`
+	Name                    string             `json:"name"`
+	Description             pgtype.Text        `json:"description"`
+	OwnerID                 pgtype.Int4        `json:"owner_id"`
+	CreatedAt               pgtype.Timestamptz `json:"created_at"`
+	UpdatedAt               pgtype.Timestamptz `json:"updated_at"`
+	ParentID                pgtype.Int4        `json:"parent_id"`
+	RequireManagerTwoFactor bool               `json:"require_manager_two_factor"`
+	DeletedAt               pgtype.Timestamptz `json:"deleted_at"`
+	Version                 int32              `json:"version"`
+}
+
+type IdempotencyKey struct {
+	ID              int32              `json:"id"`
+	UserID          int32              `json:"user_id"`
+	Key             string             `json:"key"`
+	Fingerprint     string             `json:"fingerprint"`
+	StatusCode      pgtype.Int4        `json:"status_code"`
+	ResponseHeaders json.RawMessage    `json:"response_headers"`
+	ResponseBody    []byte             `json:"response_body"`
+	CreatedAt       pgtype.Timestamptz `json:"created_at"`
+	ExpiresAt       pgtype.Timestamptz `json:"expires_at"`
+}
+
+type Identity struct {
 	ID          int32              `json:"id"`
-	Name        string             `json:"name"`
-	Description pgtype.Text        `json:"description"`
-	OwnerID     pgtype.Int4        `json:"owner_id"`
+	UserID      int32              `json:"user_id"`
+	Issuer      string             `json:"issuer"`
+	Subject     string             `json:"subject"`
+	Email       pgtype.Text        `json:"email"`
 	CreatedAt   pgtype.Timestamptz `json:"created_at"`
-	UpdatedAt   pgtype.Timestamptz `json:"updated_at"`
+	LastLoginAt pgtype.Timestamptz `json:"last_login_at"`
+}
+
+type LoginAttempt struct {
+	ID         int32              `json:"id"`
+	Identifier string             `json:"identifier"`
+	UserID     pgtype.Int4        `json:"user_id"`
+	IpAddress  string             `json:"ip_address"`
+	UserAgent  pgtype.Text        `json:"user_agent"`
+	Succeeded  bool               `json:"succeeded"`
+	Reason     pgtype.Text        `json:"reason"`
+	CreatedAt  pgtype.Timestamptz `json:"created_at"`
+}
+
+type MfaChallenge struct {
+	ChallengeHash string             `json:"challenge_hash"`
+	UserID        int32              `json:"user_id"`
+	Attempts      int32              `json:"attempts"`
+	ExpiresAt     pgtype.Timestamptz `json:"expires_at"`
+	CreatedAt     pgtype.Timestamptz `json:"created_at"`
+}
+
+type OidcLoginState struct {
+	StateHash    string             `json:"state_hash"`
+	CodeVerifier string             `json:"code_verifier"`
+	Nonce        string             `json:"nonce"`
+	LinkUserID   pgtype.Int4        `json:"link_user_id"`
+	ExpiresAt    pgtype.Timestamptz `json:"expires_at"`
+	CreatedAt    pgtype.Timestamptz `json:"created_at"`
+	BrowserHash  string             `json:"browser_hash"`
+}
+
+type RecoveryCode struct {
+	ID        int32              `json:"id"`
+	UserID    int32              `json:"user_id"`
+	CodeHash  string             `json:"code_hash"`
+	UsedAt    pgtype.Timestamptz `json:"used_at"`
+	CreatedAt pgtype.Timestamptz `json:"created_at"`
+}
+
+type RefreshToken struct {
+	ID        int32              `json:"id"`
+	SessionID int32              `json:"session_id"`
+	TokenHash string             `json:"token_hash"`
+	ExpiresAt pgtype.Timestamptz `json:"expires_at"`
+	UsedAt    pgtype.Timestamptz `json:"used_at"`
+	CreatedAt pgtype.Timestamptz `json:"created_at"`
+}
+
+type Session struct {
+	ID                  int32              `json:"id"`
+	UserID              int32              `json:"user_id"`
+	UserAgent           pgtype.Text        `json:"user_agent"`
+	IpAddress           pgtype.Text        `json:"ip_address"`
+	CreatedAt           pgtype.Timestamptz `json:"created_at"`
+	LastUsedAt          pgtype.Timestamptz `json:"last_used_at"`
+	ExpiresAt           pgtype.Timestamptz `json:"expires_at"`
+	RevokedAt           pgtype.Timestamptz `json:"revoked_at"`
+	TwoFactorVerifiedAt pgtype.Timestamptz `json:"two_factor_verified_at"`
 }
 
 type Shift struct {
@@ -26,17 +140,25 @@ type Shift struct {
 	EndTime   pgtype.Timestamptz `json:"end_time"`
 	CreatedAt pgtype.Timestamptz `json:"created_at"`
 	UpdatedAt pgtype.Timestamptz `json:"updated_at"`
+	DeletedAt pgtype.Timestamptz `json:"deleted_at"`
+	Version   int32              `json:"version"`
 }
 
 type User struct {
-	ID           int32              `json:"id"`
-	Username     string             `json:"username"`
-	Email        string             `json:"email"`
-	PasswordHash string             `json:"password_hash"`
-	FirstName    pgtype.Text        `json:"first_name"`
-	LastName     pgtype.Text        `json:"last_name"`
-	CreatedAt    pgtype.Timestamptz `json:"created_at"`
-	UpdatedAt    pgtype.Timestamptz `json:"updated_at"`
+	ID               int32              `json:"id"`
+	Username         string             `json:"username"`
+	Email            string             `json:"email"`
+	PasswordHash     string             `json:"-"`
+	FirstName        pgtype.Text        `json:"first_name"`
+	LastName         pgtype.Text        `json:"last_name"`
+	CreatedAt        pgtype.Timestamptz `json:"created_at"`
+	UpdatedAt        pgtype.Timestamptz `json:"updated_at"`
+	EmailVerifiedAt  pgtype.Timestamptz `json:"email_verified_at"`
+	FailedLoginCount int32              `json:"failed_login_count"`
+	LockedUntil      pgtype.Timestamptz `json:"locked_until"`
+	ServiceGroupID   pgtype.Int4        `json:"service_group_id"`
+	ErasedAt         pgtype.Timestamptz `json:"erased_at"`
+	DisabledAt       pgtype.Timestamptz `json:"disabled_at"`
 }
 
 type UserGroup struct {
@@ -44,3 +166,22 @@ type UserGroup struct {
 	GroupID  int32              `json:"group_id"`
 	JoinedAt pgtype.Timestamptz `json:"joined_at"`
 }
+
+type UserToken struct {
+	ID        int32              `json:"id"`
+	UserID    int32              `json:"user_id"`
+	Purpose   string             `json:"purpose"`
+	TokenHash string             `json:"token_hash"`
+	Email     pgtype.Text        `json:"email"`
+	ExpiresAt pgtype.Timestamptz `json:"expires_at"`
+	UsedAt    pgtype.Timestamptz `json:"used_at"`
+	CreatedAt pgtype.Timestamptz `json:"created_at"`
+}
+
+type UserTotp struct {
+	UserID       int32              `json:"user_id"`
+	Secret       string             `json:"secret"`
+	ConfirmedAt  pgtype.Timestamptz `json:"confirmed_at"`
+	LastUsedStep int64              `json:"last_used_step"`
+	CreatedAt    pgtype.Timestamptz `json:"created_at"`
+}
@@ -0,0 +1,28 @@
+package db
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// The sqlc.yaml override tags every password_hash column with json:"-". This
+// breaks if a regeneration loses it.
+func TestPasswordHashIsNotEncoded(t *testing.T) {
+	const hash = "$argon2id$v=19$m=65536,t=3,p=2$c2FsdA$aGFzaA"
+	for name, v := range map[string]interface{}{
+		"User":         User{ID: 1, Username: "alice", PasswordHash: hash},
+		"LoginUserRow": LoginUserRow{ID: 1, Username: "alice", PasswordHash: hash},
+	} {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(data), "password_hash") || strings.Contains(string(data), hash) {
+			t.Errorf("%s encodes the password hash: %s", name, data)
+		}
+		if !strings.Contains(string(data), `"username":"alice"`) {
+			t.Errorf("%s is missing the username: %s", name, data)
+		}
+	}
+}
@@ -14,7 +14,7 @@ import (
 const createShift = `-- name: CreateShift :one
 INSERT INTO shifts (user_id, group_id, name, start_time, end_time, created_at, updated_at)
 VALUES ($1, $2, $3, $4, $5, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
-RETURNING id, user_id, group_id, name, start_time, end_time, created_at, updated_at
+RETURNING id, user_id, group_id, name, start_time, end_time, created_at, updated_at, deleted_at, version
 `
 
 type CreateShiftParams struct {
@@ -44,31 +44,79 @@ func (q *Queries) CreateShift(ctx context.Context, arg CreateShiftParams) (Shift
 		&i.EndTime,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.DeletedAt,
+		&i.Version,
 	)
 	return i, err
 }
 
-const deleteShift = `-- name: DeleteShift :exec
-DELETE FROM shifts
-WHERE id = $1 AND user_id = $2 AND group_id = $3
+const deleteGroupShifts = `-- name: DeleteGroupShifts :exec
+WITH RECURSIVE subtree AS (
+    SELECT groups.id
+    FROM groups
+    WHERE groups.id = $1::int
+    UNION
+    SELECT child.id
+    FROM groups child
+    JOIN subtree ON child.parent_id = subtree.id
+    WHERE child.deleted_at = $2::timestamptz
+)
+UPDATE shifts
+SET deleted_at = $2::timestamptz,
+    version = version + 1
+WHERE shifts.group_id IN (SELECT subtree.id FROM subtree) AND shifts.deleted_at IS NULL
 `
 
-type DeleteShiftParams struct {
-	ID      int32       `json:"id"`
-	UserID  pgtype.Int4 `json:"user_id"`
-	GroupID pgtype.Int4 `json:"group_id"`
+type DeleteGroupShiftsParams struct {
+	GroupID   int32              `json:"group_id"`
+	DeletedAt pgtype.Timestamptz `json:"deleted_at"`
 }
 
-// Delete a shift by ID
-func (q *Queries) DeleteShift(ctx context.Context, arg DeleteShiftParams) error {
-	_, err := q.db.Exec(ctx, deleteShift, arg.ID, arg.UserID, arg.GroupID)
+// Soft delete the shifts of a group that is being deleted and of the
+// sub-groups deleted with it, run after DeleteGroupDescendants
+func (q *Queries) DeleteGroupShifts(ctx context.Context, arg DeleteGroupShiftsParams) error {
+	_, err := q.db.Exec(ctx, deleteGroupShifts, arg.GroupID, arg.DeletedAt)
 	return err
 }
 
+const deleteShift = `-- name: DeleteShift :one
+UPDATE shifts
+SET deleted_at = CURRENT_TIMESTAMP,
+    version = version + 1
+WHERE id = $1 AND group_id = $2 AND deleted_at IS NULL
+    AND ($3::int IS NULL OR version = $3)
+RETURNING id, user_id, group_id, name, start_time, end_time, created_at, updated_at, deleted_at, version
+`
+
+type DeleteShiftParams struct {
+	ID              int32       `json:"id"`
+	GroupID         pgtype.Int4 `json:"group_id"`
+	ExpectedVersion pgtype.Int4 `json:"expected_version"`
+}
+
+// Soft delete a shift in a group
+func (q *Queries) DeleteShift(ctx context.Context, arg DeleteShiftParams) (Shift, error) {
+	row := q.db.QueryRow(ctx, deleteShift, arg.ID, arg.GroupID, arg.ExpectedVersion)
+	var i Shift
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.GroupID,
+		&i.Name,
+		&i.StartTime,
+		&i.EndTime,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.DeletedAt,
+		&i.Version,
+	)
+	return i, err
+}
+
 const getShiftByID = `-- name: GetShiftByID :one
-SELECT id, user_id, group_id, name, start_time, end_time, created_at, updated_at
+SELECT id, user_id, group_id, name, start_time, end_time, created_at, updated_at, deleted_at, version
 FROM shifts
-WHERE id = $1
+WHERE id = $1 AND deleted_at IS NULL
 `
 
 // Get shift by ID
@@ -84,13 +132,92 @@ func (q *Queries) GetShiftByID(ctx context.Context, id int32) (Shift, error) {
 		&i.EndTime,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.DeletedAt,
+		&i.Version,
 	)
 	return i, err
 }
 
+const getSubtreeCoverage = `-- name: GetSubtreeCoverage :many
+WITH RECURSIVE subtree AS (
+    SELECT groups.id, 0 AS depth
+    FROM groups
+    WHERE groups.id = $1 AND groups.deleted_at IS NULL
+    UNION ALL
+    SELECT child.id, subtree.depth + 1
+    FROM groups child
+    JOIN subtree ON child.parent_id = subtree.id
+    WHERE child.deleted_at IS NULL
+)
+SELECT
+    g.id AS group_id,
+    g.name AS group_name,
+    g.parent_id,
+    subtree.depth,
+    COUNT(s.id) AS shift_count,
+    COUNT(DISTINCT s.user_id) AS staff_count,
+    COALESCE(SUM(EXTRACT(EPOCH FROM (
+        LEAST(s.end_time, $2::timestamptz) - GREATEST(s.start_time, $3::timestamptz)
+    ))), 0)::bigint AS covered_seconds
+FROM subtree
+JOIN groups g ON g.id = subtree.id
+LEFT JOIN shifts s ON s.group_id = g.id
+    AND s.start_time < $2::timestamptz
+    AND s.end_time > $3::timestamptz
+    AND s.deleted_at IS NULL
+GROUP BY g.id, g.name, g.parent_id, subtree.depth
+ORDER BY subtree.depth, g.name
+`
+
+type GetSubtreeCoverageParams struct {
+	RootID     int32              `json:"root_id"`
+	RangeEnd   pgtype.Timestamptz `json:"range_end"`
+	RangeStart pgtype.Timestamptz `json:"range_start"`
+}
+
+type GetSubtreeCoverageRow struct {
+	GroupID        int32       `json:"group_id"`
+	GroupName      string      `json:"group_name"`
+	ParentID       pgtype.Int4 `json:"parent_id"`
+	Depth          int32       `json:"depth"`
+	ShiftCount     int64       `json:"shift_count"`
+	StaffCount     int64       `json:"staff_count"`
+	CoveredSeconds int64       `json:"covered_seconds"`
+}
+
+// Summarise scheduled coverage per group in a subtree over a time range
+func (q *Queries) GetSubtreeCoverage(ctx context.Context, arg GetSubtreeCoverageParams) ([]GetSubtreeCoverageRow, error) {
+	rows, err := q.db.Query(ctx, getSubtreeCoverage, arg.RootID, arg.RangeEnd, arg.RangeStart)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetSubtreeCoverageRow
+	for rows.Next() {
+		var i GetSubtreeCoverageRow
+		if err := rows.Scan(
+			&i.GroupID,
+			&i.GroupName,
+			&i.ParentID,
+			&i.Depth,
+			&i.ShiftCount,
+			&i.StaffCount,
+			&i.CoveredSeconds,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const listAllShifts = `-- name: ListAllShifts :many
-SELECT id, user_id, group_id, name, start_time, end_time, created_at, updated_at
+SELECT id, user_id, group_id, name, start_time, end_time, created_at, updated_at, deleted_at, version
 FROM shifts
+WHERE deleted_at IS NULL
 ORDER BY start_time ASC
 `
 
@@ -113,6 +240,47 @@ func (q *Queries) ListAllShifts(ctx context.Context) ([]Shift, error) {
 			&i.EndTime,
 			&i.CreatedAt,
 			&i.UpdatedAt,
+			&i.DeletedAt,
+			&i.Version,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listDeletedShiftsByGroup = `-- name: ListDeletedShiftsByGroup :many
+SELECT id, user_id, group_id, name, start_time, end_time, created_at, updated_at, deleted_at, version
+FROM shifts
+WHERE group_id = $1 AND deleted_at IS NOT NULL
+ORDER BY deleted_at DESC
+`
+
+// List the soft deleted shifts of a group, newest deletion first
+func (q *Queries) ListDeletedShiftsByGroup(ctx context.Context, groupID pgtype.Int4) ([]Shift, error) {
+	rows, err := q.db.Query(ctx, listDeletedShiftsByGroup, groupID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Shift
+	for rows.Next() {
+		var i Shift
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.GroupID,
+			&i.Name,
+			&i.StartTime,
+			&i.EndTime,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.DeletedAt,
+			&i.Version,
 		); err != nil {
 			return nil, err
 		}
@@ -125,9 +293,9 @@ func (q *Queries) ListAllShifts(ctx context.Context) ([]Shift, error) {
 }
 
 const listShiftsByGroup = `-- name: ListShiftsByGroup :many
-SELECT id, user_id, group_id, name, start_time, end_time, created_at, updated_at
+SELECT id, user_id, group_id, name, start_time, end_time, created_at, updated_at, deleted_at, version
 FROM shifts
-WHERE group_id = $1
+WHERE group_id = $1 AND deleted_at IS NULL
 ORDER BY start_time ASC
 `
 
@@ -150,6 +318,8 @@ func (q *Queries) ListShiftsByGroup(ctx context.Context, groupID pgtype.Int4) ([
 			&i.EndTime,
 			&i.CreatedAt,
 			&i.UpdatedAt,
+			&i.DeletedAt,
+			&i.Version,
 		); err != nil {
 			return nil, err
 		}
@@ -177,7 +347,7 @@ FROM
 JOIN
     users ON shifts.user_id = users.id
 WHERE
-    shifts.group_id = $1
+    shifts.group_id = $1 AND shifts.deleted_at IS NULL
 ORDER BY
     shifts.start_time ASC
 `
@@ -225,10 +395,68 @@ func (q *Queries) ListShiftsByGroupWithNames(ctx context.Context, groupID pgtype
 	return items, nil
 }
 
+const listShiftsBySubtree = `-- name: ListShiftsBySubtree :many
+WITH RECURSIVE subtree AS (
+    SELECT groups.id
+    FROM groups
+    WHERE groups.id = $1 AND groups.deleted_at IS NULL
+    UNION ALL
+    SELECT child.id
+    FROM groups child
+    JOIN subtree ON child.parent_id = subtree.id
+    WHERE child.deleted_at IS NULL
+)
+SELECT shifts.id, shifts.user_id, shifts.group_id, shifts.name, shifts.start_time, shifts.end_time, shifts.created_at, shifts.updated_at, shifts.deleted_at, shifts.version
+FROM shifts
+JOIN subtree ON shifts.group_id = subtree.id
+WHERE shifts.start_time < $2::timestamptz
+  AND shifts.end_time > $3::timestamptz
+  AND shifts.deleted_at IS NULL
+ORDER BY shifts.start_time ASC
+`
+
+type ListShiftsBySubtreeParams struct {
+	RootID     int32              `json:"root_id"`
+	RangeEnd   pgtype.Timestamptz `json:"range_end"`
+	RangeStart pgtype.Timestamptz `json:"range_start"`
+}
+
+// List all shifts in a group and its descendant groups overlapping a time range
+func (q *Queries) ListShiftsBySubtree(ctx context.Context, arg ListShiftsBySubtreeParams) ([]Shift, error) {
+	rows, err := q.db.Query(ctx, listShiftsBySubtree, arg.RootID, arg.RangeEnd, arg.RangeStart)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Shift
+	for rows.Next() {
+		var i Shift
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.GroupID,
+			&i.Name,
+			&i.StartTime,
+			&i.EndTime,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.DeletedAt,
+			&i.Version,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const listShiftsByUser = `-- name: ListShiftsByUser :many
-SELECT id, user_id, group_id, name, start_time, end_time, created_at, updated_at
+SELECT id, user_id, group_id, name, start_time, end_time, created_at, updated_at, deleted_at, version
 FROM shifts
-WHERE user_id = $1
+WHERE user_id = $1 AND deleted_at IS NULL
 ORDER BY start_time ASC
 `
 
@@ -251,6 +479,8 @@ func (q *Queries) ListShiftsByUser(ctx context.Context, userID pgtype.Int4) ([]S
 			&i.EndTime,
 			&i.CreatedAt,
 			&i.UpdatedAt,
+			&i.DeletedAt,
+			&i.Version,
 		); err != nil {
 			return nil, err
 		}
@@ -263,9 +493,9 @@ func (q *Queries) ListShiftsByUser(ctx context.Context, userID pgtype.Int4) ([]S
 }
 
 const listShiftsByUserAndGroup = `-- name: ListShiftsByUserAndGroup :many
-SELECT id, user_id, group_id, name, start_time, end_time, created_at, updated_at
+SELECT id, user_id, group_id, name, start_time, end_time, created_at, updated_at, deleted_at, version
 FROM shifts
-WHERE user_id = $1 AND group_id = $2
+WHERE user_id = $1 AND group_id = $2 AND deleted_at IS NULL
 ORDER BY start_time ASC
 `
 
@@ -293,6 +523,8 @@ func (q *Queries) ListShiftsByUserAndGroup(ctx context.Context, arg ListShiftsBy
 			&i.EndTime,
 			&i.CreatedAt,
 			&i.UpdatedAt,
+			&i.DeletedAt,
+			&i.Version,
 		); err != nil {
 			return nil, err
 		}
@@ -304,22 +536,162 @@ func (q *Queries) ListShiftsByUserAndGroup(ctx context.Context, arg ListShiftsBy
 	return items, nil
 }
 
+const purgeDeletedShifts = `-- name: PurgeDeletedShifts :execrows
+DELETE FROM shifts
+WHERE deleted_at < $1::timestamptz
+`
+
+// Permanently remove shifts deleted before the cutoff
+func (q *Queries) PurgeDeletedShifts(ctx context.Context, cutoff pgtype.Timestamptz) (int64, error) {
+	result, err := q.db.Exec(ctx, purgeDeletedShifts, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const reassignUserShifts = `-- name: ReassignUserShifts :many
+UPDATE shifts
+SET user_id = $1,
+    version = version + 1,
+    updated_at = CURRENT_TIMESTAMP
+WHERE user_id = $2
+    AND start_time >= $3::timestamptz
+    AND deleted_at IS NULL
+    AND ($4::int IS NULL OR group_id = $4)
+    AND EXISTS (
+        SELECT 1 FROM user_groups
+        WHERE user_groups.user_id = $1 AND user_groups.group_id = shifts.group_id
+    )
+RETURNING id, user_id, group_id, name, start_time, end_time, created_at, updated_at, deleted_at, version
+`
+
+type ReassignUserShiftsParams struct {
+	ToUserID   pgtype.Int4        `json:"to_user_id"`
+	FromUserID pgtype.Int4        `json:"from_user_id"`
+	Since      pgtype.Timestamptz `json:"since"`
+	GroupID    pgtype.Int4        `json:"group_id"`
+}
+
+// Move the shifts of a user starting at or after a time to another user, only in one group when it is given
+// and only in groups the other user is a member of
+func (q *Queries) ReassignUserShifts(ctx context.Context, arg ReassignUserShiftsParams) ([]Shift, error) {
+	rows, err := q.db.Query(ctx, reassignUserShifts,
+		arg.ToUserID,
+		arg.FromUserID,
+		arg.Since,
+		arg.GroupID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Shift
+	for rows.Next() {
+		var i Shift
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.GroupID,
+			&i.Name,
+			&i.StartTime,
+			&i.EndTime,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.DeletedAt,
+			&i.Version,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const restoreGroupShifts = `-- name: RestoreGroupShifts :exec
+WITH RECURSIVE subtree AS (
+    SELECT groups.id
+    FROM groups
+    WHERE groups.id = $1::int
+    UNION
+    SELECT child.id
+    FROM groups child
+    JOIN subtree ON child.parent_id = subtree.id
+    WHERE child.deleted_at = $2::timestamptz
+)
+UPDATE shifts
+SET deleted_at = NULL,
+    version = version + 1
+WHERE shifts.group_id IN (SELECT subtree.id FROM subtree) AND shifts.deleted_at = $2::timestamptz
+`
+
+type RestoreGroupShiftsParams struct {
+	GroupID   int32              `json:"group_id"`
+	DeletedAt pgtype.Timestamptz `json:"deleted_at"`
+}
+
+// Restore the shifts that were deleted together with a group and its
+// sub-groups, run before RestoreGroupDescendants
+func (q *Queries) RestoreGroupShifts(ctx context.Context, arg RestoreGroupShiftsParams) error {
+	_, err := q.db.Exec(ctx, restoreGroupShifts, arg.GroupID, arg.DeletedAt)
+	return err
+}
+
+const restoreShift = `-- name: RestoreShift :one
+UPDATE shifts
+SET deleted_at = NULL,
+    version = version + 1
+WHERE id = $1 AND group_id = $2 AND deleted_at > $3::timestamptz
+RETURNING id, user_id, group_id, name, start_time, end_time, created_at, updated_at, deleted_at, version
+`
+
+type RestoreShiftParams struct {
+	ID      int32              `json:"id"`
+	GroupID pgtype.Int4        `json:"group_id"`
+	Cutoff  pgtype.Timestamptz `json:"cutoff"`
+}
+
+// Restore a soft deleted shift if it was deleted after the cutoff
+func (q *Queries) RestoreShift(ctx context.Context, arg RestoreShiftParams) (Shift, error) {
+	row := q.db.QueryRow(ctx, restoreShift, arg.ID, arg.GroupID, arg.Cutoff)
+	var i Shift
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.GroupID,
+		&i.Name,
+		&i.StartTime,
+		&i.EndTime,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.DeletedAt,
+		&i.Version,
+	)
+	return i, err
+}
+
 const updateShift = `-- name: UpdateShift :exec
 UPDATE shifts
 SET name = $1,
     start_time = $2,
     end_time = $3,
+    version = version + 1,
     updated_at = CURRENT_TIMESTAMP
-WHERE id = $4 AND user_id = $5 AND group_id = $6
+WHERE id = $4 AND user_id = $5 AND group_id = $6 AND deleted_at IS NULL
+    AND ($7::int IS NULL OR version = $7)
 `
 
 type UpdateShiftParams struct {
-	Name      string             `json:"name"`
-	StartTime pgtype.Timestamptz `json:"start_time"`
-	EndTime   pgtype.Timestamptz `json:"end_time"`
-	ID        int32              `json:"id"`
-	UserID    pgtype.Int4        `json:"user_id"`
-	GroupID   pgtype.Int4        `json:"group_id"`
+	Name            string             `json:"name"`
+	StartTime       pgtype.Timestamptz `json:"start_time"`
+	EndTime         pgtype.Timestamptz `json:"end_time"`
+	ID              int32              `json:"id"`
+	UserID          pgtype.Int4        `json:"user_id"`
+	GroupID         pgtype.Int4        `json:"group_id"`
+	ExpectedVersion pgtype.Int4        `json:"expected_version"`
 }
 
 // Update a shift
@@ -331,6 +703,7 @@ func (q *Queries) UpdateShift(ctx context.Context, arg UpdateShiftParams) error
 		arg.ID,
 		arg.UserID,
 		arg.GroupID,
+		arg.ExpectedVersion,
 	)
 	return err
 }
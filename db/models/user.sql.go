@@ -11,6 +11,83 @@ import (
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
+const anonymizeUser = `-- name: AnonymizeUser :exec
+UPDATE users
+SET
+    username = 'erased-' || id,
+    email = 'erased-' || id || '@erased.invalid',
+    password_hash = '',
+    first_name = NULL,
+    last_name = NULL,
+    email_verified_at = NULL,
+    failed_login_count = 0,
+    locked_until = NULL,
+    erased_at = CURRENT_TIMESTAMP,
+    updated_at = CURRENT_TIMESTAMP
+WHERE id = $1
+`
+
+// Replace the personal fields of a user with placeholders, keeping the row for the shifts that reference it
+func (q *Queries) AnonymizeUser(ctx context.Context, id int32) error {
+	_, err := q.db.Exec(ctx, anonymizeUser, id)
+	return err
+}
+
+const changeUserEmail = `-- name: ChangeUserEmail :exec
+UPDATE users
+SET email = $2, email_verified_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+WHERE id = $1
+`
+
+type ChangeUserEmailParams struct {
+	ID    int32  `json:"id"`
+	Email string `json:"email"`
+}
+
+// Switch to a new email address the user has just proven they own
+func (q *Queries) ChangeUserEmail(ctx context.Context, arg ChangeUserEmailParams) error {
+	_, err := q.db.Exec(ctx, changeUserEmail, arg.ID, arg.Email)
+	return err
+}
+
+const createServiceAccount = `-- name: CreateServiceAccount :one
+INSERT INTO users (username, email, password_hash, service_group_id, email_verified_at)
+VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP)
+RETURNING id, username, service_group_id, created_at
+`
+
+type CreateServiceAccountParams struct {
+	Username       string      `json:"username"`
+	Email          string      `json:"email"`
+	PasswordHash   string      `json:"-"`
+	ServiceGroupID pgtype.Int4 `json:"service_group_id"`
+}
+
+type CreateServiceAccountRow struct {
+	ID             int32              `json:"id"`
+	Username       string             `json:"username"`
+	ServiceGroupID pgtype.Int4        `json:"service_group_id"`
+	CreatedAt      pgtype.Timestamptz `json:"created_at"`
+}
+
+// Create a service account for a group, it can only authenticate with API keys
+func (q *Queries) CreateServiceAccount(ctx context.Context, arg CreateServiceAccountParams) (CreateServiceAccountRow, error) {
+	row := q.db.QueryRow(ctx, createServiceAccount,
+		arg.Username,
+		arg.Email,
+		arg.PasswordHash,
+		arg.ServiceGroupID,
+	)
+	var i CreateServiceAccountRow
+	err := row.Scan(
+		&i.ID,
+		&i.Username,
+		&i.ServiceGroupID,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
 const createUser = `-- name: CreateUser :one
 INSERT INTO users (username, email, password_hash, first_name, last_name)
 VALUES ($1, $2, $3, $4, $5)
@@ -20,7 +97,7 @@ RETURNING id, username, email, first_name, last_name, created_at, updated_at
 type CreateUserParams struct {
 	Username     string      `json:"username"`
 	Email        string      `json:"email"`
-	PasswordHash string      `json:"password_hash"`
+	PasswordHash string      `json:"-"`
 	FirstName    pgtype.Text `json:"first_name"`
 	LastName     pgtype.Text `json:"last_name"`
 }
@@ -57,8 +134,117 @@ func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (CreateU
 	return i, err
 }
 
+const deleteServiceAccount = `-- name: DeleteServiceAccount :execrows
+DELETE FROM users
+WHERE id = $1 AND service_group_id = $2
+`
+
+type DeleteServiceAccountParams struct {
+	ID             int32       `json:"id"`
+	ServiceGroupID pgtype.Int4 `json:"service_group_id"`
+}
+
+// Delete a service account together with its API keys
+func (q *Queries) DeleteServiceAccount(ctx context.Context, arg DeleteServiceAccountParams) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteServiceAccount, arg.ID, arg.ServiceGroupID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const disableUser = `-- name: DisableUser :execrows
+UPDATE users
+SET disabled_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+WHERE id = $1 AND disabled_at IS NULL
+`
+
+// Stop a user from logging in
+func (q *Queries) DisableUser(ctx context.Context, id int32) (int64, error) {
+	result, err := q.db.Exec(ctx, disableUser, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const enableUser = `-- name: EnableUser :execrows
+UPDATE users
+SET disabled_at = NULL, updated_at = CURRENT_TIMESTAMP
+WHERE id = $1 AND disabled_at IS NOT NULL
+`
+
+// Let a disabled user log in again
+func (q *Queries) EnableUser(ctx context.Context, id int32) (int64, error) {
+	result, err := q.db.Exec(ctx, enableUser, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const getServiceAccount = `-- name: GetServiceAccount :one
+SELECT id, username, service_group_id, created_at
+FROM users
+WHERE id = $1 AND service_group_id = $2
+`
+
+type GetServiceAccountParams struct {
+	ID             int32       `json:"id"`
+	ServiceGroupID pgtype.Int4 `json:"service_group_id"`
+}
+
+type GetServiceAccountRow struct {
+	ID             int32              `json:"id"`
+	Username       string             `json:"username"`
+	ServiceGroupID pgtype.Int4        `json:"service_group_id"`
+	CreatedAt      pgtype.Timestamptz `json:"created_at"`
+}
+
+// Get a service account of a group
+func (q *Queries) GetServiceAccount(ctx context.Context, arg GetServiceAccountParams) (GetServiceAccountRow, error) {
+	row := q.db.QueryRow(ctx, getServiceAccount, arg.ID, arg.ServiceGroupID)
+	var i GetServiceAccountRow
+	err := row.Scan(
+		&i.ID,
+		&i.Username,
+		&i.ServiceGroupID,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const getUserByEmail = `-- name: GetUserByEmail :one
+SELECT id, username, email, password_hash, first_name, last_name, created_at, updated_at, email_verified_at, failed_login_count, locked_until, service_group_id, erased_at, disabled_at
+FROM users
+WHERE email = $1
+`
+
+// Get user by email
+func (q *Queries) GetUserByEmail(ctx context.Context, email string) (User, error) {
+	row := q.db.QueryRow(ctx, getUserByEmail, email)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Username,
+		&i.Email,
+		&i.PasswordHash,
+		&i.FirstName,
+		&i.LastName,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.EmailVerifiedAt,
+		&i.FailedLoginCount,
+		&i.LockedUntil,
+		&i.ServiceGroupID,
+		&i.ErasedAt,
+		&i.DisabledAt,
+	)
+	return i, err
+}
+
 const getUserByID = `-- name: GetUserByID :one
-SELECT id, username, email, password_hash, first_name, last_name, created_at, updated_at
+SELECT id, username, email, password_hash, first_name, last_name, created_at, updated_at, email_verified_at, failed_login_count, locked_until, service_group_id, erased_at, disabled_at
 FROM users
 WHERE id = $1
 `
@@ -76,6 +262,12 @@ func (q *Queries) GetUserByID(ctx context.Context, id int32) (User, error) {
 		&i.LastName,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.EmailVerifiedAt,
+		&i.FailedLoginCount,
+		&i.LockedUntil,
+		&i.ServiceGroupID,
+		&i.ErasedAt,
+		&i.DisabledAt,
 	)
 	return i, err
 }
@@ -108,19 +300,107 @@ func (q *Queries) GetUserByUsername(ctx context.Context, username string) (GetUs
 	return i, err
 }
 
+const getUserByUsernameOrEmail = `-- name: GetUserByUsernameOrEmail :one
+SELECT id, username, email, password_hash, first_name, last_name, created_at, updated_at, email_verified_at, failed_login_count, locked_until, service_group_id, erased_at, disabled_at
+FROM users
+WHERE username = $1 OR email = $1
+`
+
+// Get any user by username or email, including disabled and service accounts
+func (q *Queries) GetUserByUsernameOrEmail(ctx context.Context, username string) (User, error) {
+	row := q.db.QueryRow(ctx, getUserByUsernameOrEmail, username)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Username,
+		&i.Email,
+		&i.PasswordHash,
+		&i.FirstName,
+		&i.LastName,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.EmailVerifiedAt,
+		&i.FailedLoginCount,
+		&i.LockedUntil,
+		&i.ServiceGroupID,
+		&i.ErasedAt,
+		&i.DisabledAt,
+	)
+	return i, err
+}
+
+const listServiceAccounts = `-- name: ListServiceAccounts :many
+SELECT id, username, service_group_id, created_at
+FROM users
+WHERE service_group_id = $1
+ORDER BY username
+`
+
+type ListServiceAccountsRow struct {
+	ID             int32              `json:"id"`
+	Username       string             `json:"username"`
+	ServiceGroupID pgtype.Int4        `json:"service_group_id"`
+	CreatedAt      pgtype.Timestamptz `json:"created_at"`
+}
+
+// List the service accounts of a group
+func (q *Queries) ListServiceAccounts(ctx context.Context, serviceGroupID pgtype.Int4) ([]ListServiceAccountsRow, error) {
+	rows, err := q.db.Query(ctx, listServiceAccounts, serviceGroupID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListServiceAccountsRow
+	for rows.Next() {
+		var i ListServiceAccountsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Username,
+			&i.ServiceGroupID,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const lockUser = `-- name: LockUser :exec
+UPDATE users
+SET locked_until = $2
+WHERE id = $1
+`
+
+type LockUserParams struct {
+	ID          int32              `json:"id"`
+	LockedUntil pgtype.Timestamptz `json:"locked_until"`
+}
+
+// Block logins for the account until the given time
+func (q *Queries) LockUser(ctx context.Context, arg LockUserParams) error {
+	_, err := q.db.Exec(ctx, lockUser, arg.ID, arg.LockedUntil)
+	return err
+}
+
 const loginUser = `-- name: LoginUser :one
-SELECT id, username, email, first_name, last_name, password_hash
+SELECT id, username, email, first_name, last_name, password_hash, failed_login_count, locked_until
 FROM users
-WHERE (username = $1 OR email = $1)
+WHERE (username = $1 OR email = $1) AND service_group_id IS NULL AND erased_at IS NULL AND disabled_at IS NULL
 `
 
 type LoginUserRow struct {
-	ID           int32       `json:"id"`
-	Username     string      `json:"username"`
-	Email        string      `json:"email"`
-	FirstName    pgtype.Text `json:"first_name"`
-	LastName     pgtype.Text `json:"last_name"`
-	PasswordHash string      `json:"password_hash"`
+	ID               int32              `json:"id"`
+	Username         string             `json:"username"`
+	Email            string             `json:"email"`
+	FirstName        pgtype.Text        `json:"first_name"`
+	LastName         pgtype.Text        `json:"last_name"`
+	PasswordHash     string             `json:"-"`
+	FailedLoginCount int32              `json:"failed_login_count"`
+	LockedUntil      pgtype.Timestamptz `json:"locked_until"`
 }
 
 // Login user by username or email and password
@@ -134,10 +414,59 @@ func (q *Queries) LoginUser(ctx context.Context, username string) (LoginUserRow,
 		&i.FirstName,
 		&i.LastName,
 		&i.PasswordHash,
+		&i.FailedLoginCount,
+		&i.LockedUntil,
 	)
 	return i, err
 }
 
+const markEmailVerified = `-- name: MarkEmailVerified :execrows
+UPDATE users
+SET email_verified_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+WHERE id = $1 AND email = $2
+`
+
+type MarkEmailVerifiedParams struct {
+	ID    int32  `json:"id"`
+	Email string `json:"email"`
+}
+
+// Mark the user's email verified, only if it has not changed since the token was sent
+func (q *Queries) MarkEmailVerified(ctx context.Context, arg MarkEmailVerifiedParams) (int64, error) {
+	result, err := q.db.Exec(ctx, markEmailVerified, arg.ID, arg.Email)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const recordFailedLogin = `-- name: RecordFailedLogin :one
+UPDATE users
+SET failed_login_count = failed_login_count + 1
+WHERE id = $1
+RETURNING failed_login_count
+`
+
+// Count a failed login against the account
+func (q *Queries) RecordFailedLogin(ctx context.Context, id int32) (int32, error) {
+	row := q.db.QueryRow(ctx, recordFailedLogin, id)
+	var failed_login_count int32
+	err := row.Scan(&failed_login_count)
+	return failed_login_count, err
+}
+
+const unlockUser = `-- name: UnlockUser :exec
+UPDATE users
+SET failed_login_count = 0, locked_until = NULL
+WHERE id = $1
+`
+
+// Clear failed logins and any lockout
+func (q *Queries) UnlockUser(ctx context.Context, id int32) error {
+	_, err := q.db.Exec(ctx, unlockUser, id)
+	return err
+}
+
 const updateUserPassword = `-- name: UpdateUserPassword :exec
 UPDATE users
 SET password_hash = $2, updated_at = CURRENT_TIMESTAMP
@@ -146,7 +475,7 @@ WHERE id = $1
 
 type UpdateUserPasswordParams struct {
 	ID           int32  `json:"id"`
-	PasswordHash string `json:"password_hash"`
+	PasswordHash string `json:"-"`
 }
 
 // Update user password
@@ -154,3 +483,49 @@ func (q *Queries) UpdateUserPassword(ctx context.Context, arg UpdateUserPassword
 	_, err := q.db.Exec(ctx, updateUserPassword, arg.ID, arg.PasswordHash)
 	return err
 }
+
+const updateUserProfile = `-- name: UpdateUserProfile :one
+UPDATE users
+SET
+    username = COALESCE($1, username),
+    first_name = COALESCE($2, first_name),
+    last_name = COALESCE($3, last_name),
+    updated_at = CURRENT_TIMESTAMP
+WHERE id = $4
+RETURNING id, username, email, password_hash, first_name, last_name, created_at, updated_at, email_verified_at, failed_login_count, locked_until, service_group_id, erased_at, disabled_at
+`
+
+type UpdateUserProfileParams struct {
+	Username  pgtype.Text `json:"username"`
+	FirstName pgtype.Text `json:"first_name"`
+	LastName  pgtype.Text `json:"last_name"`
+	ID        int32       `json:"id"`
+}
+
+// Update the fields of a profile that were given
+func (q *Queries) UpdateUserProfile(ctx context.Context, arg UpdateUserProfileParams) (User, error) {
+	row := q.db.QueryRow(ctx, updateUserProfile,
+		arg.Username,
+		arg.FirstName,
+		arg.LastName,
+		arg.ID,
+	)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Username,
+		&i.Email,
+		&i.PasswordHash,
+		&i.FirstName,
+		&i.LastName,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.EmailVerifiedAt,
+		&i.FailedLoginCount,
+		&i.LockedUntil,
+		&i.ServiceGroupID,
+		&i.ErasedAt,
+		&i.DisabledAt,
+	)
+	return i, err
+}
@@ -0,0 +1,36 @@
+-- Store a hashed API key
+-- name: CreateAPIKey :one
+INSERT INTO api_keys (user_id, name, key_prefix, key_hash, scopes, created_by, expires_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+RETURNING id, user_id, name, key_prefix, scopes, created_by, expires_at, last_used_at, revoked_at, created_at;
+
+-- Look up a key that is neither revoked nor expired
+-- name: GetActiveAPIKeyByHash :one
+SELECT *
+FROM api_keys
+WHERE key_hash = $1 AND revoked_at IS NULL AND expires_at > CURRENT_TIMESTAMP;
+
+-- Record key usage, at most once a minute to keep writes down
+-- name: TouchAPIKey :exec
+UPDATE api_keys
+SET last_used_at = CURRENT_TIMESTAMP
+WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < CURRENT_TIMESTAMP - INTERVAL '1 minute');
+
+-- List the keys of a user or service account without their hashes
+-- name: ListUserAPIKeys :many
+SELECT id, user_id, name, key_prefix, scopes, created_by, expires_at, last_used_at, revoked_at, created_at
+FROM api_keys
+WHERE user_id = $1
+ORDER BY created_at DESC;
+
+-- Revoke a key owned by the given user
+-- name: RevokeAPIKey :one
+UPDATE api_keys
+SET revoked_at = CURRENT_TIMESTAMP
+WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL
+RETURNING id, user_id, name, key_prefix, scopes, created_by, expires_at, last_used_at, revoked_at, created_at;
+
+-- Delete every API key of a user
+-- name: DeleteUserAPIKeys :exec
+DELETE FROM api_keys
+WHERE user_id = $1;
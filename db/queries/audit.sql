@@ -0,0 +1,36 @@
+-- Record a change
+-- name: CreateAuditEvent :exec
+INSERT INTO audit_events (actor_id, action, entity_type, entity_id, group_id, before, after, request_id, ip_address)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9);
+
+-- List the events of a group and its sub-groups, newest first, narrowed by the filters that are set
+-- name: ListGroupAuditEvents :many
+WITH RECURSIVE subtree AS (
+    SELECT groups.id
+    FROM groups
+    WHERE groups.id = sqlc.arg('root_id')
+    UNION ALL
+    SELECT child.id
+    FROM groups child
+    JOIN subtree ON child.parent_id = subtree.id
+)
+SELECT *
+FROM audit_events
+WHERE group_id IN (SELECT subtree.id FROM subtree)
+    AND (sqlc.narg('actor_id')::int IS NULL OR actor_id = sqlc.narg('actor_id'))
+    AND (sqlc.narg('action')::text IS NULL OR action = sqlc.narg('action'))
+    AND (sqlc.narg('entity_type')::text IS NULL OR entity_type = sqlc.narg('entity_type'))
+    AND (sqlc.narg('entity_id')::int IS NULL OR entity_id = sqlc.narg('entity_id'))
+    AND (sqlc.narg('since')::timestamptz IS NULL OR created_at >= sqlc.narg('since'))
+    AND (sqlc.narg('until')::timestamptz IS NULL OR created_at < sqlc.narg('until'))
+    AND (sqlc.narg('before_id')::bigint IS NULL OR id < sqlc.narg('before_id'))
+ORDER BY id DESC
+LIMIT sqlc.arg('limit');
+
+-- List the changes a user made and the changes made to their account and memberships
+-- name: ListUserAuditEvents :many
+SELECT *
+FROM audit_events
+WHERE actor_id = sqlc.arg('user_id')::int
+    OR (entity_type IN ('user', 'membership') AND entity_id = sqlc.arg('user_id')::int)
+ORDER BY id DESC;
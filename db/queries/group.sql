@@ -1,28 +1,55 @@
 -- name: CreateGroup :one
-INSERT INTO groups (name, description, owner_id, created_at, updated_at)
-VALUES ($1, $2, $3, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
-RETURNING id, name, description, owner_id, created_at, updated_at;
+INSERT INTO groups (name, description, owner_id, parent_id, created_at, updated_at)
+VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+RETURNING id, name, description, owner_id, created_at, updated_at, parent_id, require_manager_two_factor, deleted_at, version;
 
 -- name: UpdateGroup :one
 UPDATE groups
 SET name = $2,
     description = $3,
+    version = version + 1,
     updated_at = CURRENT_TIMESTAMP
-WHERE id = $1
+WHERE id = $1 AND deleted_at IS NULL
+    AND (sqlc.narg('expected_version')::int IS NULL OR version = sqlc.narg('expected_version'))
 RETURNING *;
 
--- name: DeleteGroup :exec
-DELETE FROM groups
-WHERE id = $1;
+-- Soft delete a group, its sub-groups and shifts are marked in the same
+-- transaction with the same deleted_at
+-- name: DeleteGroup :one
+UPDATE groups
+SET deleted_at = CURRENT_TIMESTAMP,
+    version = version + 1,
+    updated_at = CURRENT_TIMESTAMP
+WHERE id = $1 AND deleted_at IS NULL
+    AND (sqlc.narg('expected_version')::int IS NULL OR version = sqlc.narg('expected_version'))
+RETURNING *;
+
+-- Soft delete the live sub-groups of a group that is being deleted
+-- name: DeleteGroupDescendants :exec
+WITH RECURSIVE descendants AS (
+    SELECT groups.id
+    FROM groups
+    WHERE groups.parent_id = sqlc.arg('root_id')::int AND groups.deleted_at IS NULL
+    UNION
+    SELECT child.id
+    FROM groups child
+    JOIN descendants ON child.parent_id = descendants.id
+    WHERE child.deleted_at IS NULL
+)
+UPDATE groups
+SET deleted_at = sqlc.arg('deleted_at')::timestamptz,
+    version = version + 1,
+    updated_at = CURRENT_TIMESTAMP
+WHERE groups.id IN (SELECT descendants.id FROM descendants);
 
 -- name: GetGroupByID :one
 SELECT *
 FROM groups
-WHERE id = $1;
+WHERE id = $1 AND deleted_at IS NULL;
 
 -- name: GetGroupsByOwner :many
 SELECT * FROM groups
-WHERE owner_id = $1
+WHERE owner_id = $1 AND deleted_at IS NULL
 ORDER BY created_at DESC;
 
 -- name: PatchGroup :one
@@ -30,10 +57,126 @@ UPDATE groups
 SET 
     name = COALESCE(sqlc.narg('name'), name),
     description = COALESCE($2, description),
+    version = version + 1,
     updated_at = CURRENT_TIMESTAMP
-WHERE id = $1
+WHERE id = $1 AND deleted_at IS NULL
+    AND (sqlc.narg('expected_version')::int IS NULL OR version = sqlc.narg('expected_version'))
 RETURNING *;
 
+-- name: SetGroupParent :one
+UPDATE groups
+SET parent_id = $2,
+    version = version + 1,
+    updated_at = CURRENT_TIMESTAMP
+WHERE id = $1 AND deleted_at IS NULL
+    AND (sqlc.narg('expected_version')::int IS NULL OR version = sqlc.narg('expected_version'))
+RETURNING *;
+
+-- name: GetGroupSubtree :many
+WITH RECURSIVE subtree AS (
+    SELECT groups.id, 0 AS depth
+    FROM groups
+    WHERE groups.id = sqlc.arg('root_id') AND groups.deleted_at IS NULL
+    UNION ALL
+    SELECT child.id, subtree.depth + 1
+    FROM groups child
+    JOIN subtree ON child.parent_id = subtree.id
+    WHERE child.deleted_at IS NULL
+)
+SELECT
+    g.id,
+    g.name,
+    g.description,
+    g.owner_id,
+    g.parent_id,
+    g.created_at,
+    g.updated_at,
+    subtree.depth
+FROM subtree
+JOIN groups g ON g.id = subtree.id
+ORDER BY subtree.depth, g.name;
+
+-- name: IsGroupInSubtree :one
+WITH RECURSIVE subtree AS (
+    SELECT groups.id
+    FROM groups
+    WHERE groups.id = sqlc.arg('root_id') AND groups.deleted_at IS NULL
+    UNION
+    SELECT child.id
+    FROM groups child
+    JOIN subtree ON child.parent_id = subtree.id
+    WHERE child.deleted_at IS NULL
+)
+SELECT EXISTS (
+    SELECT 1 FROM subtree WHERE subtree.id = sqlc.arg('group_id')
+) AS in_subtree;
+
+-- name: UserCanManageGroup :one
+WITH RECURSIVE ancestors AS (
+    SELECT groups.id, groups.parent_id, groups.owner_id
+    FROM groups
+    WHERE groups.id = sqlc.arg('group_id') AND groups.deleted_at IS NULL
+    UNION
+    SELECT parent.id, parent.parent_id, parent.owner_id
+    FROM groups parent
+    JOIN ancestors ON parent.id = ancestors.parent_id
+    WHERE parent.deleted_at IS NULL
+)
+SELECT EXISTS (
+    SELECT 1 FROM ancestors
+    WHERE ancestors.owner_id = sqlc.arg('user_id')::int
+        OR ancestors.id = (SELECT users.service_group_id FROM users WHERE users.id = sqlc.arg('user_id')::int)
+) AS can_manage;
+
+-- name: SetGroupTwoFactorPolicy :one
+UPDATE groups
+SET require_manager_two_factor = $2,
+    version = version + 1,
+    updated_at = CURRENT_TIMESTAMP
+WHERE id = $1 AND deleted_at IS NULL
+    AND (sqlc.narg('expected_version')::int IS NULL OR version = sqlc.narg('expected_version'))
+RETURNING *;
+
+-- name: GroupRequiresManagerTwoFactor :one
+WITH RECURSIVE ancestors AS (
+    SELECT groups.id, groups.parent_id, groups.require_manager_two_factor
+    FROM groups
+    WHERE groups.id = sqlc.arg('group_id') AND groups.deleted_at IS NULL
+    UNION
+    SELECT parent.id, parent.parent_id, parent.require_manager_two_factor
+    FROM groups parent
+    JOIN ancestors ON parent.id = ancestors.parent_id
+    WHERE parent.deleted_at IS NULL
+)
+SELECT EXISTS (
+    SELECT 1 FROM ancestors WHERE ancestors.require_manager_two_factor
+) AS required;
+
+-- name: UserRequiresTwoFactor :one
+WITH RECURSIVE managed AS (
+    SELECT groups.id, groups.require_manager_two_factor
+    FROM groups
+    WHERE groups.owner_id = sqlc.arg('user_id')::int AND groups.deleted_at IS NULL
+    UNION
+    SELECT child.id, child.require_manager_two_factor
+    FROM groups child
+    JOIN managed ON child.parent_id = managed.id
+    WHERE child.deleted_at IS NULL
+), ancestors AS (
+    SELECT groups.id, groups.parent_id, groups.require_manager_two_factor
+    FROM groups
+    WHERE groups.owner_id = sqlc.arg('user_id')::int AND groups.deleted_at IS NULL
+    UNION
+    SELECT parent.id, parent.parent_id, parent.require_manager_two_factor
+    FROM groups parent
+    JOIN ancestors ON parent.id = ancestors.parent_id
+    WHERE parent.deleted_at IS NULL
+)
+SELECT (
+    EXISTS (SELECT 1 FROM managed WHERE managed.require_manager_two_factor)
+    OR EXISTS (SELECT 1 FROM ancestors WHERE ancestors.require_manager_two_factor)
+)::bool AS required;
+
 -- name: AddUserToGroup :one
 INSERT INTO user_groups (user_id, group_id)
 VALUES ($1, $2)
@@ -54,7 +197,7 @@ SELECT
     ug.joined_at AS user_joined_at
 FROM user_groups ug
 JOIN groups g ON ug.group_id = g.id
-WHERE ug.user_id = $1;
+WHERE ug.user_id = $1 AND g.deleted_at IS NULL;
 
 -- name: GetGroupMembers :many
 SELECT 
@@ -68,4 +211,94 @@ SELECT
     ug.joined_at
 FROM user_groups ug
 JOIN users u ON ug.user_id = u.id
-WHERE ug.group_id = $1;
+JOIN groups g ON ug.group_id = g.id
+WHERE ug.group_id = $1 AND g.deleted_at IS NULL;
+
+-- name: GetSubtreeMembers :many
+WITH RECURSIVE subtree AS (
+    SELECT groups.id
+    FROM groups
+    WHERE groups.id = sqlc.arg('root_id') AND groups.deleted_at IS NULL
+    UNION ALL
+    SELECT child.id
+    FROM groups child
+    JOIN subtree ON child.parent_id = subtree.id
+    WHERE child.deleted_at IS NULL
+)
+SELECT
+    u.id AS user_id,
+    u.username,
+    u.email,
+    u.first_name,
+    u.last_name,
+    ug.group_id,
+    ug.joined_at
+FROM user_groups ug
+JOIN subtree ON ug.group_id = subtree.id
+JOIN users u ON ug.user_id = u.id
+ORDER BY u.id, ug.group_id;
+
+-- Count the groups a user owns
+-- name: CountGroupsOwnedByUser :one
+SELECT COUNT(*)
+FROM groups
+WHERE owner_id = $1 AND deleted_at IS NULL;
+
+-- Remove a user from every group
+-- name: DeleteUserMemberships :exec
+DELETE FROM user_groups
+WHERE user_id = $1;
+
+-- Get a soft deleted group
+-- name: GetDeletedGroupByID :one
+SELECT *
+FROM groups
+WHERE id = $1 AND deleted_at IS NOT NULL;
+
+-- List the soft deleted children of a group, newest deletion first
+-- name: ListDeletedChildGroups :many
+SELECT *
+FROM groups
+WHERE parent_id = $1 AND deleted_at IS NOT NULL
+ORDER BY deleted_at DESC;
+
+-- Restore a soft deleted group if it was deleted after the cutoff
+-- name: RestoreGroup :one
+UPDATE groups
+SET deleted_at = NULL,
+    version = version + 1,
+    updated_at = CURRENT_TIMESTAMP
+WHERE id = $1 AND deleted_at > sqlc.arg('cutoff')::timestamptz
+RETURNING *;
+
+-- Restore the sub-groups that were deleted together with a group
+-- name: RestoreGroupDescendants :exec
+WITH RECURSIVE descendants AS (
+    SELECT groups.id
+    FROM groups
+    WHERE groups.parent_id = sqlc.arg('root_id')::int AND groups.deleted_at = sqlc.arg('deleted_at')::timestamptz
+    UNION
+    SELECT child.id
+    FROM groups child
+    JOIN descendants ON child.parent_id = descendants.id
+    WHERE child.deleted_at = sqlc.arg('deleted_at')::timestamptz
+)
+UPDATE groups
+SET deleted_at = NULL,
+    version = version + 1,
+    updated_at = CURRENT_TIMESTAMP
+WHERE groups.id IN (SELECT descendants.id FROM descendants);
+
+-- Permanently remove groups deleted before the cutoff
+-- name: PurgeDeletedGroups :execrows
+DELETE FROM groups
+WHERE deleted_at < sqlc.arg('cutoff')::timestamptz;
+
+-- Hand a group over to a new owner
+-- name: TransferGroupOwnership :one
+UPDATE groups
+SET owner_id = $2,
+    version = version + 1,
+    updated_at = CURRENT_TIMESTAMP
+WHERE id = $1 AND deleted_at IS NULL
+RETURNING *;
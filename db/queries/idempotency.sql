@@ -0,0 +1,40 @@
+-- Claim a key for a request, taking over keys that have expired and claims
+-- whose request has not finished since in_progress_before, which a crash left
+-- behind. Returns no row when the key is already in use.
+-- name: ClaimIdempotencyKey :one
+INSERT INTO idempotency_keys (user_id, key, fingerprint, expires_at)
+VALUES (sqlc.arg('user_id'), sqlc.arg('key'), sqlc.arg('fingerprint'), sqlc.arg('expires_at'))
+ON CONFLICT (user_id, key) DO UPDATE
+SET fingerprint = EXCLUDED.fingerprint,
+    status_code = NULL,
+    response_headers = NULL,
+    response_body = NULL,
+    created_at = CURRENT_TIMESTAMP,
+    expires_at = EXCLUDED.expires_at
+WHERE idempotency_keys.expires_at < CURRENT_TIMESTAMP
+    OR (idempotency_keys.status_code IS NULL AND idempotency_keys.created_at < sqlc.arg('in_progress_before'))
+RETURNING id, user_id, key, fingerprint, status_code, response_headers, response_body, created_at, expires_at;
+
+-- Get a key that has not expired
+-- name: GetIdempotencyKey :one
+SELECT id, user_id, key, fingerprint, status_code, response_headers, response_body, created_at, expires_at
+FROM idempotency_keys
+WHERE user_id = $1 AND key = $2 AND expires_at > CURRENT_TIMESTAMP;
+
+-- Store the response of the request that claimed a key
+-- name: CompleteIdempotencyKey :exec
+UPDATE idempotency_keys
+SET status_code = $2,
+    response_headers = $3,
+    response_body = $4
+WHERE id = $1;
+
+-- Release a key so the request can be retried
+-- name: DeleteIdempotencyKey :exec
+DELETE FROM idempotency_keys
+WHERE id = $1;
+
+-- Remove expired keys
+-- name: DeleteExpiredIdempotencyKeys :execrows
+DELETE FROM idempotency_keys
+WHERE expires_at < CURRENT_TIMESTAMP;
@@ -0,0 +1,45 @@
+-- Link an external identity to a user
+-- name: CreateIdentity :one
+INSERT INTO identities (user_id, issuer, subject, email, last_login_at)
+VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP)
+RETURNING *;
+
+-- Find the identity for an issuer and subject
+-- name: GetIdentityByIssuerSubject :one
+SELECT *
+FROM identities
+WHERE issuer = $1 AND subject = $2;
+
+-- Record a login through an identity
+-- name: TouchIdentity :exec
+UPDATE identities
+SET last_login_at = CURRENT_TIMESTAMP, email = $2
+WHERE id = $1;
+
+-- List the identities linked to a user
+-- name: ListUserIdentities :many
+SELECT *
+FROM identities
+WHERE user_id = $1
+ORDER BY created_at ASC;
+
+-- Remember an authorization request until the provider redirects back
+-- name: CreateOIDCLoginState :exec
+INSERT INTO oidc_login_states (state_hash, code_verifier, nonce, link_user_id, expires_at, browser_hash)
+VALUES ($1, $2, $3, $4, $5, $6);
+
+-- Take an authorization request so its state can only be used once
+-- name: ConsumeOIDCLoginState :one
+DELETE FROM oidc_login_states
+WHERE state_hash = $1 AND expires_at > CURRENT_TIMESTAMP
+RETURNING *;
+
+-- Remove authorization requests that were never completed
+-- name: DeleteExpiredOIDCLoginStates :execrows
+DELETE FROM oidc_login_states
+WHERE expires_at <= CURRENT_TIMESTAMP;
+
+-- Unlink every external identity of a user
+-- name: DeleteUserIdentities :exec
+DELETE FROM identities
+WHERE user_id = $1;
@@ -0,0 +1,30 @@
+-- Record a login attempt for the audit trail
+-- name: CreateLoginAttempt :exec
+INSERT INTO login_attempts (identifier, user_id, ip_address, user_agent, succeeded, reason)
+VALUES ($1, $2, $3, $4, $5, $6);
+
+-- Count recent failed logins from an address
+-- name: GetIPFailureStats :one
+SELECT COUNT(*) AS failures, MAX(created_at)::timestamptz AS last_failed_at
+FROM login_attempts
+WHERE ip_address = $1 AND NOT succeeded AND created_at > $2;
+
+-- List the most recent login attempts for a user
+-- name: ListUserLoginAttempts :many
+SELECT *
+FROM login_attempts
+WHERE user_id = $1
+ORDER BY created_at DESC
+LIMIT $2;
+
+-- List every recorded login attempt against a user
+-- name: ListAllUserLoginAttempts :many
+SELECT *
+FROM login_attempts
+WHERE user_id = $1
+ORDER BY created_at DESC;
+
+-- Delete the login attempts of a user, including ones made with their username or email before it matched the account
+-- name: DeleteUserLoginAttempts :exec
+DELETE FROM login_attempts
+WHERE user_id = sqlc.arg('user_id') OR identifier = sqlc.arg('username') OR identifier = sqlc.arg('email');
@@ -0,0 +1,9 @@
+-- Count the users with a live session and the shifts and failed logins since a time, for the metrics endpoint
+-- name: GetDomainStats :one
+SELECT
+    (SELECT COUNT(DISTINCT user_id) FROM sessions
+     WHERE revoked_at IS NULL AND expires_at > CURRENT_TIMESTAMP)::bigint AS active_users,
+    (SELECT COUNT(*) FROM shifts
+     WHERE created_at > sqlc.arg('since'))::bigint AS shifts_created,
+    (SELECT COUNT(*) FROM login_attempts
+     WHERE NOT succeeded AND created_at > sqlc.arg('since'))::bigint AS failed_logins;
@@ -0,0 +1,95 @@
+-- Create a new session for a user
+-- name: CreateSession :one
+INSERT INTO sessions (user_id, user_agent, ip_address, expires_at, two_factor_verified_at)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING *;
+
+-- Get a session that has not been revoked or expired
+-- name: GetActiveSession :one
+SELECT *
+FROM sessions
+WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL AND expires_at > CURRENT_TIMESTAMP;
+
+-- Record session activity and extend its expiry
+-- name: TouchSession :exec
+UPDATE sessions
+SET last_used_at = CURRENT_TIMESTAMP, expires_at = $2
+WHERE id = $1;
+
+-- Revoke a single session
+-- name: RevokeSession :exec
+UPDATE sessions
+SET revoked_at = CURRENT_TIMESTAMP
+WHERE id = $1 AND revoked_at IS NULL;
+
+-- Revoke every session belonging to a user
+-- name: RevokeUserSessions :exec
+UPDATE sessions
+SET revoked_at = CURRENT_TIMESTAMP
+WHERE user_id = $1 AND revoked_at IS NULL;
+
+-- Revoke every session of a user except the one the request came from
+-- name: RevokeOtherUserSessions :exec
+UPDATE sessions
+SET revoked_at = CURRENT_TIMESTAMP
+WHERE user_id = sqlc.arg('user_id') AND id <> sqlc.arg('keep_id') AND revoked_at IS NULL;
+
+-- Store a hashed refresh token
+-- name: CreateRefreshToken :one
+INSERT INTO refresh_tokens (session_id, token_hash, expires_at)
+VALUES ($1, $2, $3)
+RETURNING *;
+
+-- Look up a refresh token by its hash
+-- name: GetRefreshTokenByHash :one
+SELECT *
+FROM refresh_tokens
+WHERE token_hash = $1;
+
+-- Mark a refresh token as used, only succeeding for the first caller
+-- name: MarkRefreshTokenUsed :execrows
+UPDATE refresh_tokens
+SET used_at = CURRENT_TIMESTAMP
+WHERE id = $1 AND used_at IS NULL;
+
+-- Get a session by ID regardless of its state
+-- name: GetSessionByID :one
+SELECT *
+FROM sessions
+WHERE id = $1;
+
+-- Record that the session passed the second factor
+-- name: MarkSessionTwoFactorVerified :exec
+UPDATE sessions
+SET two_factor_verified_at = CURRENT_TIMESTAMP
+WHERE id = $1;
+
+-- List the sessions of a user that are still usable
+-- name: ListUserSessions :many
+SELECT *
+FROM sessions
+WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > CURRENT_TIMESTAMP
+ORDER BY COALESCE(last_used_at, created_at) DESC;
+
+-- Revoke one session of a user
+-- name: RevokeUserSession :execrows
+UPDATE sessions
+SET revoked_at = CURRENT_TIMESTAMP
+WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL;
+
+-- List every session of a user, including ended ones
+-- name: ListAllUserSessions :many
+SELECT *
+FROM sessions
+WHERE user_id = $1
+ORDER BY created_at DESC;
+
+-- Delete every session of a user along with its refresh tokens
+-- name: DeleteUserSessions :exec
+DELETE FROM sessions
+WHERE user_id = $1;
+
+-- Delete sessions that expired or were revoked before the cutoff, their refresh tokens go with them
+-- name: DeleteEndedSessions :execrows
+DELETE FROM sessions
+WHERE expires_at < sqlc.arg('cutoff')::timestamptz OR revoked_at < sqlc.arg('cutoff')::timestamptz;
@@ -2,7 +2,7 @@
 -- name: CreateShift :one
 INSERT INTO shifts (user_id, group_id, name, start_time, end_time, created_at, updated_at)
 VALUES ($1, $2, $3, $4, $5, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
-RETURNING id, user_id, group_id, name, start_time, end_time, created_at, updated_at;
+RETURNING id, user_id, group_id, name, start_time, end_time, created_at, updated_at, deleted_at, version;
 
 -- Update a shift
 -- name: UpdateShift :exec
@@ -10,45 +10,52 @@ UPDATE shifts
 SET name = $1,
     start_time = $2,
     end_time = $3,
+    version = version + 1,
     updated_at = CURRENT_TIMESTAMP
-WHERE id = $4 AND user_id = $5 AND group_id = $6;
+WHERE id = $4 AND user_id = $5 AND group_id = $6 AND deleted_at IS NULL
+    AND (sqlc.narg('expected_version')::int IS NULL OR version = sqlc.narg('expected_version'));
 
--- Delete a shift by ID
--- name: DeleteShift :exec
-DELETE FROM shifts
-WHERE id = $1 AND user_id = $2 AND group_id = $3;
+-- Soft delete a shift in a group
+-- name: DeleteShift :one
+UPDATE shifts
+SET deleted_at = CURRENT_TIMESTAMP,
+    version = version + 1
+WHERE id = $1 AND group_id = $2 AND deleted_at IS NULL
+    AND (sqlc.narg('expected_version')::int IS NULL OR version = sqlc.narg('expected_version'))
+RETURNING id, user_id, group_id, name, start_time, end_time, created_at, updated_at, deleted_at, version;
 
 -- Get shift by ID
 -- name: GetShiftByID :one
-SELECT id, user_id, group_id, name, start_time, end_time, created_at, updated_at
+SELECT id, user_id, group_id, name, start_time, end_time, created_at, updated_at, deleted_at, version
 FROM shifts
-WHERE id = $1;
+WHERE id = $1 AND deleted_at IS NULL;
 
 -- List all shifts for a specific user in a group
 -- name: ListShiftsByUserAndGroup :many
-SELECT id, user_id, group_id, name, start_time, end_time, created_at, updated_at
+SELECT id, user_id, group_id, name, start_time, end_time, created_at, updated_at, deleted_at, version
 FROM shifts
-WHERE user_id = $1 AND group_id = $2
+WHERE user_id = $1 AND group_id = $2 AND deleted_at IS NULL
 ORDER BY start_time ASC;
 
 -- List all shifts in a specific group
 -- name: ListShiftsByGroup :many
-SELECT id, user_id, group_id, name, start_time, end_time, created_at, updated_at
+SELECT id, user_id, group_id, name, start_time, end_time, created_at, updated_at, deleted_at, version
 FROM shifts
-WHERE group_id = $1
+WHERE group_id = $1 AND deleted_at IS NULL
 ORDER BY start_time ASC;
 
 -- List all shifts for a specific user across all groups
 -- name: ListShiftsByUser :many
-SELECT id, user_id, group_id, name, start_time, end_time, created_at, updated_at
+SELECT id, user_id, group_id, name, start_time, end_time, created_at, updated_at, deleted_at, version
 FROM shifts
-WHERE user_id = $1
+WHERE user_id = $1 AND deleted_at IS NULL
 ORDER BY start_time ASC;
 
 -- List all shifts
 -- name: ListAllShifts :many
-SELECT id, user_id, group_id, name, start_time, end_time, created_at, updated_at
+SELECT id, user_id, group_id, name, start_time, end_time, created_at, updated_at, deleted_at, version
 FROM shifts
+WHERE deleted_at IS NULL
 ORDER BY start_time ASC;
 
 -- Get all shifts by group ID including user names
@@ -68,9 +75,130 @@ FROM
 JOIN
     users ON shifts.user_id = users.id
 WHERE
-    shifts.group_id = $1
+    shifts.group_id = $1 AND shifts.deleted_at IS NULL
 ORDER BY
     shifts.start_time ASC;
 
+-- List all shifts in a group and its descendant groups overlapping a time range
+-- name: ListShiftsBySubtree :many
+WITH RECURSIVE subtree AS (
+    SELECT groups.id
+    FROM groups
+    WHERE groups.id = sqlc.arg('root_id') AND groups.deleted_at IS NULL
+    UNION ALL
+    SELECT child.id
+    FROM groups child
+    JOIN subtree ON child.parent_id = subtree.id
+    WHERE child.deleted_at IS NULL
+)
+SELECT shifts.id, shifts.user_id, shifts.group_id, shifts.name, shifts.start_time, shifts.end_time, shifts.created_at, shifts.updated_at, shifts.deleted_at, shifts.version
+FROM shifts
+JOIN subtree ON shifts.group_id = subtree.id
+WHERE shifts.start_time < sqlc.arg('range_end')::timestamptz
+  AND shifts.end_time > sqlc.arg('range_start')::timestamptz
+  AND shifts.deleted_at IS NULL
+ORDER BY shifts.start_time ASC;
+
+-- Summarise scheduled coverage per group in a subtree over a time range
+-- name: GetSubtreeCoverage :many
+WITH RECURSIVE subtree AS (
+    SELECT groups.id, 0 AS depth
+    FROM groups
+    WHERE groups.id = sqlc.arg('root_id') AND groups.deleted_at IS NULL
+    UNION ALL
+    SELECT child.id, subtree.depth + 1
+    FROM groups child
+    JOIN subtree ON child.parent_id = subtree.id
+    WHERE child.deleted_at IS NULL
+)
+SELECT
+    g.id AS group_id,
+    g.name AS group_name,
+    g.parent_id,
+    subtree.depth,
+    COUNT(s.id) AS shift_count,
+    COUNT(DISTINCT s.user_id) AS staff_count,
+    COALESCE(SUM(EXTRACT(EPOCH FROM (
+        LEAST(s.end_time, sqlc.arg('range_end')::timestamptz) - GREATEST(s.start_time, sqlc.arg('range_start')::timestamptz)
+    ))), 0)::bigint AS covered_seconds
+FROM subtree
+JOIN groups g ON g.id = subtree.id
+LEFT JOIN shifts s ON s.group_id = g.id
+    AND s.start_time < sqlc.arg('range_end')::timestamptz
+    AND s.end_time > sqlc.arg('range_start')::timestamptz
+    AND s.deleted_at IS NULL
+GROUP BY g.id, g.name, g.parent_id, subtree.depth
+ORDER BY subtree.depth, g.name;
+
+-- Soft delete the shifts of a group that is being deleted and of the
+-- sub-groups deleted with it, run after DeleteGroupDescendants
+-- name: DeleteGroupShifts :exec
+WITH RECURSIVE subtree AS (
+    SELECT groups.id
+    FROM groups
+    WHERE groups.id = sqlc.arg('group_id')::int
+    UNION
+    SELECT child.id
+    FROM groups child
+    JOIN subtree ON child.parent_id = subtree.id
+    WHERE child.deleted_at = sqlc.arg('deleted_at')::timestamptz
+)
+UPDATE shifts
+SET deleted_at = sqlc.arg('deleted_at')::timestamptz,
+    version = version + 1
+WHERE shifts.group_id IN (SELECT subtree.id FROM subtree) AND shifts.deleted_at IS NULL;
+
+-- Restore the shifts that were deleted together with a group and its
+-- sub-groups, run before RestoreGroupDescendants
+-- name: RestoreGroupShifts :exec
+WITH RECURSIVE subtree AS (
+    SELECT groups.id
+    FROM groups
+    WHERE groups.id = sqlc.arg('group_id')::int
+    UNION
+    SELECT child.id
+    FROM groups child
+    JOIN subtree ON child.parent_id = subtree.id
+    WHERE child.deleted_at = sqlc.arg('deleted_at')::timestamptz
+)
+UPDATE shifts
+SET deleted_at = NULL,
+    version = version + 1
+WHERE shifts.group_id IN (SELECT subtree.id FROM subtree) AND shifts.deleted_at = sqlc.arg('deleted_at')::timestamptz;
+
+-- Restore a soft deleted shift if it was deleted after the cutoff
+-- name: RestoreShift :one
+UPDATE shifts
+SET deleted_at = NULL,
+    version = version + 1
+WHERE id = $1 AND group_id = $2 AND deleted_at > sqlc.arg('cutoff')::timestamptz
+RETURNING id, user_id, group_id, name, start_time, end_time, created_at, updated_at, deleted_at, version;
+
+-- List the soft deleted shifts of a group, newest deletion first
+-- name: ListDeletedShiftsByGroup :many
+SELECT id, user_id, group_id, name, start_time, end_time, created_at, updated_at, deleted_at, version
+FROM shifts
+WHERE group_id = $1 AND deleted_at IS NOT NULL
+ORDER BY deleted_at DESC;
 
+-- Permanently remove shifts deleted before the cutoff
+-- name: PurgeDeletedShifts :execrows
+DELETE FROM shifts
+WHERE deleted_at < sqlc.arg('cutoff')::timestamptz;
 
+-- Move the shifts of a user starting at or after a time to another user, only in one group when it is given
+-- and only in groups the other user is a member of
+-- name: ReassignUserShifts :many
+UPDATE shifts
+SET user_id = sqlc.arg('to_user_id'),
+    version = version + 1,
+    updated_at = CURRENT_TIMESTAMP
+WHERE user_id = sqlc.arg('from_user_id')
+    AND start_time >= sqlc.arg('since')::timestamptz
+    AND deleted_at IS NULL
+    AND (sqlc.narg('group_id')::int IS NULL OR group_id = sqlc.narg('group_id'))
+    AND EXISTS (
+        SELECT 1 FROM user_groups
+        WHERE user_groups.user_id = sqlc.arg('to_user_id') AND user_groups.group_id = shifts.group_id
+    )
+RETURNING id, user_id, group_id, name, start_time, end_time, created_at, updated_at, deleted_at, version;
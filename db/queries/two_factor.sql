@@ -0,0 +1,86 @@
+-- Start or restart enrolment, refusing to overwrite a confirmed secret
+-- name: UpsertUserTOTP :one
+INSERT INTO user_totp (user_id, secret)
+VALUES ($1, $2)
+ON CONFLICT (user_id) DO UPDATE
+SET secret = EXCLUDED.secret, confirmed_at = NULL, last_used_step = 0, created_at = CURRENT_TIMESTAMP
+WHERE user_totp.confirmed_at IS NULL
+RETURNING *;
+
+-- Get a user's authenticator secret
+-- name: GetUserTOTP :one
+SELECT *
+FROM user_totp
+WHERE user_id = $1;
+
+-- Finish enrolment once the user proved the authenticator works
+-- name: ConfirmUserTOTP :execrows
+UPDATE user_totp
+SET confirmed_at = CURRENT_TIMESTAMP, last_used_step = $2
+WHERE user_id = $1 AND confirmed_at IS NULL;
+
+-- Record a used time step, failing when the code was already used
+-- name: UseTOTPStep :execrows
+UPDATE user_totp
+SET last_used_step = $2
+WHERE user_id = $1 AND last_used_step < $2;
+
+-- Remove a user's authenticator
+-- name: DeleteUserTOTP :exec
+DELETE FROM user_totp
+WHERE user_id = $1;
+
+-- Store a hashed recovery code
+-- name: CreateRecoveryCode :exec
+INSERT INTO recovery_codes (user_id, code_hash)
+VALUES ($1, $2);
+
+-- Remove all recovery codes of a user
+-- name: DeleteRecoveryCodes :exec
+DELETE FROM recovery_codes
+WHERE user_id = $1;
+
+-- Use a recovery code, succeeding only once
+-- name: UseRecoveryCode :execrows
+UPDATE recovery_codes
+SET used_at = CURRENT_TIMESTAMP
+WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL;
+
+-- Count the recovery codes a user has left
+-- name: CountUnusedRecoveryCodes :one
+SELECT COUNT(*)
+FROM recovery_codes
+WHERE user_id = $1 AND used_at IS NULL;
+
+-- Store a pending second factor challenge
+-- name: CreateMFAChallenge :exec
+INSERT INTO mfa_challenges (challenge_hash, user_id, expires_at)
+VALUES ($1, $2, $3);
+
+-- Get a challenge that has not expired
+-- name: GetMFAChallenge :one
+SELECT *
+FROM mfa_challenges
+WHERE challenge_hash = $1 AND expires_at > CURRENT_TIMESTAMP;
+
+-- Count a wrong code against the challenge
+-- name: RecordMFAChallengeFailure :one
+UPDATE mfa_challenges
+SET attempts = attempts + 1
+WHERE challenge_hash = $1
+RETURNING attempts;
+
+-- Remove a challenge once it is used up
+-- name: DeleteMFAChallenge :exec
+DELETE FROM mfa_challenges
+WHERE challenge_hash = $1;
+
+-- Remove expired challenges
+-- name: DeleteExpiredMFAChallenges :execrows
+DELETE FROM mfa_challenges
+WHERE expires_at <= CURRENT_TIMESTAMP;
+
+-- Delete the pending login challenges of a user
+-- name: DeleteUserMFAChallenges :exec
+DELETE FROM mfa_challenges
+WHERE user_id = $1;
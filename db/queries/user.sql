@@ -24,7 +24,112 @@ WHERE id = $1;
 
 -- Login user by username or email and password
 -- name: LoginUser :one
-SELECT id, username, email, first_name, last_name, password_hash
+SELECT id, username, email, first_name, last_name, password_hash, failed_login_count, locked_until
 FROM users
-WHERE (username = $1 OR email = $1);
+WHERE (username = $1 OR email = $1) AND service_group_id IS NULL AND erased_at IS NULL AND disabled_at IS NULL;
 
+-- Get user by email
+-- name: GetUserByEmail :one
+SELECT *
+FROM users
+WHERE email = $1;
+
+-- Mark the user's email verified, only if it has not changed since the token was sent
+-- name: MarkEmailVerified :execrows
+UPDATE users
+SET email_verified_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+WHERE id = $1 AND email = $2;
+
+-- Count a failed login against the account
+-- name: RecordFailedLogin :one
+UPDATE users
+SET failed_login_count = failed_login_count + 1
+WHERE id = $1
+RETURNING failed_login_count;
+
+-- Block logins for the account until the given time
+-- name: LockUser :exec
+UPDATE users
+SET locked_until = $2
+WHERE id = $1;
+
+-- Clear failed logins and any lockout
+-- name: UnlockUser :exec
+UPDATE users
+SET failed_login_count = 0, locked_until = NULL
+WHERE id = $1;
+
+-- Create a service account for a group, it can only authenticate with API keys
+-- name: CreateServiceAccount :one
+INSERT INTO users (username, email, password_hash, service_group_id, email_verified_at)
+VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP)
+RETURNING id, username, service_group_id, created_at;
+
+-- List the service accounts of a group
+-- name: ListServiceAccounts :many
+SELECT id, username, service_group_id, created_at
+FROM users
+WHERE service_group_id = $1
+ORDER BY username;
+
+-- Get a service account of a group
+-- name: GetServiceAccount :one
+SELECT id, username, service_group_id, created_at
+FROM users
+WHERE id = $1 AND service_group_id = $2;
+
+-- Delete a service account together with its API keys
+-- name: DeleteServiceAccount :execrows
+DELETE FROM users
+WHERE id = $1 AND service_group_id = $2;
+
+-- Update the fields of a profile that were given
+-- name: UpdateUserProfile :one
+UPDATE users
+SET
+    username = COALESCE(sqlc.narg('username'), username),
+    first_name = COALESCE(sqlc.narg('first_name'), first_name),
+    last_name = COALESCE(sqlc.narg('last_name'), last_name),
+    updated_at = CURRENT_TIMESTAMP
+WHERE id = sqlc.arg('id')
+RETURNING *;
+
+-- Switch to a new email address the user has just proven they own
+-- name: ChangeUserEmail :exec
+UPDATE users
+SET email = $2, email_verified_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+WHERE id = $1;
+
+-- Replace the personal fields of a user with placeholders, keeping the row for the shifts that reference it
+-- name: AnonymizeUser :exec
+UPDATE users
+SET
+    username = 'erased-' || id,
+    email = 'erased-' || id || '@erased.invalid',
+    password_hash = '',
+    first_name = NULL,
+    last_name = NULL,
+    email_verified_at = NULL,
+    failed_login_count = 0,
+    locked_until = NULL,
+    erased_at = CURRENT_TIMESTAMP,
+    updated_at = CURRENT_TIMESTAMP
+WHERE id = $1;
+
+-- Get any user by username or email, including disabled and service accounts
+-- name: GetUserByUsernameOrEmail :one
+SELECT *
+FROM users
+WHERE username = $1 OR email = $1;
+
+-- Stop a user from logging in
+-- name: DisableUser :execrows
+UPDATE users
+SET disabled_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+WHERE id = $1 AND disabled_at IS NULL;
+
+-- Let a disabled user log in again
+-- name: EnableUser :execrows
+UPDATE users
+SET disabled_at = NULL, updated_at = CURRENT_TIMESTAMP
+WHERE id = $1 AND disabled_at IS NOT NULL;
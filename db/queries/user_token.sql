@@ -0,0 +1,28 @@
+-- Store a hashed single-use token
+-- name: CreateUserToken :one
+INSERT INTO user_tokens (user_id, purpose, token_hash, email, expires_at)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING *;
+
+-- Use a token, succeeding only once and only before it expires
+-- name: ConsumeUserToken :one
+UPDATE user_tokens
+SET used_at = CURRENT_TIMESTAMP
+WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > CURRENT_TIMESTAMP
+RETURNING *;
+
+-- Invalidate every outstanding token of a purpose for a user
+-- name: InvalidateUserTokens :exec
+UPDATE user_tokens
+SET used_at = CURRENT_TIMESTAMP
+WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL;
+
+-- Delete every email token of a user
+-- name: DeleteUserTokens :exec
+DELETE FROM user_tokens
+WHERE user_id = $1;
+
+-- Delete tokens that were used or have expired
+-- name: DeleteSpentUserTokens :execrows
+DELETE FROM user_tokens
+WHERE used_at IS NOT NULL OR expires_at <= CURRENT_TIMESTAMP;
@@ -0,0 +1,47 @@
+package db
+
+import (
+	"context"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// QueryName returns the name sqlc puts in the first line of its queries,
+// "-- name: GetUserByID :one", or "other" for SQL written by hand such as
+// migrations and transaction statements.
+func QueryName(sql string) string {
+	rest, ok := strings.CutPrefix(sql, "-- name: ")
+	if !ok {
+		return "other"
+	}
+	name, _, _ := strings.Cut(rest, " ")
+	return name
+}
+
+// ChainTracers lets more than one tracer see the queries of a connection,
+// pgx.ConnConfig only takes one. Nil tracers are skipped.
+func ChainTracers(tracers ...pgx.QueryTracer) pgx.QueryTracer {
+	var chain queryTracers
+	for _, tracer := range tracers {
+		if tracer != nil {
+			chain = append(chain, tracer)
+		}
+	}
+	return chain
+}
+
+type queryTracers []pgx.QueryTracer
+
+func (c queryTracers) TraceQueryStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	for _, tracer := range c {
+		ctx = tracer.TraceQueryStart(ctx, conn, data)
+	}
+	return ctx
+}
+
+func (c queryTracers) TraceQueryEnd(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryEndData) {
+	for _, tracer := range c {
+		tracer.TraceQueryEnd(ctx, conn, data)
+	}
+}
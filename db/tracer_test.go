@@ -0,0 +1,17 @@
+package db
+
+import "testing"
+
+func TestQueryName(t *testing.T) {
+	tests := map[string]string{
+		"-- name: GetUserByID :one\nSELECT id FROM users WHERE id = $1": "GetUserByID",
+		"-- name: ListShifts :many\nSELECT 1":                           "ListShifts",
+		"begin":                                                         "other",
+		"SELECT version, dirty FROM schema_migrations":                  "other",
+	}
+	for sql, want := range tests {
+		if got := QueryName(sql); got != want {
+			t.Errorf("QueryName(%q) = %q, want %q", sql, got, want)
+		}
+	}
+}
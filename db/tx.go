@@ -0,0 +1,32 @@
+package db
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+	models "github.com/joseph-gunnarsson/scheduling/db/models"
+)
+
+// TxBeginner is anything a transaction can be started on, such as a
+// *pgxpool.Pool or a *pgx.Conn.
+type TxBeginner interface {
+	models.DBTX
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
+
+// InTx runs fn with queries bound to a single transaction. The transaction
+// is committed when fn returns nil and rolled back otherwise, so the steps
+// of fn either all happen or none do.
+func InTx(ctx context.Context, conn TxBeginner, fn func(query *models.Queries) error) error {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	err = fn(models.New(conn).WithTx(tx))
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"slices"
+	"strings"
+	"time"
+)
+
+// APIKeyPrefix marks a bearer token as an API key rather than a JWT.
+const APIKeyPrefix = "sch_"
+
+const (
+	DefaultAPIKeyTTL = 90 * 24 * time.Hour
+	MaxAPIKeyTTL     = 365 * 24 * time.Hour
+)
+
+// Scopes an API key can be granted. JWT sessions are not limited by scopes.
+const (
+	ScopeReadGroups  = "read:groups"
+	ScopeWriteGroups = "write:groups"
+	ScopeReadShifts  = "read:shifts"
+	ScopeWriteShifts = "write:shifts"
+)
+
+var AllScopes = []string{ScopeReadGroups, ScopeWriteGroups, ScopeReadShifts, ScopeWriteShifts}
+
+// GenerateAPIKey returns the key for the client, a short prefix that is safe
+// to show in listings, and the hash to store.
+func GenerateAPIKey() (string, string, string, error) {
+	secret, err := RandomString(32)
+	if err != nil {
+		return "", "", "", err
+	}
+	key := APIKeyPrefix + secret
+	return key, key[:len(APIKeyPrefix)+8], HashToken(key), nil
+}
+
+func IsAPIKey(token string) bool {
+	return strings.HasPrefix(token, APIKeyPrefix)
+}
+
+func ValidScope(scope string) bool {
+	return slices.Contains(AllScopes, scope)
+}
+
+// HasScope reports whether granted covers scope. A write scope implies read
+// access to the same resource.
+func HasScope(granted []string, scope string) bool {
+	if slices.Contains(granted, scope) {
+		return true
+	}
+	resource, isRead := strings.CutPrefix(scope, "read:")
+	return isRead && slices.Contains(granted, "write:"+resource)
+}
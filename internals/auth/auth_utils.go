@@ -1,11 +1,29 @@
 package auth
 
-import "golang.org/x/crypto/bcrypt"
+import (
+	"context"
 
-func HashPassword(password string) (string, error) {
+	"go.opentelemetry.io/otel"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// tracer times bcrypt, which is slow on purpose and often the bulk of a
+// login.
+var tracer = otel.Tracer("github.com/joseph-gunnarsson/scheduling/internals/auth")
+
+func HashPassword(ctx context.Context, password string) (string, error) {
+	_, span := tracer.Start(ctx, "bcrypt.GenerateFromPassword")
+	defer span.End()
 	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
 		return "", err
 	}
 	return string(hash), nil
 }
+
+// ComparePassword returns nil when password matches hash.
+func ComparePassword(ctx context.Context, hash string, password string) error {
+	_, span := tracer.Start(ctx, "bcrypt.CompareHashAndPassword")
+	defer span.End()
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+}
@@ -1,38 +1,254 @@
 package auth
 
 import (
-	"crypto/hmac"
-	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"os"
 	"strings"
 	"time"
 )
 
+var (
+	ErrMalformedToken   = errors.New("invalid token format: token should have 3 parts")
+	ErrUnsupportedAlg   = errors.New("token signed with an unsupported algorithm")
+	ErrUnknownKey       = errors.New("token signed with an unknown key")
+	ErrInvalidSignature = errors.New("invalid token signature: token verification failed")
+	ErrTokenExpired     = errors.New("token has expired")
+	ErrTokenNotYetValid = errors.New("token is not valid yet")
+	ErrInvalidIssuer    = errors.New("token issuer is not accepted")
+	ErrInvalidAudience  = errors.New("token audience is not accepted")
+	ErrMissingClaim     = errors.New("token is missing a required claim")
+)
+
+// AccessTokenTTL is kept short, the refresh token carries the long-lived login.
+const AccessTokenTTL = 15 * time.Minute
+
+var allowedAlgs = map[string]bool{
+	AlgHS256: true,
+	AlgRS256: true,
+	AlgEdDSA: true,
+}
+
 type Header struct {
 	Alg string `json:"alg"`
 	Typ string `json:"typ"`
+	Kid string `json:"kid,omitempty"`
 }
 
 type Payload struct {
-	Sub  int32  `json:"sub"`
-	Name string `json:"name"`
-	Exp  int64  `json:"exp"`
-	Iat  int64  `json:"iat"`
+	Iss  string   `json:"iss"`
+	Aud  Audience `json:"aud"`
+	Sub  int32    `json:"sub"`
+	Name string   `json:"name"`
+	Sid  int32    `json:"sid"`
+	Exp  int64    `json:"exp"`
+	Nbf  int64    `json:"nbf"`
+	Iat  int64    `json:"iat"`
+}
+
+// Audience accepts both the single string and the array form of "aud".
+type Audience []string
+
+func (a Audience) MarshalJSON() ([]byte, error) {
+	if len(a) == 1 {
+		return json.Marshal(a[0])
+	}
+	return json.Marshal([]string(a))
+}
+
+func (a *Audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = Audience{single}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return err
+	}
+	*a = many
+	return nil
+}
+
+func (a Audience) Contains(audience string) bool {
+	for _, aud := range a {
+		if aud == audience {
+			return true
+		}
+	}
+	return false
+}
+
+type TokenConfig struct {
+	Issuer   string
+	Audience string
+	Leeway   time.Duration
+	// ActiveKeyID selects the key new tokens are signed with. Every other key
+	// in Keys is still accepted for verification so secrets can be rotated.
+	ActiveKeyID string
+	Keys        map[string]SigningKey
+}
+
+type TokenService struct {
+	issuer      string
+	audience    string
+	leeway      time.Duration
+	activeKeyID string
+	keys        map[string]SigningKey
+	now         func() time.Time
 }
 
-func GenerateJWTToken(id int32, name string) (string, error) {
+func NewTokenService(cfg TokenConfig) (*TokenService, error) {
+	if cfg.Issuer == "" || cfg.Audience == "" {
+		return nil, errors.New("token issuer and audience must be set")
+	}
+	if cfg.Leeway < 0 {
+		return nil, errors.New("token leeway must not be negative")
+	}
+	if len(cfg.Keys) == 0 {
+		return nil, errors.New("at least one signing key is required")
+	}
+	for kid, key := range cfg.Keys {
+		if kid == "" {
+			return nil, errors.New("signing key id must not be empty")
+		}
+		if !allowedAlgs[key.Alg()] {
+			return nil, fmt.Errorf("signing key %q uses unsupported algorithm %s", kid, key.Alg())
+		}
+	}
+	active, ok := cfg.Keys[cfg.ActiveKeyID]
+	if !ok {
+		return nil, fmt.Errorf("active signing key %q is not configured", cfg.ActiveKeyID)
+	}
+	if !active.CanSign() {
+		return nil, fmt.Errorf("active signing key %q has no private key", cfg.ActiveKeyID)
+	}
+
+	return &TokenService{
+		issuer:      cfg.Issuer,
+		audience:    cfg.Audience,
+		leeway:      cfg.Leeway,
+		activeKeyID: cfg.ActiveKeyID,
+		keys:        cfg.Keys,
+		now:         time.Now,
+	}, nil
+}
+
+// KeySettings describe the signing keys as they are configured. Algorithm
+// picks HS256 (Secret) or RS256/EdDSA (PrivateKeyFile) for the active key.
+// PreviousSecrets and PublicKeyFiles hold retired keys by kid, which are
+// still accepted until their tokens expire.
+type KeySettings struct {
+	Algorithm       string
+	Secret          string
+	PrivateKeyFile  string
+	KeyID           string
+	Issuer          string
+	Audience        string
+	Leeway          time.Duration
+	PreviousSecrets map[string]string
+	PublicKeyFiles  map[string]string
+}
+
+// NewTokenServiceFromSettings loads the keys the settings point at and
+// builds a TokenService from them.
+func NewTokenServiceFromSettings(s KeySettings) (*TokenService, error) {
+	cfg := TokenConfig{
+		Issuer:      s.Issuer,
+		Audience:    s.Audience,
+		Leeway:      s.Leeway,
+		ActiveKeyID: s.KeyID,
+		Keys:        map[string]SigningKey{},
+	}
+
+	active, err := activeKey(s)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Keys[cfg.ActiveKeyID] = active
+
+	err = addKeys(cfg.Keys, "previous secret", s.PreviousSecrets, func(secret string) (SigningKey, error) {
+		return NewHMACKey([]byte(secret))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = addKeys(cfg.Keys, "public key file", s.PublicKeyFiles, func(path string) (SigningKey, error) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return ParsePublicKeyPEM(data)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return NewTokenService(cfg)
+}
+
+func activeKey(s KeySettings) (SigningKey, error) {
+	switch s.Algorithm {
+	case AlgHS256:
+		if s.Secret == "" {
+			return nil, errors.New("a secret must be set for HS256")
+		}
+		return NewHMACKey([]byte(s.Secret))
+	case AlgRS256, AlgEdDSA:
+		if s.PrivateKeyFile == "" {
+			return nil, fmt.Errorf("a private key file must be set for %s", s.Algorithm)
+		}
+		data, err := os.ReadFile(s.PrivateKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		key, err := ParsePrivateKeyPEM(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid private key file: %w", err)
+		}
+		if key.Alg() != s.Algorithm {
+			return nil, fmt.Errorf("the private key file holds a %s key but the algorithm is %s", key.Alg(), s.Algorithm)
+		}
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q", s.Algorithm)
+	}
+}
+
+func addKeys(keys map[string]SigningKey, kind string, values map[string]string, load func(string) (SigningKey, error)) error {
+	for kid, value := range values {
+		if _, exists := keys[kid]; exists {
+			return fmt.Errorf("signing key %q is configured twice", kid)
+		}
+		key, err := load(value)
+		if err != nil {
+			return fmt.Errorf("invalid %s %q: %w", kind, kid, err)
+		}
+		keys[kid] = key
+	}
+	return nil
+}
+
+func (s *TokenService) GenerateAccessToken(id int32, name string, sessionID int32) (string, error) {
+	now := s.now()
+	key := s.keys[s.activeKeyID]
 	header := Header{
-		Alg: "HS256",
+		Alg: key.Alg(),
 		Typ: "JWT",
+		Kid: s.activeKeyID,
 	}
 	payload := Payload{
+		Iss:  s.issuer,
+		Aud:  Audience{s.audience},
 		Sub:  id,
 		Name: name,
-		Exp:  time.Now().Add(24 * time.Hour).Unix(),
-		Iat:  time.Now().Unix(),
+		Sid:  sessionID,
+		Exp:  now.Add(AccessTokenTTL).Unix(),
+		Nbf:  now.Unix(),
+		Iat:  now.Unix(),
 	}
 
 	headerJSON, err := json.Marshal(header)
@@ -48,91 +264,87 @@ func GenerateJWTToken(id int32, name string) (string, error) {
 	payloadEncoded := base64.RawURLEncoding.EncodeToString(payloadJSON)
 
 	signingInput := headerEncoded + "." + payloadEncoded
-	signature, err := generateSignature(signingInput, os.Getenv("JWT_SECRET"))
+	signature, err := key.Sign([]byte(signingInput))
 	if err != nil {
 		return "", err
 	}
 
-	token := signingInput + "." + signature
-	return token, nil
-}
-
-func generateSignature(signingInput, secretKey string) (string, error) {
-	h := hmac.New(sha256.New, []byte(secretKey))
-	_, err := h.Write([]byte(signingInput))
-	if err != nil {
-		return "", err
-	}
-	signature := h.Sum(nil)
-	return base64.RawURLEncoding.EncodeToString(signature), nil
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
 }
 
-func VerifyToken(token string) error {
+// VerifyToken checks the signature and every registered claim and returns the
+// payload only when the token is fully trusted.
+func (s *TokenService) VerifyToken(token string) (Payload, error) {
 	parts := strings.Split(token, ".")
 	if len(parts) != 3 {
-		return errors.New("invalid token format: token should have 3 parts")
+		return Payload{}, ErrMalformedToken
 	}
 
-	headerEncoded := parts[0]
-	payloadEncoded := parts[1]
-	providedSignature := parts[2]
-
-	signingInput := headerEncoded + "." + payloadEncoded
-	expectedSignature, err := generateSignature(signingInput, os.Getenv("JWT_SECRET"))
-	if err != nil {
-		return err
+	var header Header
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return Payload{}, ErrMalformedToken
 	}
-
-	if providedSignature != expectedSignature {
-		return errors.New("invalid token signature: token verification failed")
+	if !allowedAlgs[header.Alg] {
+		return Payload{}, ErrUnsupportedAlg
 	}
 
-	return CheckTokenExpiration(payloadEncoded)
-}
+	kid := header.Kid
+	if kid == "" {
+		kid = s.activeKeyID
+	}
+	key, ok := s.keys[kid]
+	if !ok {
+		return Payload{}, ErrUnknownKey
+	}
+	// The key decides the algorithm, never the token. This stops a token from
+	// claiming HS256 and being checked against an RSA public key as a secret.
+	if key.Alg() != header.Alg {
+		return Payload{}, ErrUnsupportedAlg
+	}
 
-func CheckTokenExpiration(payloadEncoded string) error {
-	var payload Payload
-	decodedPayload, err := base64.RawURLEncoding.DecodeString(payloadEncoded)
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
 	if err != nil {
-		return err
+		return Payload{}, ErrInvalidSignature
 	}
-	err = json.Unmarshal(decodedPayload, &payload)
-	if err != nil {
-		return err
+	if !key.Verify([]byte(parts[0]+"."+parts[1]), signature) {
+		return Payload{}, ErrInvalidSignature
 	}
 
-	if time.Now().Unix() > payload.Exp {
-		return errors.New("token has expired")
+	var payload Payload
+	if err := decodeSegment(parts[1], &payload); err != nil {
+		return Payload{}, ErrMalformedToken
 	}
 
-	return nil
+	if err := s.validateClaims(payload); err != nil {
+		return Payload{}, err
+	}
+	return payload, nil
 }
 
-func ExtractSubFromToken(token string) (int32, error) {
-	parts := strings.Split(token, ".")
-	if len(parts) != 3 {
-		return 0, errors.New("invalid token format: token should have 3 parts")
+func (s *TokenService) validateClaims(payload Payload) error {
+	now := s.now()
+	if payload.Sub == 0 || payload.Sid == 0 || payload.Exp == 0 {
+		return ErrMissingClaim
 	}
-
-	payloadEncoded := parts[1]
-	var payload Payload
-	decodedPayload, err := base64.RawURLEncoding.DecodeString(payloadEncoded)
-	if err != nil {
-		return 0, err
+	if now.After(time.Unix(payload.Exp, 0).Add(s.leeway)) {
+		return ErrTokenExpired
 	}
-
-	err = json.Unmarshal(decodedPayload, &payload)
-	if err != nil {
-		return 0, err
+	if payload.Nbf != 0 && now.Add(s.leeway).Before(time.Unix(payload.Nbf, 0)) {
+		return ErrTokenNotYetValid
 	}
-
-	if payload.Sub == 0 {
-		return 0, errors.New("token does not contain a subject claim")
+	if payload.Iss != s.issuer {
+		return ErrInvalidIssuer
 	}
+	if !payload.Aud.Contains(s.audience) {
+		return ErrInvalidAudience
+	}
+	return nil
+}
 
+func decodeSegment(segment string, v interface{}) error {
+	decoded, err := base64.RawURLEncoding.DecodeString(segment)
 	if err != nil {
-		return 0, errors.New("failed to parse subject as int32: " + err.Error())
+		return err
 	}
-
-	return payload.Sub, nil
+	return json.Unmarshal(decoded, v)
 }
@@ -0,0 +1,187 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSecret = "0123456789abcdef0123456789abcdef"
+
+var testNow = time.Unix(1_700_000_000, 0)
+
+func newTestService(t *testing.T, keys map[string]SigningKey, activeKeyID string) *TokenService {
+	t.Helper()
+	s, err := NewTokenService(TokenConfig{
+		Issuer:      "scheduling",
+		Audience:    "scheduling-api",
+		Leeway:      30 * time.Second,
+		ActiveKeyID: activeKeyID,
+		Keys:        keys,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.now = func() time.Time { return testNow }
+	return s
+}
+
+// signToken builds a token from any header and payload, so tests can forge
+// what GenerateAccessToken would never produce.
+func signToken(t *testing.T, header Header, payload Payload, key SigningKey) string {
+	t.Helper()
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payloadJSON, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := base64.RawURLEncoding.EncodeToString(headerJSON) + "." + base64.RawURLEncoding.EncodeToString(payloadJSON)
+	if key == nil {
+		return input + "."
+	}
+	signature, err := key.Sign([]byte(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func newEd25519Key(t *testing.T) SigningKey {
+	t.Helper()
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ed25519Key{private: private, public: public}
+}
+
+func validPayload() Payload {
+	return Payload{
+		Iss: "scheduling",
+		Aud: Audience{"scheduling-api"},
+		Sub: 7,
+		Sid: 3,
+		Exp: testNow.Add(AccessTokenTTL).Unix(),
+		Nbf: testNow.Unix(),
+		Iat: testNow.Unix(),
+	}
+}
+
+func TestVerifyToken(t *testing.T) {
+	hmac, err := NewHMACKey([]byte(testSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherHMAC, err := NewHMACKey([]byte(strings.Repeat("x", MinHMACSecretLength)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ed := newEd25519Key(t)
+	s := newTestService(t, map[string]SigningKey{"current": hmac, "ed": ed}, "current")
+
+	header := Header{Alg: AlgHS256, Typ: "JWT", Kid: "current"}
+	with := func(change func(*Payload)) Payload {
+		p := validPayload()
+		change(&p)
+		return p
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{"valid", signToken(t, header, validPayload(), hmac), nil},
+		{"no kid uses the active key", signToken(t, Header{Alg: AlgHS256, Typ: "JWT"}, validPayload(), hmac), nil},
+		{"audience array", signToken(t, header, with(func(p *Payload) { p.Aud = Audience{"other", "scheduling-api"} }), hmac), nil},
+		{"malformed", "not.a-token", ErrMalformedToken},
+		{"alg none", signToken(t, Header{Alg: "none", Typ: "JWT", Kid: "current"}, validPayload(), nil), ErrUnsupportedAlg},
+		{"alg none with a signature", signToken(t, Header{Alg: "none", Typ: "JWT", Kid: "current"}, validPayload(), hmac), ErrUnsupportedAlg},
+		{"alg does not match the key", signToken(t, Header{Alg: AlgEdDSA, Typ: "JWT", Kid: "current"}, validPayload(), ed), ErrUnsupportedAlg},
+		{"HS256 against an asymmetric key", signToken(t, Header{Alg: AlgHS256, Typ: "JWT", Kid: "ed"}, validPayload(), hmac), ErrUnsupportedAlg},
+		{"unknown kid", signToken(t, Header{Alg: AlgHS256, Typ: "JWT", Kid: "retired"}, validPayload(), hmac), ErrUnknownKey},
+		{"signed with another secret", signToken(t, header, validPayload(), otherHMAC), ErrInvalidSignature},
+		{"wrong issuer", signToken(t, header, with(func(p *Payload) { p.Iss = "someone-else" }), hmac), ErrInvalidIssuer},
+		{"wrong audience", signToken(t, header, with(func(p *Payload) { p.Aud = Audience{"other"} }), hmac), ErrInvalidAudience},
+		{"missing subject", signToken(t, header, with(func(p *Payload) { p.Sub = 0 }), hmac), ErrMissingClaim},
+		{"missing session", signToken(t, header, with(func(p *Payload) { p.Sid = 0 }), hmac), ErrMissingClaim},
+		{"missing expiry", signToken(t, header, with(func(p *Payload) { p.Exp = 0 }), hmac), ErrMissingClaim},
+		{"expired within leeway", signToken(t, header, with(func(p *Payload) { p.Exp = testNow.Add(-20 * time.Second).Unix() }), hmac), nil},
+		{"expired beyond leeway", signToken(t, header, with(func(p *Payload) { p.Exp = testNow.Add(-time.Minute).Unix() }), hmac), ErrTokenExpired},
+		{"not yet valid within leeway", signToken(t, header, with(func(p *Payload) { p.Nbf = testNow.Add(20 * time.Second).Unix() }), hmac), nil},
+		{"not yet valid beyond leeway", signToken(t, header, with(func(p *Payload) { p.Nbf = testNow.Add(time.Minute).Unix() }), hmac), ErrTokenNotYetValid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := s.VerifyToken(tt.token)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("VerifyToken() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyTokenTampered(t *testing.T) {
+	hmac, err := NewHMACKey([]byte(testSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newTestService(t, map[string]SigningKey{"current": hmac}, "current")
+	token := signToken(t, Header{Alg: AlgHS256, Typ: "JWT", Kid: "current"}, validPayload(), hmac)
+	parts := strings.Split(token, ".")
+
+	forged := validPayload()
+	forged.Sub = 1
+	forgedJSON, err := json.Marshal(forged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature[0] ^= 1
+
+	for name, token := range map[string]string{
+		"payload":   parts[0] + "." + base64.RawURLEncoding.EncodeToString(forgedJSON) + "." + parts[2],
+		"signature": parts[0] + "." + parts[1] + "." + base64.RawURLEncoding.EncodeToString(signature),
+		"stripped":  parts[0] + "." + parts[1] + ".",
+	} {
+		if _, err := s.VerifyToken(token); !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("tampered %s: error = %v, want %v", name, err, ErrInvalidSignature)
+		}
+	}
+}
+
+func TestValidateClaimsLeeway(t *testing.T) {
+	hmac, err := NewHMACKey([]byte(testSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newTestService(t, map[string]SigningKey{"current": hmac}, "current")
+
+	// The leeway is inclusive: a token is still accepted on its last second.
+	p := validPayload()
+	p.Exp = testNow.Add(-30 * time.Second).Unix()
+	if err := s.validateClaims(p); err != nil {
+		t.Errorf("token expiring exactly at the leeway: %v", err)
+	}
+	p.Exp = testNow.Add(-31 * time.Second).Unix()
+	if err := s.validateClaims(p); !errors.Is(err, ErrTokenExpired) {
+		t.Errorf("token past the leeway: error = %v, want %v", err, ErrTokenExpired)
+	}
+
+	p = validPayload()
+	p.Nbf = 0
+	if err := s.validateClaims(p); err != nil {
+		t.Errorf("nbf is optional: %v", err)
+	}
+}
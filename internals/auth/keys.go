@@ -0,0 +1,183 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+)
+
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+)
+
+const minRSAKeyBits = 2048
+
+// MinHMACSecretLength is the shortest HS256 secret NewHMACKey accepts.
+const MinHMACSecretLength = 32
+
+// SigningKey is one entry of the token key set. Keys without a private half
+// can only verify, which is how retired or externally held keys are loaded.
+type SigningKey interface {
+	Alg() string
+	CanSign() bool
+	Sign(signingInput []byte) ([]byte, error)
+	Verify(signingInput, signature []byte) bool
+	// PublicJWK returns the key in JWK form, or false for symmetric keys that
+	// must never be published.
+	PublicJWK(kid string) (JWK, bool)
+}
+
+type hmacKey struct {
+	secret []byte
+}
+
+func NewHMACKey(secret []byte) (SigningKey, error) {
+	if len(secret) < MinHMACSecretLength {
+		return nil, fmt.Errorf("HMAC secret must be at least %d bytes", MinHMACSecretLength)
+	}
+	return hmacKey{secret: secret}, nil
+}
+
+func (k hmacKey) Alg() string   { return AlgHS256 }
+func (k hmacKey) CanSign() bool { return true }
+
+func (k hmacKey) Sign(signingInput []byte) ([]byte, error) {
+	h := hmac.New(sha256.New, k.secret)
+	h.Write(signingInput)
+	return h.Sum(nil), nil
+}
+
+func (k hmacKey) Verify(signingInput, signature []byte) bool {
+	expected, _ := k.Sign(signingInput)
+	return hmac.Equal(signature, expected)
+}
+
+func (k hmacKey) PublicJWK(kid string) (JWK, bool) {
+	return JWK{}, false
+}
+
+type rsaKey struct {
+	private *rsa.PrivateKey
+	public  *rsa.PublicKey
+}
+
+func (k rsaKey) Alg() string   { return AlgRS256 }
+func (k rsaKey) CanSign() bool { return k.private != nil }
+
+func (k rsaKey) Sign(signingInput []byte) ([]byte, error) {
+	if k.private == nil {
+		return nil, errors.New("RSA key has no private part")
+	}
+	digest := sha256.Sum256(signingInput)
+	return rsa.SignPKCS1v15(rand.Reader, k.private, crypto.SHA256, digest[:])
+}
+
+func (k rsaKey) Verify(signingInput, signature []byte) bool {
+	digest := sha256.Sum256(signingInput)
+	return rsa.VerifyPKCS1v15(k.public, crypto.SHA256, digest[:], signature) == nil
+}
+
+func (k rsaKey) PublicJWK(kid string) (JWK, bool) {
+	return rsaJWK(kid, k.public), true
+}
+
+type ed25519Key struct {
+	private ed25519.PrivateKey
+	public  ed25519.PublicKey
+}
+
+func (k ed25519Key) Alg() string   { return AlgEdDSA }
+func (k ed25519Key) CanSign() bool { return k.private != nil }
+
+func (k ed25519Key) Sign(signingInput []byte) ([]byte, error) {
+	if k.private == nil {
+		return nil, errors.New("Ed25519 key has no private part")
+	}
+	return ed25519.Sign(k.private, signingInput), nil
+}
+
+func (k ed25519Key) Verify(signingInput, signature []byte) bool {
+	return ed25519.Verify(k.public, signingInput, signature)
+}
+
+func (k ed25519Key) PublicJWK(kid string) (JWK, bool) {
+	return ed25519JWK(kid, k.public), true
+}
+
+// ParsePrivateKeyPEM loads an RSA (PKCS#1 or PKCS#8) or Ed25519 (PKCS#8)
+// private key that can sign tokens.
+func ParsePrivateKeyPEM(data []byte) (SigningKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	var parsed interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch key := parsed.(type) {
+	case *rsa.PrivateKey:
+		if key.N.BitLen() < minRSAKeyBits {
+			return nil, fmt.Errorf("RSA key must be at least %d bits", minRSAKeyBits)
+		}
+		return rsaKey{private: key, public: &key.PublicKey}, nil
+	case ed25519.PrivateKey:
+		return ed25519Key{private: key, public: key.Public().(ed25519.PublicKey)}, nil
+	default:
+		return nil, fmt.Errorf("unsupported private key type %T", parsed)
+	}
+}
+
+// ParsePublicKeyPEM loads a verification-only RSA or Ed25519 public key.
+func ParsePublicKeyPEM(data []byte) (SigningKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	var parsed interface{}
+	var err error
+	switch block.Type {
+	case "RSA PUBLIC KEY":
+		parsed, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch key := parsed.(type) {
+	case *rsa.PublicKey:
+		if key.N.BitLen() < minRSAKeyBits {
+			return nil, fmt.Errorf("RSA key must be at least %d bits", minRSAKeyBits)
+		}
+		return rsaKey{public: key}, nil
+	case ed25519.PublicKey:
+		return ed25519Key{public: key}, nil
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", parsed)
+	}
+}
@@ -0,0 +1,275 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func encodePEM(t *testing.T, blockType string, der []byte) []byte {
+	t.Helper()
+	return pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+}
+
+func pkcs8(t *testing.T, key interface{}) []byte {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+func pkix(t *testing.T, key interface{}) []byte {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+func TestParseKeyPEM(t *testing.T) {
+	rsaPrivate, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	weakRSA, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPublic, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	private := []struct {
+		name    string
+		pem     []byte
+		wantAlg string
+	}{
+		{"RSA PKCS#1", encodePEM(t, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaPrivate)), AlgRS256},
+		{"RSA PKCS#8", encodePEM(t, "PRIVATE KEY", pkcs8(t, rsaPrivate)), AlgRS256},
+		{"Ed25519 PKCS#8", encodePEM(t, "PRIVATE KEY", pkcs8(t, edPrivate)), AlgEdDSA},
+		{"RSA under 2048 bits", encodePEM(t, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(weakRSA)), ""},
+		{"public key block", encodePEM(t, "PUBLIC KEY", pkix(t, edPublic)), ""},
+		{"not PEM", []byte("-----BEGIN nothing"), ""},
+	}
+	for _, tt := range private {
+		t.Run("private "+tt.name, func(t *testing.T) {
+			key, err := ParsePrivateKeyPEM(tt.pem)
+			if tt.wantAlg == "" {
+				if err == nil {
+					t.Fatal("ParsePrivateKeyPEM() accepted the key")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if key.Alg() != tt.wantAlg || !key.CanSign() {
+				t.Errorf("got a %s key that can sign: %v", key.Alg(), key.CanSign())
+			}
+		})
+	}
+
+	public := []struct {
+		name    string
+		pem     []byte
+		wantAlg string
+	}{
+		{"RSA PKCS#1", encodePEM(t, "RSA PUBLIC KEY", x509.MarshalPKCS1PublicKey(&rsaPrivate.PublicKey)), AlgRS256},
+		{"RSA PKIX", encodePEM(t, "PUBLIC KEY", pkix(t, &rsaPrivate.PublicKey)), AlgRS256},
+		{"Ed25519 PKIX", encodePEM(t, "PUBLIC KEY", pkix(t, edPublic)), AlgEdDSA},
+		{"RSA under 2048 bits", encodePEM(t, "PUBLIC KEY", pkix(t, &weakRSA.PublicKey)), ""},
+		{"private key block", encodePEM(t, "PRIVATE KEY", pkcs8(t, edPrivate)), ""},
+	}
+	for _, tt := range public {
+		t.Run("public "+tt.name, func(t *testing.T) {
+			key, err := ParsePublicKeyPEM(tt.pem)
+			if tt.wantAlg == "" {
+				if err == nil {
+					t.Fatal("ParsePublicKeyPEM() accepted the key")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if key.Alg() != tt.wantAlg || key.CanSign() {
+				t.Errorf("got a %s key that can sign: %v", key.Alg(), key.CanSign())
+			}
+		})
+	}
+}
+
+func TestSignVerifyRoundTrip(t *testing.T) {
+	rsaPrivate, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, private := range []interface{}{rsaPrivate, edPrivate} {
+		key, err := ParsePrivateKeyPEM(encodePEM(t, "PRIVATE KEY", pkcs8(t, private)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Run(key.Alg(), func(t *testing.T) {
+			s := newTestService(t, map[string]SigningKey{"current": key}, "current")
+			token, err := s.GenerateAccessToken(7, "alice", 3)
+			if err != nil {
+				t.Fatal(err)
+			}
+			payload, err := s.VerifyToken(token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if payload.Sub != 7 || payload.Name != "alice" || payload.Sid != 3 {
+				t.Errorf("payload = %+v", payload)
+			}
+
+			// Anyone holding only the published key can check the token too.
+			jwk, ok := key.PublicJWK("current")
+			if !ok {
+				t.Fatal("asymmetric key has no JWK")
+			}
+			public, err := ParseJWK(jwk)
+			if err != nil {
+				t.Fatal(err)
+			}
+			parts := strings.Split(token, ".")
+			signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !public.Verify([]byte(parts[0]+"."+parts[1]), signature) {
+				t.Error("the JWK does not verify the token")
+			}
+			if public.Verify([]byte(parts[0]+"."+parts[0]), signature) {
+				t.Error("the JWK verifies the signature over other input")
+			}
+		})
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	dir := t.TempDir()
+	writeKey := func(name string, data []byte) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	oldPublic, oldPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, newPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	settings := KeySettings{
+		Algorithm:      AlgEdDSA,
+		PrivateKeyFile: writeKey("old.pem", encodePEM(t, "PRIVATE KEY", pkcs8(t, oldPrivate))),
+		KeyID:          "2024-01",
+		Issuer:         "scheduling",
+		Audience:       "scheduling-api",
+	}
+	before, err := NewTokenServiceFromSettings(settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldToken, err := before.GenerateAccessToken(7, "alice", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Rotate: a new active key, the old one kept by its public half.
+	settings.PrivateKeyFile = writeKey("new.pem", encodePEM(t, "PRIVATE KEY", pkcs8(t, newPrivate)))
+	settings.KeyID = "2024-06"
+	settings.PublicKeyFiles = map[string]string{"2024-01": writeKey("old.pub", encodePEM(t, "PUBLIC KEY", pkix(t, oldPublic)))}
+	after, err := NewTokenServiceFromSettings(settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := after.VerifyToken(oldToken); err != nil {
+		t.Errorf("token signed before the rotation: %v", err)
+	}
+	newToken, err := after.GenerateAccessToken(7, "alice", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := after.VerifyToken(newToken); err != nil {
+		t.Errorf("token signed after the rotation: %v", err)
+	}
+	if _, err := before.VerifyToken(newToken); err != ErrUnknownKey {
+		t.Errorf("old service verifying a new token: error = %v, want %v", err, ErrUnknownKey)
+	}
+
+	// Once the old key is dropped its tokens stop working.
+	settings.PublicKeyFiles = nil
+	dropped, err := NewTokenServiceFromSettings(settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dropped.VerifyToken(oldToken); err != ErrUnknownKey {
+		t.Errorf("token of a dropped key: error = %v, want %v", err, ErrUnknownKey)
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	hmac, err := NewHMACKey([]byte(testSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaPrivate, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edKey := newEd25519Key(t)
+	s := newTestService(t, map[string]SigningKey{
+		"b-rsa":    rsaKey{private: rsaPrivate, public: &rsaPrivate.PublicKey},
+		"a-ed":     edKey,
+		"c-secret": hmac,
+	}, "a-ed")
+
+	set := s.JWKS()
+	if len(set.Keys) != 2 {
+		t.Fatalf("JWKS has %d keys, want 2 without the HMAC secret", len(set.Keys))
+	}
+	ed, rs := set.Keys[0], set.Keys[1]
+	if ed.Kid != "a-ed" || ed.Kty != "OKP" || ed.Crv != "Ed25519" || ed.Alg != AlgEdDSA || ed.Use != "sig" || ed.X == "" {
+		t.Errorf("Ed25519 JWK = %+v", ed)
+	}
+	// 65537 is AQAB in base64url.
+	if rs.Kid != "b-rsa" || rs.Kty != "RSA" || rs.Alg != AlgRS256 || rs.E != "AQAB" || rs.N == "" {
+		t.Errorf("RSA JWK = %+v", rs)
+	}
+
+	for _, jwk := range set.Keys {
+		key, err := ParseJWK(jwk)
+		if err != nil {
+			t.Fatalf("ParseJWK(%s): %v", jwk.Kid, err)
+		}
+		if key.CanSign() {
+			t.Errorf("key parsed from JWK %s can sign", jwk.Kid)
+		}
+	}
+	if _, err := ParseJWK(JWK{Kty: "oct"}); err == nil {
+		t.Error("ParseJWK() accepted a symmetric key")
+	}
+}
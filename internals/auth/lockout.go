@@ -0,0 +1,61 @@
+package auth
+
+import "time"
+
+// LockoutPolicy decides how long to wait after repeated failed logins. The
+// first FreeAttempts failures cost nothing, after that the wait doubles from
+// BaseDelay up to MaxDelay. Reaching LockoutThreshold locks for
+// LockoutDuration instead; a threshold of zero disables the hard lockout.
+type LockoutPolicy struct {
+	FreeAttempts     int
+	BaseDelay        time.Duration
+	MaxDelay         time.Duration
+	LockoutThreshold int
+	LockoutDuration  time.Duration
+}
+
+// DefaultAccountLockout applies to failed logins against a single account.
+var DefaultAccountLockout = LockoutPolicy{
+	FreeAttempts:     3,
+	BaseDelay:        time.Second,
+	MaxDelay:         5 * time.Minute,
+	LockoutThreshold: 10,
+	LockoutDuration:  time.Hour,
+}
+
+// DefaultIPThrottle applies to failed logins from one address across all
+// accounts, so it is more lenient to allow for shared NAT addresses.
+var DefaultIPThrottle = LockoutPolicy{
+	FreeAttempts: 10,
+	BaseDelay:    time.Second,
+	MaxDelay:     15 * time.Minute,
+}
+
+// Delay returns the backoff after the given number of consecutive failures.
+func (p LockoutPolicy) Delay(failures int) time.Duration {
+	if failures < p.FreeAttempts {
+		return 0
+	}
+
+	delay := p.BaseDelay
+	for i := p.FreeAttempts; i < failures && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	return delay
+}
+
+// Locked reports whether failures has reached the hard lockout.
+func (p LockoutPolicy) Locked(failures int) bool {
+	return p.LockoutThreshold > 0 && failures >= p.LockoutThreshold
+}
+
+// BlockedUntil returns when the next attempt is allowed after the last failure.
+func (p LockoutPolicy) BlockedUntil(failures int, lastFailure time.Time) time.Time {
+	if p.Locked(failures) {
+		return lastFailure.Add(p.LockoutDuration)
+	}
+	return lastFailure.Add(p.Delay(failures))
+}
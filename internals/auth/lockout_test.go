@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLockoutDelay(t *testing.T) {
+	p := LockoutPolicy{FreeAttempts: 3, BaseDelay: time.Second, MaxDelay: 10 * time.Second}
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{2, 0},
+		{3, time.Second},
+		{4, 2 * time.Second},
+		{5, 4 * time.Second},
+		{6, 8 * time.Second},
+		{7, 10 * time.Second},
+		{100, 10 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := p.Delay(tt.failures); got != tt.want {
+			t.Errorf("Delay(%d) = %s, want %s", tt.failures, got, tt.want)
+		}
+	}
+
+	// Large counts must not overflow into a negative wait.
+	if got := DefaultIPThrottle.Delay(1 << 20); got != DefaultIPThrottle.MaxDelay {
+		t.Errorf("Delay after a million failures = %s, want %s", got, DefaultIPThrottle.MaxDelay)
+	}
+}
+
+func TestLockoutBlockedUntil(t *testing.T) {
+	last := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	p := DefaultAccountLockout
+	tests := []struct {
+		failures int
+		want     time.Time
+		locked   bool
+	}{
+		{2, last, false},
+		{3, last.Add(time.Second), false},
+		{9, last.Add(64 * time.Second), false},
+		{10, last.Add(time.Hour), true},
+		{25, last.Add(time.Hour), true},
+	}
+	for _, tt := range tests {
+		if got := p.BlockedUntil(tt.failures, last); !got.Equal(tt.want) {
+			t.Errorf("BlockedUntil(%d) = %s, want %s", tt.failures, got, tt.want)
+		}
+		if got := p.Locked(tt.failures); got != tt.locked {
+			t.Errorf("Locked(%d) = %v, want %v", tt.failures, got, tt.locked)
+		}
+	}
+
+	// Without a threshold an address is only ever slowed down.
+	if DefaultIPThrottle.Locked(1000) {
+		t.Error("the IP throttle locks")
+	}
+}
@@ -0,0 +1,37 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"time"
+)
+
+// RefreshTokenTTL is how long a session stays alive without being refreshed.
+const RefreshTokenTTL = 30 * 24 * time.Hour
+
+// GenerateOpaqueToken returns a random token for the client and the hash that
+// is stored in the database. Only the hash is ever persisted.
+func GenerateOpaqueToken() (string, string, error) {
+	token, err := RandomString(32)
+	if err != nil {
+		return "", "", err
+	}
+	return token, HashToken(token), nil
+}
+
+// RandomString returns n random bytes encoded as unpadded base64url.
+func RandomString(n int) (string, error) {
+	buf := make([]byte, n)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,385 @@
+// Package config loads the server configuration. Every setting has a key
+// used in config files and as a flag name, and most have an environment
+// variable. Values are applied in this order, later ones win: the defaults
+// of the APP_ENV profile, the config file, .env files, the environment and
+// the command line flags. Load rejects anything invalid or missing so the
+// server never starts with a half working setup.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/joseph-gunnarsson/scheduling/internals/auth"
+)
+
+// Profile is the environment the server runs in, set with APP_ENV.
+type Profile string
+
+const (
+	Development Profile = "development"
+	Test        Profile = "test"
+	Production  Profile = "production"
+)
+
+type Config struct {
+	Env       Profile
+	Server    Server    `key:"server"`
+	Database  Database  `key:"database"`
+	Retention Retention `key:"retention"`
+	JWT       JWT       `key:"jwt"`
+	OIDC      OIDC      `key:"oidc"`
+	Mail      Mail      `key:"mail"`
+	Log       Log       `key:"log"`
+	Tracing   Tracing   `key:"tracing"`
+}
+
+type Server struct {
+	Addr                 string        `key:"addr" env:"LISTEN_ADDR" help:"address the API listens on"`
+	PublicURL            string        `key:"public_url" env:"PUBLIC_URL" help:"base URL used in email links"`
+	UnverifiedUserAccess string        `key:"unverified_user_access" env:"UNVERIFIED_USER_ACCESS" help:"what users with an unverified email may do: allow, read_only or deny"`
+	IdempotencyKeyTTL    time.Duration `key:"idempotency_key_ttl" env:"IDEMPOTENCY_KEY_TTL" help:"how long responses to Idempotency-Key requests are kept"`
+	ReadTimeout          time.Duration `key:"read_timeout" env:"HTTP_READ_TIMEOUT" help:"longest time to read a request, headers and body"`
+	WriteTimeout         time.Duration `key:"write_timeout" env:"HTTP_WRITE_TIMEOUT" help:"longest time from the end of the request headers to the end of the response"`
+	IdleTimeout          time.Duration `key:"idle_timeout" env:"HTTP_IDLE_TIMEOUT" help:"how long an idle keep-alive connection stays open"`
+	ShutdownDelay        time.Duration `key:"shutdown_delay" env:"SHUTDOWN_DELAY" help:"how long /readyz fails before the listener closes on SIGTERM"`
+	ShutdownTimeout      time.Duration `key:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT" help:"how long in flight requests get to finish on SIGTERM"`
+	MetricsToken         string        `key:"metrics_token" env:"METRICS_TOKEN" secret:"true" help:"bearer token required to scrape /metrics, open when empty outside production"`
+	TrustedProxies       []string      `key:"trusted_proxies" env:"TRUSTED_PROXIES" help:"space separated proxy addresses or CIDR ranges whose X-Forwarded-For is believed"`
+}
+
+type Database struct {
+	URL             string        `key:"url" env:"POSTGRES_URL" help:"PostgreSQL connection URL"`
+	MaxConns        int           `key:"max_conns" env:"DB_MAX_CONNS" help:"most connections open at once, 0 keeps the pgxpool default"`
+	MinConns        int           `key:"min_conns" env:"DB_MIN_CONNS" help:"connections kept open even when idle"`
+	MaxConnLifetime time.Duration `key:"max_conn_lifetime" env:"DB_MAX_CONN_LIFETIME" help:"connections are replaced after this long, 0 keeps the default"`
+	MaxConnIdleTime time.Duration `key:"max_conn_idle_time" env:"DB_MAX_CONN_IDLE_TIME" help:"idle connections are closed after this long, 0 keeps the default"`
+}
+
+type Retention struct {
+	Window time.Duration `key:"window" env:"SOFT_DELETE_RETENTION" help:"how long deleted groups and shifts can be restored"`
+}
+
+type JWT struct {
+	Algorithm       string            `key:"algorithm" env:"JWT_ALGORITHM" help:"HS256, RS256 or EdDSA"`
+	Secret          string            `key:"secret" env:"JWT_SECRET" secret:"true" help:"HS256 signing secret"`
+	PrivateKeyFile  string            `key:"private_key_file" env:"JWT_PRIVATE_KEY_FILE" help:"PEM private key for RS256 or EdDSA"`
+	KeyID           string            `key:"key_id" env:"JWT_KEY_ID" help:"kid written into new tokens"`
+	Issuer          string            `key:"issuer" env:"JWT_ISSUER" help:"expected and issued iss claim"`
+	Audience        string            `key:"audience" env:"JWT_AUDIENCE" help:"expected and issued aud claim"`
+	Leeway          time.Duration     `key:"leeway" env:"JWT_LEEWAY" help:"clock skew allowed when checking exp and nbf"`
+	PreviousSecrets map[string]string `key:"previous_secrets" env:"JWT_PREVIOUS_SECRETS" secret:"true" help:"retired HS256 secrets as kid:secret,kid:secret"`
+	PublicKeyFiles  map[string]string `key:"public_key_files" env:"JWT_PUBLIC_KEY_FILES" help:"retired public keys as kid:path,kid:path"`
+}
+
+type OIDC struct {
+	Issuer        string   `key:"issuer" env:"OIDC_ISSUER" help:"OpenID Connect issuer URL, empty disables OIDC login"`
+	ClientID      string   `key:"client_id" env:"OIDC_CLIENT_ID" help:"client id registered with the provider"`
+	ClientSecret  string   `key:"client_secret" env:"OIDC_CLIENT_SECRET" secret:"true" help:"client secret, empty for public clients"`
+	RedirectURL   string   `key:"redirect_url" env:"OIDC_REDIRECT_URL" help:"public URL of GET /auth/oidc/callback/"`
+	Scopes        []string `key:"scopes" env:"OIDC_SCOPES" help:"space separated scopes"`
+	AutoProvision bool     `key:"auto_provision" env:"OIDC_AUTO_PROVISION" help:"create a user on first login"`
+}
+
+type Mail struct {
+	Driver string `key:"driver" env:"MAIL_DRIVER" help:"log, file or smtp"`
+	From   string `key:"from" env:"MAIL_FROM" help:"sender address"`
+	Dir    string `key:"dir" env:"MAIL_DIR" help:"directory the file driver writes to"`
+	SMTP   SMTP   `key:"smtp"`
+}
+
+type Log struct {
+	Level  slog.Level `key:"level" env:"LOG_LEVEL" help:"lowest level logged: debug, info, warn or error"`
+	Format string     `key:"format" env:"LOG_FORMAT" help:"json or text"`
+}
+
+type Tracing struct {
+	Exporter string `key:"exporter" env:"TRACING_EXPORTER" help:"where spans go: none, stdout, file or otlp"`
+	File     string `key:"file" env:"TRACING_FILE" help:"file the file exporter appends spans to"`
+	Endpoint string `key:"endpoint" env:"TRACING_ENDPOINT" help:"OTLP/HTTP traces URL, empty for the OTEL_EXPORTER_OTLP_* variables"`
+}
+
+type SMTP struct {
+	Host     string `key:"host" env:"SMTP_HOST" help:"SMTP server host"`
+	Port     int    `key:"port" env:"SMTP_PORT" help:"SMTP server port"`
+	Username string `key:"username" env:"SMTP_USERNAME" help:"SMTP user"`
+	Password string `key:"password" env:"SMTP_PASSWORD" secret:"true" help:"SMTP password"`
+}
+
+// Defaults returns the settings of a profile before anything is loaded.
+// Production leaves out what has to be chosen for a real deploy.
+func Defaults(env Profile) Config {
+	cfg := Config{
+		Env: env,
+		Server: Server{
+			Addr:                 ":8080",
+			PublicURL:            "http://localhost:8080",
+			UnverifiedUserAccess: "allow",
+			IdempotencyKeyTTL:    24 * time.Hour,
+			ReadTimeout:          15 * time.Second,
+			WriteTimeout:         30 * time.Second,
+			IdleTimeout:          60 * time.Second,
+			ShutdownTimeout:      30 * time.Second,
+		},
+		Retention: Retention{Window: 30 * 24 * time.Hour},
+		JWT: JWT{
+			Algorithm: auth.AlgHS256,
+			KeyID:     "default",
+			Issuer:    "scheduling",
+			Audience:  "scheduling-api",
+			Leeway:    30 * time.Second,
+		},
+		OIDC: OIDC{Scopes: []string{"openid", "email", "profile"}},
+		Mail: Mail{
+			Driver: "log",
+			From:   "no-reply@localhost",
+			SMTP:   SMTP{Port: 587},
+		},
+		Log:     Log{Level: slog.LevelInfo, Format: "json"},
+		Tracing: Tracing{Exporter: "none", File: "traces.jsonl"},
+	}
+	if env == Production {
+		cfg.Server.PublicURL = ""
+		cfg.Mail.Driver = ""
+		// Gives the load balancer time to see /readyz fail before the
+		// listener goes away.
+		cfg.Server.ShutdownDelay = 5 * time.Second
+	}
+	return cfg
+}
+
+// parseProfile has no default, a server that forgot APP_ENV would otherwise
+// run in production with the development defaults.
+func parseProfile(s string) (Profile, error) {
+	switch Profile(s) {
+	case "":
+		return "", errors.New("APP_ENV is not set, use development, test or production")
+	case Development, Test, Production:
+		return Profile(s), nil
+	default:
+		return "", fmt.Errorf("unknown APP_ENV %q, use development, test or production", s)
+	}
+}
+
+// validate checks the named sections, all of them when none are named, and
+// returns every problem it finds.
+func (c *Config) validate(sections []string) []string {
+	checks := map[string]func() []string{
+		"server":    c.Server.validate,
+		"database":  c.Database.validate,
+		"retention": c.Retention.validate,
+		"jwt":       c.JWT.validate,
+		"oidc":      c.OIDC.validate,
+		"mail":      c.Mail.validate,
+		"log":       c.Log.validate,
+		"tracing":   c.Tracing.validate,
+	}
+	if len(sections) == 0 {
+		sections = []string{"server", "database", "retention", "jwt", "oidc", "mail", "log", "tracing"}
+	}
+
+	var problems []string
+	for _, section := range sections {
+		problems = append(problems, checks[section]()...)
+	}
+	if c.Env == Production {
+		problems = append(problems, c.validateProduction(sections)...)
+	}
+	return problems
+}
+
+func (s Server) validate() []string {
+	var problems []string
+	if s.Addr == "" {
+		problems = append(problems, "server.addr must be set")
+	}
+	if err := checkURL(s.PublicURL); err != nil {
+		problems = append(problems, "server.public_url "+err.Error())
+	}
+	switch s.UnverifiedUserAccess {
+	case "allow", "read_only", "deny":
+	default:
+		problems = append(problems, fmt.Sprintf("server.unverified_user_access %q must be allow, read_only or deny", s.UnverifiedUserAccess))
+	}
+	if s.IdempotencyKeyTTL <= 0 {
+		problems = append(problems, "server.idempotency_key_ttl must be positive")
+	}
+	if s.ReadTimeout <= 0 || s.WriteTimeout <= 0 || s.IdleTimeout <= 0 || s.ShutdownTimeout <= 0 {
+		problems = append(problems, "server.read_timeout, server.write_timeout, server.idle_timeout and server.shutdown_timeout must be positive")
+	}
+	if s.ShutdownDelay < 0 {
+		problems = append(problems, "server.shutdown_delay must not be negative")
+	}
+	for _, proxy := range s.TrustedProxies {
+		_, prefixErr := netip.ParsePrefix(proxy)
+		_, addrErr := netip.ParseAddr(proxy)
+		if prefixErr != nil && addrErr != nil {
+			problems = append(problems, fmt.Sprintf("server.trusted_proxies entry %q must be an address or CIDR range", proxy))
+		}
+	}
+	return problems
+}
+
+func (d Database) validate() []string {
+	var problems []string
+	if d.URL == "" {
+		problems = append(problems, "database.url must be set")
+	}
+	if d.MaxConns < 0 || d.MinConns < 0 {
+		problems = append(problems, "database.max_conns and database.min_conns must not be negative")
+	}
+	if d.MaxConns > 0 && d.MinConns > d.MaxConns {
+		problems = append(problems, "database.min_conns can't be larger than database.max_conns")
+	}
+	if d.MaxConnLifetime < 0 || d.MaxConnIdleTime < 0 {
+		problems = append(problems, "database.max_conn_lifetime and database.max_conn_idle_time must not be negative")
+	}
+	return problems
+}
+
+func (r Retention) validate() []string {
+	if r.Window <= 0 {
+		return []string{"retention.window must be positive"}
+	}
+	return nil
+}
+
+func (j JWT) validate() []string {
+	var problems []string
+	switch j.Algorithm {
+	case auth.AlgHS256:
+		if len(j.Secret) < auth.MinHMACSecretLength {
+			problems = append(problems, fmt.Sprintf("jwt.secret must be set to at least %d bytes for HS256", auth.MinHMACSecretLength))
+		}
+	case auth.AlgRS256, auth.AlgEdDSA:
+		if j.PrivateKeyFile == "" {
+			problems = append(problems, "jwt.private_key_file must be set for "+j.Algorithm)
+		} else if _, err := os.Stat(j.PrivateKeyFile); err != nil {
+			problems = append(problems, "jwt.private_key_file "+err.Error())
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("jwt.algorithm %q must be HS256, RS256 or EdDSA", j.Algorithm))
+	}
+	for kid, secret := range j.PreviousSecrets {
+		if len(secret) < auth.MinHMACSecretLength {
+			problems = append(problems, fmt.Sprintf("jwt.previous_secrets %q must be at least %d bytes", kid, auth.MinHMACSecretLength))
+		}
+	}
+	if j.KeyID == "" || j.Issuer == "" || j.Audience == "" {
+		problems = append(problems, "jwt.key_id, jwt.issuer and jwt.audience must be set")
+	}
+	if j.Leeway < 0 {
+		problems = append(problems, "jwt.leeway must not be negative")
+	}
+	return problems
+}
+
+func (o OIDC) validate() []string {
+	if o.Issuer == "" {
+		return nil
+	}
+	var problems []string
+	if err := checkURL(o.Issuer); err != nil {
+		problems = append(problems, "oidc.issuer "+err.Error())
+	}
+	if o.ClientID == "" || o.RedirectURL == "" {
+		problems = append(problems, "oidc.client_id and oidc.redirect_url must be set when oidc.issuer is")
+	}
+	return problems
+}
+
+func (m Mail) validate() []string {
+	var problems []string
+	switch m.Driver {
+	case "log":
+	case "file":
+		if m.Dir == "" {
+			problems = append(problems, "mail.dir must be set for the file driver")
+		}
+	case "smtp":
+		if m.SMTP.Host == "" {
+			problems = append(problems, "mail.smtp.host must be set for the smtp driver")
+		}
+		if m.SMTP.Port < 1 || m.SMTP.Port > 65535 {
+			problems = append(problems, fmt.Sprintf("mail.smtp.port %d is not a port", m.SMTP.Port))
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("mail.driver %q must be log, file or smtp", m.Driver))
+	}
+	if m.From == "" {
+		problems = append(problems, "mail.from must be set")
+	}
+	return problems
+}
+
+func (l Log) validate() []string {
+	if l.Format != "json" && l.Format != "text" {
+		return []string{fmt.Sprintf("log.format %q must be json or text", l.Format)}
+	}
+	return nil
+}
+
+func (t Tracing) validate() []string {
+	switch t.Exporter {
+	case "none", "stdout", "otlp":
+	case "file":
+		if t.File == "" {
+			return []string{"tracing.file must be set for the file exporter"}
+		}
+	default:
+		return []string{fmt.Sprintf("tracing.exporter %q must be none, stdout, file or otlp", t.Exporter)}
+	}
+	if t.Endpoint != "" {
+		if err := checkURL(t.Endpoint); err != nil {
+			return []string{"tracing.endpoint " + err.Error()}
+		}
+	}
+	return nil
+}
+
+// validateProduction adds the rules that only make sense for real users.
+func (c *Config) validateProduction(sections []string) []string {
+	checked := func(section string) bool {
+		if len(sections) == 0 {
+			return true
+		}
+		for _, s := range sections {
+			if s == section {
+				return true
+			}
+		}
+		return false
+	}
+
+	var problems []string
+	if checked("server") && !strings.HasPrefix(c.Server.PublicURL, "https://") {
+		problems = append(problems, "server.public_url must use https in production")
+	}
+	if checked("server") && c.Server.MetricsToken == "" {
+		problems = append(problems, "server.metrics_token must be set in production, /metrics is on the API port")
+	}
+	if checked("jwt") && strings.Contains(strings.ToLower(c.JWT.Secret), "change_me") {
+		problems = append(problems, "jwt.secret is still the example value")
+	}
+	if checked("mail") && c.Mail.Driver == "log" {
+		problems = append(problems, "mail.driver log only prints mail, choose smtp or file in production")
+	}
+	return problems
+}
+
+func checkURL(s string) error {
+	if s == "" {
+		return errors.New("must be set")
+	}
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%q is not an http or https URL", s)
+	}
+	return nil
+}
@@ -0,0 +1,216 @@
+package config
+
+import (
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const secret = "0123456789abcdef0123456789abcdef"
+
+// newTestLoader returns a loader that reads files from a temporary directory
+// and sees only env instead of the process environment.
+func newTestLoader(t *testing.T, env map[string]string, files map[string]string, args ...string) *Loader {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	l := &Loader{dir: dir, lookupEnv: func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}}
+
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	l.RegisterFlags(flags)
+	err := flags.Parse(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	files := map[string]string{
+		"config.development.yaml": `
+server:
+  addr: ":7000"
+  public_url: http://file.example.com
+  idempotency_key_ttl: 1h
+database:
+  url: postgres://file/db
+  max_conns: 4
+oidc:
+  scopes: [openid, email]
+jwt:
+  previous_secrets:
+    old: ` + secret + `
+`,
+		".env":             "APP_ENV=development\nJWT_SECRET=" + secret + "\nPUBLIC_URL=http://dotenv.example.com\nDB_MAX_CONNS=6\n",
+		".env.development": "DB_MAX_CONNS=8\n",
+	}
+	env := map[string]string{"POSTGRES_URL": "postgres://env/db", "JWT_LEEWAY": ""}
+
+	cfg, err := newTestLoader(t, env, files, "-server.addr", ":9000").Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checks := []struct {
+		name      string
+		got, want any
+	}{
+		{"flag over file", cfg.Server.Addr, ":9000"},
+		{".env over file", cfg.Server.PublicURL, "http://dotenv.example.com"},
+		{".env.development over .env", cfg.Database.MaxConns, 8},
+		{"environment over file", cfg.Database.URL, "postgres://env/db"},
+		{"file over default", cfg.Server.IdempotencyKeyTTL, time.Hour},
+		{"empty variable ignored", cfg.JWT.Leeway, 30 * time.Second},
+		{"default", cfg.Mail.Driver, "log"},
+		{"file list", strings.Join(cfg.OIDC.Scopes, " "), "openid email"},
+		{"file table", cfg.JWT.PreviousSecrets["old"], secret},
+	}
+	for _, c := range checks {
+		if c.got != c.want {
+			t.Errorf("%s: got %v, want %v", c.name, c.got, c.want)
+		}
+	}
+}
+
+func TestLoadTOMLAndSections(t *testing.T) {
+	files := map[string]string{"app.toml": `
+[database]
+url = "postgres://toml/db"
+min_conns = 2
+
+[mail.smtp]
+port = 2525
+`}
+	env := map[string]string{"APP_ENV": "test"}
+	l := newTestLoader(t, env, files)
+	l.file = filepath.Join(l.dir, "app.toml")
+
+	// Only the database is checked, the missing JWT secret doesn't matter.
+	cfg, err := l.Load("database")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Env != Test || cfg.Database.URL != "postgres://toml/db" || cfg.Database.MinConns != 2 || cfg.Mail.SMTP.Port != 2525 {
+		t.Fatalf("loaded %+v", cfg)
+	}
+
+	_, err = l.Load()
+	if err == nil || !strings.Contains(err.Error(), "jwt.secret") {
+		t.Fatalf("Load of every section = %v, want a jwt.secret error", err)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		env   map[string]string
+		files map[string]string
+		want  []string
+	}{
+		{
+			name: "missing secrets",
+			env:  map[string]string{"APP_ENV": "development"},
+			want: []string{"database.url must be set", "jwt.secret must be set"},
+		},
+		{
+			name: "short secret and bad values",
+			env: map[string]string{
+				"APP_ENV": "development", "POSTGRES_URL": "postgres://db", "JWT_SECRET": "short",
+				"DB_MAX_CONNS": "many", "JWT_LEEWAY": "30", "MAIL_DRIVER": "smtp", "TRACING_EXPORTER": "jaeger",
+				"TRUSTED_PROXIES": "10.0.0.0/8 proxy.internal",
+			},
+			want: []string{"jwt.secret must be set to at least 32 bytes", "DB_MAX_CONNS: invalid number", "JWT_LEEWAY: invalid duration", "mail.smtp.host must be set", "tracing.exporter \"jaeger\"", "server.trusted_proxies entry \"proxy.internal\""},
+		},
+		{
+			name:  "unknown file key",
+			env:   map[string]string{"APP_ENV": "development", "POSTGRES_URL": "postgres://db", "JWT_SECRET": secret},
+			files: map[string]string{"config.development.yml": "server:\n  adr: \":80\"\n"},
+			want:  []string{"unknown key server.adr"},
+		},
+		{
+			name: "production",
+			env: map[string]string{
+				"APP_ENV": "production", "POSTGRES_URL": "postgres://db",
+				"JWT_SECRET": "change_me_change_me_change_me_change_me", "PUBLIC_URL": "http://example.com",
+			},
+			want: []string{"public_url must use https", "server.metrics_token must be set", "jwt.secret is still the example value", "mail.driver \"\" must be"},
+		},
+		{
+			name: "oidc without client",
+			env:  map[string]string{"APP_ENV": "development", "POSTGRES_URL": "postgres://db", "JWT_SECRET": secret, "OIDC_ISSUER": "https://id.example.com"},
+			want: []string{"oidc.client_id and oidc.redirect_url must be set"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newTestLoader(t, tt.env, tt.files).Load()
+			if err == nil {
+				t.Fatal("Load succeeded")
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q doesn't mention %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadProduction(t *testing.T) {
+	env := map[string]string{
+		"APP_ENV": "production", "POSTGRES_URL": "postgres://db", "JWT_SECRET": secret,
+		"PUBLIC_URL": "https://example.com", "MAIL_DRIVER": "smtp", "SMTP_HOST": "smtp.example.com",
+		"METRICS_TOKEN": "scrape-token",
+	}
+	cfg, err := newTestLoader(t, env, nil).Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Env != Production || cfg.Server.MetricsToken != "scrape-token" {
+		t.Fatalf("loaded %+v", cfg)
+	}
+}
+
+func TestLoadProfile(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		want string
+	}{
+		{"unknown", map[string]string{"APP_ENV": "staging"}, "staging"},
+		{"missing", map[string]string{"POSTGRES_URL": "postgres://db", "JWT_SECRET": secret}, "APP_ENV is not set"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newTestLoader(t, tt.env, nil).Load()
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("Load = %v, want an error mentioning %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestSecretsHaveNoFlags(t *testing.T) {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	NewLoader().RegisterFlags(flags)
+	for _, name := range []string{"jwt.secret", "jwt.previous_secrets", "oidc.client_secret", "mail.smtp.password"} {
+		if flags.Lookup(name) != nil {
+			t.Errorf("-%s is a flag", name)
+		}
+	}
+	if flags.Lookup("database.url") == nil || flags.Lookup("config") == nil {
+		t.Error("missing -database.url or -config")
+	}
+}
@@ -0,0 +1,380 @@
+package config
+
+import (
+	"encoding"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+// Loader reads a Config from its sources. Create one with NewLoader, call
+// RegisterFlags before the flags are parsed and Load after.
+type Loader struct {
+	// dir is where .env and config.<APP_ENV>.* files are looked up.
+	dir       string
+	lookupEnv func(string) (string, bool)
+
+	file  string
+	flags map[string]*flagValue
+}
+
+func NewLoader() *Loader {
+	return &Loader{dir: ".", lookupEnv: os.LookupEnv}
+}
+
+// setting is one leaf of Config, such as mail.smtp.host.
+type setting struct {
+	key    string
+	env    string
+	help   string
+	secret bool
+	value  reflect.Value
+}
+
+// settings lists the leaves of cfg, with values that point into it.
+func settings(cfg *Config) []setting {
+	var all []setting
+	var walk func(prefix string, v reflect.Value)
+	walk = func(prefix string, v reflect.Value) {
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			key, ok := field.Tag.Lookup("key")
+			if !ok {
+				continue
+			}
+			if prefix != "" {
+				key = prefix + "." + key
+			}
+			if field.Type.Kind() == reflect.Struct {
+				walk(key, v.Field(i))
+				continue
+			}
+			all = append(all, setting{
+				key:    key,
+				env:    field.Tag.Get("env"),
+				help:   field.Tag.Get("help"),
+				secret: field.Tag.Get("secret") == "true",
+				value:  v.Field(i),
+			})
+		}
+	}
+	walk("", reflect.ValueOf(cfg).Elem())
+	return all
+}
+
+// flagValue remembers the text of a flag until Load knows the type to parse
+// it into.
+type flagValue struct {
+	text   string
+	set    bool
+	isBool bool
+}
+
+func (f *flagValue) String() string   { return f.text }
+func (f *flagValue) IsBoolFlag() bool { return f.isBool }
+
+func (f *flagValue) Set(s string) error {
+	f.text, f.set = s, true
+	return nil
+}
+
+// RegisterFlags adds -config and a flag per setting, named after its key, to
+// flags. Secrets have no flag, command lines end up in process listings.
+func (l *Loader) RegisterFlags(flags *flag.FlagSet) {
+	flags.StringVar(&l.file, "config", "", "YAML or TOML config file, overrides CONFIG_FILE")
+	l.flags = map[string]*flagValue{}
+	defaults := Defaults(Development)
+	for _, s := range settings(&defaults) {
+		if s.secret {
+			continue
+		}
+		value := &flagValue{isBool: s.value.Kind() == reflect.Bool}
+		l.flags[s.key] = value
+		help := s.help
+		if s.env != "" {
+			help += ", overrides " + s.env
+		}
+		flags.Var(value, s.key, help)
+	}
+}
+
+// Load reads the configuration and validates the named sections, such as
+// "database", or every section when none are named. The error lists every
+// problem found, not just the first.
+func (l *Loader) Load(sections ...string) (*Config, error) {
+	var problems []string
+	dotenv := l.readDotenv(".env", &problems)
+
+	envName, _ := l.lookup("APP_ENV", dotenv)
+	env, err := parseProfile(envName)
+	if err != nil {
+		return nil, err
+	}
+	// .env.<APP_ENV> wins over .env and loses to the real environment.
+	profileEnv := l.readDotenv(".env."+string(env), &problems)
+	for key, value := range dotenv {
+		if _, ok := profileEnv[key]; !ok {
+			profileEnv[key] = value
+		}
+	}
+
+	cfg := Defaults(env)
+	all := settings(&cfg)
+
+	file, err := l.configFile(env, profileEnv)
+	if err != nil {
+		return nil, err
+	}
+	if file != "" {
+		values, err := readFile(file)
+		if err != nil {
+			return nil, err
+		}
+		problems = append(problems, applyFile(file, values, all)...)
+	}
+
+	for _, s := range all {
+		if s.env == "" {
+			continue
+		}
+		// Empty variables count as unset, compose files often declare them
+		// without a value.
+		text, _ := l.lookup(s.env, profileEnv)
+		if text == "" {
+			continue
+		}
+		err := setText(s.value, text)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", s.env, err))
+		}
+	}
+
+	for _, s := range all {
+		value := l.flags[s.key]
+		if value == nil || !value.set {
+			continue
+		}
+		err := setText(s.value, value.text)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("-%s: %v", s.key, err))
+		}
+	}
+
+	problems = append(problems, cfg.validate(sections)...)
+	if len(problems) > 0 {
+		return nil, errors.New("invalid configuration:\n  " + strings.Join(problems, "\n  "))
+	}
+	return &cfg, nil
+}
+
+// lookup reads a variable from the environment, then from the .env files.
+func (l *Loader) lookup(name string, dotenv map[string]string) (string, bool) {
+	if value, ok := l.lookupEnv(name); ok {
+		return value, true
+	}
+	value, ok := dotenv[name]
+	return value, ok
+}
+
+// readDotenv reads a .env file into a map instead of the process
+// environment. A missing file is fine, .env files are for local development.
+func (l *Loader) readDotenv(name string, problems *[]string) map[string]string {
+	values, err := godotenv.Read(filepath.Join(l.dir, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return map[string]string{}
+	}
+	if err != nil {
+		*problems = append(*problems, fmt.Sprintf("%s: %v", name, err))
+		return map[string]string{}
+	}
+	return values
+}
+
+// configFile picks the -config flag, then CONFIG_FILE, then the first
+// config.<APP_ENV>.yaml, .yml or .toml that exists. Files that are asked for
+// have to exist, the profile files don't.
+func (l *Loader) configFile(env Profile, dotenv map[string]string) (string, error) {
+	file := l.file
+	if file == "" {
+		file, _ = l.lookup("CONFIG_FILE", dotenv)
+	}
+	if file != "" {
+		_, err := os.Stat(file)
+		return file, err
+	}
+	for _, ext := range []string{".yaml", ".yml", ".toml"} {
+		candidate := filepath.Join(l.dir, "config."+string(env)+ext)
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate, nil
+		}
+	}
+	return "", nil
+}
+
+func readFile(name string) (map[string]any, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	values := map[string]any{}
+	switch filepath.Ext(name) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &values)
+	case ".toml":
+		err = toml.Unmarshal(data, &values)
+	default:
+		return nil, fmt.Errorf("%s: unknown config file type, use .yaml, .yml or .toml", name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return values, nil
+}
+
+// applyFile sets the settings found in a parsed config file. Keys that match
+// no setting are reported, they are usually typos.
+func applyFile(name string, values map[string]any, all []setting) []string {
+	flat := map[string]any{}
+	flatten("", values, flat)
+
+	var problems []string
+	for _, s := range all {
+		value, ok := flat[s.key]
+		if !ok {
+			continue
+		}
+		delete(flat, s.key)
+		err := setValue(s.value, value)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %s: %v", name, s.key, err))
+		}
+	}
+	unknown := make([]string, 0, len(flat))
+	for key := range flat {
+		unknown = append(unknown, key)
+	}
+	sort.Strings(unknown)
+	for _, key := range unknown {
+		problems = append(problems, fmt.Sprintf("%s: unknown key %s", name, key))
+	}
+	return problems
+}
+
+// flatten turns nested tables into dotted keys. Maps stay whole under keys
+// that hold map settings, which is fine because those keys are not nested
+// any deeper.
+func flatten(prefix string, values map[string]any, flat map[string]any) {
+	for key, value := range values {
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		nested, ok := value.(map[string]any)
+		if ok && !isMapSetting(key) {
+			flatten(key, nested, flat)
+			continue
+		}
+		flat[key] = value
+	}
+}
+
+func isMapSetting(key string) bool {
+	var cfg Config
+	for _, s := range settings(&cfg) {
+		if s.key == key {
+			return s.value.Kind() == reflect.Map
+		}
+	}
+	return false
+}
+
+// setValue sets a setting from a config file value. Lists and tables are
+// taken as they are, everything else is parsed like an environment variable.
+func setValue(v reflect.Value, value any) error {
+	switch value := value.(type) {
+	case []any:
+		if v.Kind() != reflect.Slice {
+			return errors.New("a list is not allowed here")
+		}
+		items := make([]string, len(value))
+		for i, item := range value {
+			items[i] = fmt.Sprint(item)
+		}
+		v.Set(reflect.ValueOf(items))
+		return nil
+	case map[string]any:
+		if v.Kind() != reflect.Map {
+			return errors.New("a table is not allowed here")
+		}
+		entries := make(map[string]string, len(value))
+		for key, item := range value {
+			entries[key] = fmt.Sprint(item)
+		}
+		v.Set(reflect.ValueOf(entries))
+		return nil
+	default:
+		return setText(v, fmt.Sprint(value))
+	}
+}
+
+// setText parses text into a setting. Lists are space separated and maps are
+// written as key:value,key:value.
+func setText(v reflect.Value, text string) error {
+	if unmarshaler, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return unmarshaler.UnmarshalText([]byte(text))
+	}
+	if v.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(text)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q, use a value like 30s or 24h", text)
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(text)
+	case reflect.Int:
+		n, err := strconv.Atoi(text)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", text)
+		}
+		v.SetInt(int64(n))
+	case reflect.Bool:
+		b, err := strconv.ParseBool(text)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", text)
+		}
+		v.SetBool(b)
+	case reflect.Slice:
+		v.Set(reflect.ValueOf(strings.Fields(text)))
+	case reflect.Map:
+		entries := map[string]string{}
+		for _, entry := range strings.Split(text, ",") {
+			entry = strings.TrimSpace(entry)
+			if entry == "" {
+				continue
+			}
+			key, value, ok := strings.Cut(entry, ":")
+			if !ok || key == "" || value == "" {
+				return fmt.Errorf("invalid entry %q, want key:value", entry)
+			}
+			entries[key] = value
+		}
+		v.Set(reflect.ValueOf(entries))
+	default:
+		panic("config: unsupported setting type " + v.Type().String())
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+// Package logging sets up the structured logger of the server. Records
+// logged with the context of a request carry its request ID and, once
+// authenticated, the user. Values under sensitive keys such as password or
+// token are redacted, including fields of logged structs and maps, and so
+// are tokens in the query of URLs logged as strings.
+package logging
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+const redacted = "[REDACTED]"
+
+// New returns a logger writing JSON, or logfmt style text when format is
+// "text", at level and above.
+func New(w io.Writer, format string, level slog.Level) *slog.Logger {
+	options := &slog.HandlerOptions{Level: level, ReplaceAttr: redact}
+	var handler slog.Handler
+	if format == "text" {
+		handler = slog.NewTextHandler(w, options)
+	} else {
+		handler = slog.NewJSONHandler(w, options)
+	}
+	return slog.New(contextHandler{handler})
+}
+
+type requestKey struct{}
+
+// request is shared between the middleware that starts a request and the
+// ones that learn more about it later, such as who is logged in.
+type request struct {
+	id     string
+	userID atomic.Int32
+}
+
+// WithRequest starts the log context of a request.
+func WithRequest(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestKey{}, &request{id: requestID})
+}
+
+// RequestID returns the ID given to WithRequest, empty outside a request.
+func RequestID(ctx context.Context) string {
+	if req, ok := ctx.Value(requestKey{}).(*request); ok {
+		return req.id
+	}
+	return ""
+}
+
+// SetUserID adds the authenticated user to every later record of the
+// request, the access log included.
+func SetUserID(ctx context.Context, userID int32) {
+	if req, ok := ctx.Value(requestKey{}).(*request); ok {
+		req.userID.Store(userID)
+	}
+}
+
+// contextHandler adds the request ID and user to records logged with the
+// context of a request, and the trace ID when the request is traced.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if req, ok := ctx.Value(requestKey{}).(*request); ok {
+		record.AddAttrs(slog.String("request_id", req.id))
+		if userID := req.userID.Load(); userID != 0 {
+			record.AddAttrs(slog.Int("user_id", int(userID)))
+		}
+	}
+	if span := trace.SpanContextFromContext(ctx); span.IsSampled() {
+		record.AddAttrs(slog.String("trace_id", span.TraceID().String()))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// sensitiveParts are redacted wherever they appear in a key, sensitiveKeys
+// only when they are the whole key. The body of a mail holds single-use
+// links.
+var (
+	sensitiveParts = []string{"password", "secret", "token", "authorization", "cookie", "api_key", "apikey"}
+	sensitiveKeys  = map[string]bool{"code": true, "recovery_codes": true, "key": true, "body": true}
+)
+
+// tokenParam matches query parameters such as token= and reset_token= in
+// links logged under a harmless key.
+var tokenParam = regexp.MustCompile(`(?i)(token=)[^&\s"']+`)
+
+func redactString(s string) string {
+	return tokenParam.ReplaceAllString(s, "${1}"+redacted)
+}
+
+func isSensitive(key string) bool {
+	key = strings.ToLower(strings.ReplaceAll(key, "-", "_"))
+	if sensitiveKeys[key] {
+		return true
+	}
+	for _, part := range sensitiveParts {
+		if strings.Contains(key, part) {
+			return true
+		}
+	}
+	return false
+}
+
+// redact is the ReplaceAttr of the handlers. Structs, maps and slices are
+// logged as their JSON so their fields can be redacted by name as well.
+func redact(groups []string, a slog.Attr) slog.Attr {
+	if isSensitive(a.Key) {
+		return slog.String(a.Key, redacted)
+	}
+	switch a.Value.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, redactString(a.Value.String()))
+	case slog.KindAny:
+	default:
+		return a
+	}
+	value := a.Value.Any()
+	if err, ok := value.(error); ok {
+		if message := redactString(err.Error()); message != err.Error() {
+			return slog.String(a.Key, message)
+		}
+		return a
+	}
+	switch kind := reflect.Indirect(reflect.ValueOf(value)).Kind(); kind {
+	case reflect.Struct, reflect.Map, reflect.Slice:
+	default:
+		return a
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return a
+	}
+	var decoded any
+	err = json.Unmarshal(data, &decoded)
+	if err != nil {
+		return a
+	}
+	return slog.Any(a.Key, redactValue(decoded))
+}
+
+func redactValue(value any) any {
+	switch value := value.(type) {
+	case map[string]any:
+		for key, item := range value {
+			if isSensitive(key) {
+				value[key] = redacted
+			} else {
+				value[key] = redactValue(item)
+			}
+		}
+	case []any:
+		for i, item := range value {
+			value[i] = redactValue(item)
+		}
+	case string:
+		return redactString(value)
+	}
+	return value
+}
@@ -0,0 +1,117 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"testing"
+
+	"github.com/joseph-gunnarsson/scheduling/internals/mail"
+)
+
+func logLine(t *testing.T, log func(logger *slog.Logger)) map[string]any {
+	t.Helper()
+	var out bytes.Buffer
+	log(New(&out, "json", slog.LevelDebug))
+
+	var line map[string]any
+	err := json.Unmarshal(out.Bytes(), &line)
+	if err != nil {
+		t.Fatalf("%v in %s", err, out.String())
+	}
+	return line
+}
+
+func TestRedact(t *testing.T) {
+	type credentials struct {
+		Username     string `json:"username"`
+		OldPassword  string `json:"old_password"`
+		PasswordHash string `json:"password_hash"`
+		Nested       struct {
+			RefreshToken string `json:"refresh_token"`
+		} `json:"nested"`
+	}
+	var request credentials
+	request.Username = "alice"
+	request.OldPassword = "hunter2"
+	request.PasswordHash = "$2a$10$hash"
+	request.Nested.RefreshToken = "rt_secret"
+
+	line := logLine(t, func(logger *slog.Logger) {
+		logger.Info("update",
+			"password", "hunter2",
+			"Authorization", "Bearer abc",
+			"request", request,
+			"headers", map[string][]string{"Cookie": {"session=abc"}, "Accept": {"application/json"}},
+			"error", errors.New("password too short"),
+			"user_id", 7,
+		)
+	})
+
+	if line["password"] != redacted || line["Authorization"] != redacted {
+		t.Errorf("top level secrets logged: %v", line)
+	}
+	logged := line["request"].(map[string]any)
+	if logged["username"] != "alice" || logged["old_password"] != redacted || logged["password_hash"] != redacted {
+		t.Errorf("request logged as %v", logged)
+	}
+	if nested := logged["nested"].(map[string]any); nested["refresh_token"] != redacted {
+		t.Errorf("nested token logged as %v", nested)
+	}
+	headers := line["headers"].(map[string]any)
+	if headers["Cookie"] != redacted || headers["Accept"] == redacted {
+		t.Errorf("headers logged as %v", headers)
+	}
+	// Errors are messages, not secrets, and keep their text.
+	if line["error"] != "password too short" || line["user_id"] != float64(7) {
+		t.Errorf("plain values changed: %v", line)
+	}
+}
+
+func TestRedactMail(t *testing.T) {
+	msg := mail.Message{
+		To:      "alice@example.com",
+		Subject: "Reset your password",
+		Body:    "Open https://example.com/reset?token=abc123 to choose a new password.",
+	}
+	line := logLine(t, func(logger *slog.Logger) {
+		logger.Info("mail",
+			"message", msg,
+			"link", "https://example.com/verify?user=7&token=abc123",
+			"error", errors.New(`Post "https://example.com/hook?reset_token=abc123": timeout`),
+		)
+	})
+
+	logged := line["message"].(map[string]any)
+	if logged["To"] != msg.To || logged["Subject"] != msg.Subject || logged["Body"] != redacted {
+		t.Errorf("message logged as %v", logged)
+	}
+	if line["link"] != "https://example.com/verify?user=7&token="+redacted {
+		t.Errorf("link logged as %v", line["link"])
+	}
+	if line["error"] != `Post "https://example.com/hook?reset_token=`+redacted+`": timeout` {
+		t.Errorf("error logged as %v", line["error"])
+	}
+}
+
+func TestRequestContext(t *testing.T) {
+	ctx := WithRequest(context.Background(), "req-1")
+	SetUserID(ctx, 42)
+	if RequestID(ctx) != "req-1" {
+		t.Fatalf("RequestID = %q", RequestID(ctx))
+	}
+
+	line := logLine(t, func(logger *slog.Logger) { logger.InfoContext(ctx, "request") })
+	if line["request_id"] != "req-1" || line["user_id"] != float64(42) {
+		t.Fatalf("line = %v, want the request ID and user", line)
+	}
+
+	line = logLine(t, func(logger *slog.Logger) { logger.Info("startup") })
+	if _, ok := line["request_id"]; ok {
+		t.Fatalf("line outside a request has a request ID: %v", line)
+	}
+	// Outside a request there is nothing to set, and nothing to panic about.
+	SetUserID(context.Background(), 1)
+}
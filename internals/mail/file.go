@@ -0,0 +1,52 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// FileMailer writes every message to its own .eml file in a directory so
+// tests and local setups can read what would have been sent.
+type FileMailer struct {
+	dir  string
+	from string
+
+	mu    sync.Mutex
+	count int
+}
+
+func NewFileMailer(dir, from string) (*FileMailer, error) {
+	err := os.MkdirAll(dir, 0o750)
+	if err != nil {
+		return nil, err
+	}
+	return &FileMailer{dir: dir, from: from}, nil
+}
+
+func (m *FileMailer) Send(ctx context.Context, msg Message) error {
+	m.mu.Lock()
+	m.count++
+	name := fmt.Sprintf("%d-%04d.eml", time.Now().UnixNano(), m.count)
+	m.mu.Unlock()
+
+	return os.WriteFile(filepath.Join(m.dir, name), formatMessage(m.from, msg), 0o640)
+}
+
+// LogMailer only logs who a message went to and its subject. The body holds
+// single-use links that must not end up in logs, use FileMailer to read it.
+// It is the default for development.
+type LogMailer struct{}
+
+func NewLogMailer() *LogMailer {
+	return &LogMailer{}
+}
+
+func (m *LogMailer) Send(ctx context.Context, msg Message) error {
+	slog.InfoContext(ctx, "Mail", "to", msg.To, "subject", msg.Subject)
+	return nil
+}
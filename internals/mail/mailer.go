@@ -0,0 +1,50 @@
+package mail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers transactional email such as password resets.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// Config picks the implementation. Driver is log, file or smtp.
+type Config struct {
+	Driver string
+	From   string
+	// Dir is where the file driver writes messages.
+	Dir  string
+	SMTP SMTPConfig
+}
+
+// New builds the mailer for cfg.Driver. The log driver is the default so
+// development setups never send real email by accident.
+func New(cfg Config) (Mailer, error) {
+	switch cfg.Driver {
+	case "", "log":
+		return NewLogMailer(), nil
+	case "file":
+		if cfg.Dir == "" {
+			return nil, errors.New("a directory must be set for the file mail driver")
+		}
+		return NewFileMailer(cfg.Dir, cfg.From)
+	case "smtp":
+		if cfg.SMTP.Host == "" {
+			return nil, errors.New("a host must be set for the smtp mail driver")
+		}
+		smtp := cfg.SMTP
+		smtp.From = cfg.From
+		return NewSMTPMailer(smtp), nil
+	default:
+		return nil, fmt.Errorf("unsupported mail driver %q", cfg.Driver)
+	}
+}
@@ -0,0 +1,187 @@
+// Package metrics collects the Prometheus metrics of the server: requests
+// by route and status, query durations by sqlc query name, the connection
+// pool and a few numbers about the schedule itself.
+package metrics
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/joseph-gunnarsson/scheduling/db"
+	models "github.com/joseph-gunnarsson/scheduling/db/models"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// DomainWindow is how far back the shift and failed login gauges count.
+const DomainWindow = time.Hour
+
+// statsTimeout bounds the query behind the domain gauges, a slow database
+// shouldn't hold up the scrape.
+const statsTimeout = 2 * time.Second
+
+// Metrics is the registry of the server. The zero value is not usable, use
+// New.
+type Metrics struct {
+	registry        *prometheus.Registry
+	handler         http.Handler
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	queryDuration   *prometheus.HistogramVec
+}
+
+// New registers the HTTP, query, Go runtime and process metrics.
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_requests_total",
+			Help: "HTTP requests by route pattern and status.",
+		}, []string{"route", "status"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_request_duration_seconds",
+			Help:    "Time to serve HTTP requests by route pattern and status.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"route", "status"}),
+		queryDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "db_query_duration_seconds",
+			Help:    "Time to run database queries by sqlc query name and outcome.",
+			Buckets: prometheus.ExponentialBuckets(0.0005, 2, 14),
+		}, []string{"query", "status"}),
+	}
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requests, m.requestDuration, m.queryDuration,
+	)
+	// A failing collector leaves out its own metrics, not the whole scrape.
+	m.handler = promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{ErrorHandling: promhttp.ContinueOnError})
+	return m
+}
+
+// ServeHTTP writes the metrics in the Prometheus text format.
+func (m *Metrics) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	m.handler.ServeHTTP(rw, r)
+}
+
+// ObserveRequest counts a served request. route is the pattern the mux
+// matched, which keeps the number of series bounded unlike the path.
+func (m *Metrics) ObserveRequest(route string, status int, duration time.Duration) {
+	labels := prometheus.Labels{"route": route, "status": strconv.Itoa(status)}
+	m.requests.With(labels).Inc()
+	m.requestDuration.With(labels).Observe(duration.Seconds())
+}
+
+// WatchPool adds the statistics of pool and the domain gauges, both read
+// when scraped.
+func (m *Metrics) WatchPool(pool *pgxpool.Pool) {
+	m.registry.MustRegister(poolCollector{pool}, statsCollector{models.New(pool)})
+}
+
+// QueryTracer times every query run on connections it is set on, see
+// pgx.ConnConfig.Tracer.
+func (m *Metrics) QueryTracer() pgx.QueryTracer {
+	return queryTracer{m.queryDuration}
+}
+
+type queryStartKey struct{}
+
+type queryStart struct {
+	name  string
+	start time.Time
+}
+
+type queryTracer struct {
+	duration *prometheus.HistogramVec
+}
+
+func (t queryTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	return context.WithValue(ctx, queryStartKey{}, queryStart{name: db.QueryName(data.SQL), start: time.Now()})
+}
+
+func (t queryTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	start, ok := ctx.Value(queryStartKey{}).(queryStart)
+	if !ok {
+		return
+	}
+	status := "ok"
+	if data.Err != nil && !errors.Is(data.Err, pgx.ErrNoRows) {
+		status = "error"
+	}
+	t.duration.WithLabelValues(start.name, status).Observe(time.Since(start.start).Seconds())
+}
+
+var (
+	poolMaxDesc      = prometheus.NewDesc("db_pool_max_connections", "Most connections the pool opens.", nil, nil)
+	poolTotalDesc    = prometheus.NewDesc("db_pool_total_connections", "Connections open or being opened.", nil, nil)
+	poolAcquiredDesc = prometheus.NewDesc("db_pool_acquired_connections", "Connections in use.", nil, nil)
+	poolIdleDesc     = prometheus.NewDesc("db_pool_idle_connections", "Connections open and not in use.", nil, nil)
+	poolAcquiresDesc = prometheus.NewDesc("db_pool_acquires_total", "Connections taken from the pool.", nil, nil)
+	poolEmptyDesc    = prometheus.NewDesc("db_pool_empty_acquires_total", "Acquires that had to wait for a connection.", nil, nil)
+	poolCanceledDesc = prometheus.NewDesc("db_pool_canceled_acquires_total", "Acquires given up before getting a connection.", nil, nil)
+	poolWaitDesc     = prometheus.NewDesc("db_pool_acquire_wait_seconds_total", "Time spent acquiring connections.", nil, nil)
+)
+
+type poolCollector struct {
+	pool *pgxpool.Pool
+}
+
+func (c poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{poolMaxDesc, poolTotalDesc, poolAcquiredDesc, poolIdleDesc, poolAcquiresDesc, poolEmptyDesc, poolCanceledDesc, poolWaitDesc} {
+		ch <- desc
+	}
+}
+
+func (c poolCollector) Collect(ch chan<- prometheus.Metric) {
+	stat := c.pool.Stat()
+	ch <- prometheus.MustNewConstMetric(poolMaxDesc, prometheus.GaugeValue, float64(stat.MaxConns()))
+	ch <- prometheus.MustNewConstMetric(poolTotalDesc, prometheus.GaugeValue, float64(stat.TotalConns()))
+	ch <- prometheus.MustNewConstMetric(poolAcquiredDesc, prometheus.GaugeValue, float64(stat.AcquiredConns()))
+	ch <- prometheus.MustNewConstMetric(poolIdleDesc, prometheus.GaugeValue, float64(stat.IdleConns()))
+	ch <- prometheus.MustNewConstMetric(poolAcquiresDesc, prometheus.CounterValue, float64(stat.AcquireCount()))
+	ch <- prometheus.MustNewConstMetric(poolEmptyDesc, prometheus.CounterValue, float64(stat.EmptyAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(poolCanceledDesc, prometheus.CounterValue, float64(stat.CanceledAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(poolWaitDesc, prometheus.CounterValue, stat.AcquireDuration().Seconds())
+}
+
+var (
+	activeUsersDesc   = prometheus.NewDesc("scheduling_active_users", "Users with a session that is neither revoked nor expired.", nil, nil)
+	shiftsCreatedDesc = prometheus.NewDesc("scheduling_shifts_created_last_hour", "Shifts created in the last hour.", nil, nil)
+	failedLoginsDesc  = prometheus.NewDesc("scheduling_failed_logins_last_hour", "Failed login attempts in the last hour.", nil, nil)
+)
+
+// statsCollector reads the domain gauges from the database rather than
+// counting in the process, so every replica reports the same numbers and a
+// restart doesn't reset them.
+type statsCollector struct {
+	query *models.Queries
+}
+
+func (c statsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- activeUsersDesc
+	ch <- shiftsCreatedDesc
+	ch <- failedLoginsDesc
+}
+
+func (c statsCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), statsTimeout)
+	defer cancel()
+	since := pgtype.Timestamptz{Time: time.Now().Add(-DomainWindow), Valid: true}
+	stats, err := c.query.GetDomainStats(ctx, since)
+	if err != nil {
+		slog.Warn("Failed to read the domain metrics", "error", err)
+		ch <- prometheus.NewInvalidMetric(activeUsersDesc, err)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(activeUsersDesc, prometheus.GaugeValue, float64(stats.ActiveUsers))
+	ch <- prometheus.MustNewConstMetric(shiftsCreatedDesc, prometheus.GaugeValue, float64(stats.ShiftsCreated))
+	ch <- prometheus.MustNewConstMetric(failedLoginsDesc, prometheus.GaugeValue, float64(stats.FailedLogins))
+}
@@ -0,0 +1,42 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+func TestServeHTTP(t *testing.T) {
+	m := New()
+	m.ObserveRequest("GET /group/{id}/", http.StatusOK, 20*time.Millisecond)
+	m.ObserveRequest("GET /group/{id}/", http.StatusOK, 30*time.Millisecond)
+	m.ObserveRequest("GET /group/{id}/", http.StatusNotFound, time.Millisecond)
+
+	tracer := m.QueryTracer()
+	ctx := tracer.TraceQueryStart(context.Background(), nil, pgx.TraceQueryStartData{SQL: "-- name: GetGroup :one\nSELECT 1"})
+	tracer.TraceQueryEnd(ctx, nil, pgx.TraceQueryEndData{})
+	ctx = tracer.TraceQueryStart(context.Background(), nil, pgx.TraceQueryStartData{SQL: "-- name: GetGroup :one\nSELECT 1"})
+	tracer.TraceQueryEnd(ctx, nil, pgx.TraceQueryEndData{Err: errors.New("conn closed")})
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	for _, want := range []string{
+		`http_requests_total{route="GET /group/{id}/",status="200"} 2`,
+		`http_requests_total{route="GET /group/{id}/",status="404"} 1`,
+		`http_request_duration_seconds_sum{route="GET /group/{id}/",status="200"} 0.05`,
+		`db_query_duration_seconds_count{query="GetGroup",status="ok"} 1`,
+		`db_query_duration_seconds_count{query="GetGroup",status="error"} 1`,
+		"go_goroutines",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics don't include %s", want)
+		}
+	}
+}
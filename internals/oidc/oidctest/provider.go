@@ -0,0 +1,248 @@
+// Package oidctest is a minimal OpenID Connect provider for local development
+// and tests. It approves every authorization request as a single configured
+// identity, but otherwise enforces the code flow with PKCE like a real one.
+package oidctest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/joseph-gunnarsson/scheduling/internals/auth"
+	"github.com/joseph-gunnarsson/scheduling/internals/oidc"
+)
+
+const keyID = "oidctest"
+
+type Identity struct {
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	PreferredUsername string
+	GivenName         string
+	FamilyName        string
+}
+
+type authorization struct {
+	clientID      string
+	redirectURI   string
+	nonce         string
+	codeChallenge string
+	expiresAt     time.Time
+}
+
+type Provider struct {
+	issuer   string
+	clientID string
+	key      auth.SigningKey
+
+	mu       sync.Mutex
+	identity Identity
+	codes    map[string]authorization
+}
+
+func NewProvider(issuer, clientID string, identity Identity) (*Provider, error) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	key, err := auth.ParsePrivateKeyPEM(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Provider{
+		issuer:   issuer,
+		clientID: clientID,
+		key:      key,
+		identity: identity,
+		codes:    map[string]authorization{},
+	}, nil
+}
+
+// NewServer starts the provider on a random local port. The server URL is
+// the issuer.
+func NewServer(clientID string, identity Identity) (*httptest.Server, *Provider, error) {
+	server := httptest.NewUnstartedServer(nil)
+	provider, err := NewProvider("http://"+server.Listener.Addr().String(), clientID, identity)
+	if err != nil {
+		server.Close()
+		return nil, nil, err
+	}
+	server.Config.Handler = provider
+	server.Start()
+	return server, provider, nil
+}
+
+func (p *Provider) Issuer() string {
+	return p.issuer
+}
+
+// SetIdentity changes who the next authorization request logs in as.
+func (p *Provider) SetIdentity(identity Identity) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.identity = identity
+}
+
+func (p *Provider) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/.well-known/openid-configuration":
+		p.discovery(rw)
+	case r.Method == http.MethodGet && r.URL.Path == "/jwks":
+		p.jwks(rw)
+	case r.Method == http.MethodGet && r.URL.Path == "/authorize":
+		p.authorize(rw, r)
+	case r.Method == http.MethodPost && r.URL.Path == "/token":
+		p.token(rw, r)
+	default:
+		http.NotFound(rw, r)
+	}
+}
+
+func (p *Provider) discovery(rw http.ResponseWriter) {
+	writeJSON(rw, http.StatusOK, map[string]interface{}{
+		"issuer":                                p.issuer,
+		"authorization_endpoint":                p.issuer + "/authorize",
+		"token_endpoint":                        p.issuer + "/token",
+		"jwks_uri":                              p.issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{auth.AlgRS256},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (p *Provider) jwks(rw http.ResponseWriter) {
+	jwk, _ := p.key.PublicJWK(keyID)
+	writeJSON(rw, http.StatusOK, auth.JWKSet{Keys: []auth.JWK{jwk}})
+}
+
+func (p *Provider) authorize(rw http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	redirectURI, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil || redirectURI.Scheme == "" {
+		http.Error(rw, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	if q.Get("client_id") != p.clientID || q.Get("response_type") != "code" {
+		http.Error(rw, "invalid client_id or response_type", http.StatusBadRequest)
+		return
+	}
+	if q.Get("code_challenge") == "" || q.Get("code_challenge_method") != "S256" {
+		http.Error(rw, "PKCE with S256 is required", http.StatusBadRequest)
+		return
+	}
+
+	code, err := auth.RandomString(16)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	p.mu.Lock()
+	p.codes[code] = authorization{
+		clientID:      q.Get("client_id"),
+		redirectURI:   q.Get("redirect_uri"),
+		nonce:         q.Get("nonce"),
+		codeChallenge: q.Get("code_challenge"),
+		expiresAt:     time.Now().Add(time.Minute),
+	}
+	p.mu.Unlock()
+
+	params := redirectURI.Query()
+	params.Set("code", code)
+	params.Set("state", q.Get("state"))
+	redirectURI.RawQuery = params.Encode()
+	http.Redirect(rw, r, redirectURI.String(), http.StatusFound)
+}
+
+func (p *Provider) token(rw http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeJSON(rw, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+
+	p.mu.Lock()
+	grant, ok := p.codes[r.PostForm.Get("code")]
+	delete(p.codes, r.PostForm.Get("code"))
+	identity := p.identity
+	p.mu.Unlock()
+
+	switch {
+	case r.PostForm.Get("grant_type") != "authorization_code":
+		writeJSON(rw, http.StatusBadRequest, map[string]string{"error": "unsupported_grant_type"})
+		return
+	case !ok || time.Now().After(grant.expiresAt):
+		writeJSON(rw, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	case r.PostForm.Get("client_id") != grant.clientID || r.PostForm.Get("redirect_uri") != grant.redirectURI:
+		writeJSON(rw, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	case oidc.CodeChallengeS256(r.PostForm.Get("code_verifier")) != grant.codeChallenge:
+		writeJSON(rw, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "PKCE verification failed"})
+		return
+	}
+
+	idToken, err := p.signIDToken(identity, grant)
+	if err != nil {
+		writeJSON(rw, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+
+	writeJSON(rw, http.StatusOK, map[string]interface{}{
+		"access_token": "oidctest-access-token",
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     idToken,
+	})
+}
+
+func (p *Provider) signIDToken(identity Identity, grant authorization) (string, error) {
+	now := time.Now()
+	header, err := json.Marshal(auth.Header{Alg: p.key.Alg(), Typ: "JWT", Kid: keyID})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(oidc.Claims{
+		Issuer:            p.issuer,
+		Subject:           identity.Subject,
+		Audience:          auth.Audience{grant.clientID},
+		Expiry:            now.Add(5 * time.Minute).Unix(),
+		IssuedAt:          now.Unix(),
+		Nonce:             grant.nonce,
+		Email:             identity.Email,
+		EmailVerified:     identity.EmailVerified,
+		PreferredUsername: identity.PreferredUsername,
+		GivenName:         identity.GivenName,
+		FamilyName:        identity.FamilyName,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	signature, err := p.key.Sign([]byte(signingInput))
+	if err != nil {
+		return "", err
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+func writeJSON(rw http.ResponseWriter, status int, v interface{}) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	json.NewEncoder(rw).Encode(v)
+}
@@ -0,0 +1,308 @@
+package oidc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/joseph-gunnarsson/scheduling/internals/auth"
+)
+
+const (
+	clockLeeway       = time.Minute
+	minKeyRefreshWait = time.Minute
+)
+
+var (
+	ErrInvalidIDToken = errors.New("invalid ID token")
+	ErrNonceMismatch  = errors.New("ID token nonce does not match the login request")
+)
+
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+	// AutoProvision creates a local user the first time an unknown identity
+	// logs in. Without it identities must be linked by a logged in user.
+	AutoProvision bool
+}
+
+type discoveryDocument struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Claims are the ID token claims the application relies on.
+type Claims struct {
+	Issuer            string        `json:"iss"`
+	Subject           string        `json:"sub"`
+	Audience          auth.Audience `json:"aud"`
+	AuthorizedParty   string        `json:"azp"`
+	Expiry            int64         `json:"exp"`
+	IssuedAt          int64         `json:"iat"`
+	Nonce             string        `json:"nonce"`
+	Email             string        `json:"email"`
+	EmailVerified     bool          `json:"email_verified"`
+	PreferredUsername string        `json:"preferred_username"`
+	GivenName         string        `json:"given_name"`
+	FamilyName        string        `json:"family_name"`
+}
+
+// Provider talks to one OpenID Connect identity provider. Discovery and the
+// provider's signing keys are fetched lazily and cached.
+type Provider struct {
+	cfg    Config
+	client *http.Client
+
+	mu            sync.Mutex
+	discovery     *discoveryDocument
+	keys          map[string]auth.SigningKey
+	keysFetchedAt time.Time
+}
+
+func NewProvider(cfg Config, client *http.Client) *Provider {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &Provider{cfg: cfg, client: client}
+}
+
+func (p *Provider) Config() Config {
+	return p.cfg
+}
+
+// AuthCodeURL builds the authorization request for the code flow with PKCE.
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, codeChallenge string) (string, error) {
+	doc, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	authURL, err := url.Parse(doc.AuthorizationEndpoint)
+	if err != nil {
+		return "", err
+	}
+	params := authURL.Query()
+	params.Set("response_type", "code")
+	params.Set("client_id", p.cfg.ClientID)
+	params.Set("redirect_uri", p.cfg.RedirectURL)
+	params.Set("scope", strings.Join(p.cfg.Scopes, " "))
+	params.Set("state", state)
+	params.Set("nonce", nonce)
+	params.Set("code_challenge", codeChallenge)
+	params.Set("code_challenge_method", "S256")
+	authURL.RawQuery = params.Encode()
+	return authURL.String(), nil
+}
+
+// Exchange redeems an authorization code and returns the verified ID token claims.
+func (p *Provider) Exchange(ctx context.Context, code, codeVerifier, nonce string) (Claims, error) {
+	doc, err := p.discover(ctx)
+	if err != nil {
+		return Claims{}, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.cfg.RedirectURL)
+	form.Set("client_id", p.cfg.ClientID)
+	form.Set("code_verifier", codeVerifier)
+	if p.cfg.ClientSecret != "" {
+		form.Set("client_secret", p.cfg.ClientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, doc.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Claims{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return Claims{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Claims{}, fmt.Errorf("token endpoint returned %s", resp.Status)
+	}
+
+	var tokenResponse struct {
+		IDToken string `json:"id_token"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&tokenResponse)
+	if err != nil {
+		return Claims{}, err
+	}
+	if tokenResponse.IDToken == "" {
+		return Claims{}, errors.New("token endpoint did not return an ID token")
+	}
+
+	return p.VerifyIDToken(ctx, tokenResponse.IDToken, nonce)
+}
+
+// VerifyIDToken checks the signature against the provider's JWKS and
+// validates issuer, audience, expiry and nonce.
+func (p *Provider) VerifyIDToken(ctx context.Context, rawToken, nonce string) (Claims, error) {
+	parts := strings.Split(rawToken, ".")
+	if len(parts) != 3 {
+		return Claims{}, ErrInvalidIDToken
+	}
+
+	var header auth.Header
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return Claims{}, ErrInvalidIDToken
+	}
+
+	key, err := p.signingKey(ctx, header.Kid)
+	if err != nil {
+		return Claims{}, err
+	}
+	if key.Alg() != header.Alg {
+		return Claims{}, ErrInvalidIDToken
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !key.Verify([]byte(parts[0]+"."+parts[1]), signature) {
+		return Claims{}, ErrInvalidIDToken
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return Claims{}, ErrInvalidIDToken
+	}
+
+	now := time.Now()
+	switch {
+	case claims.Issuer != p.cfg.Issuer:
+		return Claims{}, fmt.Errorf("%w: unexpected issuer", ErrInvalidIDToken)
+	case !claims.Audience.Contains(p.cfg.ClientID):
+		return Claims{}, fmt.Errorf("%w: unexpected audience", ErrInvalidIDToken)
+	case len(claims.Audience) > 1 && claims.AuthorizedParty != p.cfg.ClientID:
+		return Claims{}, fmt.Errorf("%w: unexpected authorized party", ErrInvalidIDToken)
+	case claims.Subject == "":
+		return Claims{}, fmt.Errorf("%w: missing subject", ErrInvalidIDToken)
+	case now.After(time.Unix(claims.Expiry, 0).Add(clockLeeway)):
+		return Claims{}, fmt.Errorf("%w: token has expired", ErrInvalidIDToken)
+	case now.Add(clockLeeway).Before(time.Unix(claims.IssuedAt, 0)):
+		return Claims{}, fmt.Errorf("%w: token issued in the future", ErrInvalidIDToken)
+	case claims.Nonce != nonce:
+		return Claims{}, ErrNonceMismatch
+	}
+
+	return claims, nil
+}
+
+func (p *Provider) discover(ctx context.Context) (*discoveryDocument, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	var doc discoveryDocument
+	err := p.getJSON(ctx, p.cfg.Issuer+"/.well-known/openid-configuration", &doc)
+	if err != nil {
+		return nil, fmt.Errorf("OIDC discovery failed: %w", err)
+	}
+	if strings.TrimSuffix(doc.Issuer, "/") != p.cfg.Issuer {
+		return nil, fmt.Errorf("OIDC discovery returned issuer %q, expected %q", doc.Issuer, p.cfg.Issuer)
+	}
+	if doc.AuthorizationEndpoint == "" || doc.TokenEndpoint == "" || doc.JWKSURI == "" {
+		return nil, errors.New("OIDC discovery document is missing endpoints")
+	}
+	p.discovery = &doc
+	return p.discovery, nil
+}
+
+// signingKey returns the provider key for kid, refetching the JWKS once when
+// the kid is unknown so provider key rotation is picked up automatically.
+func (p *Provider) signingKey(ctx context.Context, kid string) (auth.SigningKey, error) {
+	doc, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	if time.Since(p.keysFetchedAt) < minKeyRefreshWait {
+		return nil, fmt.Errorf("%w: unknown signing key %q", ErrInvalidIDToken, kid)
+	}
+
+	var set auth.JWKSet
+	err = p.getJSON(ctx, doc.JWKSURI, &set)
+	if err != nil {
+		return nil, fmt.Errorf("fetching provider keys failed: %w", err)
+	}
+	keys := map[string]auth.SigningKey{}
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := auth.ParseJWK(jwk)
+		if err != nil {
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+	p.keys = keys
+	p.keysFetchedAt = time.Now()
+
+	key, ok := p.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown signing key %q", ErrInvalidIDToken, kid)
+	}
+	return key, nil
+}
+
+func (p *Provider) getJSON(ctx context.Context, target string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", target, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	decoded, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(decoded, v)
+}
+
+// NewCodeVerifier returns a PKCE code verifier as defined in RFC 7636.
+func NewCodeVerifier() (string, error) {
+	return auth.RandomString(32)
+}
+
+func CodeChallengeS256(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
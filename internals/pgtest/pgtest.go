@@ -0,0 +1,200 @@
+// Package pgtest runs integration tests against a real PostgreSQL. Start
+// brings up a server, applies the migrations to a template database once,
+// and NewDatabase gives every test its own copy of it.
+package pgtest
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	embeddedpostgres "github.com/fergusstrange/embedded-postgres"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/joseph-gunnarsson/scheduling/db/migrations"
+)
+
+const startTimeout = time.Minute
+
+// Server is a PostgreSQL server that tests can create databases on.
+type Server struct {
+	admin    *pgxpool.Pool
+	config   *pgxpool.Config
+	template string
+
+	// embedded is nil when the tests run against TEST_POSTGRES_URL.
+	embedded *embeddedpostgres.EmbeddedPostgres
+	runtime  string
+
+	mu    sync.Mutex
+	count int
+}
+
+// Start connects to the server in TEST_POSTGRES_URL, or starts an embedded
+// one when it is not set. The URL needs a user that may create databases;
+// the embedded server downloads the PostgreSQL binaries on first use.
+func Start() (*Server, error) {
+	ctx := context.Background()
+	s := &Server{}
+
+	url := os.Getenv("TEST_POSTGRES_URL")
+	if url == "" {
+		var err error
+		url, err = s.startEmbedded()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	config, err := pgxpool.ParseConfig(url)
+	if err != nil {
+		s.Stop()
+		return nil, fmt.Errorf("parse test database URL: %w", err)
+	}
+	s.config = config
+	s.admin, err = pgxpool.NewWithConfig(ctx, config)
+	if err != nil {
+		s.Stop()
+		return nil, fmt.Errorf("connect to test database: %w", err)
+	}
+
+	// The process ID keeps packages that run in parallel against the same
+	// server out of each other's way.
+	s.template = fmt.Sprintf("pgtest_template_%d", os.Getpid())
+	err = s.createTemplate(ctx)
+	if err != nil {
+		s.Stop()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Server) startEmbedded() (string, error) {
+	port, err := freePort()
+	if err != nil {
+		return "", err
+	}
+	s.runtime, err = os.MkdirTemp("", "pgtest")
+	if err != nil {
+		return "", err
+	}
+
+	config := embeddedpostgres.DefaultConfig().
+		Port(port).
+		RuntimePath(filepath.Join(s.runtime, "runtime")).
+		DataPath(filepath.Join(s.runtime, "data")).
+		StartTimeout(startTimeout).
+		Logger(io.Discard)
+	s.embedded = embeddedpostgres.NewDatabase(config)
+	err = s.embedded.Start()
+	if err != nil {
+		s.embedded = nil
+		os.RemoveAll(s.runtime)
+		return "", fmt.Errorf("start embedded postgres: %w", err)
+	}
+	return config.GetConnectionURL() + "?sslmode=disable", nil
+}
+
+func freePort() (uint32, error) {
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		return 0, err
+	}
+	defer listener.Close()
+	return uint32(listener.Addr().(*net.TCPAddr).Port), nil
+}
+
+func (s *Server) createTemplate(ctx context.Context) error {
+	_, err := s.admin.Exec(ctx, "DROP DATABASE IF EXISTS "+s.template)
+	if err != nil {
+		return err
+	}
+	_, err = s.admin.Exec(ctx, "CREATE DATABASE "+s.template)
+	if err != nil {
+		return fmt.Errorf("create template database: %w", err)
+	}
+
+	conn, err := pgx.ConnectConfig(ctx, s.connConfig(s.template))
+	if err != nil {
+		return err
+	}
+	// Nobody may be connected to the template while it is being copied.
+	defer conn.Close(ctx)
+
+	all, err := migrations.Load()
+	if err != nil {
+		return err
+	}
+	return migrations.NewMigrator(conn, all).Up(ctx, 0)
+}
+
+func (s *Server) connConfig(database string) *pgx.ConnConfig {
+	config := s.config.ConnConfig.Copy()
+	config.Database = database
+	return config
+}
+
+// NewDatabase creates a migrated database that only this test uses and
+// drops it when the test is done.
+func (s *Server) NewDatabase(t *testing.T) *pgxpool.Pool {
+	t.Helper()
+	ctx := context.Background()
+
+	s.mu.Lock()
+	s.count++
+	name := fmt.Sprintf("pgtest_%d_%d", os.Getpid(), s.count)
+	s.mu.Unlock()
+
+	_, err := s.admin.Exec(ctx, fmt.Sprintf("CREATE DATABASE %s TEMPLATE %s", name, s.template))
+	if err != nil {
+		t.Fatalf("create test database: %v", err)
+	}
+
+	config := s.config.Copy()
+	config.ConnConfig.Database = name
+	pool, err := pgxpool.NewWithConfig(ctx, config)
+	if err != nil {
+		t.Fatalf("connect to test database: %v", err)
+	}
+
+	t.Cleanup(func() {
+		pool.Close()
+		_, err := s.admin.Exec(ctx, "DROP DATABASE IF EXISTS "+name+" WITH (FORCE)")
+		if err != nil {
+			t.Errorf("drop test database: %v", err)
+		}
+	})
+	return pool
+}
+
+// Stop drops the template and shuts the embedded server down.
+func (s *Server) Stop() error {
+	var errs []string
+	if s.admin != nil && s.template != "" {
+		_, err := s.admin.Exec(context.Background(), "DROP DATABASE IF EXISTS "+s.template+" WITH (FORCE)")
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if s.admin != nil {
+		s.admin.Close()
+	}
+	if s.embedded != nil {
+		err := s.embedded.Stop()
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+		os.RemoveAll(s.runtime)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("stop test database: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
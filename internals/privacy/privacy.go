@@ -0,0 +1,146 @@
+// Package privacy answers data subject requests: exporting everything stored
+// about a user and erasing their personal data.
+package privacy
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	db "github.com/joseph-gunnarsson/scheduling/db/models"
+)
+
+// ErrOwnsGroups is returned by Erase while the user still owns groups.
+// Ownership has to be handed over first since the groups belong to everyone
+// in them.
+var ErrOwnsGroups = errors.New("user still owns groups")
+
+type TwoFactor struct {
+	Enabled     bool               `json:"enabled"`
+	ConfirmedAt pgtype.Timestamptz `json:"confirmed_at"`
+}
+
+// Archive is everything tied to a user. Secrets such as password hashes,
+// TOTP secrets and token hashes are left out.
+type Archive struct {
+	ExportedAt    time.Time               `json:"exported_at"`
+	Profile       db.User                 `json:"profile"`
+	Identities    []db.Identity           `json:"identities"`
+	Memberships   []db.GetUserGroupsRow   `json:"memberships"`
+	OwnedGroups   []db.Group              `json:"owned_groups"`
+	Shifts        []db.Shift              `json:"shifts"`
+	Sessions      []db.Session            `json:"sessions"`
+	LoginAttempts []db.LoginAttempt       `json:"login_attempts"`
+	APIKeys       []db.ListUserAPIKeysRow `json:"api_keys"`
+	TwoFactor     TwoFactor               `json:"two_factor"`
+	AuditEvents   []db.AuditEvent         `json:"audit_events"`
+}
+
+func Export(ctx context.Context, query *db.Queries, userID int32) (Archive, error) {
+	archive := Archive{ExportedAt: time.Now().UTC()}
+	var err error
+
+	archive.Profile, err = query.GetUserByID(ctx, userID)
+	if err != nil {
+		return Archive{}, err
+	}
+
+	archive.Identities, err = query.ListUserIdentities(ctx, userID)
+	if err != nil {
+		return Archive{}, err
+	}
+
+	archive.Memberships, err = query.GetUserGroups(ctx, userID)
+	if err != nil {
+		return Archive{}, err
+	}
+
+	archive.OwnedGroups, err = query.GetGroupsByOwner(ctx, pgtype.Int4{Int32: userID, Valid: true})
+	if err != nil {
+		return Archive{}, err
+	}
+
+	archive.Shifts, err = query.ListShiftsByUser(ctx, pgtype.Int4{Int32: userID, Valid: true})
+	if err != nil {
+		return Archive{}, err
+	}
+
+	archive.Sessions, err = query.ListAllUserSessions(ctx, userID)
+	if err != nil {
+		return Archive{}, err
+	}
+
+	archive.LoginAttempts, err = query.ListAllUserLoginAttempts(ctx, pgtype.Int4{Int32: userID, Valid: true})
+	if err != nil {
+		return Archive{}, err
+	}
+
+	archive.APIKeys, err = query.ListUserAPIKeys(ctx, userID)
+	if err != nil {
+		return Archive{}, err
+	}
+
+	archive.AuditEvents, err = query.ListUserAuditEvents(ctx, userID)
+	if err != nil {
+		return Archive{}, err
+	}
+
+	totp, err := query.GetUserTOTP(ctx, userID)
+	if err != nil && err != pgx.ErrNoRows {
+		return Archive{}, err
+	}
+	if err == nil {
+		archive.TwoFactor = TwoFactor{Enabled: totp.ConfirmedAt.Valid, ConfirmedAt: totp.ConfirmedAt}
+	}
+
+	return archive, nil
+}
+
+// Erase removes the personal data of a user. The users row stays with
+// placeholder values so shifts keep pointing at it and payroll history adds
+// up, everything else about the user is deleted. Run it inside a transaction.
+func Erase(ctx context.Context, query *db.Queries, userID int32) error {
+	user, err := query.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	owned, err := query.CountGroupsOwnedByUser(ctx, pgtype.Int4{Int32: userID, Valid: true})
+	if err != nil {
+		return err
+	}
+	if owned > 0 {
+		return ErrOwnsGroups
+	}
+
+	err = query.DeleteUserLoginAttempts(ctx, db.DeleteUserLoginAttemptsParams{
+		UserID:   pgtype.Int4{Int32: userID, Valid: true},
+		Username: user.Username,
+		Email:    user.Email,
+	})
+	if err != nil {
+		return err
+	}
+
+	cleanups := []func(context.Context, int32) error{
+		query.DeleteUserSessions,
+		query.DeleteUserIdentities,
+		query.DeleteUserTokens,
+		query.DeleteUserTOTP,
+		query.DeleteRecoveryCodes,
+		query.DeleteUserMFAChallenges,
+		query.DeleteUserAPIKeys,
+		query.DeleteUserMemberships,
+		query.AnonymizeUser,
+	}
+	for _, cleanup := range cleanups {
+		err = cleanup(ctx, userID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
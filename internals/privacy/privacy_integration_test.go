@@ -0,0 +1,233 @@
+//go:build integration
+
+package privacy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+	db "github.com/joseph-gunnarsson/scheduling/db/models"
+	"github.com/joseph-gunnarsson/scheduling/internals/pgtest"
+)
+
+var server *pgtest.Server
+
+func TestMain(m *testing.M) {
+	var err error
+	server, err = pgtest.Start()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	code := m.Run()
+	err = server.Stop()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	os.Exit(code)
+}
+
+// Values only stored as secrets, none of them may show up in an export.
+const (
+	passwordHash  = "$argon2id$v=19$m=65536,t=3,p=2$c2FsdA$cGFzc3dvcmQ"
+	totpSecret    = "JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP"
+	apiKeyHash    = "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
+	refreshHash   = "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"
+	userTokenHash = "cccccccccccccccccccccccccccccccccccccccccccccccccccccccccccccccc"
+	recoveryHash  = "dddddddddddddddddddddddddddddddddddddddddddddddddddddddddddddddd"
+)
+
+type fixture struct {
+	user  db.CreateUserRow
+	owner db.CreateUserRow
+	group db.Group
+	shift db.Shift
+}
+
+// newFixture creates a user with a bit of everything: a session with a
+// refresh token, an identity, a pending token, an authenticator, an API key,
+// a membership, a shift and audit events made by and about them.
+func newFixture(t *testing.T, pool *pgxpool.Pool) fixture {
+	t.Helper()
+	ctx := context.Background()
+	query := db.New(pool)
+	var f fixture
+	var err error
+	must := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	later := pgtype.Timestamptz{Time: time.Now().Add(time.Hour), Valid: true}
+
+	f.user, err = query.CreateUser(ctx, db.CreateUserParams{
+		Username:     "alice",
+		Email:        "alice@example.com",
+		PasswordHash: passwordHash,
+		FirstName:    pgtype.Text{String: "Alice", Valid: true},
+	})
+	must(err)
+	f.owner, err = query.CreateUser(ctx, db.CreateUserParams{
+		Username:     "owner",
+		Email:        "owner@example.com",
+		PasswordHash: passwordHash,
+	})
+	must(err)
+
+	session, err := query.CreateSession(ctx, db.CreateSessionParams{UserID: f.user.ID, ExpiresAt: later})
+	must(err)
+	_, err = query.CreateRefreshToken(ctx, db.CreateRefreshTokenParams{SessionID: session.ID, TokenHash: refreshHash, ExpiresAt: later})
+	must(err)
+	_, err = query.CreateIdentity(ctx, db.CreateIdentityParams{UserID: f.user.ID, Issuer: "https://id.example.com", Subject: "alice"})
+	must(err)
+	_, err = query.CreateUserToken(ctx, db.CreateUserTokenParams{UserID: f.user.ID, Purpose: "password_reset", TokenHash: userTokenHash, ExpiresAt: later})
+	must(err)
+	_, err = query.UpsertUserTOTP(ctx, db.UpsertUserTOTPParams{UserID: f.user.ID, Secret: totpSecret})
+	must(err)
+	must(query.CreateRecoveryCode(ctx, db.CreateRecoveryCodeParams{UserID: f.user.ID, CodeHash: recoveryHash}))
+	_, err = query.CreateAPIKey(ctx, db.CreateAPIKeyParams{
+		UserID:    f.user.ID,
+		Name:      "reporting",
+		KeyPrefix: "sk_12345",
+		KeyHash:   apiKeyHash,
+		Scopes:    []string{"read:groups"},
+		CreatedBy: pgtype.Int4{Int32: f.user.ID, Valid: true},
+		ExpiresAt: later,
+	})
+	must(err)
+	must(query.CreateLoginAttempt(ctx, db.CreateLoginAttemptParams{
+		Identifier: "alice",
+		UserID:     pgtype.Int4{Int32: f.user.ID, Valid: true},
+		IpAddress:  "192.0.2.1",
+		Succeeded:  true,
+	}))
+
+	f.group, err = query.CreateGroup(ctx, db.CreateGroupParams{Name: "Kitchen", OwnerID: pgtype.Int4{Int32: f.owner.ID, Valid: true}})
+	must(err)
+	_, err = query.AddUserToGroup(ctx, db.AddUserToGroupParams{UserID: f.user.ID, GroupID: f.group.ID})
+	must(err)
+	start := time.Now().Add(24 * time.Hour)
+	f.shift, err = query.CreateShift(ctx, db.CreateShiftParams{
+		UserID:    pgtype.Int4{Int32: f.user.ID, Valid: true},
+		GroupID:   pgtype.Int4{Int32: f.group.ID, Valid: true},
+		Name:      "Morning",
+		StartTime: pgtype.Timestamptz{Time: start, Valid: true},
+		EndTime:   pgtype.Timestamptz{Time: start.Add(4 * time.Hour), Valid: true},
+	})
+	must(err)
+
+	for _, event := range []db.CreateAuditEventParams{
+		{ActorID: pgtype.Int4{Int32: f.user.ID, Valid: true}, Action: "user.update", EntityType: "user", EntityID: pgtype.Int4{Int32: f.user.ID, Valid: true}},
+		{ActorID: pgtype.Int4{Int32: f.owner.ID, Valid: true}, Action: "membership.add", EntityType: "membership", EntityID: pgtype.Int4{Int32: f.user.ID, Valid: true}, GroupID: pgtype.Int4{Int32: f.group.ID, Valid: true}},
+		{Action: "user.disable", EntityType: "user", EntityID: pgtype.Int4{Int32: f.user.ID, Valid: true}},
+		{ActorID: pgtype.Int4{Int32: f.owner.ID, Valid: true}, Action: "group.update", EntityType: "group", EntityID: pgtype.Int4{Int32: f.group.ID, Valid: true}, GroupID: pgtype.Int4{Int32: f.group.ID, Valid: true}},
+		{ActorID: pgtype.Int4{Int32: f.owner.ID, Valid: true}, Action: "user.update", EntityType: "user", EntityID: pgtype.Int4{Int32: f.owner.ID, Valid: true}},
+	} {
+		must(query.CreateAuditEvent(ctx, event))
+	}
+	return f
+}
+
+func TestExport(t *testing.T) {
+	pool := server.NewDatabase(t)
+	f := newFixture(t, pool)
+
+	archive, err := Export(context.Background(), db.New(pool), f.user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if archive.Profile.Username != "alice" || len(archive.Identities) != 1 || len(archive.Memberships) != 1 ||
+		len(archive.Shifts) != 1 || len(archive.Sessions) != 1 || len(archive.LoginAttempts) != 1 || len(archive.APIKeys) != 1 {
+		t.Fatalf("archive is missing data: %+v", archive)
+	}
+
+	// The user's own change, the membership and the disable by others, but
+	// not what the owner did to the group or their own account.
+	actions := map[string]bool{}
+	for _, event := range archive.AuditEvents {
+		actions[event.Action+" "+event.EntityType] = true
+	}
+	if len(archive.AuditEvents) != 3 || !actions["user.update user"] || !actions["membership.add membership"] || !actions["user.disable user"] {
+		t.Fatalf("audit events = %+v, want the three about alice", archive.AuditEvents)
+	}
+
+	data, err := json.Marshal(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{passwordHash, totpSecret, apiKeyHash, refreshHash, userTokenHash, recoveryHash, "password_hash", "key_hash", "token_hash"} {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("export contains %s", secret)
+		}
+	}
+}
+
+func TestErase(t *testing.T) {
+	ctx := context.Background()
+	pool := server.NewDatabase(t)
+	f := newFixture(t, pool)
+	query := db.New(pool)
+
+	err := Erase(ctx, query, f.owner.ID)
+	if err != ErrOwnsGroups {
+		t.Fatalf("erasing a group owner = %v, want %v", err, ErrOwnsGroups)
+	}
+
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback(ctx)
+	err = Erase(ctx, query.WithTx(tx), f.user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	erased, err := query.GetUserByID(ctx, f.user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if erased.Username == "alice" || strings.Contains(erased.Email, "alice") || erased.FirstName.Valid || erased.PasswordHash != "" {
+		t.Fatalf("user after erasing = %+v", erased)
+	}
+
+	shift, err := query.GetShiftByID(ctx, f.shift.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if shift.UserID.Int32 != f.user.ID {
+		t.Fatalf("shift after erasing = %+v, want it kept on the anonymised user", shift)
+	}
+
+	for _, table := range []string{"sessions", "identities", "user_tokens", "user_totp", "recovery_codes", "api_keys", "user_groups", "login_attempts"} {
+		var count int
+		err = pool.QueryRow(ctx, "SELECT count(*) FROM "+table+" WHERE user_id = $1", f.user.ID).Scan(&count)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != 0 {
+			t.Errorf("%d rows left in %s", count, table)
+		}
+	}
+	var refreshTokens int
+	err = pool.QueryRow(ctx, "SELECT count(*) FROM refresh_tokens WHERE token_hash = $1", refreshHash).Scan(&refreshTokens)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refreshTokens != 0 {
+		t.Error("the refresh token survived")
+	}
+}
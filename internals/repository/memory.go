@@ -0,0 +1,636 @@
+package repository
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+	db "github.com/joseph-gunnarsson/scheduling/db/models"
+)
+
+type membershipKey struct {
+	userID  int32
+	groupID int32
+}
+
+type memoryState struct {
+	users       map[int32]db.User
+	groups      map[int32]db.Group
+	memberships map[membershipKey]db.UserGroup
+	shifts      map[int32]db.Shift
+	auditEvents []db.AuditEvent
+	lastID      int32
+}
+
+func (s memoryState) clone() memoryState {
+	s.users = maps.Clone(s.users)
+	s.groups = maps.Clone(s.groups)
+	s.memberships = maps.Clone(s.memberships)
+	s.shifts = maps.Clone(s.shifts)
+	s.auditEvents = slices.Clone(s.auditEvents)
+	return s
+}
+
+// Memory is a Store that keeps its rows in maps. It answers like the
+// PostgreSQL queries do, including pgx.ErrNoRows for missing rows and
+// *pgconn.PgError for unique and foreign key violations, so handlers map
+// errors the same way. Transactions run one at a time and are undone by
+// restoring a copy of the state taken when they began. Writes outside a
+// transaction wait for it to end, so a rollback can't discard them, while
+// reads go ahead and may see its changes.
+type Memory struct {
+	txMu sync.Mutex
+	*memoryRows
+}
+
+// memoryRows runs the statements. Each one holds mu while it runs.
+type memoryRows struct {
+	mu    sync.Mutex
+	state memoryState
+}
+
+func NewMemory() *Memory {
+	return &Memory{memoryRows: &memoryRows{state: memoryState{
+		users:       map[int32]db.User{},
+		groups:      map[int32]db.Group{},
+		memberships: map[membershipKey]db.UserGroup{},
+		shifts:      map[int32]db.Shift{},
+	}}}
+}
+
+func (m *Memory) InTx(ctx context.Context, fn func(repos Repositories) error) error {
+	m.txMu.Lock()
+	defer m.txMu.Unlock()
+
+	m.mu.Lock()
+	saved := m.state.clone()
+	m.mu.Unlock()
+
+	err := fn(m.memoryRows)
+	if err != nil {
+		m.mu.Lock()
+		m.state = saved
+		m.mu.Unlock()
+	}
+	return err
+}
+
+func (m *Memory) CreateUser(ctx context.Context, arg db.CreateUserParams) (db.CreateUserRow, error) {
+	m.txMu.Lock()
+	defer m.txMu.Unlock()
+	return m.memoryRows.CreateUser(ctx, arg)
+}
+
+func (m *Memory) UpdateUserProfile(ctx context.Context, arg db.UpdateUserProfileParams) (db.User, error) {
+	m.txMu.Lock()
+	defer m.txMu.Unlock()
+	return m.memoryRows.UpdateUserProfile(ctx, arg)
+}
+
+func (m *Memory) CreateGroup(ctx context.Context, arg db.CreateGroupParams) (db.Group, error) {
+	m.txMu.Lock()
+	defer m.txMu.Unlock()
+	return m.memoryRows.CreateGroup(ctx, arg)
+}
+
+func (m *Memory) UpdateGroup(ctx context.Context, arg db.UpdateGroupParams) (db.Group, error) {
+	m.txMu.Lock()
+	defer m.txMu.Unlock()
+	return m.memoryRows.UpdateGroup(ctx, arg)
+}
+
+func (m *Memory) PatchGroup(ctx context.Context, arg db.PatchGroupParams) (db.Group, error) {
+	m.txMu.Lock()
+	defer m.txMu.Unlock()
+	return m.memoryRows.PatchGroup(ctx, arg)
+}
+
+func (m *Memory) DeleteGroup(ctx context.Context, arg db.DeleteGroupParams) (db.Group, error) {
+	m.txMu.Lock()
+	defer m.txMu.Unlock()
+	return m.memoryRows.DeleteGroup(ctx, arg)
+}
+
+func (m *Memory) DeleteGroupDescendants(ctx context.Context, arg db.DeleteGroupDescendantsParams) error {
+	m.txMu.Lock()
+	defer m.txMu.Unlock()
+	return m.memoryRows.DeleteGroupDescendants(ctx, arg)
+}
+
+func (m *Memory) AddUserToGroup(ctx context.Context, arg db.AddUserToGroupParams) (db.UserGroup, error) {
+	m.txMu.Lock()
+	defer m.txMu.Unlock()
+	return m.memoryRows.AddUserToGroup(ctx, arg)
+}
+
+func (m *Memory) DeleteUserFromGroup(ctx context.Context, arg db.DeleteUserFromGroupParams) error {
+	m.txMu.Lock()
+	defer m.txMu.Unlock()
+	return m.memoryRows.DeleteUserFromGroup(ctx, arg)
+}
+
+func (m *Memory) CreateShift(ctx context.Context, arg db.CreateShiftParams) (db.Shift, error) {
+	m.txMu.Lock()
+	defer m.txMu.Unlock()
+	return m.memoryRows.CreateShift(ctx, arg)
+}
+
+func (m *Memory) DeleteShift(ctx context.Context, arg db.DeleteShiftParams) (db.Shift, error) {
+	m.txMu.Lock()
+	defer m.txMu.Unlock()
+	return m.memoryRows.DeleteShift(ctx, arg)
+}
+
+func (m *Memory) DeleteGroupShifts(ctx context.Context, arg db.DeleteGroupShiftsParams) error {
+	m.txMu.Lock()
+	defer m.txMu.Unlock()
+	return m.memoryRows.DeleteGroupShifts(ctx, arg)
+}
+
+func (m *Memory) CreateAuditEvent(ctx context.Context, arg db.CreateAuditEventParams) error {
+	m.txMu.Lock()
+	defer m.txMu.Unlock()
+	return m.memoryRows.CreateAuditEvent(ctx, arg)
+}
+
+// AuditEvents returns the recorded events in the order they were written.
+func (m *Memory) AuditEvents() []db.AuditEvent {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Clone(m.state.auditEvents)
+}
+
+func (m *memoryRows) nextID() int32 {
+	m.state.lastID++
+	return m.state.lastID
+}
+
+// now is rounded to the microsecond precision PostgreSQL stores.
+func now() pgtype.Timestamptz {
+	return pgtype.Timestamptz{Time: time.Now().Truncate(time.Microsecond), Valid: true}
+}
+
+func uniqueViolation(constraint string) error {
+	return &pgconn.PgError{Code: "23505", ConstraintName: constraint, Message: "duplicate key value violates unique constraint"}
+}
+
+func foreignKeyViolation(constraint string) error {
+	return &pgconn.PgError{Code: "23503", ConstraintName: constraint, Message: "insert or update violates foreign key constraint"}
+}
+
+func versionMatches(expected pgtype.Int4, version int32) bool {
+	return !expected.Valid || expected.Int32 == version
+}
+
+func (m *memoryRows) CreateUser(ctx context.Context, arg db.CreateUserParams) (db.CreateUserRow, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, user := range m.state.users {
+		if user.Username == arg.Username {
+			return db.CreateUserRow{}, uniqueViolation("users_username_key")
+		}
+		if user.Email == arg.Email {
+			return db.CreateUserRow{}, uniqueViolation("users_email_key")
+		}
+	}
+
+	createdAt := now()
+	user := db.User{
+		ID:           m.nextID(),
+		Username:     arg.Username,
+		Email:        arg.Email,
+		PasswordHash: arg.PasswordHash,
+		FirstName:    arg.FirstName,
+		LastName:     arg.LastName,
+		CreatedAt:    createdAt,
+		UpdatedAt:    createdAt,
+	}
+	m.state.users[user.ID] = user
+
+	return db.CreateUserRow{
+		ID:        user.ID,
+		Username:  user.Username,
+		Email:     user.Email,
+		FirstName: user.FirstName,
+		LastName:  user.LastName,
+		CreatedAt: user.CreatedAt,
+		UpdatedAt: user.UpdatedAt,
+	}, nil
+}
+
+func (m *memoryRows) GetUserByID(ctx context.Context, id int32) (db.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.state.users[id]
+	if !ok {
+		return db.User{}, pgx.ErrNoRows
+	}
+	return user, nil
+}
+
+func (m *memoryRows) GetUserByEmail(ctx context.Context, email string) (db.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, user := range m.state.users {
+		if user.Email == email {
+			return user, nil
+		}
+	}
+	return db.User{}, pgx.ErrNoRows
+}
+
+func (m *memoryRows) UpdateUserProfile(ctx context.Context, arg db.UpdateUserProfileParams) (db.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.state.users[arg.ID]
+	if !ok {
+		return db.User{}, pgx.ErrNoRows
+	}
+	if arg.Username.Valid {
+		for _, other := range m.state.users {
+			if other.ID != user.ID && other.Username == arg.Username.String {
+				return db.User{}, uniqueViolation("users_username_key")
+			}
+		}
+		user.Username = arg.Username.String
+	}
+	if arg.FirstName.Valid {
+		user.FirstName = arg.FirstName
+	}
+	if arg.LastName.Valid {
+		user.LastName = arg.LastName
+	}
+	user.UpdatedAt = now()
+	m.state.users[user.ID] = user
+	return user, nil
+}
+
+// liveGroup must be called with mu held.
+func (m *memoryRows) liveGroup(id int32) (db.Group, bool) {
+	group, ok := m.state.groups[id]
+	if !ok || group.DeletedAt.Valid {
+		return db.Group{}, false
+	}
+	return group, true
+}
+
+func (m *memoryRows) CreateGroup(ctx context.Context, arg db.CreateGroupParams) (db.Group, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if arg.OwnerID.Valid {
+		if _, ok := m.state.users[arg.OwnerID.Int32]; !ok {
+			return db.Group{}, foreignKeyViolation("groups_owner_id_fkey")
+		}
+	}
+	if arg.ParentID.Valid {
+		if _, ok := m.state.groups[arg.ParentID.Int32]; !ok {
+			return db.Group{}, foreignKeyViolation("groups_parent_id_fkey")
+		}
+	}
+
+	createdAt := now()
+	group := db.Group{
+		ID:          m.nextID(),
+		Name:        arg.Name,
+		Description: arg.Description,
+		OwnerID:     arg.OwnerID,
+		CreatedAt:   createdAt,
+		UpdatedAt:   createdAt,
+		ParentID:    arg.ParentID,
+		Version:     1,
+	}
+	m.state.groups[group.ID] = group
+	return group, nil
+}
+
+func (m *memoryRows) GetGroupByID(ctx context.Context, id int32) (db.Group, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	group, ok := m.liveGroup(id)
+	if !ok {
+		return db.Group{}, pgx.ErrNoRows
+	}
+	return group, nil
+}
+
+func (m *memoryRows) GetGroupsByOwner(ctx context.Context, ownerID pgtype.Int4) ([]db.Group, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var groups []db.Group
+	for _, group := range m.state.groups {
+		if ownerID.Valid && group.OwnerID.Valid && group.OwnerID.Int32 == ownerID.Int32 && !group.DeletedAt.Valid {
+			groups = append(groups, group)
+		}
+	}
+	slices.SortFunc(groups, func(a, b db.Group) int {
+		if c := b.CreatedAt.Time.Compare(a.CreatedAt.Time); c != 0 {
+			return c
+		}
+		return int(b.ID - a.ID)
+	})
+	return groups, nil
+}
+
+func (m *memoryRows) UpdateGroup(ctx context.Context, arg db.UpdateGroupParams) (db.Group, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	group, ok := m.liveGroup(arg.ID)
+	if !ok || !versionMatches(arg.ExpectedVersion, group.Version) {
+		return db.Group{}, pgx.ErrNoRows
+	}
+	group.Name = arg.Name
+	group.Description = arg.Description
+	group.Version++
+	group.UpdatedAt = now()
+	m.state.groups[group.ID] = group
+	return group, nil
+}
+
+func (m *memoryRows) PatchGroup(ctx context.Context, arg db.PatchGroupParams) (db.Group, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	group, ok := m.liveGroup(arg.ID)
+	if !ok || !versionMatches(arg.ExpectedVersion, group.Version) {
+		return db.Group{}, pgx.ErrNoRows
+	}
+	if arg.Name.Valid {
+		group.Name = arg.Name.String
+	}
+	if arg.Description.Valid {
+		group.Description = arg.Description
+	}
+	group.Version++
+	group.UpdatedAt = now()
+	m.state.groups[group.ID] = group
+	return group, nil
+}
+
+func (m *memoryRows) DeleteGroup(ctx context.Context, arg db.DeleteGroupParams) (db.Group, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	group, ok := m.liveGroup(arg.ID)
+	if !ok || !versionMatches(arg.ExpectedVersion, group.Version) {
+		return db.Group{}, pgx.ErrNoRows
+	}
+	group.DeletedAt = now()
+	group.Version++
+	group.UpdatedAt = group.DeletedAt
+	m.state.groups[group.ID] = group
+	return group, nil
+}
+
+func (m *memoryRows) DeleteGroupDescendants(ctx context.Context, arg db.DeleteGroupDescendantsParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, id := range m.descendants(arg.RootID, func(group db.Group) bool { return !group.DeletedAt.Valid }) {
+		group := m.state.groups[id]
+		group.DeletedAt = arg.DeletedAt
+		group.Version++
+		group.UpdatedAt = now()
+		m.state.groups[id] = group
+	}
+	return nil
+}
+
+// descendants lists the sub-groups of rootID reached through groups that
+// match follow, like the recursive queries of group.sql.
+func (m *memoryRows) descendants(rootID int32, follow func(group db.Group) bool) []int32 {
+	var found []int32
+	parents := []int32{rootID}
+	for len(parents) > 0 {
+		parent := parents[0]
+		parents = parents[1:]
+		for id, group := range m.state.groups {
+			if group.ParentID.Valid && group.ParentID.Int32 == parent && follow(group) {
+				found = append(found, id)
+				parents = append(parents, id)
+			}
+		}
+	}
+	return found
+}
+
+func (m *memoryRows) GetDeletedGroupByID(ctx context.Context, id int32) (db.Group, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	group, ok := m.state.groups[id]
+	if !ok || !group.DeletedAt.Valid {
+		return db.Group{}, pgx.ErrNoRows
+	}
+	return group, nil
+}
+
+func (m *memoryRows) AddUserToGroup(ctx context.Context, arg db.AddUserToGroupParams) (db.UserGroup, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.state.users[arg.UserID]; !ok {
+		return db.UserGroup{}, foreignKeyViolation("user_groups_user_id_fkey")
+	}
+	if _, ok := m.state.groups[arg.GroupID]; !ok {
+		return db.UserGroup{}, foreignKeyViolation("user_groups_group_id_fkey")
+	}
+	key := membershipKey{userID: arg.UserID, groupID: arg.GroupID}
+	if _, ok := m.state.memberships[key]; ok {
+		return db.UserGroup{}, uniqueViolation("user_groups_pkey")
+	}
+
+	membership := db.UserGroup{UserID: arg.UserID, GroupID: arg.GroupID, JoinedAt: now()}
+	m.state.memberships[key] = membership
+	return membership, nil
+}
+
+func (m *memoryRows) DeleteUserFromGroup(ctx context.Context, arg db.DeleteUserFromGroupParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.state.memberships, membershipKey{userID: arg.UserID, groupID: arg.GroupID})
+	return nil
+}
+
+func (m *memoryRows) GetUserGroups(ctx context.Context, userID int32) ([]db.GetUserGroupsRow, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var rows []db.GetUserGroupsRow
+	for key, membership := range m.state.memberships {
+		if key.userID != userID {
+			continue
+		}
+		group, ok := m.liveGroup(key.groupID)
+		if !ok {
+			continue
+		}
+		rows = append(rows, db.GetUserGroupsRow{
+			GroupID:          group.ID,
+			GroupName:        group.Name,
+			GroupDescription: group.Description,
+			GroupOwnerID:     group.OwnerID,
+			GroupCreatedAt:   group.CreatedAt,
+			GroupUpdatedAt:   group.UpdatedAt,
+			UserJoinedAt:     membership.JoinedAt,
+		})
+	}
+	slices.SortFunc(rows, func(a, b db.GetUserGroupsRow) int { return int(a.GroupID - b.GroupID) })
+	return rows, nil
+}
+
+func (m *memoryRows) GetGroupMembers(ctx context.Context, groupID int32) ([]db.GetGroupMembersRow, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.liveGroup(groupID); !ok {
+		return nil, nil
+	}
+
+	var rows []db.GetGroupMembersRow
+	for key, membership := range m.state.memberships {
+		if key.groupID != groupID {
+			continue
+		}
+		user := m.state.users[key.userID]
+		rows = append(rows, db.GetGroupMembersRow{
+			UserID:        user.ID,
+			Username:      user.Username,
+			Email:         user.Email,
+			FirstName:     user.FirstName,
+			LastName:      user.LastName,
+			UserCreatedAt: user.CreatedAt,
+			UserUpdatedAt: user.UpdatedAt,
+			JoinedAt:      membership.JoinedAt,
+		})
+	}
+	slices.SortFunc(rows, func(a, b db.GetGroupMembersRow) int { return int(a.UserID - b.UserID) })
+	return rows, nil
+}
+
+func (m *memoryRows) CreateShift(ctx context.Context, arg db.CreateShiftParams) (db.Shift, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if arg.UserID.Valid {
+		if _, ok := m.state.users[arg.UserID.Int32]; !ok {
+			return db.Shift{}, foreignKeyViolation("shifts_user_id_fkey")
+		}
+	}
+	if arg.GroupID.Valid {
+		if _, ok := m.state.groups[arg.GroupID.Int32]; !ok {
+			return db.Shift{}, foreignKeyViolation("shifts_group_id_fkey")
+		}
+	}
+
+	createdAt := now()
+	shift := db.Shift{
+		ID:        m.nextID(),
+		UserID:    arg.UserID,
+		GroupID:   arg.GroupID,
+		Name:      arg.Name,
+		StartTime: arg.StartTime,
+		EndTime:   arg.EndTime,
+		CreatedAt: createdAt,
+		UpdatedAt: createdAt,
+		Version:   1,
+	}
+	m.state.shifts[shift.ID] = shift
+	return shift, nil
+}
+
+func (m *memoryRows) GetShiftByID(ctx context.Context, id int32) (db.Shift, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	shift, ok := m.state.shifts[id]
+	if !ok || shift.DeletedAt.Valid {
+		return db.Shift{}, pgx.ErrNoRows
+	}
+	return shift, nil
+}
+
+func (m *memoryRows) ListShiftsByGroup(ctx context.Context, groupID pgtype.Int4) ([]db.Shift, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var shifts []db.Shift
+	for _, shift := range m.state.shifts {
+		if groupID.Valid && shift.GroupID.Valid && shift.GroupID.Int32 == groupID.Int32 && !shift.DeletedAt.Valid {
+			shifts = append(shifts, shift)
+		}
+	}
+	slices.SortFunc(shifts, func(a, b db.Shift) int {
+		if c := a.StartTime.Time.Compare(b.StartTime.Time); c != 0 {
+			return c
+		}
+		return int(a.ID - b.ID)
+	})
+	return shifts, nil
+}
+
+func (m *memoryRows) DeleteShift(ctx context.Context, arg db.DeleteShiftParams) (db.Shift, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	shift, ok := m.state.shifts[arg.ID]
+	if !ok || shift.DeletedAt.Valid || !arg.GroupID.Valid || shift.GroupID != arg.GroupID ||
+		!versionMatches(arg.ExpectedVersion, shift.Version) {
+		return db.Shift{}, pgx.ErrNoRows
+	}
+	shift.DeletedAt = now()
+	shift.Version++
+	m.state.shifts[shift.ID] = shift
+	return shift, nil
+}
+
+func (m *memoryRows) DeleteGroupShifts(ctx context.Context, arg db.DeleteGroupShiftsParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	groupIDs := []int32{arg.GroupID}
+	groupIDs = append(groupIDs, m.descendants(arg.GroupID, func(group db.Group) bool {
+		return group.DeletedAt.Valid && group.DeletedAt.Time.Equal(arg.DeletedAt.Time)
+	})...)
+	for id, shift := range m.state.shifts {
+		if shift.GroupID.Valid && slices.Contains(groupIDs, shift.GroupID.Int32) && !shift.DeletedAt.Valid {
+			shift.DeletedAt = arg.DeletedAt
+			shift.Version++
+			m.state.shifts[id] = shift
+		}
+	}
+	return nil
+}
+
+func (m *memoryRows) CreateAuditEvent(ctx context.Context, arg db.CreateAuditEventParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.state.auditEvents = append(m.state.auditEvents, db.AuditEvent{
+		ID:         int64(len(m.state.auditEvents) + 1),
+		ActorID:    arg.ActorID,
+		Action:     arg.Action,
+		EntityType: arg.EntityType,
+		EntityID:   arg.EntityID,
+		GroupID:    arg.GroupID,
+		Before:     arg.Before,
+		After:      arg.After,
+		RequestID:  arg.RequestID,
+		IpAddress:  arg.IpAddress,
+		CreatedAt:  now(),
+	})
+	return nil
+}
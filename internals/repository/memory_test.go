@@ -0,0 +1,58 @@
+package repository_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	db "github.com/joseph-gunnarsson/scheduling/db/models"
+	"github.com/joseph-gunnarsson/scheduling/internals/repository"
+	"github.com/joseph-gunnarsson/scheduling/internals/repository/repositorytest"
+)
+
+func TestMemoryStore(t *testing.T) {
+	repositorytest.Run(t, func(t *testing.T) repository.Store {
+		return repository.NewMemory()
+	})
+}
+
+func TestMemoryWriteDuringTransaction(t *testing.T) {
+	ctx := context.Background()
+	store := repository.NewMemory()
+	failure := errors.New("rolled back")
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	rolledBack := make(chan error)
+	go func() {
+		rolledBack <- store.InTx(ctx, func(repos repository.Repositories) error {
+			close(started)
+			<-release
+			return failure
+		})
+	}()
+	<-started
+
+	written := make(chan error)
+	go func() {
+		_, err := store.CreateUser(ctx, db.CreateUserParams{Username: "alice", Email: "alice@example.com", PasswordHash: "hash"})
+		written <- err
+	}()
+	select {
+	case <-written:
+		t.Fatal("CreateUser ran while a transaction was open")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(release)
+	if err := <-rolledBack; !errors.Is(err, failure) {
+		t.Fatalf("InTx = %v, want %v", err, failure)
+	}
+	if err := <-written; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.GetUserByEmail(ctx, "alice@example.com"); err != nil {
+		t.Errorf("write made during a rolled back transaction was lost: %v", err)
+	}
+}
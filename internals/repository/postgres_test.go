@@ -0,0 +1,37 @@
+//go:build integration
+
+package repository_test
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/joseph-gunnarsson/scheduling/internals/pgtest"
+	"github.com/joseph-gunnarsson/scheduling/internals/repository"
+	"github.com/joseph-gunnarsson/scheduling/internals/repository/repositorytest"
+)
+
+var server *pgtest.Server
+
+func TestMain(m *testing.M) {
+	var err error
+	server, err = pgtest.Start()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	code := m.Run()
+	err = server.Stop()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	os.Exit(code)
+}
+
+func TestPostgresStore(t *testing.T) {
+	repositorytest.Run(t, func(t *testing.T) repository.Store {
+		return repository.NewPostgres(server.NewDatabase(t))
+	})
+}
@@ -0,0 +1,85 @@
+// Package repository describes the storage that the user, group, membership
+// and shift services need. The method sets match the generated queries, so
+// PostgreSQL is served by *db.Queries directly, while Memory keeps everything
+// in process for tests that should not need a database.
+package repository
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	database "github.com/joseph-gunnarsson/scheduling/db"
+	db "github.com/joseph-gunnarsson/scheduling/db/models"
+)
+
+type Users interface {
+	CreateUser(ctx context.Context, arg db.CreateUserParams) (db.CreateUserRow, error)
+	GetUserByID(ctx context.Context, id int32) (db.User, error)
+	GetUserByEmail(ctx context.Context, email string) (db.User, error)
+	UpdateUserProfile(ctx context.Context, arg db.UpdateUserProfileParams) (db.User, error)
+}
+
+// Groups only ever sees groups that are not deleted, except for
+// GetDeletedGroupByID. Update, patch and delete fail with pgx.ErrNoRows when
+// ExpectedVersion is set and no longer matches.
+type Groups interface {
+	CreateGroup(ctx context.Context, arg db.CreateGroupParams) (db.Group, error)
+	GetGroupByID(ctx context.Context, id int32) (db.Group, error)
+	GetGroupsByOwner(ctx context.Context, ownerID pgtype.Int4) ([]db.Group, error)
+	UpdateGroup(ctx context.Context, arg db.UpdateGroupParams) (db.Group, error)
+	PatchGroup(ctx context.Context, arg db.PatchGroupParams) (db.Group, error)
+	DeleteGroup(ctx context.Context, arg db.DeleteGroupParams) (db.Group, error)
+	DeleteGroupDescendants(ctx context.Context, arg db.DeleteGroupDescendantsParams) error
+	GetDeletedGroupByID(ctx context.Context, id int32) (db.Group, error)
+}
+
+type Memberships interface {
+	AddUserToGroup(ctx context.Context, arg db.AddUserToGroupParams) (db.UserGroup, error)
+	DeleteUserFromGroup(ctx context.Context, arg db.DeleteUserFromGroupParams) error
+	GetUserGroups(ctx context.Context, userID int32) ([]db.GetUserGroupsRow, error)
+	GetGroupMembers(ctx context.Context, groupID int32) ([]db.GetGroupMembersRow, error)
+}
+
+type Shifts interface {
+	CreateShift(ctx context.Context, arg db.CreateShiftParams) (db.Shift, error)
+	GetShiftByID(ctx context.Context, id int32) (db.Shift, error)
+	ListShiftsByGroup(ctx context.Context, groupID pgtype.Int4) ([]db.Shift, error)
+	DeleteShift(ctx context.Context, arg db.DeleteShiftParams) (db.Shift, error)
+	DeleteGroupShifts(ctx context.Context, arg db.DeleteGroupShiftsParams) error
+}
+
+type Audit interface {
+	CreateAuditEvent(ctx context.Context, arg db.CreateAuditEventParams) error
+}
+
+// Repositories is everything a unit of work can read and change.
+type Repositories interface {
+	Users
+	Groups
+	Memberships
+	Shifts
+	Audit
+}
+
+// Store runs single statements on its own and groups of them with InTx. The
+// changes made by fn are kept only when it returns nil.
+type Store interface {
+	Repositories
+	InTx(ctx context.Context, fn func(repos Repositories) error) error
+}
+
+type postgresStore struct {
+	*db.Queries
+	conn database.TxBeginner
+}
+
+// NewPostgres returns a Store on a pool, a connection or an open transaction.
+func NewPostgres(conn database.TxBeginner) Store {
+	return &postgresStore{Queries: db.New(conn), conn: conn}
+}
+
+func (s *postgresStore) InTx(ctx context.Context, fn func(repos Repositories) error) error {
+	return database.InTx(ctx, s.conn, func(query *db.Queries) error {
+		return fn(query)
+	})
+}
@@ -0,0 +1,549 @@
+// Package repositorytest is the contract every repository.Store has to meet.
+// Run it from a test of each implementation so the in-memory store used by
+// handler tests keeps behaving like PostgreSQL.
+package repositorytest
+
+import (
+	"context"
+	stderrors "errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+	db "github.com/joseph-gunnarsson/scheduling/db/models"
+	"github.com/joseph-gunnarsson/scheduling/internals/repository"
+)
+
+// NewStore returns an empty store. It is called once per subtest.
+type NewStore func(t *testing.T) repository.Store
+
+// Run runs the contract against stores made by newStore.
+func Run(t *testing.T, newStore NewStore) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, store repository.Store)
+	}{
+		{"Users", testUsers},
+		{"Groups", testGroups},
+		{"GroupVersions", testGroupVersions},
+		{"GroupSubtreeDelete", testGroupSubtreeDelete},
+		{"Memberships", testMemberships},
+		{"Shifts", testShifts},
+		{"TransactionCommits", testTransactionCommits},
+		{"TransactionRollsBack", testTransactionRollsBack},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, newStore(t))
+		})
+	}
+}
+
+// CreateUser adds a user with a username and email derived from name.
+func CreateUser(t *testing.T, store repository.Users, name string) db.User {
+	t.Helper()
+	ctx := context.Background()
+	created, err := store.CreateUser(ctx, db.CreateUserParams{
+		Username:     name,
+		Email:        name + "@example.com",
+		PasswordHash: "not-a-real-hash",
+	})
+	if err != nil {
+		t.Fatalf("CreateUser(%q): %v", name, err)
+	}
+	user, err := store.GetUserByID(ctx, created.ID)
+	if err != nil {
+		t.Fatalf("GetUserByID(%d): %v", created.ID, err)
+	}
+	return user
+}
+
+// CreateGroup adds a root group owned by owner.
+func CreateGroup(t *testing.T, store repository.Groups, owner db.User, name string) db.Group {
+	t.Helper()
+	group, err := store.CreateGroup(context.Background(), db.CreateGroupParams{
+		Name:    name,
+		OwnerID: pgtype.Int4{Int32: owner.ID, Valid: true},
+	})
+	if err != nil {
+		t.Fatalf("CreateGroup(%q): %v", name, err)
+	}
+	return group
+}
+
+func pgCode(err error) string {
+	var pgErr *pgconn.PgError
+	if stderrors.As(err, &pgErr) {
+		return pgErr.Code
+	}
+	return ""
+}
+
+func expectNoRows(t *testing.T, err error, what string) {
+	t.Helper()
+	if !stderrors.Is(err, pgx.ErrNoRows) {
+		t.Fatalf("%s: got error %v, want pgx.ErrNoRows", what, err)
+	}
+}
+
+func expectCode(t *testing.T, err error, code, what string) {
+	t.Helper()
+	if pgCode(err) != code {
+		t.Fatalf("%s: got error %v, want SQLSTATE %s", what, err, code)
+	}
+}
+
+func at(hour int) pgtype.Timestamptz {
+	return pgtype.Timestamptz{Time: time.Date(2030, 1, 1, hour, 0, 0, 0, time.UTC), Valid: true}
+}
+
+func testUsers(t *testing.T, store repository.Store) {
+	ctx := context.Background()
+	alice := CreateUser(t, store, "alice")
+	if alice.Username != "alice" || alice.Email != "alice@example.com" || !alice.CreatedAt.Valid {
+		t.Fatalf("unexpected user %+v", alice)
+	}
+
+	byEmail, err := store.GetUserByEmail(ctx, "alice@example.com")
+	if err != nil || byEmail.ID != alice.ID {
+		t.Fatalf("GetUserByEmail = %d, %v, want %d", byEmail.ID, err, alice.ID)
+	}
+
+	_, err = store.CreateUser(ctx, db.CreateUserParams{Username: "alice", Email: "other@example.com", PasswordHash: "x"})
+	expectCode(t, err, "23505", "duplicate username")
+	_, err = store.CreateUser(ctx, db.CreateUserParams{Username: "other", Email: "alice@example.com", PasswordHash: "x"})
+	expectCode(t, err, "23505", "duplicate email")
+
+	_, err = store.GetUserByID(ctx, alice.ID+1000)
+	expectNoRows(t, err, "GetUserByID of a missing user")
+	_, err = store.GetUserByEmail(ctx, "nobody@example.com")
+	expectNoRows(t, err, "GetUserByEmail of a missing user")
+
+	updated, err := store.UpdateUserProfile(ctx, db.UpdateUserProfileParams{
+		ID:        alice.ID,
+		FirstName: pgtype.Text{String: "Alice", Valid: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Username != "alice" || updated.FirstName.String != "Alice" || updated.LastName.Valid {
+		t.Fatalf("UpdateUserProfile changed more than the first name: %+v", updated)
+	}
+	CreateUser(t, store, "bob")
+	_, err = store.UpdateUserProfile(ctx, db.UpdateUserProfileParams{
+		ID:       alice.ID,
+		Username: pgtype.Text{String: "bob", Valid: true},
+	})
+	expectCode(t, err, "23505", "UpdateUserProfile to a taken username")
+	_, err = store.UpdateUserProfile(ctx, db.UpdateUserProfileParams{ID: alice.ID + 1000})
+	expectNoRows(t, err, "UpdateUserProfile of a missing user")
+}
+
+func testGroups(t *testing.T, store repository.Store) {
+	ctx := context.Background()
+	alice := CreateUser(t, store, "alice")
+	bob := CreateUser(t, store, "bob")
+
+	group := CreateGroup(t, store, alice, "Kitchen")
+	if group.Version != 1 || group.DeletedAt.Valid || group.OwnerID.Int32 != alice.ID {
+		t.Fatalf("unexpected group %+v", group)
+	}
+	child, err := store.CreateGroup(ctx, db.CreateGroupParams{
+		Name:     "Bar",
+		OwnerID:  pgtype.Int4{Int32: alice.ID, Valid: true},
+		ParentID: pgtype.Int4{Int32: group.ID, Valid: true},
+	})
+	if err != nil {
+		t.Fatalf("CreateGroup with parent: %v", err)
+	}
+	CreateGroup(t, store, bob, "Floor")
+
+	_, err = store.CreateGroup(ctx, db.CreateGroupParams{Name: "Orphan", OwnerID: pgtype.Int4{Int32: bob.ID + 1000, Valid: true}})
+	expectCode(t, err, "23503", "group with a missing owner")
+
+	got, err := store.GetGroupByID(ctx, group.ID)
+	if err != nil || got != group {
+		t.Fatalf("GetGroupByID = %+v, %v, want %+v", got, err, group)
+	}
+
+	owned, err := store.GetGroupsByOwner(ctx, pgtype.Int4{Int32: alice.ID, Valid: true})
+	if err != nil {
+		t.Fatalf("GetGroupsByOwner: %v", err)
+	}
+	if len(owned) != 2 {
+		t.Fatalf("GetGroupsByOwner returned %d groups, want 2", len(owned))
+	}
+
+	deleted, err := store.DeleteGroup(ctx, db.DeleteGroupParams{ID: child.ID})
+	if err != nil {
+		t.Fatalf("DeleteGroup: %v", err)
+	}
+	if !deleted.DeletedAt.Valid || deleted.Version != child.Version+1 {
+		t.Fatalf("DeleteGroup returned %+v", deleted)
+	}
+	_, err = store.GetGroupByID(ctx, child.ID)
+	expectNoRows(t, err, "GetGroupByID of a deleted group")
+	_, err = store.DeleteGroup(ctx, db.DeleteGroupParams{ID: child.ID})
+	expectNoRows(t, err, "DeleteGroup of a deleted group")
+
+	owned, err = store.GetGroupsByOwner(ctx, pgtype.Int4{Int32: alice.ID, Valid: true})
+	if err != nil || len(owned) != 1 || owned[0].ID != group.ID {
+		t.Fatalf("GetGroupsByOwner after delete = %+v, %v", owned, err)
+	}
+}
+
+func testGroupVersions(t *testing.T, store repository.Store) {
+	ctx := context.Background()
+	group := CreateGroup(t, store, CreateUser(t, store, "alice"), "Kitchen")
+	stale := pgtype.Int4{Int32: group.Version, Valid: true}
+
+	updated, err := store.UpdateGroup(ctx, db.UpdateGroupParams{
+		ID:              group.ID,
+		Name:            "Main kitchen",
+		Description:     pgtype.Text{String: "Ground floor", Valid: true},
+		ExpectedVersion: stale,
+	})
+	if err != nil {
+		t.Fatalf("UpdateGroup: %v", err)
+	}
+	if updated.Name != "Main kitchen" || updated.Description.String != "Ground floor" || updated.Version != group.Version+1 {
+		t.Fatalf("UpdateGroup returned %+v", updated)
+	}
+
+	_, err = store.UpdateGroup(ctx, db.UpdateGroupParams{ID: group.ID, Name: "Lost update", ExpectedVersion: stale})
+	expectNoRows(t, err, "UpdateGroup with a stale version")
+	_, err = store.PatchGroup(ctx, db.PatchGroupParams{ID: group.ID, ExpectedVersion: stale})
+	expectNoRows(t, err, "PatchGroup with a stale version")
+	_, err = store.DeleteGroup(ctx, db.DeleteGroupParams{ID: group.ID, ExpectedVersion: stale})
+	expectNoRows(t, err, "DeleteGroup with a stale version")
+
+	patched, err := store.PatchGroup(ctx, db.PatchGroupParams{
+		ID:              group.ID,
+		Name:            pgtype.Text{String: "Kitchen", Valid: true},
+		ExpectedVersion: pgtype.Int4{Int32: updated.Version, Valid: true},
+	})
+	if err != nil {
+		t.Fatalf("PatchGroup: %v", err)
+	}
+	if patched.Name != "Kitchen" || patched.Description != updated.Description || patched.Version != updated.Version+1 {
+		t.Fatalf("PatchGroup returned %+v", patched)
+	}
+
+	unchecked, err := store.UpdateGroup(ctx, db.UpdateGroupParams{ID: group.ID, Name: "Unchecked"})
+	if err != nil || unchecked.Version != patched.Version+1 {
+		t.Fatalf("UpdateGroup without a version = %+v, %v", unchecked, err)
+	}
+}
+
+func testGroupSubtreeDelete(t *testing.T, store repository.Store) {
+	ctx := context.Background()
+	alice := CreateUser(t, store, "alice")
+	restaurant := CreateGroup(t, store, alice, "Restaurant")
+	child := func(parent db.Group, name string) db.Group {
+		t.Helper()
+		group, err := store.CreateGroup(ctx, db.CreateGroupParams{
+			Name:     name,
+			OwnerID:  pgtype.Int4{Int32: alice.ID, Valid: true},
+			ParentID: pgtype.Int4{Int32: parent.ID, Valid: true},
+		})
+		if err != nil {
+			t.Fatalf("CreateGroup(%q): %v", name, err)
+		}
+		return group
+	}
+	kitchen := child(restaurant, "Kitchen")
+	pastry := child(kitchen, "Pastry")
+	floor := child(restaurant, "Floor")
+	other := CreateGroup(t, store, alice, "Bar")
+	shift, err := store.CreateShift(ctx, db.CreateShiftParams{
+		GroupID:   pgtype.Int4{Int32: pastry.ID, Valid: true},
+		Name:      "Baking",
+		StartTime: at(6),
+		EndTime:   at(10),
+	})
+	if err != nil {
+		t.Fatalf("CreateShift: %v", err)
+	}
+
+	// A sub-group deleted on its own keeps its own deleted_at.
+	floorDeleted, err := store.DeleteGroup(ctx, db.DeleteGroupParams{ID: floor.ID})
+	if err != nil {
+		t.Fatalf("DeleteGroup(floor): %v", err)
+	}
+
+	deleted, err := store.DeleteGroup(ctx, db.DeleteGroupParams{ID: restaurant.ID})
+	if err != nil {
+		t.Fatalf("DeleteGroup: %v", err)
+	}
+	if !deleted.UpdatedAt.Time.Equal(deleted.DeletedAt.Time) {
+		t.Fatalf("DeleteGroup returned updated_at %v, want the deletion time %v", deleted.UpdatedAt.Time, deleted.DeletedAt.Time)
+	}
+	err = store.DeleteGroupDescendants(ctx, db.DeleteGroupDescendantsParams{RootID: restaurant.ID, DeletedAt: deleted.DeletedAt})
+	if err != nil {
+		t.Fatalf("DeleteGroupDescendants: %v", err)
+	}
+	err = store.DeleteGroupShifts(ctx, db.DeleteGroupShiftsParams{GroupID: restaurant.ID, DeletedAt: deleted.DeletedAt})
+	if err != nil {
+		t.Fatalf("DeleteGroupShifts: %v", err)
+	}
+
+	for _, group := range []db.Group{kitchen, pastry} {
+		got, err := store.GetDeletedGroupByID(ctx, group.ID)
+		if err != nil || !got.DeletedAt.Time.Equal(deleted.DeletedAt.Time) || got.Version != group.Version+1 {
+			t.Fatalf("GetDeletedGroupByID(%s) = %+v, %v, want it deleted with the restaurant", group.Name, got, err)
+		}
+	}
+	got, err := store.GetDeletedGroupByID(ctx, floor.ID)
+	if err != nil || got.Version != floorDeleted.Version || !got.DeletedAt.Time.Equal(floorDeleted.DeletedAt.Time) {
+		t.Fatalf("GetDeletedGroupByID(floor) = %+v, %v, want it unchanged", got, err)
+	}
+	_, err = store.GetShiftByID(ctx, shift.ID)
+	expectNoRows(t, err, "GetShiftByID of a shift in a deleted sub-group")
+	_, err = store.GetGroupByID(ctx, other.ID)
+	if err != nil {
+		t.Fatalf("GetGroupByID of an unrelated group: %v", err)
+	}
+	_, err = store.GetDeletedGroupByID(ctx, other.ID)
+	expectNoRows(t, err, "GetDeletedGroupByID of a live group")
+}
+
+func testMemberships(t *testing.T, store repository.Store) {
+	ctx := context.Background()
+	alice := CreateUser(t, store, "alice")
+	bob := CreateUser(t, store, "bob")
+	kitchen := CreateGroup(t, store, alice, "Kitchen")
+	floor := CreateGroup(t, store, alice, "Floor")
+
+	for _, membership := range []db.AddUserToGroupParams{
+		{UserID: bob.ID, GroupID: kitchen.ID},
+		{UserID: bob.ID, GroupID: floor.ID},
+		{UserID: alice.ID, GroupID: kitchen.ID},
+	} {
+		added, err := store.AddUserToGroup(ctx, membership)
+		if err != nil {
+			t.Fatalf("AddUserToGroup(%+v): %v", membership, err)
+		}
+		if added.UserID != membership.UserID || added.GroupID != membership.GroupID || !added.JoinedAt.Valid {
+			t.Fatalf("AddUserToGroup returned %+v", added)
+		}
+	}
+
+	_, err := store.AddUserToGroup(ctx, db.AddUserToGroupParams{UserID: bob.ID, GroupID: kitchen.ID})
+	expectCode(t, err, "23505", "duplicate membership")
+	_, err = store.AddUserToGroup(ctx, db.AddUserToGroupParams{UserID: bob.ID + 1000, GroupID: kitchen.ID})
+	expectCode(t, err, "23503", "membership of a missing user")
+
+	members, err := store.GetGroupMembers(ctx, kitchen.ID)
+	if err != nil {
+		t.Fatalf("GetGroupMembers: %v", err)
+	}
+	if got := memberIDs(members); len(got) != 2 || !got[alice.ID] || !got[bob.ID] {
+		t.Fatalf("GetGroupMembers = %v, want alice and bob", got)
+	}
+	if got := members[0].Username; got != "alice" && got != "bob" {
+		t.Fatalf("GetGroupMembers returned username %q", got)
+	}
+
+	groups, err := store.GetUserGroups(ctx, bob.ID)
+	if err != nil {
+		t.Fatalf("GetUserGroups: %v", err)
+	}
+	if got := userGroupIDs(groups); len(got) != 2 || !got[kitchen.ID] || !got[floor.ID] {
+		t.Fatalf("GetUserGroups = %v, want kitchen and floor", got)
+	}
+
+	err = store.DeleteUserFromGroup(ctx, db.DeleteUserFromGroupParams{UserID: bob.ID, GroupID: kitchen.ID})
+	if err != nil {
+		t.Fatalf("DeleteUserFromGroup: %v", err)
+	}
+	err = store.DeleteUserFromGroup(ctx, db.DeleteUserFromGroupParams{UserID: bob.ID, GroupID: kitchen.ID})
+	if err != nil {
+		t.Fatalf("DeleteUserFromGroup of a removed member: %v", err)
+	}
+	members, err = store.GetGroupMembers(ctx, kitchen.ID)
+	if got := memberIDs(members); err != nil || len(got) != 1 || !got[alice.ID] {
+		t.Fatalf("GetGroupMembers after removal = %v, %v", got, err)
+	}
+
+	_, err = store.DeleteGroup(ctx, db.DeleteGroupParams{ID: floor.ID})
+	if err != nil {
+		t.Fatalf("DeleteGroup: %v", err)
+	}
+	groups, err = store.GetUserGroups(ctx, bob.ID)
+	if err != nil || len(groups) != 0 {
+		t.Fatalf("GetUserGroups after the group was deleted = %+v, %v", groups, err)
+	}
+	members, err = store.GetGroupMembers(ctx, floor.ID)
+	if err != nil || len(members) != 0 {
+		t.Fatalf("GetGroupMembers of a deleted group = %+v, %v", members, err)
+	}
+}
+
+func memberIDs(rows []db.GetGroupMembersRow) map[int32]bool {
+	ids := map[int32]bool{}
+	for _, row := range rows {
+		ids[row.UserID] = true
+	}
+	return ids
+}
+
+func userGroupIDs(rows []db.GetUserGroupsRow) map[int32]bool {
+	ids := map[int32]bool{}
+	for _, row := range rows {
+		ids[row.GroupID] = true
+	}
+	return ids
+}
+
+func testShifts(t *testing.T, store repository.Store) {
+	ctx := context.Background()
+	alice := CreateUser(t, store, "alice")
+	kitchen := CreateGroup(t, store, alice, "Kitchen")
+	floor := CreateGroup(t, store, alice, "Floor")
+	kitchenID := pgtype.Int4{Int32: kitchen.ID, Valid: true}
+
+	var created []db.Shift
+	for _, hour := range []int{14, 8, 11} {
+		shift, err := store.CreateShift(ctx, db.CreateShiftParams{
+			UserID:    pgtype.Int4{Int32: alice.ID, Valid: true},
+			GroupID:   kitchenID,
+			Name:      fmt.Sprintf("Shift at %d", hour),
+			StartTime: at(hour),
+			EndTime:   at(hour + 2),
+		})
+		if err != nil {
+			t.Fatalf("CreateShift: %v", err)
+		}
+		if shift.Version != 1 || shift.DeletedAt.Valid || !shift.StartTime.Time.Equal(at(hour).Time) {
+			t.Fatalf("CreateShift returned %+v", shift)
+		}
+		created = append(created, shift)
+	}
+
+	_, err := store.CreateShift(ctx, db.CreateShiftParams{
+		GroupID:   pgtype.Int4{Int32: floor.ID + 1000, Valid: true},
+		Name:      "Nowhere",
+		StartTime: at(1),
+		EndTime:   at(2),
+	})
+	expectCode(t, err, "23503", "shift in a missing group")
+
+	shifts, err := store.ListShiftsByGroup(ctx, kitchenID)
+	if err != nil {
+		t.Fatalf("ListShiftsByGroup: %v", err)
+	}
+	if len(shifts) != 3 || shifts[0].ID != created[1].ID || shifts[1].ID != created[2].ID || shifts[2].ID != created[0].ID {
+		t.Fatalf("ListShiftsByGroup is not ordered by start time: %+v", shifts)
+	}
+
+	_, err = store.DeleteShift(ctx, db.DeleteShiftParams{ID: created[0].ID, GroupID: pgtype.Int4{Int32: floor.ID, Valid: true}})
+	expectNoRows(t, err, "DeleteShift through another group")
+	_, err = store.DeleteShift(ctx, db.DeleteShiftParams{
+		ID:              created[0].ID,
+		GroupID:         kitchenID,
+		ExpectedVersion: pgtype.Int4{Int32: created[0].Version + 1, Valid: true},
+	})
+	expectNoRows(t, err, "DeleteShift with a stale version")
+
+	deleted, err := store.DeleteShift(ctx, db.DeleteShiftParams{
+		ID:              created[0].ID,
+		GroupID:         kitchenID,
+		ExpectedVersion: pgtype.Int4{Int32: created[0].Version, Valid: true},
+	})
+	if err != nil {
+		t.Fatalf("DeleteShift: %v", err)
+	}
+	if !deleted.DeletedAt.Valid || deleted.Version != created[0].Version+1 {
+		t.Fatalf("DeleteShift returned %+v", deleted)
+	}
+	_, err = store.GetShiftByID(ctx, created[0].ID)
+	expectNoRows(t, err, "GetShiftByID of a deleted shift")
+
+	got, err := store.GetShiftByID(ctx, created[1].ID)
+	if err != nil || got.ID != created[1].ID {
+		t.Fatalf("GetShiftByID = %+v, %v", got, err)
+	}
+
+	err = store.DeleteGroupShifts(ctx, db.DeleteGroupShiftsParams{GroupID: kitchen.ID, DeletedAt: at(20)})
+	if err != nil {
+		t.Fatalf("DeleteGroupShifts: %v", err)
+	}
+	shifts, err = store.ListShiftsByGroup(ctx, kitchenID)
+	if err != nil || len(shifts) != 0 {
+		t.Fatalf("ListShiftsByGroup after DeleteGroupShifts = %+v, %v", shifts, err)
+	}
+}
+
+func testTransactionCommits(t *testing.T, store repository.Store) {
+	ctx := context.Background()
+	alice := CreateUser(t, store, "alice")
+
+	var group db.Group
+	err := store.InTx(ctx, func(repos repository.Repositories) error {
+		var err error
+		group, err = repos.CreateGroup(ctx, db.CreateGroupParams{
+			Name:    "Kitchen",
+			OwnerID: pgtype.Int4{Int32: alice.ID, Valid: true},
+		})
+		if err != nil {
+			return err
+		}
+		_, err = repos.AddUserToGroup(ctx, db.AddUserToGroupParams{UserID: alice.ID, GroupID: group.ID})
+		if err != nil {
+			return err
+		}
+		return repos.CreateAuditEvent(ctx, db.CreateAuditEventParams{
+			ActorID:    pgtype.Int4{Int32: alice.ID, Valid: true},
+			Action:     "group.create",
+			EntityType: "group",
+			EntityID:   pgtype.Int4{Int32: group.ID, Valid: true},
+			GroupID:    pgtype.Int4{Int32: group.ID, Valid: true},
+		})
+	})
+	if err != nil {
+		t.Fatalf("InTx: %v", err)
+	}
+
+	_, err = store.GetGroupByID(ctx, group.ID)
+	if err != nil {
+		t.Fatalf("GetGroupByID after commit: %v", err)
+	}
+	members, err := store.GetGroupMembers(ctx, group.ID)
+	if err != nil || len(members) != 1 {
+		t.Fatalf("GetGroupMembers after commit = %+v, %v", members, err)
+	}
+}
+
+func testTransactionRollsBack(t *testing.T, store repository.Store) {
+	ctx := context.Background()
+	alice := CreateUser(t, store, "alice")
+	kitchen := CreateGroup(t, store, alice, "Kitchen")
+	failure := stderrors.New("second step failed")
+
+	err := store.InTx(ctx, func(repos repository.Repositories) error {
+		_, err := repos.AddUserToGroup(ctx, db.AddUserToGroupParams{UserID: alice.ID, GroupID: kitchen.ID})
+		if err != nil {
+			return err
+		}
+		_, err = repos.UpdateGroup(ctx, db.UpdateGroupParams{ID: kitchen.ID, Name: "Renamed"})
+		if err != nil {
+			return err
+		}
+		return failure
+	})
+	if !stderrors.Is(err, failure) {
+		t.Fatalf("InTx returned %v, want the error of fn", err)
+	}
+
+	members, err := store.GetGroupMembers(ctx, kitchen.ID)
+	if err != nil || len(members) != 0 {
+		t.Fatalf("membership survived the rollback: %+v, %v", members, err)
+	}
+	got, err := store.GetGroupByID(ctx, kitchen.ID)
+	if err != nil || got != kitchen {
+		t.Fatalf("group changed by a rolled back transaction: %+v, %v", got, err)
+	}
+}
@@ -0,0 +1,107 @@
+// Package retention decides how long soft deleted groups and shifts can be
+// restored and removes them for good once that window has passed. The same
+// job clears expired idempotency keys, and PurgeExpired clears everything
+// else that has expired for the admin command.
+package retention
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+	db "github.com/joseph-gunnarsson/scheduling/db/models"
+)
+
+// DefaultPurgeInterval is how often Run looks for expired rows.
+const DefaultPurgeInterval = time.Hour
+
+// Cutoff is the oldest deletion time that can still be restored.
+func Cutoff(now time.Time, window time.Duration) pgtype.Timestamptz {
+	return pgtype.Timestamptz{Time: now.Add(-window), Valid: true}
+}
+
+// Purge permanently deletes groups and shifts that were soft deleted before
+// the cutoff. Shifts go first so shifts deleted together with a group are
+// counted instead of disappearing in the cascade.
+func Purge(ctx context.Context, query *db.Queries, cutoff pgtype.Timestamptz) (groups int64, shifts int64, err error) {
+	shifts, err = query.PurgeDeletedShifts(ctx, cutoff)
+	if err != nil {
+		return 0, 0, err
+	}
+	groups, err = query.PurgeDeletedGroups(ctx, cutoff)
+	if err != nil {
+		return 0, shifts, err
+	}
+	return groups, shifts, nil
+}
+
+// Expired is how many rows of one kind PurgeExpired removed.
+type Expired struct {
+	Kind  string `json:"kind"`
+	Count int64  `json:"count"`
+}
+
+// PurgeExpired removes everything that can no longer be used or restored:
+// groups and shifts deleted before the cutoff, sessions that ended before
+// it, spent email tokens, and expired login state and idempotency keys.
+// Session history is kept as long as deleted rows so users can still see
+// recent sign-ins in their export.
+func PurgeExpired(ctx context.Context, query *db.Queries, cutoff pgtype.Timestamptz) ([]Expired, error) {
+	groups, shifts, err := Purge(ctx, query, cutoff)
+	if err != nil {
+		return nil, err
+	}
+	expired := []Expired{
+		{Kind: "deleted_groups", Count: groups},
+		{Kind: "deleted_shifts", Count: shifts},
+	}
+
+	deletes := []struct {
+		kind   string
+		delete func(context.Context) (int64, error)
+	}{
+		{"sessions", func(ctx context.Context) (int64, error) { return query.DeleteEndedSessions(ctx, cutoff) }},
+		{"user_tokens", query.DeleteSpentUserTokens},
+		{"mfa_challenges", query.DeleteExpiredMFAChallenges},
+		{"oidc_login_states", query.DeleteExpiredOIDCLoginStates},
+		{"idempotency_keys", query.DeleteExpiredIdempotencyKeys},
+	}
+	for _, d := range deletes {
+		count, err := d.delete(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("purge %s: %w", d.kind, err)
+		}
+		expired = append(expired, Expired{Kind: d.kind, Count: count})
+	}
+	return expired, nil
+}
+
+// Run purges expired rows every interval until ctx is done.
+func Run(ctx context.Context, pool *pgxpool.Pool, window, interval time.Duration) {
+	query := db.New(pool)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		groups, shifts, err := Purge(ctx, query, Cutoff(time.Now(), window))
+		if err != nil {
+			slog.Error("Failed to purge deleted rows", "error", err)
+		} else if groups > 0 || shifts > 0 {
+			slog.Info("Purged deleted rows", "groups", groups, "shifts", shifts)
+		}
+
+		_, err = query.DeleteExpiredIdempotencyKeys(ctx)
+		if err != nil {
+			slog.Error("Failed to delete expired idempotency keys", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
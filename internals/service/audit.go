@@ -0,0 +1,70 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	db "github.com/joseph-gunnarsson/scheduling/db/models"
+	"github.com/joseph-gunnarsson/scheduling/internals/repository"
+)
+
+// Entity types recorded in the audit log.
+const (
+	AuditEntityUser       = "user"
+	AuditEntityGroup      = "group"
+	AuditEntityMembership = "membership"
+	AuditEntityShift      = "shift"
+	AuditEntityAPIKey     = "api_key"
+)
+
+type AuditEvent struct {
+	Action     string
+	EntityType string
+	EntityID   int32
+	// GroupID is the group whose owners get to see the event, zero when the
+	// event does not belong to a group.
+	GroupID int32
+	// ActorID defaults to the actor of the change. Requests without one, such
+	// as registration or email links, set it themselves.
+	ActorID int32
+	Before  any
+	After   any
+}
+
+// RecordAudit appends an event. Pass the query of the transaction that makes
+// the change so the event is only kept if the change is.
+func RecordAudit(ctx context.Context, query repository.Audit, actor Actor, event AuditEvent) error {
+	actorID := event.ActorID
+	if actorID == 0 {
+		actorID = actor.UserID
+	}
+
+	before, err := auditJSON(event.Before)
+	if err != nil {
+		return err
+	}
+	after, err := auditJSON(event.After)
+	if err != nil {
+		return err
+	}
+
+	return query.CreateAuditEvent(ctx, db.CreateAuditEventParams{
+		ActorID:    pgtype.Int4{Int32: actorID, Valid: actorID != 0},
+		Action:     event.Action,
+		EntityType: event.EntityType,
+		EntityID:   pgtype.Int4{Int32: event.EntityID, Valid: event.EntityID != 0},
+		GroupID:    pgtype.Int4{Int32: event.GroupID, Valid: event.GroupID != 0},
+		Before:     before,
+		After:      after,
+		RequestID:  pgtype.Text{String: actor.RequestID, Valid: actor.RequestID != ""},
+		IpAddress:  pgtype.Text{String: actor.IPAddress, Valid: actor.IPAddress != ""},
+	})
+}
+
+func auditJSON(value any) (json.RawMessage, error) {
+	if value == nil {
+		return nil, nil
+	}
+	return json.Marshal(value)
+}
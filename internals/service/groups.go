@@ -0,0 +1,162 @@
+package service
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	db "github.com/joseph-gunnarsson/scheduling/db/models"
+	"github.com/joseph-gunnarsson/scheduling/internals/repository"
+)
+
+func (s *services) CreateGroup(ctx context.Context, actor Actor, arg db.CreateGroupParams, check ParentCheck) (db.Group, error) {
+	if arg.ParentID.Valid {
+		err := check(ctx, arg.ParentID.Int32)
+		if err != nil {
+			return db.Group{}, err
+		}
+	}
+
+	var group db.Group
+	err := s.store.InTx(ctx, func(query repository.Repositories) error {
+		var err error
+		group, err = query.CreateGroup(ctx, arg)
+		if err != nil {
+			return err
+		}
+
+		return RecordAudit(ctx, query, actor, AuditEvent{
+			Action:     "group.create",
+			EntityType: AuditEntityGroup,
+			EntityID:   group.ID,
+			GroupID:    group.ID,
+			After:      group,
+		})
+	})
+	return group, err
+}
+
+func (s *services) GetGroup(ctx context.Context, id int32) (db.Group, error) {
+	return s.store.GetGroupByID(ctx, id)
+}
+
+func (s *services) ListOwnedGroups(ctx context.Context, ownerID int32) ([]db.Group, error) {
+	return s.store.GetGroupsByOwner(ctx, pgtype.Int4{Int32: ownerID, Valid: true})
+}
+
+func (s *services) UpdateGroup(ctx context.Context, actor Actor, arg db.UpdateGroupParams, check Precondition) (db.Group, error) {
+	var group db.Group
+	err := s.store.InTx(ctx, func(query repository.Repositories) error {
+		before, err := query.GetGroupByID(ctx, arg.ID)
+		if err != nil {
+			return err
+		}
+
+		arg.ExpectedVersion, err = check(before.Version)
+		if err != nil {
+			return err
+		}
+
+		group, err = query.UpdateGroup(ctx, arg)
+		if err != nil {
+			return versionConflict(err)
+		}
+
+		return RecordAudit(ctx, query, actor, AuditEvent{
+			Action:     "group.update",
+			EntityType: AuditEntityGroup,
+			EntityID:   group.ID,
+			GroupID:    group.ID,
+			Before:     before,
+			After:      group,
+		})
+	})
+	return group, err
+}
+
+func (s *services) PatchGroup(ctx context.Context, actor Actor, arg db.PatchGroupParams, check Precondition) (db.Group, error) {
+	var group db.Group
+	err := s.store.InTx(ctx, func(query repository.Repositories) error {
+		before, err := query.GetGroupByID(ctx, arg.ID)
+		if err != nil {
+			return err
+		}
+
+		arg.ExpectedVersion, err = check(before.Version)
+		if err != nil {
+			return err
+		}
+
+		group, err = query.PatchGroup(ctx, arg)
+		if err != nil {
+			return versionConflict(err)
+		}
+
+		return RecordAudit(ctx, query, actor, AuditEvent{
+			Action:     "group.update",
+			EntityType: AuditEntityGroup,
+			EntityID:   group.ID,
+			GroupID:    group.ID,
+			Before:     before,
+			After:      group,
+		})
+	})
+	return group, err
+}
+
+func (s *services) DeleteGroup(ctx context.Context, actor Actor, id int32, check Precondition) (db.Group, error) {
+	var deleted db.Group
+	err := s.store.InTx(ctx, func(query repository.Repositories) error {
+		group, err := query.GetGroupByID(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		expectedVersion, err := check(group.Version)
+		if err != nil {
+			return err
+		}
+
+		deleted, err = query.DeleteGroup(ctx, db.DeleteGroupParams{
+			ID:              id,
+			ExpectedVersion: expectedVersion,
+		})
+		if err != nil {
+			return versionConflict(err)
+		}
+
+		// Sub-groups go with their parent and share its deleted_at, so
+		// restoring the parent brings back exactly what was deleted here.
+		err = query.DeleteGroupDescendants(ctx, db.DeleteGroupDescendantsParams{
+			RootID:    deleted.ID,
+			DeletedAt: deleted.DeletedAt,
+		})
+		if err != nil {
+			return err
+		}
+
+		err = query.DeleteGroupShifts(ctx, db.DeleteGroupShiftsParams{
+			GroupID:   deleted.ID,
+			DeletedAt: deleted.DeletedAt,
+		})
+		if err != nil {
+			return err
+		}
+
+		// File a sub-group's deletion under the parent, where the parent's
+		// managers can find it. A root group keeps it, and its owner can still
+		// open the deleted group's audit log.
+		auditGroupID := group.ID
+		if group.ParentID.Valid {
+			auditGroupID = group.ParentID.Int32
+		}
+		return RecordAudit(ctx, query, actor, AuditEvent{
+			Action:     "group.delete",
+			EntityType: AuditEntityGroup,
+			EntityID:   group.ID,
+			GroupID:    auditGroupID,
+			Before:     group,
+			After:      deleted,
+		})
+	})
+	return deleted, err
+}
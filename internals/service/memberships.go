@@ -0,0 +1,53 @@
+package service
+
+import (
+	"context"
+
+	db "github.com/joseph-gunnarsson/scheduling/db/models"
+	"github.com/joseph-gunnarsson/scheduling/internals/repository"
+)
+
+func (s *services) AddMember(ctx context.Context, actor Actor, arg db.AddUserToGroupParams) (db.UserGroup, error) {
+	var membership db.UserGroup
+	err := s.store.InTx(ctx, func(query repository.Repositories) error {
+		var err error
+		membership, err = query.AddUserToGroup(ctx, arg)
+		if err != nil {
+			return err
+		}
+
+		return RecordAudit(ctx, query, actor, AuditEvent{
+			Action:     "membership.add",
+			EntityType: AuditEntityMembership,
+			EntityID:   membership.UserID,
+			GroupID:    membership.GroupID,
+			After:      membership,
+		})
+	})
+	return membership, err
+}
+
+func (s *services) RemoveMember(ctx context.Context, actor Actor, arg db.DeleteUserFromGroupParams) error {
+	return s.store.InTx(ctx, func(query repository.Repositories) error {
+		err := query.DeleteUserFromGroup(ctx, arg)
+		if err != nil {
+			return err
+		}
+
+		return RecordAudit(ctx, query, actor, AuditEvent{
+			Action:     "membership.remove",
+			EntityType: AuditEntityMembership,
+			EntityID:   arg.UserID,
+			GroupID:    arg.GroupID,
+			Before:     arg,
+		})
+	})
+}
+
+func (s *services) ListUserGroups(ctx context.Context, userID int32) ([]db.GetUserGroupsRow, error) {
+	return s.store.GetUserGroups(ctx, userID)
+}
+
+func (s *services) ListGroupMembers(ctx context.Context, groupID int32) ([]db.GetGroupMembersRow, error) {
+	return s.store.GetGroupMembers(ctx, groupID)
+}
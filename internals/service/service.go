@@ -0,0 +1,102 @@
+// Package service holds the rules for changing users, groups, memberships
+// and shifts: the version checks, what a change cascades to and the audit
+// event written with it. Handlers translate HTTP to and from these calls and the
+// repository stores the rows, so both PostgreSQL and the in-memory store
+// get the same behaviour.
+package service
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	db "github.com/joseph-gunnarsson/scheduling/db/models"
+	"github.com/joseph-gunnarsson/scheduling/internals/repository"
+)
+
+// ErrVersionChanged is returned when a row changed between the precondition
+// check and the update.
+var ErrVersionChanged = errors.New("the row was changed since it was read")
+
+// Actor is who makes a change and from where, as recorded in the audit log.
+// A zero UserID records the change without an actor.
+type Actor struct {
+	UserID    int32
+	RequestID string
+	IPAddress string
+}
+
+// Precondition checks the current version of a row before it is changed,
+// such as against an If-Match header. The version it returns is passed on to
+// the update so a change made in between still fails there.
+type Precondition func(version int32) (pgtype.Int4, error)
+
+// ParentCheck decides whether a group may be created under parentID, such as
+// whether the actor manages the parent. The error it returns is passed on.
+type ParentCheck func(ctx context.Context, parentID int32) error
+
+// Users registers accounts and changes their profiles. Passwords are hashed
+// before they reach the service.
+type Users interface {
+	Register(ctx context.Context, actor Actor, arg db.CreateUserParams) (db.CreateUserRow, error)
+	GetUser(ctx context.Context, id int32) (db.User, error)
+	// UpdateProfile changes the fields of arg that are set. Only their names
+	// are audited.
+	UpdateProfile(ctx context.Context, actor Actor, arg db.UpdateUserProfileParams) (db.User, error)
+}
+
+// Groups only ever sees groups that are not deleted.
+type Groups interface {
+	// CreateGroup runs check first when the group has a parent.
+	CreateGroup(ctx context.Context, actor Actor, arg db.CreateGroupParams, check ParentCheck) (db.Group, error)
+	GetGroup(ctx context.Context, id int32) (db.Group, error)
+	ListOwnedGroups(ctx context.Context, ownerID int32) ([]db.Group, error)
+	UpdateGroup(ctx context.Context, actor Actor, arg db.UpdateGroupParams, check Precondition) (db.Group, error)
+	PatchGroup(ctx context.Context, actor Actor, arg db.PatchGroupParams, check Precondition) (db.Group, error)
+	// DeleteGroup soft deletes a group, its sub-groups and their shifts.
+	DeleteGroup(ctx context.Context, actor Actor, id int32, check Precondition) (db.Group, error)
+}
+
+type Memberships interface {
+	AddMember(ctx context.Context, actor Actor, arg db.AddUserToGroupParams) (db.UserGroup, error)
+	RemoveMember(ctx context.Context, actor Actor, arg db.DeleteUserFromGroupParams) error
+	ListUserGroups(ctx context.Context, userID int32) ([]db.GetUserGroupsRow, error)
+	ListGroupMembers(ctx context.Context, groupID int32) ([]db.GetGroupMembersRow, error)
+}
+
+// Shifts are always reached through their group, so permission on one group
+// can't be used to reach the shifts of another.
+type Shifts interface {
+	GetShift(ctx context.Context, groupID, shiftID int32) (db.Shift, error)
+	DeleteShift(ctx context.Context, actor Actor, groupID, shiftID int32, check Precondition) (db.Shift, error)
+}
+
+// Services is everything the user, group, membership and shift handlers
+// need.
+type Services interface {
+	Users
+	Groups
+	Memberships
+	Shifts
+}
+
+type services struct {
+	store repository.Store
+}
+
+// New returns the services on a store, such as repository.NewPostgres or
+// repository.NewMemory.
+func New(store repository.Store) Services {
+	return &services{store: store}
+}
+
+// versionConflict turns the missing row of a version checked update into
+// ErrVersionChanged. Only use it after the row was read in the same
+// transaction.
+func versionConflict(err error) error {
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrVersionChanged
+	}
+	return err
+}
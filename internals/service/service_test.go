@@ -0,0 +1,259 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	db "github.com/joseph-gunnarsson/scheduling/db/models"
+	"github.com/joseph-gunnarsson/scheduling/internals/repository"
+	"github.com/joseph-gunnarsson/scheduling/internals/repository/repositorytest"
+)
+
+// anyVersion is the precondition of a client sending If-Match: *.
+func anyVersion(version int32) (pgtype.Int4, error) {
+	return pgtype.Int4{Int32: version, Valid: true}, nil
+}
+
+func TestUsers(t *testing.T) {
+	ctx := context.Background()
+	store := repository.NewMemory()
+	s := New(store)
+
+	created, err := s.Register(ctx, Actor{RequestID: "req-1"}, db.CreateUserParams{
+		Username:     "alice",
+		Email:        "alice@example.com",
+		PasswordHash: "not-a-real-hash",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.Register(ctx, Actor{}, db.CreateUserParams{Username: "alice", Email: "other@example.com", PasswordHash: "x"})
+	if err == nil {
+		t.Fatal("Register() of a taken username succeeded")
+	}
+
+	updated, err := s.UpdateProfile(ctx, Actor{UserID: created.ID}, db.UpdateUserProfileParams{
+		ID:       created.ID,
+		LastName: pgtype.Text{String: "Smith", Valid: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := s.GetUser(ctx, created.ID)
+	if err != nil || user.LastName.String != "Smith" || user.Username != "alice" || user.UpdatedAt != updated.UpdatedAt {
+		t.Fatalf("GetUser() = %+v, %v, want the updated profile", user, err)
+	}
+
+	events := store.AuditEvents()
+	if len(events) != 2 {
+		t.Fatalf("audit events = %+v, want two", events)
+	}
+	if events[0].Action != "user.register" || events[0].ActorID.Int32 != created.ID || events[0].RequestID.String != "req-1" {
+		t.Errorf("audit event = %+v, want the registration by alice", events[0])
+	}
+	if events[1].Action != "user.update_profile" || string(events[1].After) != `{"fields":["last_name"]}` {
+		t.Errorf("audit event = %+v, want the names of the changed fields only", events[1])
+	}
+}
+
+func TestCreateGroup(t *testing.T) {
+	ctx := context.Background()
+	store := repository.NewMemory()
+	s := New(store)
+	alice := repositorytest.CreateUser(t, store, "alice")
+	owner := pgtype.Int4{Int32: alice.ID, Valid: true}
+
+	restaurant, err := s.CreateGroup(ctx, Actor{UserID: alice.ID}, db.CreateGroupParams{Name: "Restaurant", OwnerID: owner}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	refused := errors.New("not a manager of the parent")
+	var checked []int32
+	check := func(ctx context.Context, parentID int32) error {
+		checked = append(checked, parentID)
+		return refused
+	}
+	parent := pgtype.Int4{Int32: restaurant.ID, Valid: true}
+	_, err = s.CreateGroup(ctx, Actor{UserID: alice.ID}, db.CreateGroupParams{Name: "Kitchen", OwnerID: owner, ParentID: parent}, check)
+	if !errors.Is(err, refused) {
+		t.Fatalf("CreateGroup() error = %v, want %v", err, refused)
+	}
+	if len(checked) != 1 || checked[0] != restaurant.ID {
+		t.Errorf("checked parents = %v, want [%d]", checked, restaurant.ID)
+	}
+	if groups, _ := s.ListOwnedGroups(ctx, alice.ID); len(groups) != 1 {
+		t.Errorf("owned groups = %+v, want only the restaurant", groups)
+	}
+
+	events := store.AuditEvents()
+	if len(events) != 1 || events[0].Action != "group.create" || events[0].EntityID.Int32 != restaurant.ID ||
+		events[0].GroupID.Int32 != restaurant.ID || events[0].ActorID.Int32 != alice.ID {
+		t.Errorf("audit events = %+v, want one group.create of the restaurant", events)
+	}
+}
+
+func TestDeleteGroup(t *testing.T) {
+	ctx := context.Background()
+	store := repository.NewMemory()
+	s := New(store)
+	alice := repositorytest.CreateUser(t, store, "alice")
+	restaurant := repositorytest.CreateGroup(t, store, alice, "Restaurant")
+	kitchen, err := store.CreateGroup(ctx, db.CreateGroupParams{
+		Name:     "Kitchen",
+		OwnerID:  pgtype.Int4{Int32: alice.ID, Valid: true},
+		ParentID: pgtype.Int4{Int32: restaurant.ID, Valid: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	shift, err := store.CreateShift(ctx, db.CreateShiftParams{
+		GroupID:   pgtype.Int4{Int32: kitchen.ID, Valid: true},
+		Name:      "Morning",
+		StartTime: pgtype.Timestamptz{Time: time.Now(), Valid: true},
+		EndTime:   pgtype.Timestamptz{Time: time.Now().Add(time.Hour), Valid: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	actor := Actor{UserID: alice.ID, RequestID: "req-1", IPAddress: "192.0.2.1"}
+	deleted, err := s.DeleteGroup(ctx, actor, kitchen.ID, anyVersion)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !deleted.DeletedAt.Valid {
+		t.Errorf("DeleteGroup() = %+v, want it deleted", deleted)
+	}
+	if _, err := s.GetShift(ctx, kitchen.ID, shift.ID); !errors.Is(err, pgx.ErrNoRows) {
+		t.Errorf("shift of the deleted group: error = %v, want %v", err, pgx.ErrNoRows)
+	}
+
+	events := store.AuditEvents()
+	if len(events) != 1 {
+		t.Fatalf("audit events = %+v, want one", events)
+	}
+	event := events[0]
+	if event.Action != "group.delete" || event.GroupID.Int32 != restaurant.ID || event.ActorID.Int32 != alice.ID ||
+		event.RequestID.String != "req-1" || event.IpAddress.String != "192.0.2.1" {
+		t.Errorf("audit event = %+v, want the deletion filed under the parent", event)
+	}
+}
+
+func TestDeleteGroupWithSubGroups(t *testing.T) {
+	ctx := context.Background()
+	store := repository.NewMemory()
+	s := New(store)
+	alice := repositorytest.CreateUser(t, store, "alice")
+	restaurant := repositorytest.CreateGroup(t, store, alice, "Restaurant")
+	parent := restaurant
+	var subGroups []db.Group
+	for _, name := range []string{"Kitchen", "Pastry"} {
+		group, err := store.CreateGroup(ctx, db.CreateGroupParams{
+			Name:     name,
+			OwnerID:  pgtype.Int4{Int32: alice.ID, Valid: true},
+			ParentID: pgtype.Int4{Int32: parent.ID, Valid: true},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		subGroups = append(subGroups, group)
+		parent = group
+	}
+	shift, err := store.CreateShift(ctx, db.CreateShiftParams{
+		GroupID:   pgtype.Int4{Int32: parent.ID, Valid: true},
+		Name:      "Baking",
+		StartTime: pgtype.Timestamptz{Time: time.Now(), Valid: true},
+		EndTime:   pgtype.Timestamptz{Time: time.Now().Add(time.Hour), Valid: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deleted, err := s.DeleteGroup(ctx, Actor{UserID: alice.ID}, restaurant.ID, anyVersion)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, group := range subGroups {
+		if _, err := s.GetGroup(ctx, group.ID); !errors.Is(err, pgx.ErrNoRows) {
+			t.Errorf("sub-group %s: error = %v, want %v", group.Name, err, pgx.ErrNoRows)
+		}
+		got, err := store.GetDeletedGroupByID(ctx, group.ID)
+		if err != nil || !got.DeletedAt.Time.Equal(deleted.DeletedAt.Time) {
+			t.Errorf("sub-group %s deleted_at = %v, %v, want %v", group.Name, got.DeletedAt.Time, err, deleted.DeletedAt.Time)
+		}
+	}
+	if _, err := s.GetShift(ctx, parent.ID, shift.ID); !errors.Is(err, pgx.ErrNoRows) {
+		t.Errorf("shift of a deleted sub-group: error = %v, want %v", err, pgx.ErrNoRows)
+	}
+	if events := store.AuditEvents(); len(events) != 1 || events[0].EntityID.Int32 != restaurant.ID {
+		t.Errorf("audit events = %+v, want the one deletion", events)
+	}
+}
+
+func TestPreconditions(t *testing.T) {
+	ctx := context.Background()
+	store := repository.NewMemory()
+	s := New(store)
+	alice := repositorytest.CreateUser(t, store, "alice")
+	group := repositorytest.CreateGroup(t, store, alice, "Kitchen")
+	refused := errors.New("If-Match does not match")
+
+	tests := []struct {
+		name  string
+		check Precondition
+		want  error
+	}{
+		{"refused", func(int32) (pgtype.Int4, error) { return pgtype.Int4{}, refused }, refused},
+		{"changed in between", func(version int32) (pgtype.Int4, error) { return pgtype.Int4{Int32: version - 1, Valid: true}, nil }, ErrVersionChanged},
+		{"current version", anyVersion, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := s.UpdateGroup(ctx, Actor{UserID: alice.ID}, db.UpdateGroupParams{ID: group.ID, Name: tt.name}, tt.check)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("UpdateGroup() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	// Only the change that went through is in the log.
+	if events := store.AuditEvents(); len(events) != 1 || events[0].Action != "group.update" {
+		t.Errorf("audit events = %+v, want the one update", events)
+	}
+	if _, err := s.UpdateGroup(ctx, Actor{}, db.UpdateGroupParams{ID: group.ID + 100}, anyVersion); !errors.Is(err, pgx.ErrNoRows) {
+		t.Errorf("updating a missing group: error = %v, want %v", err, pgx.ErrNoRows)
+	}
+}
+
+func TestShiftsStayInTheirGroup(t *testing.T) {
+	ctx := context.Background()
+	store := repository.NewMemory()
+	s := New(store)
+	alice := repositorytest.CreateUser(t, store, "alice")
+	kitchen := repositorytest.CreateGroup(t, store, alice, "Kitchen")
+	floor := repositorytest.CreateGroup(t, store, alice, "Floor")
+	shift, err := store.CreateShift(ctx, db.CreateShiftParams{
+		GroupID:   pgtype.Int4{Int32: kitchen.ID, Valid: true},
+		Name:      "Morning",
+		StartTime: pgtype.Timestamptz{Time: time.Now(), Valid: true},
+		EndTime:   pgtype.Timestamptz{Time: time.Now().Add(time.Hour), Valid: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.GetShift(ctx, floor.ID, shift.ID); !errors.Is(err, pgx.ErrNoRows) {
+		t.Errorf("GetShift() through another group: error = %v, want %v", err, pgx.ErrNoRows)
+	}
+	if _, err := s.DeleteShift(ctx, Actor{UserID: alice.ID}, floor.ID, shift.ID, anyVersion); !errors.Is(err, pgx.ErrNoRows) {
+		t.Errorf("DeleteShift() through another group: error = %v, want %v", err, pgx.ErrNoRows)
+	}
+	if _, err := s.DeleteShift(ctx, Actor{UserID: alice.ID}, kitchen.ID, shift.ID, anyVersion); err != nil {
+		t.Errorf("DeleteShift(): %v", err)
+	}
+}
@@ -0,0 +1,61 @@
+package service
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	db "github.com/joseph-gunnarsson/scheduling/db/models"
+	"github.com/joseph-gunnarsson/scheduling/internals/repository"
+)
+
+// groupShift loads a shift and answers pgx.ErrNoRows when it belongs to
+// another group.
+func groupShift(ctx context.Context, query repository.Shifts, groupID, shiftID int32) (db.Shift, error) {
+	shift, err := query.GetShiftByID(ctx, shiftID)
+	if err != nil {
+		return db.Shift{}, err
+	}
+	if shift.GroupID.Int32 != groupID {
+		return db.Shift{}, pgx.ErrNoRows
+	}
+	return shift, nil
+}
+
+func (s *services) GetShift(ctx context.Context, groupID, shiftID int32) (db.Shift, error) {
+	return groupShift(ctx, s.store, groupID, shiftID)
+}
+
+func (s *services) DeleteShift(ctx context.Context, actor Actor, groupID, shiftID int32, check Precondition) (db.Shift, error) {
+	var shift db.Shift
+	err := s.store.InTx(ctx, func(query repository.Repositories) error {
+		before, err := groupShift(ctx, query, groupID, shiftID)
+		if err != nil {
+			return err
+		}
+
+		expectedVersion, err := check(before.Version)
+		if err != nil {
+			return err
+		}
+
+		shift, err = query.DeleteShift(ctx, db.DeleteShiftParams{
+			ID:              shiftID,
+			GroupID:         pgtype.Int4{Int32: groupID, Valid: true},
+			ExpectedVersion: expectedVersion,
+		})
+		if err != nil {
+			return versionConflict(err)
+		}
+
+		return RecordAudit(ctx, query, actor, AuditEvent{
+			Action:     "shift.delete",
+			EntityType: AuditEntityShift,
+			EntityID:   shift.ID,
+			GroupID:    groupID,
+			Before:     before,
+			After:      shift,
+		})
+	})
+	return shift, err
+}
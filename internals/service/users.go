@@ -0,0 +1,67 @@
+package service
+
+import (
+	"context"
+
+	db "github.com/joseph-gunnarsson/scheduling/db/models"
+	"github.com/joseph-gunnarsson/scheduling/internals/repository"
+)
+
+// changedFields is logged for user changes instead of the values, so erasing
+// an account leaves nothing personal behind in the append-only log.
+type changedFields struct {
+	Fields []string `json:"fields"`
+}
+
+func (s *services) Register(ctx context.Context, actor Actor, arg db.CreateUserParams) (db.CreateUserRow, error) {
+	var user db.CreateUserRow
+	err := s.store.InTx(ctx, func(query repository.Repositories) error {
+		var err error
+		user, err = query.CreateUser(ctx, arg)
+		if err != nil {
+			return err
+		}
+
+		return RecordAudit(ctx, query, actor, AuditEvent{
+			Action:     "user.register",
+			EntityType: AuditEntityUser,
+			EntityID:   user.ID,
+			ActorID:    user.ID,
+		})
+	})
+	return user, err
+}
+
+func (s *services) GetUser(ctx context.Context, id int32) (db.User, error) {
+	return s.store.GetUserByID(ctx, id)
+}
+
+func (s *services) UpdateProfile(ctx context.Context, actor Actor, arg db.UpdateUserProfileParams) (db.User, error) {
+	var changed changedFields
+	if arg.Username.Valid {
+		changed.Fields = append(changed.Fields, "username")
+	}
+	if arg.FirstName.Valid {
+		changed.Fields = append(changed.Fields, "first_name")
+	}
+	if arg.LastName.Valid {
+		changed.Fields = append(changed.Fields, "last_name")
+	}
+
+	var user db.User
+	err := s.store.InTx(ctx, func(query repository.Repositories) error {
+		var err error
+		user, err = query.UpdateUserProfile(ctx, arg)
+		if err != nil {
+			return err
+		}
+
+		return RecordAudit(ctx, query, actor, AuditEvent{
+			Action:     "user.update_profile",
+			EntityType: AuditEntityUser,
+			EntityID:   user.ID,
+			After:      changed,
+		})
+	})
+	return user, err
+}
@@ -0,0 +1,60 @@
+package tracing
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestRequestSpans(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	queries := QueryTracer()
+	handler := func(rw http.ResponseWriter, r *http.Request) {
+		ctx := queries.TraceQueryStart(r.Context(), nil, pgx.TraceQueryStartData{SQL: "-- name: GetGroup :one\nSELECT 1"})
+		queries.TraceQueryEnd(ctx, nil, pgx.TraceQueryEndData{Err: errors.New("conn closed")})
+		rw.WriteHeader(http.StatusInternalServerError)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /group/{id}/", Wrap("AuthMiddleware", Wrap("GetGroupHandler", handler)))
+
+	req := httptest.NewRequest("GET", "/group/7/", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	Handler(mux).ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := exporter.GetSpans()
+	if len(spans) != 4 {
+		t.Fatalf("got %d spans, want 4", len(spans))
+	}
+	// Spans are exported as they end, the innermost first.
+	query, handlerSpan, middleware, server := spans[0], spans[1], spans[2], spans[3]
+	if server.Name != "GET /group/{id}/" || server.Parent.SpanID().String() != "00f067aa0ba902b7" {
+		t.Errorf("server span %q has parent %s, want the route and the caller", server.Name, server.Parent.SpanID())
+	}
+	if server.SpanContext.TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" || server.Status.Code != codes.Error {
+		t.Errorf("server span in trace %s with status %v", server.SpanContext.TraceID(), server.Status)
+	}
+	for _, pair := range []struct {
+		child, parent tracetest.SpanStub
+	}{{middleware, server}, {handlerSpan, middleware}, {query, handlerSpan}} {
+		if pair.child.Parent.SpanID() != pair.parent.SpanContext.SpanID() {
+			t.Errorf("%s is not nested in %s", pair.child.Name, pair.parent.Name)
+		}
+	}
+	if middleware.Name != "AuthMiddleware" || handlerSpan.Name != "GetGroupHandler" {
+		t.Errorf("spans named %q and %q", middleware.Name, handlerSpan.Name)
+	}
+	if query.Name != "GetGroup" || query.Status.Code != codes.Error {
+		t.Errorf("query span %q with status %v", query.Name, query.Status)
+	}
+}